@@ -0,0 +1,42 @@
+package libp2pquic
+
+import (
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+// StreamRejectedErrorCode is the error code used to reset streams opened by the peer
+// that are rejected by the accept filter.
+const StreamRejectedErrorCode quic.ErrorCode = 0x2
+
+// SetAcceptFilter sets a filter that is applied to streams opened by the peer when they are accepted.
+// Streams for which the filter returns false are reset, and not returned by AcceptStream.
+// A nil filter accepts all streams.
+func (c *conn) SetAcceptFilter(filter func(quic.StreamID) bool) {
+	c.acceptFilterMutex.Lock()
+	c.acceptFilter = filter
+	c.acceptFilterMutex.Unlock()
+}
+
+// acceptStream accepts the next stream that passes the accept filter.
+func (c *conn) acceptStream() (quic.Stream, error) {
+	for {
+		var qstr quic.Stream
+		var err error
+		if c.streamQueue != nil {
+			qstr, err = c.streamQueue.Accept()
+		} else {
+			qstr, err = c.sess.AcceptStream()
+		}
+		if err != nil {
+			return qstr, err
+		}
+		c.acceptFilterMutex.Lock()
+		filter := c.acceptFilter
+		c.acceptFilterMutex.Unlock()
+		if filter == nil || filter(qstr.StreamID()) {
+			return qstr, nil
+		}
+		qstr.CancelRead(StreamRejectedErrorCode)
+		qstr.CancelWrite(StreamRejectedErrorCode)
+	}
+}
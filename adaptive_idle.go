@@ -0,0 +1,34 @@
+package libp2pquic
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// closeWhenIdle closes the connection once it had no open streams, and no stream data was sent or received, for timeout.
+// quic-go v0.11 doesn't allow changing the idle timeout of a session, so quic-go keeps all sessions alive,
+// and idle connections are closed here instead. Unlike keep-alives, this doesn't require the peer to support control streams.
+func (c *conn) closeWhenIdle(timeout time.Duration) {
+	ctx := c.sess.Context()
+	ticker := time.NewTicker(timeout / 10)
+	defer ticker.Stop()
+	var lastSent, lastReceived uint64
+	lastActive := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			sent, received := atomic.LoadUint64(&c.bytesSent), atomic.LoadUint64(&c.bytesReceived)
+			if c.hasOpenStreams() || sent != lastSent || received != lastReceived {
+				lastSent, lastReceived, lastActive = sent, received, now
+				continue
+			}
+			if now.Sub(lastActive) >= timeout {
+				atomic.StoreInt32(&c.closedIdle, 1)
+				c.closeSession()
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package libp2pquic
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrDisallowedAddress is returned by Dial when the address filter rejects the peer's address, see WithAddressFilter.
+var ErrDisallowedAddress = errors.New("address not allowed by the address filter")
+
+// An AddressFilter says if an address may be dialed.
+type AddressFilter func(addr *net.UDPAddr) bool
+
+// Address ranges that are not reachable on the public internet.
+var nonPublicNets = mustParseCIDRs(
+	"10.0.0.0/8",     // RFC 1918
+	"172.16.0.0/12",  // RFC 1918
+	"192.168.0.0/16", // RFC 1918
+	"100.64.0.0/10",  // RFC 6598, carrier-grade NAT
+	"fc00::/7",       // RFC 4193, unique local addresses
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// PublicAddressesOnly is an AddressFilter that rejects loopback, private, link-local, unspecified and multicast addresses.
+// Use it to prevent dialing hosts on the local network when peer addresses come from untrusted sources.
+func PublicAddressesOnly(addr *net.UDPAddr) bool {
+	ip := addr.IP
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, n := range nonPublicNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkAddressFilter returns ErrDisallowedAddress if the address filter rejects addr.
+func (t *transport) checkAddressFilter(addr net.Addr) error {
+	if t.config.addressFilter == nil {
+		return nil
+	}
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok || !t.config.addressFilter(udpAddr) {
+		return ErrDisallowedAddress
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package libp2pquic
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Address filter", func() {
+	It("only allows public addresses", func() {
+		for _, ip := range []string{"127.0.0.1", "::1", "10.1.2.3", "172.20.0.1", "192.168.1.1", "100.64.0.1", "169.254.1.1", "fe80::1", "fd00::1", "0.0.0.0", "224.0.0.1", "::ffff:192.168.1.1"} {
+			Expect(PublicAddressesOnly(&net.UDPAddr{IP: net.ParseIP(ip), Port: 1234})).To(BeFalse(), ip)
+		}
+		for _, ip := range []string{"1.2.3.4", "8.8.8.8", "172.32.0.1", "2001:4860:4860::8888", "::ffff:1.2.3.4"} {
+			Expect(PublicAddressesOnly(&net.UDPAddr{IP: net.ParseIP(ip), Port: 1234})).To(BeTrue(), ip)
+		}
+	})
+})
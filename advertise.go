@@ -0,0 +1,64 @@
+package libp2pquic
+
+import (
+	"net"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+var interfaceAddrs = net.InterfaceAddrs
+
+// AdvertiseAddrs returns the multiaddrs this listener can be reached at,
+// to be advertised to other peers (e.g. using identify).
+// If announce addresses were configured (see WithAnnounceAddrs), those are returned instead.
+// Unspecified addresses (0.0.0.0 and ::) are expanded to the addresses of all interfaces of the same address family.
+// Loopback addresses are only returned if includeLoopback is set.
+// Only the /quic multiaddr format is supported.
+func (l *listener) AdvertiseAddrs(includeLoopback bool) ([]ma.Multiaddr, error) {
+	if len(l.transport.config.announceAddrs) > 0 {
+		return l.transport.config.announceAddrs, nil
+	}
+	addr := l.quicListener.Addr().(*net.UDPAddr)
+	ips := []net.IP{addr.IP}
+	if addr.IP.IsUnspecified() {
+		var err error
+		ips, err = interfaceIPs(addr.IP.To4() != nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var addrs []ma.Multiaddr
+	for _, ip := range ips {
+		if ip.IsLoopback() && !includeLoopback {
+			continue
+		}
+		maddr, err := toQuicMultiaddr(&net.UDPAddr{IP: ip, Port: addr.Port})
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, maddr)
+	}
+	return addrs, nil
+}
+
+// interfaceIPs returns the IP addresses of all interfaces of an address family.
+// Link-local addresses are skipped, since they can't be used without specifying a zone.
+func interfaceIPs(ipv4 bool) ([]net.IP, error) {
+	ifaceAddrs, err := interfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, a := range ifaceAddrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipnet.IP
+		if (ip.To4() != nil) != ipv4 || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
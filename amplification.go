@@ -0,0 +1,94 @@
+package libp2pquic
+
+import (
+	"net"
+	"sync"
+)
+
+// Before the client's address is validated, a QUIC server must not send more than 3 times
+// the number of bytes it received from the client (the anti-amplification limit).
+// A server whose handshake flight is larger than that (e.g. due to a large certificate chain) stalls
+// until it receives more packets from the client.
+//
+// quic-go v0.11 doesn't enforce the limit, and doesn't expose whether a connection was limited.
+// The listener therefore counts the bytes sent and received during the handshake itself,
+// and reports if the limit was exceeded, i.e. if the handshake would have stalled.
+const amplificationFactor = 3
+
+type amplificationStats struct {
+	received, sent int
+	limited        bool
+}
+
+// An amplificationTrackingConn is the socket of a listener.
+// It counts the bytes sent to and received from clients until their handshake completes.
+type amplificationTrackingConn struct {
+	net.PacketConn
+
+	mutex sync.Mutex
+	stats map[string]*amplificationStats // keyed by the client's address
+}
+
+func newAmplificationTrackingConn(c net.PacketConn) *amplificationTrackingConn {
+	return &amplificationTrackingConn{
+		PacketConn: c,
+		stats:      make(map[string]*amplificationStats),
+	}
+}
+
+func (c *amplificationTrackingConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err != nil {
+		return n, addr, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stats, ok := c.stats[addr.String()]
+	if !ok {
+		// Start tracking when the client sends its first Initial packet.
+		if _, initial, ok := parseSrcConnID(b[:n]); !ok || !initial {
+			return n, addr, err
+		}
+		if len(c.stats) >= maxPendingConnIDs {
+			// evict a random entry
+			for k := range c.stats {
+				delete(c.stats, k)
+				break
+			}
+		}
+		stats = &amplificationStats{}
+		c.stats[addr.String()] = stats
+	}
+	stats.received += n
+	return n, addr, err
+}
+
+func (c *amplificationTrackingConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mutex.Lock()
+	if stats, ok := c.stats[addr.String()]; ok {
+		stats.sent += len(b)
+		if stats.sent > amplificationFactor*stats.received {
+			stats.limited = true
+		}
+	}
+	c.mutex.Unlock()
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+// PopLimited says if the handshake with the client at addr exceeded the anti-amplification limit,
+// and stops tracking the client.
+func (c *amplificationTrackingConn) PopLimited(addr net.Addr) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stats, ok := c.stats[addr.String()]
+	delete(c.stats, addr.String())
+	return ok && stats.limited
+}
+
+// AmplificationLimited says if the handshake of an accepted connection exceeded the anti-amplification limit,
+// i.e. if the server's handshake flight was larger than 3 times the bytes received from the client.
+// Such handshakes stall with QUIC implementations enforcing the limit.
+// It always returns false for dialed connections.
+func (c *conn) AmplificationLimited() bool {
+	return c.amplificationLimited
+}
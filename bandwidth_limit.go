@@ -0,0 +1,10 @@
+package libp2pquic
+
+// globalPacer returns the pacer enforcing the transport-wide bandwidth limit,
+// or nil if the bandwidth is not limited (see WithGlobalBandwidthLimit).
+func (c *conn) globalPacer() *pacer {
+	if t, ok := c.transport.(*transport); ok && t != nil {
+		return t.bandwidthLimit
+	}
+	return nil
+}
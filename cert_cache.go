@@ -0,0 +1,80 @@
+package libp2pquic
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"sync"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+)
+
+// The maximum number of certificate chains kept in the cache.
+const certCacheSize = 1024
+
+type certCacheKey [sha256.Size]byte
+
+type certCacheEntry struct {
+	pubKey   ic.PubKey
+	notAfter time.Time
+}
+
+// A certCache caches the results of verifying certificate chains,
+// such that repeated handshakes with the same peer don't need to verify the chain again.
+// Entries are keyed by the hash of the raw bytes of the whole chain.
+// A chain is therefore only found in the cache if it is identical to a chain that was verified before,
+// even if it was generated for the same peer ID.
+// A nil certCache doesn't cache anything.
+type certCache struct {
+	mutex   sync.Mutex
+	entries map[certCacheKey]certCacheEntry
+}
+
+func newCertCache() *certCache {
+	return &certCache{entries: make(map[certCacheKey]certCacheEntry)}
+}
+
+// getRemotePubKey verifies the chain (unless a successful verification is cached) and returns the peer's public key.
+// The verifiers must be the same for every call, since the cache doesn't record which verifier was used.
+func (c *certCache) getRemotePubKey(chain []*x509.Certificate, verifiers []PeerVerifier) (ic.PubKey, error) {
+	if c == nil {
+		return getRemotePubKey(chain, verifiers)
+	}
+	h := sha256.New()
+	for _, cert := range chain {
+		h.Write(cert.Raw)
+	}
+	var key certCacheKey
+	copy(key[:], h.Sum(nil))
+
+	c.mutex.Lock()
+	entry, ok := c.entries[key]
+	c.mutex.Unlock()
+	if ok && time.Now().Before(entry.notAfter) {
+		return entry.pubKey, nil
+	}
+
+	pubKey, err := getRemotePubKey(chain, verifiers)
+	if err != nil {
+		return nil, err
+	}
+	// The chain is only valid as long as all its certificates are valid.
+	notAfter := chain[0].NotAfter
+	for _, cert := range chain[1:] {
+		if cert.NotAfter.Before(notAfter) {
+			notAfter = cert.NotAfter
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.entries) >= certCacheSize {
+		// evict a random entry
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = certCacheEntry{pubKey: pubKey, notAfter: notAfter}
+	return pubKey, nil
+}
@@ -0,0 +1,103 @@
+package libp2pquic
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func generateChain(key ic.PrivKey) ([]*x509.Certificate, error) {
+	cert, err := GenerateCertificate(key)
+	if err != nil {
+		return nil, err
+	}
+	return parseCertChain(cert.Certificate)
+}
+
+var _ = Describe("Certificate Cache", func() {
+	var key ic.PrivKey
+
+	BeforeEach(func() {
+		var err error
+		key, _, err = ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("returns the same results with and without caching", func() {
+		chain, err := generateChain(key)
+		Expect(err).ToNot(HaveOccurred())
+		var cache *certCache
+		pubKey, err := cache.getRemotePubKey(chain, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pubKey).To(Equal(key.GetPublic()))
+		cache = newCertCache()
+		for i := 0; i < 2; i++ {
+			pubKey, err = cache.getRemotePubKey(chain, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pubKey).To(Equal(key.GetPublic()))
+		}
+		Expect(cache.entries).To(HaveLen(1))
+	})
+
+	It("doesn't cache failed verifications", func() {
+		chain, err := generateChain(key)
+		Expect(err).ToNot(HaveOccurred())
+		cache := newCertCache()
+		_, err = cache.getRemotePubKey(chain[:1], nil)
+		Expect(err).To(HaveOccurred())
+		Expect(cache.entries).To(BeEmpty())
+	})
+
+	It("can't be poisoned by a different chain for the same peer", func() {
+		chain, err := generateChain(key)
+		Expect(err).ToNot(HaveOccurred())
+		cache := newCertCache()
+		_, err = cache.getRemotePubKey(chain, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		// use the same host certificate, but a leaf certificate that wasn't signed by the host key
+		otherKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		otherChain, err := generateChain(otherKey)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = cache.getRemotePubKey([]*x509.Certificate{otherChain[0], chain[1]}, nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("evicts entries when full", func() {
+		cache := newCertCache()
+		for i := 0; i < certCacheSize; i++ {
+			cache.entries[certCacheKey{byte(i), byte(i >> 8)}] = certCacheEntry{}
+		}
+		chain, err := generateChain(key)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = cache.getRemotePubKey(chain, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cache.entries).To(HaveLen(certCacheSize))
+	})
+})
+
+func benchmarkGetRemotePubKey(b *testing.B, cache *certCache) {
+	key, _, err := ic.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		b.Fatal(err)
+	}
+	chain, err := generateChain(key)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := cache.getRemotePubKey(chain, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetRemotePubKeyWithoutCache(b *testing.B) { benchmarkGetRemotePubKey(b, nil) }
+func BenchmarkGetRemotePubKeyWithCache(b *testing.B)    { benchmarkGetRemotePubKey(b, newCertCache()) }
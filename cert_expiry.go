@@ -0,0 +1,40 @@
+package libp2pquic
+
+import (
+	"crypto/x509"
+	"time"
+)
+
+// chainExpiry returns when a certificate chain expires, i.e. the earliest expiry of its certificates.
+func chainExpiry(chain []*x509.Certificate) time.Time {
+	var expiry time.Time
+	for _, cert := range chain {
+		if expiry.IsZero() || cert.NotAfter.Before(expiry) {
+			expiry = cert.NotAfter
+		}
+	}
+	return expiry
+}
+
+// PeerCertExpiry returns when the certificate chain presented by the peer during the handshake expires.
+func (c *conn) PeerCertExpiry() time.Time {
+	return c.peerCertExpiry
+}
+
+// watchCertExpiry calls the callback set by WithPeerCertExpiryWarning
+// once the peer's certificate chain is about to expire.
+// The returned function must be called when the connection is closed.
+func (t *transport) watchCertExpiry(c *conn) (stop func()) {
+	cb := t.config.onPeerCertExpiring
+	if cb == nil {
+		return func() {}
+	}
+	warn := func() { cb(c.remotePeerID, c.peerCertExpiry) }
+	d := time.Until(c.peerCertExpiry.Add(-t.config.peerCertExpiryWindow))
+	if d <= 0 {
+		go warn()
+		return func() {}
+	}
+	timer := time.AfterFunc(d, warn)
+	return func() { timer.Stop() }
+}
@@ -0,0 +1,57 @@
+package libp2pquic
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+)
+
+// ErrCertificatePinMismatch is returned by Dial when none of the certificates presented by the peer matches a pin set by WithCertificatePins.
+var ErrCertificatePinMismatch = errors.New("certificate pin mismatch")
+
+// A CertificatePin is the SHA-256 hash of a certificate's SubjectPublicKeyInfo.
+type CertificatePin [sha256.Size]byte
+
+// PinForCertificate returns the pin for a certificate.
+func PinForCertificate(cert *x509.Certificate) CertificatePin {
+	return sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+}
+
+type certificatePinsKey struct{}
+
+// WithCertificatePins returns a context that pins the certificates a dialed peer may present.
+// In addition to the peer ID check, the handshake is rejected unless at least one certificate
+// in the peer's chain matches one of the pins.
+func WithCertificatePins(ctx context.Context, pins ...CertificatePin) context.Context {
+	return context.WithValue(ctx, certificatePinsKey{}, pins)
+}
+
+// certificatePins returns the pins set on the context, or nil if certificates are not pinned.
+func certificatePins(ctx context.Context) []CertificatePin {
+	pins, _ := ctx.Value(certificatePinsKey{}).([]CertificatePin)
+	return pins
+}
+
+// matchesCertificatePins says if the certificates presented by the peer of an established connection match the pins.
+// Pooled and shared connections are checked before being returned by Dial, since they might have been dialed without pins.
+func (c *conn) matchesCertificatePins(pins []CertificatePin) bool {
+	return checkCertificatePins(c.sess.ConnectionState().PeerCertificates, pins) == nil
+}
+
+// checkCertificatePins checks that one of the certificates in chain matches one of the pins.
+// If there are no pins, any chain is accepted.
+func checkCertificatePins(chain []*x509.Certificate, pins []CertificatePin) error {
+	if len(pins) == 0 {
+		return nil
+	}
+	for _, cert := range chain {
+		pin := PinForCertificate(cert)
+		for _, p := range pins {
+			if pin == p {
+				return nil
+			}
+		}
+	}
+	return ErrCertificatePinMismatch
+}
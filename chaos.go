@@ -0,0 +1,107 @@
+package libp2pquic
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ChaosParams configure the adverse network conditions simulated by WithChaos.
+type ChaosParams struct {
+	// Loss is the probability that a packet is dropped, between 0 and 1.
+	Loss float64
+	// Latency is the delay added to every packet.
+	Latency time.Duration
+	// Jitter is the maximum random delay added on top of Latency.
+	// Since packets are delayed independently, jitter also reorders packets.
+	Jitter time.Duration
+	// Reorder is the probability that a packet is delayed by an additional ReorderDelay, between 0 and 1,
+	// such that packets sent after it overtake it.
+	Reorder      float64
+	ReorderDelay time.Duration
+	// Seed seeds the random number generator. If 0, a random seed is used.
+	Seed int64
+}
+
+func (p *ChaosParams) validate() error {
+	if p.Loss < 0 || p.Loss > 1 {
+		return errors.New("chaos: loss must be between 0 and 1")
+	}
+	if p.Reorder < 0 || p.Reorder > 1 {
+		return errors.New("chaos: reorder probability must be between 0 and 1")
+	}
+	if p.Latency < 0 || p.Jitter < 0 || p.ReorderDelay < 0 {
+		return errors.New("chaos: delays must not be negative")
+	}
+	if p.Reorder > 0 && p.ReorderDelay == 0 {
+		return errors.New("chaos: reordering requires a reorder delay")
+	}
+	return nil
+}
+
+// A chaosConn is a socket that drops and delays the packets it sends, see WithChaos.
+type chaosConn struct {
+	net.PacketConn
+	params ChaosParams
+
+	randMutex sync.Mutex
+	rand      *rand.Rand
+}
+
+var _ syscall.Conn = &chaosConn{}
+
+func newChaosConn(c net.PacketConn, params ChaosParams) *chaosConn {
+	seed := params.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &chaosConn{
+		PacketConn: c,
+		params:     params,
+		rand:       rand.New(rand.NewSource(seed)),
+	}
+}
+
+// SyscallConn returns the raw connection of the underlying socket, e.g. for reading the error queue.
+func (c *chaosConn) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := c.PacketConn.(syscall.Conn)
+	if !ok {
+		return nil, errors.New("chaos: socket doesn't support SyscallConn")
+	}
+	return sc.SyscallConn()
+}
+
+func (c *chaosConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	drop, delay := c.fate()
+	if drop {
+		return len(b), nil
+	}
+	if delay == 0 {
+		return c.PacketConn.WriteTo(b, addr)
+	}
+	// quic-go reuses the buffer once WriteTo returns
+	data := make([]byte, len(b))
+	copy(data, b)
+	time.AfterFunc(delay, func() { c.PacketConn.WriteTo(data, addr) })
+	return len(b), nil
+}
+
+// fate decides if a packet is dropped, and by how much it is delayed.
+func (c *chaosConn) fate() (drop bool, delay time.Duration) {
+	c.randMutex.Lock()
+	defer c.randMutex.Unlock()
+	if c.params.Loss > 0 && c.rand.Float64() < c.params.Loss {
+		return true, 0
+	}
+	delay = c.params.Latency
+	if c.params.Jitter > 0 {
+		delay += time.Duration(c.rand.Int63n(int64(c.params.Jitter) + 1))
+	}
+	if c.params.Reorder > 0 && c.rand.Float64() < c.params.Reorder {
+		delay += c.params.ReorderDelay
+	}
+	return false, delay
+}
@@ -0,0 +1,100 @@
+package libp2pquic
+
+import (
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Chaos mode", func() {
+	var sender, receiver *net.UDPConn
+
+	BeforeEach(func() {
+		var err error
+		sender, err = net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		receiver, err = net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(receiver.SetReadBuffer(1 << 20)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		sender.Close()
+		receiver.Close()
+	})
+
+	// receive counts the packets received until no packet arrived for timeout
+	receive := func(timeout time.Duration) int {
+		var n int
+		b := make([]byte, 1500)
+		for {
+			receiver.SetReadDeadline(time.Now().Add(timeout))
+			if _, _, err := receiver.ReadFrom(b); err != nil {
+				return n
+			}
+			n++
+		}
+	}
+
+	It("drops packets", func() {
+		conn := newChaosConn(sender, ChaosParams{Loss: 0.3, Seed: 42})
+		for i := 0; i < 1000; i++ {
+			n, err := conn.WriteTo([]byte("foobar"), receiver.LocalAddr())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(6))
+		}
+		Expect(receive(100 * time.Millisecond)).To(BeNumerically("~", 700, 60))
+	})
+
+	It("delays packets", func() {
+		conn := newChaosConn(sender, ChaosParams{Latency: 150 * time.Millisecond})
+		start := time.Now()
+		_, err := conn.WriteTo([]byte("foobar"), receiver.LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically("<", 50*time.Millisecond))
+		b := make([]byte, 1500)
+		receiver.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := receiver.ReadFrom(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b[:n]).To(Equal([]byte("foobar")))
+		Expect(time.Since(start)).To(BeNumerically(">=", 150*time.Millisecond))
+	})
+
+	It("reorders packets", func() {
+		conn := newChaosConn(sender, ChaosParams{Reorder: 1, ReorderDelay: 100 * time.Millisecond})
+		_, err := conn.WriteTo([]byte("first"), receiver.LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+		conn.params.Reorder = 0
+		_, err = conn.WriteTo([]byte("second"), receiver.LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+		b := make([]byte, 1500)
+		receiver.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := receiver.ReadFrom(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b[:n]).To(Equal([]byte("second")))
+		n, _, err = receiver.ReadFrom(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b[:n]).To(Equal([]byte("first")))
+	})
+
+	It("validates the parameters", func() {
+		_, err := newConfig(WithChaos(ChaosParams{Loss: 1.5}))
+		Expect(err).To(MatchError("chaos: loss must be between 0 and 1"))
+		_, err = newConfig(WithChaos(ChaosParams{Reorder: 0.1}))
+		Expect(err).To(MatchError("chaos: reordering requires a reorder delay"))
+		_, err = newConfig(WithChaos(ChaosParams{Latency: -time.Second}))
+		Expect(err).To(MatchError("chaos: delays must not be negative"))
+		_, err = newConfig(WithConnManager(NewConnManager()), WithChaos(ChaosParams{Loss: 0.1}))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("wraps the sockets used for dialing", func() {
+		cm := &connManager{chaos: &ChaosParams{Latency: time.Millisecond}}
+		pconn, release, err := cm.GetConnForAddr("udp4", 0, noShard, "")
+		Expect(err).ToNot(HaveOccurred())
+		defer release()
+		Expect(pconn.PacketConn).To(BeAssignableToTypeOf(&chaosConn{}))
+	})
+})
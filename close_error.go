@@ -0,0 +1,143 @@
+package libp2pquic
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sync/atomic"
+
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+// CloseReason says why a connection was closed.
+type CloseReason int
+
+const (
+	// CloseReasonLocal means that we closed the connection.
+	CloseReasonLocal CloseReason = iota + 1
+	// CloseReasonRemote means that the peer closed the connection.
+	CloseReasonRemote
+	// CloseReasonIdleTimeout means that the connection timed out due to lack of network activity.
+	CloseReasonIdleTimeout
+	// CloseReasonTransportError means that the connection was closed due to a QUIC transport error.
+	CloseReasonTransportError
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case CloseReasonLocal:
+		return "local close"
+	case CloseReasonRemote:
+		return "remote close"
+	case CloseReasonIdleTimeout:
+		return "idle timeout"
+	case CloseReasonTransportError:
+		return "transport error"
+	default:
+		return fmt.Sprintf("unknown close reason: %d", int(r))
+	}
+}
+
+// ErrConnClosed is matched (using errors.Is) by the *ClosedError returned by the methods of a connection
+// that was closed, or is being closed, e.g. OpenStream and AcceptStream.
+var ErrConnClosed = errors.New("connection closed")
+
+// A ClosedError is returned by CloseError for closed connections.
+// It is also returned by stream operations that fail because the connection was closed.
+type ClosedError struct {
+	Reason CloseReason
+	// Err is the error the QUIC session was closed with.
+	Err error
+	// ErrorCode and ReasonPhrase are the application error code and the reason sent by the peer.
+	// They are only set if the Reason is CloseReasonRemote.
+	ErrorCode    quic.ErrorCode
+	ReasonPhrase string
+}
+
+func (e *ClosedError) Error() string {
+	return fmt.Sprintf("connection closed (%s): %s", e.Reason, e.Err)
+}
+
+// Is makes a *ClosedError match ErrConnClosed.
+func (e *ClosedError) Is(target error) bool {
+	return target == ErrConnClosed
+}
+
+// The highest error code defined by QUIC for transport errors.
+// Crypto errors use the range 0x100 - 0x1ff.
+const maxTransportErrorCode = 0xc
+
+// CloseError returns nil as long as the connection is open.
+// Once it is closed, it returns a *ClosedError that says why the connection was closed.
+func (c *conn) CloseError() error {
+	if c.sess.Context().Err() == nil {
+		return nil
+	}
+	// Once the session is closed, AcceptUniStream returns the error the session was closed with.
+	_, err := c.sess.AcceptUniStream()
+	closeErr := &ClosedError{
+		Reason: classifyCloseError(err, c.isClosedLocally()),
+		Err:    err,
+	}
+	if atomic.LoadInt32(&c.closedIdle) == 1 {
+		closeErr.Reason = CloseReasonIdleTimeout
+	}
+	if closeErr.Reason == CloseReasonRemote {
+		closeErr.ErrorCode, _ = quicErrorCode(err)
+		closeErr.ReasonPhrase = quicErrorMessage(err)
+	}
+	return closeErr
+}
+
+// classifyCloseError determines why a session was closed.
+// quic-go v0.11 doesn't distinguish between application and transport error codes.
+// When closed by the peer, error codes in the range used for transport errors are therefore classified as transport errors.
+func classifyCloseError(err error, closedLocally bool) CloseReason {
+	if closedLocally {
+		return CloseReasonLocal
+	}
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return CloseReasonIdleTimeout
+	}
+	code, ok := quicErrorCode(err)
+	if !ok || (code > 0 && code <= maxTransportErrorCode) || (code >= 0x100 && code < 0x200) {
+		return CloseReasonTransportError
+	}
+	return CloseReasonRemote
+}
+
+// quicErrorField returns the field of a quic-go error (a *qerr.QuicError), if it has the expected kind.
+// quic-go v0.11 doesn't export its error type, nor an interface to access the error code of a session error
+// (quic.StreamError only applies to streams), so we need to use reflection.
+// The conn tests check that quic-go's error type still has these fields.
+func quicErrorField(err error, name string, kind reflect.Kind) (reflect.Value, bool) {
+	v := reflect.ValueOf(err)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	f := v.Elem().FieldByName(name)
+	if !f.IsValid() || f.Kind() != kind {
+		return reflect.Value{}, false
+	}
+	return f, true
+}
+
+// quicErrorCode extracts the error code from a quic-go error.
+func quicErrorCode(err error) (quic.ErrorCode, bool) {
+	f, ok := quicErrorField(err, "ErrorCode", reflect.Uint16)
+	if !ok {
+		return 0, false
+	}
+	return quic.ErrorCode(f.Uint()), true
+}
+
+// quicErrorMessage extracts the error message from a quic-go error.
+// For errors received from the peer, this is the reason phrase of the CONNECTION_CLOSE frame.
+func quicErrorMessage(err error) string {
+	f, ok := quicErrorField(err, "ErrorMessage", reflect.String)
+	if !ok {
+		return ""
+	}
+	return f.String()
+}
@@ -0,0 +1,95 @@
+package libp2pquic
+
+import (
+	"context"
+	"errors"
+
+	quic "github.com/lucas-clemente/quic-go"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// closedSession is a quic.Session that was closed with closeErr.
+type closedSession struct {
+	quic.Session
+	closeErr error
+}
+
+func (s *closedSession) Context() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func (s *closedSession) AcceptUniStream() (quic.ReceiveStream, error) {
+	return nil, s.closeErr
+}
+
+// quicError mimics the error type used by quic-go
+type quicError struct {
+	ErrorCode    quic.ErrorCode
+	ErrorMessage string
+	isTimeout    bool
+}
+
+func (e *quicError) Error() string   { return "quic error" }
+func (e *quicError) Timeout() bool   { return e.isTimeout }
+func (e *quicError) Temporary() bool { return false }
+
+var _ = Describe("Close Errors", func() {
+	getCloseReason := func(closeErr error, closedLocally bool) CloseReason {
+		c := &conn{sess: &closedSession{closeErr: closeErr}}
+		if closedLocally {
+			c.closedLocally = 1
+		}
+		err := c.CloseError()
+		Expect(err).To(BeAssignableToTypeOf(&ClosedError{}))
+		Expect(err.(*ClosedError).Err).To(Equal(closeErr))
+		return err.(*ClosedError).Reason
+	}
+
+	It("returns nil for open connections", func() {
+		c := &conn{sess: &openSession{ctx: context.Background()}}
+		Expect(c.CloseError()).ToNot(HaveOccurred())
+	})
+
+	It("classifies local closes", func() {
+		Expect(getCloseReason(&quicError{}, true)).To(Equal(CloseReasonLocal))
+	})
+
+	It("classifies idle timeouts", func() {
+		Expect(getCloseReason(&quicError{isTimeout: true}, false)).To(Equal(CloseReasonIdleTimeout))
+	})
+
+	It("classifies closes by the peer", func() {
+		Expect(getCloseReason(&quicError{ErrorCode: 0}, false)).To(Equal(CloseReasonRemote))
+		Expect(getCloseReason(&quicError{ErrorCode: 1337}, false)).To(Equal(CloseReasonRemote))
+	})
+
+	It("preserves the application error code and reason sent by the peer", func() {
+		c := &conn{sess: &closedSession{closeErr: &quicError{ErrorCode: 1337, ErrorMessage: "going away"}}}
+		err := c.CloseError().(*ClosedError)
+		Expect(err.ErrorCode).To(Equal(quic.ErrorCode(1337)))
+		Expect(err.ReasonPhrase).To(Equal("going away"))
+		// the code is only set for application errors sent by the peer
+		c = &conn{sess: &closedSession{closeErr: &quicError{ErrorCode: 0xa, ErrorMessage: "protocol violation"}}}
+		err = c.CloseError().(*ClosedError)
+		Expect(err.ErrorCode).To(BeZero())
+		Expect(err.ReasonPhrase).To(BeEmpty())
+	})
+
+	It("classifies transport errors", func() {
+		Expect(getCloseReason(&quicError{ErrorCode: 0xa}, false)).To(Equal(CloseReasonTransportError))   // PROTOCOL_VIOLATION
+		Expect(getCloseReason(&quicError{ErrorCode: 0x128}, false)).To(Equal(CloseReasonTransportError)) // crypto error
+		Expect(getCloseReason(errors.New("unknown error"), false)).To(Equal(CloseReasonTransportError))
+	})
+})
+
+// openSession is a quic.Session that is still open.
+type openSession struct {
+	quic.Session
+	ctx context.Context
+}
+
+func (s *openSession) Context() context.Context { return s.ctx }
@@ -0,0 +1,76 @@
+package libp2pquic
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrConnClosing is returned by OpenStream after CloseIdle was called.
+var ErrConnClosing = errors.New("connection is closing")
+
+// streamTracker keeps track of the streams of a connection that are still in use,
+// i.e. that haven't been closed or reset by the application yet.
+type streamTracker struct {
+	mutex       sync.Mutex
+	openStreams int
+	// drained is created when the connection starts closing,
+	// and closed as soon as all streams have been closed.
+	drained chan struct{}
+}
+
+// Add tracks a new stream opened by the application.
+// It fails once the connection is closing.
+func (t *streamTracker) Add() (done func(), err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.drained != nil {
+		return nil, ErrConnClosing
+	}
+	t.openStreams++
+	var once sync.Once
+	return func() { once.Do(t.remove) }, nil
+}
+
+func (t *streamTracker) remove() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.openStreams--
+	if t.openStreams == 0 && t.drained != nil {
+		close(t.drained)
+	}
+}
+
+// Drain stops accepting new streams.
+// The returned channel is closed when all streams have been closed.
+func (t *streamTracker) Drain() <-chan struct{} {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.drained == nil {
+		t.drained = make(chan struct{})
+		if t.openStreams == 0 {
+			close(t.drained)
+		}
+	}
+	return t.drained
+}
+
+// CloseIdle gracefully closes the connection.
+// New calls to OpenStream fail with ErrConnClosing.
+// Streams that are already open can be used until they are closed (or reset),
+// but for at most the timeout. Then the connection is closed.
+// Streams accepted while closing are not waited for.
+func (c *conn) CloseIdle(timeout time.Duration) error {
+	drained := c.streams.Drain()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+	case <-timer.C:
+	case <-c.sess.Context().Done():
+	}
+	return c.Close()
+}
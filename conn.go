@@ -1,6 +1,11 @@
 package libp2pquic
 
 import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	ic "github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/mux"
 	"github.com/libp2p/go-libp2p-core/peer"
@@ -11,6 +16,8 @@ import (
 )
 
 type conn struct {
+	metadata
+
 	sess      quic.Session
 	transport tpt.Transport
 
@@ -21,14 +28,110 @@ type conn struct {
 	remotePeerID    peer.ID
 	remotePubKey    ic.PubKey
 	remoteMultiaddr ma.Multiaddr
+	// the connection ID chosen by the peer, nil if unknown
+	remoteConnID []byte
+	// the QUIC version, 0 if unknown
+	version quic.VersionNumber
+	// see AmplificationLimited
+	amplificationLimited bool
+
+	pings   *pingManager
+	pacer   pacer
+	streams streamTracker
+	// unidirectional streams opened by the peer that haven't been accepted yet
+	uniStreams chan quic.ReceiveStream
+	// the maximum number of open unidirectional streams opened by the peer, 0 if not limited
+	maxIncomingUniStreams int32
+	// controlStreams says if control streams are enabled, see WithControlStreams
+	controlStreams bool
+	// nil if the depth of the stream accept queue is not limited
+	streamQueue *streamQueue
+	// nil for accepted connections
+	timings *EstablishmentTimings
+	// when the handshake completed
+	openedAt time.Time
+	// when the certificate chain presented by the peer expires
+	peerCertExpiry time.Time
+	// drops the packets sent to the peer when the connection is closed silently, see WithConnectionClose
+	silencer *silencer
+	// the bytes sent and received on the wire, nil if unknown
+	wire *byteCounts
+
+	// calls the callbacks registered using OnClose
+	closeNotifier closeNotifier
+
+	// the views on this connection's session, see WithSessionSharing
+	viewsMutex   sync.Mutex
+	numViews     int
+	handleClosed bool // set when Close is called
+
+	acceptFilterMutex sync.Mutex
+	acceptFilter      func(quic.StreamID) bool // nil if all streams are accepted
+
+	// The number of writes that have been blocked for longer than writeBlockedThreshold.
+	// Must be accessed atomically.
+	blockedWrites int32
+	// Set to 1 when the connection is closed by us.
+	// Must be accessed atomically.
+	closedLocally int32
+	// Set to 1 when the connection is closed because it was idle, see WithAdaptiveIdleTimeout.
+	// Must be accessed atomically.
+	closedIdle int32
+	// The number of open streams, see Stats.
+	// Must be accessed atomically.
+	numBidiStreams, numUniStreams int32
+	// The number of unidirectional streams opened by the peer that are queued or accepted, and haven't been closed yet.
+	// Must be accessed atomically.
+	numIncomingUniStreams int32
+	// The number of path changes, see Migrations.
+	// Must be accessed atomically.
+	migrations int32
+	// The number of stream opens that were blocked by the peer's stream limit, see Stats.
+	// Must be accessed atomically.
+	blockedStreamOpens uint32
+	// The RTT measured by the most recent successful Ping, in nanoseconds.
+	// Must be accessed atomically.
+	lastPingRTT int64
+	// The number of bytes written to and read from streams.
+	// Must be accessed atomically.
+	bytesSent, bytesReceived uint64
 }
 
 var _ tpt.CapableConn = &conn{}
 
+// Close closes the connection.
+// If views on the session were created (see WithSessionSharing), the session is only closed once all views are closed.
 func (c *conn) Close() error {
+	if !c.releaseHandle() {
+		return nil
+	}
+	return c.closeSession()
+}
+
+func (c *conn) closeSession() error {
+	atomic.StoreInt32(&c.closedLocally, 1)
+	if c.silencer != nil && c.silentClose() {
+		c.silencer.Silence(c.sess.RemoteAddr(), silentCloseDuration)
+	}
 	return c.sess.Close()
 }
 
+// closeWithError closes the connection using an application error.
+func (c *conn) closeWithError(code quic.ErrorCode, reason error) error {
+	atomic.StoreInt32(&c.closedLocally, 1)
+	return c.sess.CloseWithError(code, reason)
+}
+
+// silentClose says if sessions are abandoned without sending a CONNECTION_CLOSE, see WithConnectionClose.
+func (c *conn) silentClose() bool {
+	t, ok := c.transport.(*transport)
+	return ok && t != nil && t.config != nil && t.config.silentClose
+}
+
+func (c *conn) isClosedLocally() bool {
+	return atomic.LoadInt32(&c.closedLocally) == 1
+}
+
 // IsClosed returns whether a connection is fully closed.
 func (c *conn) IsClosed() bool {
 	return c.sess.Context().Err() != nil
@@ -36,14 +139,70 @@ func (c *conn) IsClosed() bool {
 
 // OpenStream creates a new stream.
 func (c *conn) OpenStream() (mux.MuxedStream, error) {
-	qstr, err := c.sess.OpenStreamSync()
-	return &stream{Stream: qstr}, err
+	done, err := c.streams.Add()
+	if err != nil {
+		return nil, err
+	}
+	qstr, err := c.openStreamSync()
+	if err != nil {
+		done()
+		return &stream{Stream: qstr, conn: c}, c.streamError(err)
+	}
+	return c.newStream(qstr, done), nil
 }
 
 // AcceptStream accepts a stream opened by the other side.
 func (c *conn) AcceptStream() (mux.MuxedStream, error) {
-	qstr, err := c.sess.AcceptStream()
-	return &stream{Stream: qstr}, err
+	qstr, err := c.acceptStream()
+	if err != nil {
+		return &stream{Stream: qstr, conn: c}, c.streamError(err)
+	}
+	// Streams accepted after CloseIdle was called are not tracked.
+	done, _ := c.streams.Add()
+	return c.newStream(qstr, done), nil
+}
+
+// newStream wraps a stream opened or accepted by the application.
+// done is called when the stream is closed or reset. It may be nil.
+func (c *conn) newStream(qstr quic.Stream, done func()) *stream {
+	uncount := countStream(&c.numBidiStreams)
+	c.publish(EventStreamOpened)
+	var once sync.Once
+	return &stream{
+		Stream: qstr,
+		conn:   c,
+		done: func() {
+			uncount()
+			once.Do(func() { c.publish(EventStreamClosed) })
+			if done != nil {
+				done()
+			}
+		},
+	}
+}
+
+// WritesBlocked says if writes on any stream of this connection are blocked,
+// i.e. if a write hasn't completed for at least writeBlockedThreshold.
+// quic-go doesn't expose its flow control state, so this is a best-effort signal:
+// Writes block when the send buffer is full, which happens when flow control prevents sending.
+func (c *conn) WritesBlocked() bool {
+	return atomic.LoadInt32(&c.blockedWrites) > 0
+}
+
+// EstablishmentTimings returns when the stages of dialing this connection completed.
+// It returns nil for accepted connections.
+func (c *conn) EstablishmentTimings() *EstablishmentTimings {
+	return c.timings
+}
+
+// OpenedAt returns when the connection was established, i.e. when the handshake completed.
+func (c *conn) OpenedAt() time.Time {
+	return c.openedAt
+}
+
+// Age returns how long ago the connection was established.
+func (c *conn) Age() time.Duration {
+	return time.Since(c.openedAt)
 }
 
 // LocalPeer returns our peer ID
@@ -62,12 +221,29 @@ func (c *conn) RemotePeer() peer.ID {
 }
 
 // RemotePublicKey returns the public key of the remote peer.
+// It was verified during the handshake, and always matches RemotePeer.
 func (c *conn) RemotePublicKey() ic.PubKey {
 	return c.remotePubKey
 }
 
-// LocalMultiaddr returns the local Multiaddr associated
+// LocalAddr returns the local address of the socket used by this connection.
+func (c *conn) LocalAddr() net.Addr {
+	return c.sess.LocalAddr()
+}
+
+// RemoteAddr returns the address of the remote peer.
+func (c *conn) RemoteAddr() net.Addr {
+	return unwrapAddr(c.sess.RemoteAddr())
+}
+
+// LocalMultiaddr returns the local Multiaddr associated.
+// If an external address mapper is set (see WithExternalAddrMapper), this is the address the peer sees us at.
 func (c *conn) LocalMultiaddr() ma.Multiaddr {
+	if t, ok := c.transport.(*transport); ok && t != nil && t.config != nil && t.config.externalAddrMapper != nil {
+		if addr := t.config.externalAddrMapper(c.localMultiaddr, c.remoteMultiaddr); addr != nil {
+			return addr
+		}
+	}
 	return c.localMultiaddr
 }
 
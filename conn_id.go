@@ -0,0 +1,104 @@
+package libp2pquic
+
+import (
+	"net"
+	"sync"
+
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+// quic-go v0.11 doesn't expose the connection IDs of a session.
+// The connection ID chosen by the peer is therefore taken from the source connection ID field
+// of the first long header packet received from the peer.
+
+// The maximum number of connection IDs of clients that are remembered by a listener until the handshake completes.
+const maxPendingConnIDs = 1024
+
+// RemoteConnectionID returns the connection ID chosen by the peer, i.e. the connection ID of the packets we send.
+// It returns nil if the connection ID is not known.
+func (c *conn) RemoteConnectionID() []byte {
+	return c.remoteConnID
+}
+
+// parseSrcConnID returns the source connection ID of a long header packet.
+// initial says if the packet is an Initial packet.
+func parseSrcConnID(b []byte) (connID []byte, initial bool, ok bool) {
+	// 1 byte type, 4 bytes version, 1 byte connection ID lengths
+	if len(b) < 6 || b[0]&0x80 == 0 {
+		return nil, false, false
+	}
+	// version negotiation packets
+	if b[1] == 0 && b[2] == 0 && b[3] == 0 && b[4] == 0 {
+		return nil, false, false
+	}
+	dcil, scil := decodeConnIDLen(b[5]>>4), decodeConnIDLen(b[5]&0xf)
+	if scil == 0 || len(b) < 6+dcil+scil {
+		return nil, false, false
+	}
+	connID = make([]byte, scil)
+	copy(connID, b[6+dcil:])
+	return connID, (b[0]&0x30)>>4 == 0, true
+}
+
+func decodeConnIDLen(enc byte) int {
+	if enc == 0 {
+		return 0
+	}
+	return int(enc) + 3
+}
+
+// A connIDRecordingConn is the socket of a listener.
+// It records the connection IDs chosen by clients (and the QUIC version they used) from their Initial packets,
+// until they are retrieved when the handshake completes.
+type connIDRecordingConn struct {
+	net.PacketConn
+
+	mutex   sync.Mutex
+	clients map[string]clientInitial // keyed by the client's address
+}
+
+// clientInitial is the information taken from a client's first Initial packet.
+type clientInitial struct {
+	connID  []byte
+	version quic.VersionNumber
+}
+
+func newConnIDRecordingConn(c net.PacketConn) *connIDRecordingConn {
+	return &connIDRecordingConn{
+		PacketConn: c,
+		clients:    make(map[string]clientInitial),
+	}
+}
+
+func (c *connIDRecordingConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err != nil {
+		return n, addr, err
+	}
+	if connID, initial, ok := parseSrcConnID(b[:n]); ok && initial {
+		c.mutex.Lock()
+		if _, ok := c.clients[addr.String()]; !ok {
+			if len(c.clients) >= maxPendingConnIDs {
+				// evict a random entry
+				for k := range c.clients {
+					delete(c.clients, k)
+					break
+				}
+			}
+			version, _ := parseVersion(b[:n])
+			c.clients[addr.String()] = clientInitial{connID: connID, version: version}
+		}
+		c.mutex.Unlock()
+	}
+	return n, addr, err
+}
+
+// PopConnID returns the connection ID chosen by the client at addr and the QUIC version it used,
+// and forgets about them.
+func (c *connIDRecordingConn) PopConnID(addr net.Addr) ([]byte, quic.VersionNumber) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	client := c.clients[addr.String()]
+	delete(c.clients, addr.String())
+	return client.connID, client.version
+}
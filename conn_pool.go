@@ -0,0 +1,196 @@
+package libp2pquic
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+type pooledConn struct {
+	conn  *conn
+	timer *time.Timer // fires when the connection wasn't used for the idle timeout
+	// pinned connections are neither expired nor evicted, see WarmPool
+	pinned bool
+}
+
+// A connPool keeps dialed connections, such that Dial can return an existing connection to a peer.
+// See WithConnPool.
+type connPool struct {
+	maxIdle     int
+	idleTimeout time.Duration
+
+	mutex sync.Mutex
+	lru   *list.List // of *pooledConn, the most recently used connection first
+	elems map[*conn]*list.Element
+	// closed when the pool is closed, see Close
+	closed chan struct{}
+}
+
+func newConnPool(maxIdle int, idleTimeout time.Duration) *connPool {
+	return &connPool{
+		maxIdle:     maxIdle,
+		idleTimeout: idleTimeout,
+		lru:         list.New(),
+		elems:       make(map[*conn]*list.Element),
+		closed:      make(chan struct{}),
+	}
+}
+
+// Get returns a view on a pooled connection to the peer, or nil if there is none.
+// Connections that are closed or closing, or whose peer certificates don't match the pins, are skipped.
+func (p *connPool) Get(pid peer.ID, pins []CertificatePin) *sharedConn {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for e := p.lru.Front(); e != nil; e = e.Next() {
+		pc := e.Value.(*pooledConn)
+		if pc.conn.remotePeerID != pid || pc.conn.isClosedLocally() || !pc.conn.matchesCertificatePins(pins) {
+			continue
+		}
+		v := pc.conn.newView()
+		if v == nil {
+			continue
+		}
+		p.lru.MoveToFront(e)
+		pc.timer.Reset(p.idleTimeout)
+		return v
+	}
+	return nil
+}
+
+// Put adds a dialed connection to the pool, and returns a view on it for the caller of Dial.
+// The pool takes over the connection's own handle: The session is closed once the connection
+// was removed from the pool and all views are closed.
+// If the pool is full, the least recently used connection that isn't pinned is removed from the pool.
+// It returns nil if the connection is already closed, or if the pool was closed. In the latter case, the caller keeps the handle.
+func (p *connPool) Put(c *conn) *sharedConn {
+	p.mutex.Lock()
+	select {
+	case <-p.closed:
+		p.mutex.Unlock()
+		return nil
+	default:
+	}
+	if e, ok := p.elems[c]; ok {
+		// coalesced dials return the same connection
+		p.lru.MoveToFront(e)
+		p.mutex.Unlock()
+		return c.newView()
+	}
+	var evicted *conn
+	if p.lru.Len() >= p.maxIdle {
+		for e := p.lru.Back(); e != nil; e = e.Prev() {
+			if !e.Value.(*pooledConn).pinned {
+				evicted = p.remove(e)
+				break
+			}
+		}
+	}
+	pc := &pooledConn{conn: c}
+	p.elems[c] = p.lru.PushFront(pc)
+	pc.timer = time.AfterFunc(p.idleTimeout, func() { p.expire(c) })
+	p.mutex.Unlock()
+
+	if evicted != nil {
+		// only closes the session if no view is open
+		evicted.Close()
+	}
+	return c.newView()
+}
+
+// Close removes all connections from the pool, and stops keeping connections warm (see WarmPool).
+// The sessions are closed once all views on them are closed. Connections aren't pooled any more afterwards.
+func (p *connPool) Close() {
+	p.mutex.Lock()
+	select {
+	case <-p.closed:
+		p.mutex.Unlock()
+		return
+	default:
+	}
+	close(p.closed)
+	var conns []*conn
+	for e := p.lru.Front(); e != nil; e = p.lru.Front() {
+		conns = append(conns, p.remove(e))
+	}
+	p.mutex.Unlock()
+
+	for _, c := range conns {
+		// only closes the session if no view is open
+		c.Close()
+	}
+}
+
+// Pin keeps a pooled connection in the pool until it is unpinned or closed.
+// Connections that aren't pooled are not affected.
+func (p *connPool) Pin(c *conn) {
+	p.setPinned(c, true)
+}
+
+// Unpin allows a pinned connection to be expired and evicted again.
+func (p *connPool) Unpin(c *conn) {
+	p.setPinned(c, false)
+}
+
+func (p *connPool) setPinned(c *conn, pinned bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if e, ok := p.elems[c]; ok {
+		e.Value.(*pooledConn).pinned = pinned
+	}
+}
+
+// Remove removes a connection from the pool, e.g. when it was closed.
+func (p *connPool) Remove(c *conn) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if e, ok := p.elems[c]; ok {
+		p.remove(e)
+	}
+}
+
+// remove must be called with the mutex held.
+func (p *connPool) remove(e *list.Element) *conn {
+	pc := e.Value.(*pooledConn)
+	pc.timer.Stop()
+	p.lru.Remove(e)
+	delete(p.elems, pc.conn)
+	return pc.conn
+}
+
+// expire removes a connection that wasn't returned by Dial for the idle timeout from the pool.
+// The session is closed unless views on it are still open.
+// Connections that still have open streams are kept until they become idle, pinned connections are kept until they are unpinned.
+func (p *connPool) expire(c *conn) {
+	p.mutex.Lock()
+	e, ok := p.elems[c]
+	if !ok {
+		p.mutex.Unlock()
+		return
+	}
+	if c.hasOpenStreams() || e.Value.(*pooledConn).pinned {
+		e.Value.(*pooledConn).timer.Reset(p.idleTimeout)
+		p.mutex.Unlock()
+		return
+	}
+	p.remove(e)
+	p.mutex.Unlock()
+	// only closes the session if no view is open
+	c.Close()
+}
+
+// Len returns the number of pooled connections.
+func (p *connPool) Len() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.lru.Len()
+}
+
+func (c *conn) hasOpenStreams() bool {
+	return atomic.LoadInt32(&c.numBidiStreams) > 0 || atomic.LoadInt32(&c.numUniStreams) > 0
+}
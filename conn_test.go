@@ -3,22 +3,95 @@ package libp2pquic
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/mux"
 	"github.com/libp2p/go-libp2p-core/peer"
 	tpt "github.com/libp2p/go-libp2p-core/transport"
+
+	quic "github.com/lucas-clemente/quic-go"
 	ma "github.com/multiformats/go-multiaddr"
+	"golang.org/x/net/ipv4"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
+type timeoutError struct{}
+
+func (e *timeoutError) Error() string   { return "timeout" }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return false }
+
+// oneWayConn is a net.PacketConn that drops all short header packets it sends.
+// Since the handshake only uses long header packets, this simulates a path that breaks after the handshake.
+type oneWayConn struct {
+	net.PacketConn
+}
+
+func (c *oneWayConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if len(b) > 0 && b[0]&0x80 == 0 {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+// spanRecorder is a Tracer that records all spans in memory
+type spanRecorder struct {
+	mutex sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	name       string
+	parent     *recordedSpan
+	attributes map[string]interface{}
+	err        error
+	ended      bool
+}
+
+type recordedSpanKey struct{}
+
+func (r *spanRecorder) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(recordedSpanKey{}).(*recordedSpan)
+	s := &recordedSpan{name: name, parent: parent, attributes: make(map[string]interface{})}
+	r.mutex.Lock()
+	r.spans = append(r.spans, s)
+	r.mutex.Unlock()
+	return context.WithValue(ctx, recordedSpanKey{}, s), s
+}
+
+func (r *spanRecorder) Spans() []*recordedSpan {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.spans
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) { s.attributes[key] = value }
+func (s *recordedSpan) RecordError(err error)                      { s.err = err }
+func (s *recordedSpan) End()                                       { s.ended = true }
+
 var _ = Describe("Connection", func() {
 	var (
 		serverKey, clientKey ic.PrivKey
@@ -82,6 +155,40 @@ var _ = Describe("Connection", func() {
 		Expect(serverConn.RemotePublicKey()).To(Equal(clientKey.GetPublic()))
 	})
 
+	It("handshakes using P-384 ephemeral keys", func() {
+		serverTransport, err := NewTransport(serverKey, WithEphemeralKeyCurve(elliptic.P384()))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey, WithEphemeralKeyCurve(elliptic.P384()))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		serverConn := <-serverConnChan
+		Expect(conn.RemotePeer()).To(Equal(serverID))
+		Expect(serverConn.RemotePeer()).To(Equal(clientID))
+	})
+
+	It("returns a remote public key that matches the remote peer ID", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		serverConn := <-serverConnChan
+		for _, c := range []tpt.CapableConn{clientConn, serverConn} {
+			id, err := peer.IDFromPublicKey(c.RemotePublicKey())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(id).To(Equal(c.RemotePeer()))
+			Expect(c.RemotePeer().MatchesPublicKey(c.RemotePublicKey())).To(BeTrue())
+		}
+	})
+
 	It("handshakes on IPv6", func() {
 		serverTransport, err := NewTransport(serverKey)
 		Expect(err).ToNot(HaveOccurred())
@@ -102,6 +209,156 @@ var _ = Describe("Connection", func() {
 		Expect(serverConn.RemotePublicKey()).To(Equal(clientKey.GetPublic()))
 	})
 
+	It("handshakes with custom DNS names", func() {
+		serverTransport, err := NewTransport(serverKey, WithDNSNames("server.example.com"))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey, WithDNSNames("server.example.com"))
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(clientConn.RemotePeer()).To(Equal(serverID))
+		serverConn := <-serverConnChan
+		Expect(serverConn.(*conn).sess.ConnectionState().ServerName).To(Equal("server.example.com"))
+		cert := clientConn.(*conn).sess.ConnectionState().PeerCertificates[0]
+		Expect(cert.DNSNames).To(Equal([]string{"server.example.com"}))
+	})
+
+	It("handshakes using a pre-generated certificate", func() {
+		cert, err := GenerateCertificate(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverTransport, err := NewTransport(&inaccessibleKey{serverKey}, WithCertificate(cert))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(clientConn.RemotePeer()).To(Equal(serverID))
+		Eventually(serverConnChan).Should(Receive())
+		certs := clientConn.(*conn).sess.ConnectionState().PeerCertificates
+		Expect(certs).To(HaveLen(2))
+		Expect(certs[0].Raw).To(Equal(cert.Certificate[0]))
+		Expect(certs[1].Raw).To(Equal(cert.Certificate[1]))
+	})
+
+	Context("using a custom tls.Config", func() {
+		customTLSConfig := func(key ic.PrivKey) *tls.Config {
+			cert, err := GenerateCertificate(key)
+			Expect(err).ToNot(HaveOccurred())
+			return &tls.Config{Certificates: []tls.Certificate{*cert}}
+		}
+
+		It("uses the config", func() {
+			serverConf := customTLSConfig(serverKey)
+			var numVerified int32
+			serverConf.VerifyPeerCertificate = func([][]byte, [][]*x509.Certificate) error {
+				atomic.AddInt32(&numVerified, 1)
+				return nil
+			}
+			serverTransport, err := NewTransportWithTLSConfig(serverKey, serverConf)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientConf := customTLSConfig(clientKey)
+			clientConf.VerifyPeerCertificate = func([][]byte, [][]*x509.Certificate) error {
+				return errors.New("custom verification failed")
+			}
+			clientTransport, err := NewTransportWithTLSConfig(clientKey, clientConf)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).To(MatchError(ContainSubstring("custom verification failed")))
+
+			clientTransport, err = NewTransportWithTLSConfig(clientKey, customTLSConfig(clientKey))
+			Expect(err).ToNot(HaveOccurred())
+			clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer clientConn.Close()
+			Expect(clientConn.RemotePeer()).To(Equal(serverID))
+			var serverConn tpt.CapableConn
+			Eventually(serverConnChan).Should(Receive(&serverConn))
+			Expect(serverConn.RemotePeer()).To(Equal(clientID))
+			Expect(atomic.LoadInt32(&numVerified)).To(BeNumerically(">", 0))
+		})
+
+		It("still verifies the peer ID", func() {
+			serverTransport, err := NewTransportWithTLSConfig(serverKey, customTLSConfig(serverKey))
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			// the custom verification accepts all certificates
+			clientConf := customTLSConfig(clientKey)
+			clientConf.VerifyPeerCertificate = func([][]byte, [][]*x509.Certificate) error { return nil }
+			clientTransport, err := NewTransportWithTLSConfig(clientKey, clientConf)
+			Expect(err).ToNot(HaveOccurred())
+			thirdPartyID, _ := createPeer()
+			_, err = clientTransport.Dial(context.Background(), serverAddr, thirdPartyID)
+			Expect(err).To(MatchError(ContainSubstring("peer IDs don't match")))
+		})
+
+		It("uses the config's verification for listeners with a custom verification", func() {
+			serverConf := customTLSConfig(serverKey)
+			serverConf.VerifyPeerCertificate = func([][]byte, [][]*x509.Certificate) error {
+				return errors.New("custom verification failed")
+			}
+			serverTransport, err := NewTransportWithTLSConfig(serverKey, serverConf)
+			Expect(err).ToNot(HaveOccurred())
+			var numVerified int32
+			ln, err := serverTransport.(*transport).ListenWithConfig(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"), ListenConfig{
+				VerifyPeerCertificate: func([][]byte, [][]*x509.Certificate) error {
+					atomic.AddInt32(&numVerified, 1)
+					return nil
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+			accepted := make(chan tpt.CapableConn, 1)
+			go func() {
+				c, err := ln.Accept()
+				if err == nil {
+					accepted <- c
+				}
+			}()
+
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			// With TLS 1.3, the client might complete the handshake before the server verifies its certificate.
+			if c, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID); err == nil {
+				defer c.Close()
+			}
+			Consistently(accepted).ShouldNot(Receive())
+			Expect(atomic.LoadInt32(&numVerified)).To(BeZero())
+		})
+
+		It("rejects configs without a usable certificate", func() {
+			_, err := NewTransportWithTLSConfig(serverKey, &tls.Config{})
+			Expect(err).To(MatchError("tls.Config doesn't contain a certificate"))
+			_, err = NewTransportWithTLSConfig(serverKey, customTLSConfig(clientKey))
+			Expect(err).To(MatchError("certificate doesn't belong to the host key"))
+		})
+	})
+
+	It("handshakes using a crypto.Signer as the host key", func() {
+		signer, err := keyToSigner(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverTransport, err := NewTransportFromSigner(&opaqueSigner{signer})
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conn.RemotePeer()).To(Equal(serverID))
+		Expect(conn.RemotePublicKey()).To(Equal(serverKey.GetPublic()))
+		serverConn := <-serverConnChan
+		Expect(serverConn.LocalPeer()).To(Equal(serverID))
+		Expect(serverConn.LocalPrivateKey()).To(BeNil())
+		Expect(serverConn.RemotePeer()).To(Equal(clientID))
+	})
+
 	It("opens and accepts streams", func() {
 		serverTransport, err := NewTransport(serverKey)
 		Expect(err).ToNot(HaveOccurred())
@@ -125,143 +382,4070 @@ var _ = Describe("Connection", func() {
 		Expect(data).To(Equal([]byte("foobar")))
 	})
 
-	It("fails if the peer ID doesn't match", func() {
-		thirdPartyID, _ := createPeer()
+	It("counts open bidirectional and unidirectional streams independently", func() {
+		serverTransport, err := NewTransport(serverKey, WithControlStreams())
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
 
-		serverTransport, err := NewTransport(serverKey)
+		clientTransport, err := NewTransport(clientKey, WithControlStreams())
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		serverConn := (<-serverConnChan).(*conn)
+
+		var bidiStreams []mux.MuxedStream
+		for i := 0; i < 2; i++ {
+			str, err := clientConn.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = str.Write([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			bidiStreams = append(bidiStreams, str)
+		}
+		var uniStreams []quic.SendStream
+		for i := 0; i < 3; i++ {
+			str, err := clientConn.(*conn).OpenUniStream()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = str.Write([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			uniStreams = append(uniStreams, str)
+		}
+		Expect(clientConn.(*conn).Stats()).To(Equal(ConnStats{OpenBidiStreams: 2, OpenUniStreams: 3}))
+
+		for i := 0; i < 2; i++ {
+			_, err := serverConn.AcceptStream()
+			Expect(err).ToNot(HaveOccurred())
+		}
+		var received []quic.ReceiveStream
+		for i := 0; i < 3; i++ {
+			str, err := serverConn.AcceptUniStream()
+			Expect(err).ToNot(HaveOccurred())
+			b := make([]byte, 6)
+			_, err = io.ReadFull(str, b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b).To(Equal([]byte("foobar")))
+			received = append(received, str)
+		}
+		Expect(serverConn.Stats()).To(Equal(ConnStats{OpenBidiStreams: 2, OpenUniStreams: 3}))
+
+		// closing unidirectional streams doesn't affect the bidirectional stream count
+		Expect(uniStreams[0].Close()).To(Succeed())
+		uniStreams[1].CancelWrite(0)
+		Expect(clientConn.(*conn).Stats()).To(Equal(ConnStats{OpenBidiStreams: 2, OpenUniStreams: 1}))
+		_, err = ioutil.ReadAll(received[0])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(serverConn.Stats()).To(Equal(ConnStats{OpenBidiStreams: 2, OpenUniStreams: 2}))
+
+		// and vice versa
+		Expect(bidiStreams[0].Close()).To(Succeed())
+		Expect(bidiStreams[1].Reset()).To(Succeed())
+		Expect(clientConn.(*conn).Stats()).To(Equal(ConnStats{OpenBidiStreams: 0, OpenUniStreams: 1}))
+	})
+
+	It("refuses incoming unidirectional streams beyond the limit", func() {
+		serverTransport, err := NewTransport(serverKey, WithMaxIncomingUniStreams(2), WithControlStreams())
 		Expect(err).ToNot(HaveOccurred())
 		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
 
-		clientTransport, err := NewTransport(clientKey)
+		clientTransport, err := NewTransport(clientKey, WithControlStreams())
 		Expect(err).ToNot(HaveOccurred())
-		// dial, but expect the wrong peer ID
-		_, err = clientTransport.Dial(context.Background(), serverAddr, thirdPartyID)
-		Expect(err).To(HaveOccurred())
-		Expect(err.Error()).To(ContainSubstring("CRYPTO_ERROR"))
-		Consistently(serverConnChan).ShouldNot(Receive())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		serverConn := (<-serverConnChan).(*conn)
+
+		openUniStream := func() quic.SendStream {
+			str, err := clientConn.(*conn).OpenUniStream()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = str.Write([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			return str
+		}
+		var received []quic.ReceiveStream
+		for i := 0; i < 2; i++ {
+			openUniStream()
+			str, err := serverConn.AcceptUniStream()
+			Expect(err).ToNot(HaveOccurred())
+			received = append(received, str)
+		}
+
+		// the third stream exceeds the limit
+		str := openUniStream()
+		Eventually(func() error {
+			_, err := str.Write([]byte("foobar"))
+			return err
+		}).Should(HaveOccurred())
+		accepted := make(chan quic.ReceiveStream, 1)
+		go func() {
+			defer GinkgoRecover()
+			str, err := serverConn.AcceptUniStream()
+			if err == nil {
+				accepted <- str
+			}
+		}()
+		Consistently(accepted).ShouldNot(Receive())
+
+		// once an accepted stream is done, the peer can open a new stream
+		received[0].CancelRead(0)
+		openUniStream()
+		Eventually(accepted).Should(Receive())
 	})
 
-	It("fails if the client presents an invalid cert chain", func() {
+	It("doesn't count unidirectional streams towards the bidirectional stream limit", func() {
+		serverTransport, err := NewTransport(serverKey, WithMaxIncomingUniStreams(1), WithStreamAcceptQueueDepth(1), WithControlStreams())
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey, WithControlStreams())
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		serverConn := (<-serverConnChan).(*conn)
+
+		uniStr, err := clientConn.(*conn).OpenUniStream()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = uniStr.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = serverConn.AcceptUniStream()
+		Expect(err).ToNot(HaveOccurred())
+		str, err := clientConn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = serverConn.AcceptStream()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("counts stream opens blocked by the peer's stream limit", func() {
+		origQuicConfig := quicConfig
+		defer func() { quicConfig = origQuicConfig }()
+		conf := *quicConfig
+		conf.MaxIncomingStreams = 2
+		quicConfig = &conf
 		serverTransport, err := NewTransport(serverKey)
 		Expect(err).ToNot(HaveOccurred())
 		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		quicConfig = origQuicConfig
 
 		clientTransport, err := NewTransport(clientKey)
-		invalidateCertChain(clientTransport.(*transport).tlsConf)
 		Expect(err).ToNot(HaveOccurred())
-		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
 		Expect(err).ToNot(HaveOccurred())
-		Eventually(func() bool { return conn.IsClosed() }).Should(BeTrue())
-		Consistently(serverConnChan).ShouldNot(Receive())
+		defer clientConn.Close()
+		serverConn := (<-serverConnChan).(*conn)
+		defer serverConn.Close()
+
+		for i := 0; i < 2; i++ {
+			_, err := clientConn.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(clientConn.(*conn).Stats().BlockedStreamOpens).To(BeZero())
+
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			_, err := clientConn.OpenStream()
+			Expect(err).To(HaveOccurred())
+		}()
+		Eventually(func() int { return clientConn.(*conn).Stats().BlockedStreamOpens }).Should(Equal(1))
+		Consistently(done).ShouldNot(BeClosed())
+		// closing the connection unblocks the open
+		Expect(clientConn.Close()).To(Succeed())
+		Eventually(done).Should(BeClosed())
 	})
 
-	It("fails if the server presents an invalid cert chain", func() {
+	It("reports the peer's stream limits", func() {
+		origQuicConfig := quicConfig
+		defer func() { quicConfig = origQuicConfig }()
+		conf := *quicConfig
+		conf.MaxIncomingStreams = 42
+		conf.MaxIncomingUniStreams = 7
+		quicConfig = &conf
 		serverTransport, err := NewTransport(serverKey)
-		invalidateCertChain(serverTransport.(*transport).tlsConf)
 		Expect(err).ToNot(HaveOccurred())
 		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		quicConfig = origQuicConfig
 
 		clientTransport, err := NewTransport(clientKey)
 		Expect(err).ToNot(HaveOccurred())
-		_, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
-		Expect(err).To(HaveOccurred())
-		Expect(err.Error()).To(ContainSubstring("CRYPTO_ERROR"))
-		Consistently(serverConnChan).ShouldNot(Receive())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		serverConn := (<-serverConnChan).(*conn)
+		defer serverConn.Close()
+
+		n, ok := clientConn.(*conn).PeerMaxBidiStreams()
+		Expect(ok).To(BeTrue())
+		Expect(n).To(Equal(42))
+		n, ok = clientConn.(*conn).PeerMaxUniStreams()
+		Expect(ok).To(BeTrue())
+		Expect(n).To(Equal(7))
+		n, ok = serverConn.PeerMaxBidiStreams()
+		Expect(ok).To(BeTrue())
+		Expect(n).To(Equal(origQuicConfig.MaxIncomingStreams))
+		n, ok = serverConn.PeerMaxUniStreams()
+		Expect(ok).To(BeTrue())
+		// the client didn't enable control streams
+		Expect(n).To(BeZero())
 	})
 
-	It("keeps accepting connections after a failed connection attempt", func() {
+	It("stops opening a stream when the context is done", func() {
+		origQuicConfig := quicConfig
+		defer func() { quicConfig = origQuicConfig }()
+		conf := *quicConfig
+		conf.MaxIncomingStreams = 2
+		quicConfig = &conf
 		serverTransport, err := NewTransport(serverKey)
 		Expect(err).ToNot(HaveOccurred())
 		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		quicConfig = origQuicConfig
 
-		// first dial with an invalid cert chain
-		clientTransport1, err := NewTransport(clientKey)
-		invalidateCertChain(clientTransport1.(*transport).tlsConf)
+		clientTransport, err := NewTransport(clientKey)
 		Expect(err).ToNot(HaveOccurred())
-		_, err = clientTransport1.Dial(context.Background(), serverAddr, serverID)
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
 		Expect(err).ToNot(HaveOccurred())
-		Consistently(serverConnChan).ShouldNot(Receive())
+		defer clientConn.Close()
+		serverConn := (<-serverConnChan).(*conn)
+		defer serverConn.Close()
 
-		// then dial with a valid client
-		clientTransport2, err := NewTransport(clientKey)
+		// a slot is available
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		str, err := clientConn.(*conn).OpenStreamContext(ctx)
 		Expect(err).ToNot(HaveOccurred())
-		_, err = clientTransport2.Dial(context.Background(), serverAddr, serverID)
+		Expect(str).ToNot(BeNil())
+		_, err = clientConn.OpenStream()
 		Expect(err).ToNot(HaveOccurred())
-		Eventually(serverConnChan).Should(Receive())
-	})
 
-	It("dials to two servers at the same time", func() {
-		serverID2, serverKey2 := createPeer()
+		// the peer's stream limit is reached
+		ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err = clientConn.(*conn).OpenStreamContext(ctx)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+		Expect(time.Since(start)).To(And(
+			BeNumerically(">=", 100*time.Millisecond),
+			BeNumerically("<", time.Second),
+		))
+		Expect(clientConn.(*conn).Stats().BlockedStreamOpens).To(Equal(1))
+	})
 
+	It("resets streams rejected by the accept filter", func() {
 		serverTransport, err := NewTransport(serverKey)
 		Expect(err).ToNot(HaveOccurred())
 		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
-		serverTransport2, err := NewTransport(serverKey2)
+
+		clientTransport, err := NewTransport(clientKey)
 		Expect(err).ToNot(HaveOccurred())
-		serverAddr2, serverConnChan2 := runServer(serverTransport2, "/ip4/127.0.0.1/udp/0/quic")
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		serverConn := <-serverConnChan
+		// Streams opened by the client have even stream IDs, streams opened by the server odd ones.
+		rejectOdd := func(id quic.StreamID) bool { return id%2 == 0 }
+		clientConn.(*conn).SetAcceptFilter(rejectOdd)
+		serverConn.(*conn).SetAcceptFilter(rejectOdd)
 
-		data := bytes.Repeat([]byte{'a'}, 5*1<<20) // 5 MB
-		// wait for both servers to accept a connection
-		// then send some data
+		accepted := make(chan mux.MuxedStream, 1)
 		go func() {
-			for _, c := range []tpt.CapableConn{<-serverConnChan, <-serverConnChan2} {
-				go func(conn tpt.CapableConn) {
-					defer GinkgoRecover()
-					str, err := conn.OpenStream()
-					Expect(err).ToNot(HaveOccurred())
-					defer str.Close()
-					_, err = str.Write(data)
-					Expect(err).ToNot(HaveOccurred())
-				}(c)
+			defer GinkgoRecover()
+			str, err := clientConn.AcceptStream()
+			if err == nil {
+				accepted <- str
 			}
 		}()
+		sstr, err := serverConn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = sstr.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = sstr.Read([]byte{0})
+		Expect(err).To(HaveOccurred())
+		Consistently(accepted).ShouldNot(Receive())
 
-		clientTransport, err := NewTransport(clientKey)
+		cstr, err := clientConn.OpenStream()
 		Expect(err).ToNot(HaveOccurred())
-		c1, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		_, err = cstr.Write([]byte("foobar"))
 		Expect(err).ToNot(HaveOccurred())
-		c2, err := clientTransport.Dial(context.Background(), serverAddr2, serverID2)
+		str, err := serverConn.AcceptStream()
 		Expect(err).ToNot(HaveOccurred())
-
-		done := make(chan struct{}, 2)
-		// receive the data on both connections at the same time
-		for _, c := range []tpt.CapableConn{c1, c2} {
-			go func(conn tpt.CapableConn) {
-				defer GinkgoRecover()
-				str, err := conn.AcceptStream()
-				Expect(err).ToNot(HaveOccurred())
-				str.Close()
-				d, err := ioutil.ReadAll(str)
-				Expect(err).ToNot(HaveOccurred())
-				Expect(d).To(Equal(data))
-				conn.Close()
-				done <- struct{}{}
-			}(c)
-		}
-
-		Eventually(done, 5*time.Second).Should(Receive())
-		Eventually(done, 5*time.Second).Should(Receive())
+		Expect(str.(*stream).StreamID() % 2).To(BeZero())
 	})
 
-	It("dials to ed25519 server", func() {
-		// Generate ED25519 credentials
-		serverKey2, _, err := ic.GenerateEd25519Key(rand.Reader)
+	It("refuses to accept early data", func() {
+		serverTransport, err := NewTransport(serverKey)
 		Expect(err).ToNot(HaveOccurred())
-		serverID2, err := peer.IDFromPrivateKey(serverKey2)
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
 		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		serverConn := <-serverConnChan
+		Expect(serverConn.(*conn).SetAcceptEarlyData(true)).To(MatchError(ErrEarlyDataUnsupported))
+		Expect(serverConn.(*conn).SetAcceptEarlyData(false)).To(Succeed())
+	})
 
-		// Create ED25519 server
-		serverTransport2, err := NewTransport(serverKey2)
+	It("opens streams as net.Conns", func() {
+		serverTransport, err := NewTransport(serverKey)
 		Expect(err).ToNot(HaveOccurred())
-		serverAddr2, _ := runServer(serverTransport2, "/ip4/127.0.0.1/udp/0/quic")
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
 
-		// Dial to ED25519 server
 		clientTransport, err := NewTransport(clientKey)
 		Expect(err).ToNot(HaveOccurred())
-		conn, err := clientTransport.Dial(context.Background(), serverAddr2, serverID2)
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
 		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		serverConn := <-serverConnChan
 
-		// Verify the ID of the ED25519 server
-		Expect(conn.RemotePeer()).To(Equal(serverID2))
-	})
-})
+		netConn, err := clientConn.(*conn).OpenStreamConn(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(netConn.LocalAddr()).To(Equal(clientConn.(*conn).LocalAddr()))
+		Expect(netConn.RemoteAddr()).To(Equal(clientConn.(*conn).RemoteAddr()))
+		_, err = netConn.Write([]byte("ping"))
+		Expect(err).ToNot(HaveOccurred())
+		sstr, err := serverConn.AcceptStream()
+		Expect(err).ToNot(HaveOccurred())
+		b := make([]byte, 4)
+		_, err = io.ReadFull(sstr, b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b).To(Equal([]byte("ping")))
+		_, err = sstr.Write([]byte("pong"))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = io.ReadFull(netConn, b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b).To(Equal([]byte("pong")))
+
+		// deadlines apply to the stream
+		Expect(netConn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))).To(Succeed())
+		_, err = netConn.Read(b)
+		Expect(err).To(HaveOccurred())
+		Expect(err.(net.Error).Timeout()).To(BeTrue())
+
+		Expect(netConn.Close()).To(Succeed())
+		_, err = ioutil.ReadAll(sstr)
+		Expect(err).ToNot(HaveOccurred())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = clientConn.(*conn).OpenStreamConn(ctx)
+		Expect(err).To(MatchError(context.Canceled))
+	})
+
+	It("signals when writes are blocked", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		serverConn := <-serverConnChan
+		conn := clientConn.(*conn)
+		Expect(conn.WritesBlocked()).To(BeFalse())
+
+		// The server doesn't read from the stream, so flow control will block the write.
+		str, err := conn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		data := bytes.Repeat([]byte{'a'}, 10*1<<20) // 10 MB
+		go func() {
+			defer GinkgoRecover()
+			_, err := str.Write(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.Close()).To(Succeed())
+		}()
+		Eventually(conn.WritesBlocked).Should(BeTrue())
+
+		// Once the server reads the data, the write is unblocked.
+		sstr, err := serverConn.AcceptStream()
+		Expect(err).ToNot(HaveOccurred())
+		received, err := ioutil.ReadAll(sstr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(received).To(Equal(data))
+		Eventually(conn.WritesBlocked).Should(BeFalse())
+	})
+
+	It("fails if the peer ID doesn't match", func() {
+		thirdPartyID, _ := createPeer()
+
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		// dial, but expect the wrong peer ID
+		_, err = clientTransport.Dial(context.Background(), serverAddr, thirdPartyID)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("CRYPTO_ERROR"))
+		Consistently(serverConnChan).ShouldNot(Receive())
+	})
+
+	Context("limiting the RSA key size", func() {
+		var largeKey ic.PrivKey
+		var largeID peer.ID
+
+		BeforeEach(func() {
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).ToNot(HaveOccurred())
+			largeKey, err = ic.UnmarshalRsaPrivateKey(x509.MarshalPKCS1PrivateKey(key))
+			Expect(err).ToNot(HaveOccurred())
+			largeID, err = peer.IDFromPrivateKey(largeKey)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("accepts clients using keys within the limit", func() {
+			serverTransport, err := NewTransport(serverKey, WithMaxRSAKeySize(1024))
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			Eventually(serverConnChan).Should(Receive())
+		})
+
+		It("rejects clients using larger keys", func() {
+			serverTransport, err := NewTransport(serverKey, WithMaxRSAKeySize(1024))
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(largeKey)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).To(HaveOccurred())
+			Consistently(serverConnChan).ShouldNot(Receive())
+		})
+
+		It("refuses to dial servers using larger keys", func() {
+			serverTransport, err := NewTransport(largeKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithMaxRSAKeySize(1024))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = clientTransport.Dial(context.Background(), serverAddr, largeID)
+			Expect(err).To(MatchError(ContainSubstring("RSA key too large: 2048 bits (maximum: 1024)")))
+		})
+	})
+
+	Context("limiting the size of the certificate chain", func() {
+		// padCertChain appends copies of the leaf certificate to the chain presented during the handshake.
+		padCertChain := func(tlsConf *tls.Config, n int) {
+			chain := tlsConf.Certificates[0].Certificate
+			for i := 0; i < n; i++ {
+				chain = append(chain, chain[0])
+			}
+			tlsConf.Certificates[0].Certificate = chain
+		}
+
+		It("accepts chains within the limits", func() {
+			serverTransport, err := NewTransport(serverKey, WithMaxCertChainSize(2, 4096))
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithMaxCertChainSize(2, 4096))
+			Expect(err).ToNot(HaveOccurred())
+			conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			Eventually(serverConnChan).Should(Receive())
+		})
+
+		It("rejects clients presenting too many certificates", func() {
+			serverTransport, err := NewTransport(serverKey, WithMaxCertChainSize(2, 1<<20))
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			padCertChain(clientTransport.(*transport).tlsConf, 1)
+			clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Consistently(serverConnChan).ShouldNot(Receive())
+		})
+
+		It("refuses to dial servers presenting too many bytes", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			padCertChain(serverTransport.(*transport).tlsConf, 2)
+			serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			size := 3 * len(serverTransport.(*transport).tlsConf.Certificates[0].Certificate[0])
+			clientTransport, err := NewTransport(clientKey, WithMaxCertChainSize(10, size))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).To(MatchError(ContainSubstring("certificate chain too large")))
+		})
+
+		It("rejects invalid limits", func() {
+			_, err := NewTransport(clientKey, WithMaxCertChainSize(0, 1000))
+			Expect(err).To(MatchError("maximum number of certificates must be positive"))
+			_, err = NewTransport(clientKey, WithMaxCertChainSize(2, 0))
+			Expect(err).To(MatchError("maximum certificate chain size must be positive"))
+		})
+	})
+
+	It("fails if the client presents an invalid cert chain", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		invalidateCertChain(clientTransport.(*transport).tlsConf)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(func() bool { return conn.IsClosed() }).Should(BeTrue())
+		Consistently(serverConnChan).ShouldNot(Receive())
+	})
+
+	It("fails if the server presents an invalid cert chain", func() {
+		serverTransport, err := NewTransport(serverKey)
+		invalidateCertChain(serverTransport.(*transport).tlsConf)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("CRYPTO_ERROR"))
+		Consistently(serverConnChan).ShouldNot(Receive())
+	})
+
+	It("keeps accepting connections after a failed connection attempt", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		// first dial with an invalid cert chain
+		clientTransport1, err := NewTransport(clientKey)
+		invalidateCertChain(clientTransport1.(*transport).tlsConf)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = clientTransport1.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		Consistently(serverConnChan).ShouldNot(Receive())
+
+		// then dial with a valid client
+		clientTransport2, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = clientTransport2.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(serverConnChan).Should(Receive())
+	})
+
+	It("dials to two servers at the same time", func() {
+		serverID2, serverKey2 := createPeer()
+
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		serverTransport2, err := NewTransport(serverKey2)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr2, serverConnChan2 := runServer(serverTransport2, "/ip4/127.0.0.1/udp/0/quic")
+
+		data := bytes.Repeat([]byte{'a'}, 5*1<<20) // 5 MB
+		// wait for both servers to accept a connection
+		// then send some data
+		go func() {
+			for _, c := range []tpt.CapableConn{<-serverConnChan, <-serverConnChan2} {
+				go func(conn tpt.CapableConn) {
+					defer GinkgoRecover()
+					str, err := conn.OpenStream()
+					Expect(err).ToNot(HaveOccurred())
+					defer str.Close()
+					_, err = str.Write(data)
+					Expect(err).ToNot(HaveOccurred())
+				}(c)
+			}
+		}()
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		c1, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		c2, err := clientTransport.Dial(context.Background(), serverAddr2, serverID2)
+		Expect(err).ToNot(HaveOccurred())
+
+		done := make(chan struct{}, 2)
+		// receive the data on both connections at the same time
+		for _, c := range []tpt.CapableConn{c1, c2} {
+			go func(conn tpt.CapableConn) {
+				defer GinkgoRecover()
+				str, err := conn.AcceptStream()
+				Expect(err).ToNot(HaveOccurred())
+				str.Close()
+				d, err := ioutil.ReadAll(str)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(d).To(Equal(data))
+				conn.Close()
+				done <- struct{}{}
+			}(c)
+		}
+
+		Eventually(done, 5*time.Second).Should(Receive())
+		Eventually(done, 5*time.Second).Should(Receive())
+	})
+
+	It("closes all connections to a peer", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		addr, err := ma.NewMultiaddr("/ip4/127.0.0.1/udp/0/quic")
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(addr)
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		serverConnChan := make(chan tpt.CapableConn, 2)
+		go func() {
+			defer GinkgoRecover()
+			for i := 0; i < 2; i++ {
+				conn, err := ln.Accept()
+				Expect(err).ToNot(HaveOccurred())
+				serverConnChan <- conn
+			}
+		}()
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn1, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		conn2, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		serverConn1 := <-serverConnChan
+		serverConn2 := <-serverConnChan
+
+		err = clientTransport.(*transport).CloseConnsToPeer(serverID, 42, errors.New("evicted"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conn1.IsClosed()).To(BeTrue())
+		Expect(conn2.IsClosed()).To(BeTrue())
+		Eventually(serverConn1.IsClosed).Should(BeTrue())
+		Eventually(serverConn2.IsClosed).Should(BeTrue())
+		Eventually(func() int {
+			t := clientTransport.(*transport)
+			t.connsMutex.Lock()
+			defer t.connsMutex.Unlock()
+			return len(t.conns)
+		}).Should(BeZero())
+	})
+
+	It("accounts for the memory reserved for receive buffers", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(clientTransport.(*transport).ReservedMemory()).To(BeZero())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		serverConn := <-serverConnChan
+		Expect(clientTransport.(*transport).ReservedMemory()).To(Equal(connReceiveBufferSize))
+		Expect(serverTransport.(*transport).ReservedMemory()).To(Equal(connReceiveBufferSize))
+
+		Expect(conn.Close()).To(Succeed())
+		Eventually(serverConn.IsClosed).Should(BeTrue())
+		Eventually(clientTransport.(*transport).ReservedMemory).Should(BeZero())
+		Eventually(serverTransport.(*transport).ReservedMemory).Should(BeZero())
+	})
+
+	It("refuses to dial when the memory limit is reached", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey, WithMemoryLimit(connReceiveBufferSize))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).To(MatchError(ErrMemoryLimitExceeded))
+
+		// closing the connection frees up memory for a new connection
+		Expect(conn.Close()).To(Succeed())
+		Eventually(clientTransport.(*transport).ReservedMemory).Should(BeZero())
+	})
+
+	It("dials from a port in the ephemeral port range", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey, WithEphemeralPortRange(40000, 40100))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		port, err := conn.LocalMultiaddr().ValueForProtocol(ma.P_UDP)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(strconv.Atoi(port)).To(And(BeNumerically(">=", 40000), BeNumerically("<=", 40100)))
+	})
+
+	Context("local addresses", func() {
+		var ln tpt.Listener
+
+		AfterEach(func() {
+			Expect(ln.Close()).To(Succeed())
+		})
+
+		dialTwice := func(opts ...Option) (*conn, *conn) {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			addr, err := ma.NewMultiaddr("/ip4/127.0.0.1/udp/0/quic")
+			Expect(err).ToNot(HaveOccurred())
+			ln, err = serverTransport.Listen(addr)
+			Expect(err).ToNot(HaveOccurred())
+
+			clientTransport, err := NewTransport(clientKey, opts...)
+			Expect(err).ToNot(HaveOccurred())
+			conn1, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+			Expect(err).ToNot(HaveOccurred())
+			conn2, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+			Expect(err).ToNot(HaveOccurred())
+			return conn1.(*conn), conn2.(*conn)
+		}
+
+		expectMatchingMultiaddr := func(c *conn) {
+			maddr, err := toQuicMultiaddr(c.LocalAddr())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.LocalMultiaddr()).To(Equal(maddr))
+		}
+
+		It("reports the same local address for dials sharing a socket", func() {
+			conn1, conn2 := dialTwice()
+			Expect(conn1.LocalAddr()).To(Equal(conn2.LocalAddr()))
+			Expect(conn1.LocalMultiaddr()).To(Equal(conn2.LocalMultiaddr()))
+			expectMatchingMultiaddr(conn1)
+			expectMatchingMultiaddr(conn2)
+		})
+
+		It("reports different local addresses when not reusing sockets", func() {
+			conn1, conn2 := dialTwice(DisableReuse())
+			Expect(conn1.LocalAddr()).ToNot(Equal(conn2.LocalAddr()))
+			Expect(conn1.LocalMultiaddr()).ToNot(Equal(conn2.LocalMultiaddr()))
+			expectMatchingMultiaddr(conn1)
+			expectMatchingMultiaddr(conn2)
+		})
+	})
+
+	Context("retrying dials", func() {
+		origQuicDialContext := quicDialContext
+
+		AfterEach(func() {
+			quicDialContext = origQuicDialContext
+		})
+
+		It("retries dials that fail with a transient error", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			var attempts int
+			quicDialContext = func(ctx context.Context, pconn net.PacketConn, addr net.Addr, host string, tlsConf *tls.Config, config *quic.Config) (quic.Session, error) {
+				attempts++
+				if attempts <= 2 {
+					return nil, &timeoutError{}
+				}
+				return origQuicDialContext(ctx, pconn, addr, host, tlsConf, config)
+			}
+			clientTransport, err := NewTransport(clientKey, WithDialRetries(3, time.Millisecond))
+			Expect(err).ToNot(HaveOccurred())
+			conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(conn.RemotePeer()).To(Equal(serverID))
+			Expect(attempts).To(Equal(3))
+		})
+
+		It("gives up after the configured number of retries", func() {
+			var attempts int
+			quicDialContext = func(context.Context, net.PacketConn, net.Addr, string, *tls.Config, *quic.Config) (quic.Session, error) {
+				attempts++
+				return nil, &timeoutError{}
+			}
+			clientTransport, err := NewTransport(clientKey, WithDialRetries(2, time.Millisecond))
+			Expect(err).ToNot(HaveOccurred())
+			addr, err := ma.NewMultiaddr("/ip4/127.0.0.1/udp/1234/quic")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = clientTransport.Dial(context.Background(), addr, serverID)
+			Expect(err).To(MatchError(ErrUDPBlocked))
+			Expect(attempts).To(Equal(3))
+			Expect(clientTransport.(*transport).ReservedMemory()).To(BeZero())
+		})
+
+		It("doesn't retry if the peer ID doesn't match", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			var attempts int
+			quicDialContext = func(ctx context.Context, pconn net.PacketConn, addr net.Addr, host string, tlsConf *tls.Config, config *quic.Config) (quic.Session, error) {
+				attempts++
+				return origQuicDialContext(ctx, pconn, addr, host, tlsConf, config)
+			}
+			clientTransport, err := NewTransport(clientKey, WithDialRetries(3, time.Millisecond))
+			Expect(err).ToNot(HaveOccurred())
+			thirdPartyID, _ := createPeer()
+			_, err = clientTransport.Dial(context.Background(), serverAddr, thirdPartyID)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("CRYPTO_ERROR"))
+			Expect(attempts).To(Equal(1))
+		})
+
+		It("stops retrying when the context is canceled", func() {
+			var attempts int
+			quicDialContext = func(context.Context, net.PacketConn, net.Addr, string, *tls.Config, *quic.Config) (quic.Session, error) {
+				attempts++
+				return nil, &timeoutError{}
+			}
+			clientTransport, err := NewTransport(clientKey, WithDialRetries(10, time.Hour))
+			Expect(err).ToNot(HaveOccurred())
+			addr, err := ma.NewMultiaddr("/ip4/127.0.0.1/udp/1234/quic")
+			Expect(err).ToNot(HaveOccurred())
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, err = clientTransport.Dial(ctx, addr, serverID)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+			Expect(attempts).To(Equal(1))
+		})
+	})
+
+	Context("detecting blocked UDP", func() {
+		origQuicDialContext := quicDialContext
+
+		AfterEach(func() {
+			quicDialContext = origQuicDialContext
+		})
+
+		// runUDPServer runs a UDP server that replies to every packet it receives
+		runUDPServer := func() ma.Multiaddr {
+			conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			Expect(err).ToNot(HaveOccurred())
+			go func() {
+				defer conn.Close()
+				b := make([]byte, 1500)
+				n, addr, err := conn.ReadFrom(b)
+				if err != nil {
+					return
+				}
+				conn.WriteTo(b[:n], addr)
+			}()
+			addr, err := toQuicMultiaddr(conn.LocalAddr())
+			Expect(err).ToNot(HaveOccurred())
+			return addr
+		}
+
+		It("returns ErrUDPBlocked if the handshake times out without receiving any packets", func() {
+			quicDialContext = func(context.Context, net.PacketConn, net.Addr, string, *tls.Config, *quic.Config) (quic.Session, error) {
+				return nil, &timeoutError{}
+			}
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = clientTransport.Dial(context.Background(), runUDPServer(), serverID)
+			Expect(errors.Is(err, ErrUDPBlocked)).To(BeTrue())
+			Expect(err).To(BeAssignableToTypeOf(&HandshakeTimeoutError{}))
+			Expect(err.(*HandshakeTimeoutError).Progress).To(Equal(HandshakeProgress{}))
+			Expect(err.(*HandshakeTimeoutError).Err).To(MatchError(&timeoutError{}))
+		})
+
+		It("doesn't return ErrUDPBlocked if packets were received", func() {
+			quicDialContext = func(_ context.Context, pconn net.PacketConn, addr net.Addr, _ string, _ *tls.Config, _ *quic.Config) (quic.Session, error) {
+				_, err := pconn.WriteTo([]byte("foobar"), addr)
+				Expect(err).ToNot(HaveOccurred())
+				_, _, err = pconn.ReadFrom(make([]byte, 1500))
+				Expect(err).ToNot(HaveOccurred())
+				return nil, &timeoutError{}
+			}
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = clientTransport.Dial(context.Background(), runUDPServer(), serverID)
+			Expect(errors.Is(err, ErrUDPBlocked)).To(BeFalse())
+			Expect(err).To(Equal(&HandshakeTimeoutError{
+				Progress: HandshakeProgress{PacketsReceived: 1, BytesReceived: 6},
+				Err:      &timeoutError{},
+			}))
+		})
+
+		Context("reporting the progress of the handshake", func() {
+			// dialWithReply makes the peer reply with the packet, and then lets the handshake time out.
+			dialWithReply := func(packet []byte) HandshakeProgress {
+				quicDialContext = func(_ context.Context, pconn net.PacketConn, addr net.Addr, _ string, _ *tls.Config, _ *quic.Config) (quic.Session, error) {
+					_, err := pconn.WriteTo(packet, addr)
+					Expect(err).ToNot(HaveOccurred())
+					_, _, err = pconn.ReadFrom(make([]byte, 1500))
+					Expect(err).ToNot(HaveOccurred())
+					return nil, &timeoutError{}
+				}
+				clientTransport, err := NewTransport(clientKey)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = clientTransport.Dial(context.Background(), runUDPServer(), serverID)
+				Expect(err).To(BeAssignableToTypeOf(&HandshakeTimeoutError{}))
+				return err.(*HandshakeTimeoutError).Progress
+			}
+
+			// longHeaderPacket returns a long header packet of the type, using the version
+			longHeaderPacket := func(typ byte, version uint32) []byte {
+				b := make([]byte, 50)
+				b[0] = 0xc0 | typ<<4
+				binary.BigEndian.PutUint32(b[1:5], version)
+				b[5] = 0x55 // two 8 byte connection IDs
+				return b
+			}
+
+			It("detects version negotiation", func() {
+				p := dialWithReply(longHeaderPacket(0, 0))
+				Expect(p.VersionNegotiation).To(BeTrue())
+				Expect(p.RetryReceived).To(BeFalse())
+				Expect(p.ServerFlightReceived).To(BeFalse())
+			})
+
+			It("detects Retry packets", func() {
+				p := dialWithReply(longHeaderPacket(longHeaderTypeRetry, 0xff000013))
+				Expect(p.RetryReceived).To(BeTrue())
+				Expect(p.VersionNegotiation).To(BeFalse())
+				Expect(p.ServerFlightReceived).To(BeFalse())
+			})
+
+			It("detects the server's flight", func() {
+				p := dialWithReply(longHeaderPacket(longHeaderTypeHandshake, 0xff000013))
+				Expect(p.ServerFlightReceived).To(BeTrue())
+				Expect(p.PacketsReceived).To(Equal(1))
+				Expect(p.BytesReceived).To(BeEquivalentTo(50))
+			})
+		})
+
+		It("doesn't return ErrUDPBlocked if the port is refused", func() {
+			refusedErr := &net.OpError{Op: "read", Net: "udp", Err: errors.New("connection refused")}
+			quicDialContext = func(context.Context, net.PacketConn, net.Addr, string, *tls.Config, *quic.Config) (quic.Session, error) {
+				return nil, refusedErr
+			}
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = clientTransport.Dial(context.Background(), runUDPServer(), serverID)
+			Expect(err).To(Equal(refusedErr))
+		})
+	})
+
+	It("coalesces concurrent dials to the same peer", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		var numDials int32
+		origQuicDialContext := quicDialContext
+		defer func() { quicDialContext = origQuicDialContext }()
+		quicDialContext = func(ctx context.Context, pconn net.PacketConn, addr net.Addr, host string, tlsConf *tls.Config, config *quic.Config) (quic.Session, error) {
+			atomic.AddInt32(&numDials, 1)
+			time.Sleep(50 * time.Millisecond) // make sure the dials overlap
+			return origQuicDialContext(ctx, pconn, addr, host, tlsConf, config)
+		}
+
+		clientTransport, err := NewTransport(clientKey, WithDialCoalescing())
+		Expect(err).ToNot(HaveOccurred())
+		connChan := make(chan tpt.CapableConn, 3)
+		for i := 0; i < 3; i++ {
+			go func() {
+				defer GinkgoRecover()
+				conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+				Expect(err).ToNot(HaveOccurred())
+				connChan <- conn
+			}()
+		}
+		var conns []tpt.CapableConn
+		for i := 0; i < 3; i++ {
+			var conn tpt.CapableConn
+			Eventually(connChan).Should(Receive(&conn))
+			conns = append(conns, conn)
+		}
+		Expect(conns[0]).To(BeIdenticalTo(conns[1]))
+		Expect(conns[0]).To(BeIdenticalTo(conns[2]))
+		Expect(atomic.LoadInt32(&numDials)).To(BeEquivalentTo(1))
+	})
+
+	It("pings the peer", func() {
+		serverTransport, err := NewTransport(serverKey, WithControlStreams())
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey, WithControlStreams())
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(serverConnChan).Should(Receive())
+		rtt, err := clientConn.(*conn).Ping(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rtt).To(And(BeNumerically(">", 0), BeNumerically("<", time.Second)))
+
+		Expect(clientConn.Close()).To(Succeed())
+		_, err = clientConn.(*conn).Ping(context.Background())
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("pings the peer more often than the peer's stream limit", func() {
+		serverTransport, err := NewTransport(serverKey, WithControlStreams())
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey, WithControlStreams())
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		Eventually(serverConnChan).Should(Receive())
+		for i := 0; i < 3*maxIncomingControlStreams; i++ {
+			// the peer returns stream credit asynchronously
+			Eventually(func() error {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				_, err := clientConn.(*conn).Ping(ctx)
+				return err
+			}).Should(Succeed())
+		}
+	})
+
+	Context("control streams", func() {
+		dial := func(serverOpts, clientOpts []Option) (*conn, *conn) {
+			serverTransport, err := NewTransport(serverKey, serverOpts...)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+			clientTransport, err := NewTransport(clientKey, clientOpts...)
+			Expect(err).ToNot(HaveOccurred())
+			clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			var serverConn tpt.CapableConn
+			Eventually(serverConnChan).Should(Receive(&serverConn))
+			return clientConn.(*conn), serverConn.(*conn)
+		}
+
+		It("doesn't allow unidirectional streams unless control streams are enabled", func() {
+			clientConn, serverConn := dial(nil, nil)
+			defer clientConn.Close()
+			defer serverConn.Close()
+			n, ok := clientConn.PeerMaxUniStreams()
+			Expect(ok).To(BeTrue())
+			Expect(n).To(BeZero())
+			_, err := clientConn.Ping(context.Background())
+			Expect(err).To(MatchError(ErrControlStreamsDisabled))
+			_, err = clientConn.OpenUniStream()
+			Expect(err).To(MatchError(ErrControlStreamsDisabled))
+			_, err = serverConn.AcceptUniStream()
+			Expect(err).To(MatchError(ErrControlStreamsDisabled))
+		})
+
+		It("reports when the peer doesn't support control streams", func() {
+			clientConn, serverConn := dial(nil, []Option{WithControlStreams()})
+			defer clientConn.Close()
+			defer serverConn.Close()
+			_, err := clientConn.Ping(context.Background())
+			Expect(err).To(MatchError(ErrControlStreamsUnsupported))
+			_, err = clientConn.OpenUniStream()
+			Expect(err).To(MatchError(ErrControlStreamsUnsupported))
+			// the server didn't enable control streams itself
+			_, err = serverConn.Ping(context.Background())
+			Expect(err).To(MatchError(ErrControlStreamsDisabled))
+		})
+
+		It("fails the post-dial path check if the peer doesn't support control streams", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+			clientTransport, err := NewTransport(clientKey, WithControlStreams(), WithPostDialPathCheck(time.Second))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).To(MatchError(ErrControlStreamsUnsupported))
+		})
+
+		It("refuses options that require control streams", func() {
+			_, err := newConfig(WithKeepAlivePeriod(time.Second))
+			Expect(err).To(MatchError("a keep-alive period requires control streams, see WithControlStreams"))
+			_, err = newConfig(WithPostDialPathCheck(time.Second))
+			Expect(err).To(MatchError("the post-dial path check requires control streams, see WithControlStreams"))
+			_, err = newConfig(WithMaxIncomingUniStreams(10))
+			Expect(err).To(MatchError("limiting incoming unidirectional streams requires control streams, see WithControlStreams"))
+			_, err = newConfig(WithControlStreams(), WithKeepAlivePeriod(time.Second), WithPostDialPathCheck(time.Second), WithMaxIncomingUniStreams(10))
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	It("limits the packet size per dial", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn1, err := clientTransport.Dial(WithMaxPacketSize(context.Background(), 1210), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn1.Close()
+		conn2, err := clientTransport.Dial(WithMaxPacketSize(context.Background(), 1500), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn2.Close()
+		Expect(conn1.(*conn).MaxPacketSize()).To(Equal(1200))
+		Expect(conn2.(*conn).MaxPacketSize()).To(Equal(1252))
+		// the connections are usable, and report the peer's real address
+		Expect(conn1.(*conn).RemoteAddr()).To(Equal(ln.Addr()))
+		str, err := conn1.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write(bytes.Repeat([]byte{'a'}, 5000))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.Close()).To(Succeed())
+		for i := 0; i < 2; i++ {
+			sconn, err := ln.Accept()
+			Expect(err).ToNot(HaveOccurred())
+			if sconn.(*conn).RemoteAddr().String() != conn1.(*conn).LocalAddr().String() {
+				continue
+			}
+			sstr, err := sconn.AcceptStream()
+			Expect(err).ToNot(HaveOccurred())
+			data, err := ioutil.ReadAll(sstr)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(HaveLen(5000))
+		}
+
+		_, err = clientTransport.Dial(WithMaxPacketSize(context.Background(), 1000), ln.Multiaddr(), serverID)
+		Expect(err).To(MatchError("maximum packet size too small: 1000 bytes (minimum: 1200)"))
+	})
+
+	Context("reuse policies", func() {
+		var serverAddr1, serverAddr2 ma.Multiaddr
+		var serverID2 peer.ID
+
+		BeforeEach(func() {
+			serverTransport1, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr1, _ = runServer(serverTransport1, "/ip4/127.0.0.1/udp/0/quic")
+			var serverKey2 ic.PrivKey
+			serverID2, serverKey2 = createPeer()
+			serverTransport2, err := NewTransport(serverKey2)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr2, _ = runServer(serverTransport2, "/ip4/127.0.0.1/udp/0/quic")
+		})
+
+		dial := func(tr tpt.Transport, addr ma.Multiaddr, p peer.ID) tpt.CapableConn {
+			c, err := tr.Dial(context.Background(), addr, p)
+			Expect(err).ToNot(HaveOccurred())
+			return c
+		}
+
+		It("uses the same source port for all peers by default", func() {
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			conn1 := dial(clientTransport, serverAddr1, serverID)
+			defer conn1.Close()
+			conn2 := dial(clientTransport, serverAddr2, serverID2)
+			defer conn2.Close()
+			Expect(conn2.(*conn).LocalAddr()).To(Equal(conn1.(*conn).LocalAddr()))
+		})
+
+		It("uses a different source port for every peer", func() {
+			clientTransport, err := NewTransport(clientKey, WithReusePolicy(ReusePerPeer))
+			Expect(err).ToNot(HaveOccurred())
+			conn1 := dial(clientTransport, serverAddr1, serverID)
+			defer conn1.Close()
+			conn2 := dial(clientTransport, serverAddr2, serverID2)
+			defer conn2.Close()
+			Expect(conn2.(*conn).LocalAddr()).ToNot(Equal(conn1.(*conn).LocalAddr()))
+			// dials to the same peer still share a socket
+			conn3 := dial(clientTransport, serverAddr1, serverID)
+			defer conn3.Close()
+			Expect(conn3.(*conn).LocalAddr()).To(Equal(conn1.(*conn).LocalAddr()))
+		})
+
+		It("shares a socket between dials using the same affinity key", func() {
+			clientTransport, err := NewTransport(clientKey, WithReusePolicy(ReusePerPeer))
+			Expect(err).ToNot(HaveOccurred())
+			ctx := WithDialAffinity(context.Background(), "hole punch")
+			conn1, err := clientTransport.Dial(ctx, serverAddr1, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn1.Close()
+			conn2, err := clientTransport.Dial(ctx, serverAddr2, serverID2)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn2.Close()
+			Expect(conn2.(*conn).LocalAddr()).To(Equal(conn1.(*conn).LocalAddr()))
+
+			// dials without an affinity key follow the reuse policy
+			conn3 := dial(clientTransport, serverAddr1, serverID)
+			defer conn3.Close()
+			conn4 := dial(clientTransport, serverAddr2, serverID2)
+			defer conn4.Close()
+			Expect(conn3.(*conn).LocalAddr()).ToNot(Equal(conn1.(*conn).LocalAddr()))
+			Expect(conn4.(*conn).LocalAddr()).ToNot(Equal(conn1.(*conn).LocalAddr()))
+			Expect(conn4.(*conn).LocalAddr()).ToNot(Equal(conn3.(*conn).LocalAddr()))
+
+			// dials using a different affinity key use a different socket
+			conn5, err := clientTransport.Dial(WithDialAffinity(context.Background(), "other"), serverAddr1, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn5.Close()
+			Expect(conn5.(*conn).LocalAddr()).ToNot(Equal(conn1.(*conn).LocalAddr()))
+		})
+
+		It("shares a socket between dials using the same affinity key when reuse is disabled", func() {
+			clientTransport, err := NewTransport(clientKey, DisableReuse())
+			Expect(err).ToNot(HaveOccurred())
+			ctx := WithDialAffinity(context.Background(), "hole punch")
+			conn1, err := clientTransport.Dial(ctx, serverAddr1, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn1.Close()
+			conn2, err := clientTransport.Dial(ctx, serverAddr2, serverID2)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn2.Close()
+			Expect(conn2.(*conn).LocalAddr()).To(Equal(conn1.(*conn).LocalAddr()))
+		})
+
+		It("reports the socket selected by a dial", func() {
+			type selection struct {
+				network string
+				laddr   *net.UDPAddr
+				reused  bool
+			}
+			selections := make(chan selection, 2)
+			clientTransport, err := NewTransport(clientKey, OnDialSocketSelected(func(network string, laddr *net.UDPAddr, reused bool) {
+				selections <- selection{network: network, laddr: laddr, reused: reused}
+			}))
+			Expect(err).ToNot(HaveOccurred())
+			conn1 := dial(clientTransport, serverAddr1, serverID)
+			defer conn1.Close()
+			var s selection
+			Expect(selections).To(Receive(&s))
+			Expect(s.network).To(Equal("udp4"))
+			Expect(s.laddr.Port).To(Equal(conn1.(*conn).LocalAddr().(*net.UDPAddr).Port))
+			Expect(s.reused).To(BeFalse())
+			conn2 := dial(clientTransport, serverAddr2, serverID2)
+			defer conn2.Close()
+			Expect(selections).To(Receive(&s))
+			Expect(s.laddr.Port).To(Equal(conn1.(*conn).LocalAddr().(*net.UDPAddr).Port))
+			Expect(s.reused).To(BeTrue())
+		})
+
+		It("rejects invalid policies", func() {
+			_, err := NewTransport(clientKey, WithReusePolicy(42))
+			Expect(err).To(MatchError("invalid reuse policy: 42"))
+		})
+	})
+
+	It("limits the pacing rate", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		c := clientConn.(*conn)
+		Expect(c.PacingRate()).To(BeZero())
+		c.SetMaxPacingRate(100 << 10) // 100 KB/s
+		Expect(c.PacingRate()).To(BeEquivalentTo(100 << 10))
+
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+		go func() {
+			defer GinkgoRecover()
+			sstr, err := serverConn.AcceptStream()
+			Expect(err).ToNot(HaveOccurred())
+			ioutil.ReadAll(sstr)
+		}()
+		str, err := clientConn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		start := time.Now()
+		n, err := str.Write(make([]byte, 50<<10))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(50 << 10))
+		// the first chunk is sent right away, the rest is spread out
+		Expect(time.Since(start)).To(BeNumerically(">", 400*time.Millisecond))
+		Expect(str.Close()).To(Succeed())
+
+		// removing the limit
+		c.SetMaxPacingRate(0)
+		Expect(c.PacingRate()).To(BeZero())
+	})
+
+	It("uses 1-RTT keys once the connection is established", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		Expect(clientConn.(*conn).EncryptionLevel()).To(Equal(Encryption1RTT))
+		Expect(clientConn.(*conn).Is1RTT()).To(BeTrue())
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+		Expect(serverConn.(*conn).Is1RTT()).To(BeTrue())
+	})
+
+	It("returns a diagnostic snapshot", func() {
+		serverTransport, err := NewTransport(serverKey, WithControlStreams())
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey, WithControlStreams())
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+		go func() {
+			defer GinkgoRecover()
+			str, err := serverConn.AcceptStream()
+			Expect(err).ToNot(HaveOccurred())
+			io.Copy(str, str)
+			str.Close()
+		}()
+
+		str, err := clientConn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = io.ReadFull(str, make([]byte, 6))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = clientConn.(*conn).Ping(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+
+		s := clientConn.(*conn).DiagnosticSnapshot()
+		Expect(s.LocalPeer).To(Equal(clientConn.LocalPeer()))
+		Expect(s.RemotePeer).To(Equal(serverID))
+		Expect(s.LocalMultiaddr).To(Equal(clientConn.LocalMultiaddr()))
+		Expect(s.RemoteMultiaddr.Equal(serverAddr)).To(BeTrue())
+		Expect(s.Version).ToNot(BeZero())
+		Expect(s.RTT).To(BeNumerically(">", 0))
+		Expect(s.Streams.OpenBidiStreams).To(Equal(1))
+		Expect(s.BytesSent).To(BeEquivalentTo(6))
+		Expect(s.BytesReceived).To(BeEquivalentTo(6))
+		Expect(s.Age).To(BeNumerically(">", 0))
+		Expect(s.EncryptionLevel).To(Equal(Encryption1RTT))
+		Expect(s.CloseError).ToNot(HaveOccurred())
+
+		Expect(clientConn.Close()).To(Succeed())
+		Eventually(clientConn.IsClosed).Should(BeTrue())
+		Expect(clientConn.(*conn).DiagnosticSnapshot().CloseError).To(HaveOccurred())
+	})
+
+	It("counts the bytes sent and received", func() {
+		const dataLen = 1 << 20
+		serverTransport, err := NewTransport(serverKey, WithControlStreams())
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey, WithControlStreams())
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+
+		// the handshake is counted on both sides
+		handshakeSent := clientConn.(*conn).WireBytesSent()
+		Expect(handshakeSent).To(BeNumerically(">=", 1200))
+		Expect(serverConn.(*conn).WireBytesReceived()).To(BeNumerically(">=", 1200))
+
+		received := make(chan []byte)
+		go func() {
+			defer GinkgoRecover()
+			str, err := serverConn.AcceptStream()
+			Expect(err).ToNot(HaveOccurred())
+			data, err := ioutil.ReadAll(str)
+			Expect(err).ToNot(HaveOccurred())
+			received <- data
+		}()
+		str, err := clientConn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write(make([]byte, dataLen))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.Close()).To(Succeed())
+		Eventually(received, 5*time.Second).Should(Receive(HaveLen(dataLen)))
+
+		ustr, err := clientConn.(*conn).OpenUniStream()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = ustr.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ustr.Close()).To(Succeed())
+		sstr, err := serverConn.(*conn).AcceptUniStream()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = io.ReadFull(sstr, make([]byte, 6))
+		Expect(err).ToNot(HaveOccurred())
+
+		c := clientConn.(*conn).DiagnosticSnapshot()
+		Expect(c.BytesSent).To(BeEquivalentTo(dataLen + 6))
+		// the overhead of QUIC is a few percent
+		Expect(c.WireBytesSent).To(And(
+			BeNumerically(">", handshakeSent+dataLen),
+			BeNumerically("<", handshakeSent+dataLen*11/10),
+		))
+		Expect(c.WireBytesReceived).To(BeNumerically(">", 0))
+		s := serverConn.(*conn).DiagnosticSnapshot()
+		Expect(s.BytesReceived).To(BeEquivalentTo(dataLen + 6))
+		Expect(s.WireBytesReceived).To(And(
+			BeNumerically(">", dataLen),
+			BeNumerically("<=", c.WireBytesSent),
+		))
+
+		stats := clientTransport.(*transport).Stats()
+		Expect(stats.BytesSent).To(BeEquivalentTo(dataLen + 6))
+		Expect(stats.WireBytesSent).To(Equal(c.WireBytesSent))
+		Expect(serverTransport.(*transport).Stats().BytesReceived).To(BeEquivalentTo(dataLen + 6))
+
+		// the counts of closed connections are kept
+		Expect(clientConn.Close()).To(Succeed())
+		Eventually(func() bool { return len(clientTransport.(*transport).allConns()) == 0 }).Should(BeTrue())
+		Expect(clientTransport.(*transport).Stats().WireBytesSent).To(BeNumerically(">=", c.WireBytesSent))
+	})
+
+	It("records the sizes of the datagrams sent", func() {
+		const dataLen = 1 << 20
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+
+		received := make(chan []byte)
+		go func() {
+			defer GinkgoRecover()
+			str, err := serverConn.AcceptStream()
+			Expect(err).ToNot(HaveOccurred())
+			data, err := ioutil.ReadAll(str)
+			Expect(err).ToNot(HaveOccurred())
+			received <- data
+		}()
+		str, err := clientConn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write(make([]byte, dataLen))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.Close()).To(Succeed())
+		Eventually(received, 5*time.Second).Should(Receive(HaveLen(dataLen)))
+
+		// The data is sent in full-sized packets, quic-go sends packets of up to 1252 bytes.
+		c := clientConn.(*conn).DiagnosticSnapshot().SentDatagramSizes
+		Expect(c.Bounds[5]).To(Equal(1280))
+		Expect(c.Counts[5]).To(BeNumerically(">", dataLen/1280))
+		Expect(c.Counts[6]).To(BeZero())
+		Expect(c.Counts[7]).To(BeZero())
+		// The receiver mostly sends small packets containing acknowledgements.
+		s := serverConn.(*conn).SentDatagramSizes()
+		Expect(s.Counts[0] + s.Counts[1]).To(BeNumerically(">", s.Total()/2))
+	})
+
+	It("reports the algorithms used by the handshake", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+
+		tls13Suites := []uint16{tls.TLS_AES_128_GCM_SHA256, tls.TLS_AES_256_GCM_SHA384, tls.TLS_CHACHA20_POLY1305_SHA256}
+		for _, c := range []tpt.CapableConn{clientConn, serverConn} {
+			algs := c.(*conn).HandshakeAlgorithms()
+			Expect(algs.TLSVersion).To(BeEquivalentTo(tls.VersionTLS13))
+			Expect(tls13Suites).To(ContainElement(algs.CipherSuite))
+			// the ephemeral key is a P-256 key, signed by the peer's RSA host key
+			Expect(algs.PeerSignatureScheme).To(Equal(tls.ECDSAWithP256AndSHA256))
+			Expect(algs.PeerCertificateSignature).To(Equal(x509.SHA256WithRSA))
+			// the transport doesn't restrict the key exchange groups
+			Expect(algs.KeyExchange).To(BeZero())
+		}
+		Expect(clientConn.(*conn).HandshakeAlgorithms().CipherSuite).To(Equal(serverConn.(*conn).HandshakeAlgorithms().CipherSuite))
+		Expect(clientConn.(*conn).DiagnosticSnapshot().Handshake).To(Equal(clientConn.(*conn).HandshakeAlgorithms()))
+	})
+
+	It("reports the external local address", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		external := ma.StringCast("/ip4/1.2.3.4/udp/4321/quic")
+		var mapped bool
+		clientTransport, err := NewTransport(clientKey, WithExternalAddrMapper(func(local, remote ma.Multiaddr) ma.Multiaddr {
+			if !mapped {
+				return nil
+			}
+			Expect(remote.Equal(serverAddr)).To(BeTrue())
+			return external
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		local := clientConn.LocalMultiaddr()
+		Expect(local.String()).To(HavePrefix("/ip4/0.0.0.0/udp/"))
+		mapped = true
+		Expect(clientConn.LocalMultiaddr().Equal(external)).To(BeTrue())
+		// the socket address is not affected
+		Expect(clientConn.(*conn).LocalAddr().(*net.UDPAddr).Port).ToNot(Equal(4321))
+		localAddr, err := toQuicMultiaddr(clientConn.(*conn).LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(localAddr.Equal(local)).To(BeTrue())
+	})
+
+	It("rejects a nil external address mapper", func() {
+		_, err := NewTransport(clientKey, WithExternalAddrMapper(nil))
+		Expect(err).To(MatchError("nil address mapper"))
+	})
+
+	Context("session sharing", func() {
+		It("opens streams of a second dial on the existing session", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithSessionSharing())
+			Expect(err).ToNot(HaveOccurred())
+			conn1, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn1.Close()
+			var serverConn tpt.CapableConn
+			Eventually(serverConnChan).Should(Receive(&serverConn))
+			conn2, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn2.Close()
+			Expect(conn2).To(BeAssignableToTypeOf(&sharedConn{}))
+			Expect(conn2.(*sharedConn).sess).To(Equal(conn1.(*conn).sess))
+			Consistently(serverConnChan).ShouldNot(Receive())
+
+			str, err := conn2.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = str.Write([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.Close()).To(Succeed())
+			sstr, err := serverConn.AcceptStream()
+			Expect(err).ToNot(HaveOccurred())
+			data, err := ioutil.ReadAll(sstr)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]byte("foobar")))
+		})
+
+		It("only closes the session once all views are closed", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithSessionSharing())
+			Expect(err).ToNot(HaveOccurred())
+			conn1, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			var serverConn tpt.CapableConn
+			Eventually(serverConnChan).Should(Receive(&serverConn))
+			conn2, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			conn3, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+
+			// closing a view doesn't affect the others
+			Expect(conn2.Close()).To(Succeed())
+			Expect(conn2.IsClosed()).To(BeTrue())
+			_, err = conn2.OpenStream()
+			Expect(err).To(HaveOccurred())
+			Expect(conn3.IsClosed()).To(BeFalse())
+			_, err = conn3.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+
+			// closing the connection the view was created from doesn't affect the view
+			Expect(conn1.Close()).To(Succeed())
+			_, err = conn3.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			Consistently(serverConn.IsClosed).Should(BeFalse())
+
+			Expect(conn3.Close()).To(Succeed())
+			Eventually(serverConn.IsClosed).Should(BeTrue())
+			Expect(conn1.IsClosed()).To(BeTrue())
+		})
+
+		It("establishes a new session if there's no connection to the peer", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithSessionSharing())
+			Expect(err).ToNot(HaveOccurred())
+			conn1, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(serverConnChan).Should(Receive())
+			Expect(conn1.Close()).To(Succeed())
+			Eventually(conn1.IsClosed).Should(BeTrue())
+
+			conn2, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn2.Close()
+			Expect(conn2).To(BeAssignableToTypeOf(&conn{}))
+			Eventually(serverConnChan).Should(Receive())
+		})
+	})
+
+	Context("connection pool", func() {
+		It("returns the pooled connection", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithConnPool(10, time.Minute))
+			Expect(err).ToNot(HaveOccurred())
+			conn1, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn1.Close()
+			var serverConn tpt.CapableConn
+			Eventually(serverConnChan).Should(Receive(&serverConn))
+			conn2, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(conn2).ToNot(BeIdenticalTo(conn1))
+			Expect(conn2.(*sharedConn).conn).To(BeIdenticalTo(conn1.(*sharedConn).conn))
+			Expect(clientTransport.(*transport).connPool.Len()).To(Equal(1))
+
+			// closing a handle doesn't close the connection for the other handles, or remove it from the pool
+			Expect(conn1.Close()).To(Succeed())
+			Expect(conn1.IsClosed()).To(BeTrue())
+			Expect(conn2.IsClosed()).To(BeFalse())
+			str, err := conn2.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.Close()).To(Succeed())
+			Expect(conn2.Close()).To(Succeed())
+			Expect(clientTransport.(*transport).connPool.Len()).To(Equal(1))
+
+			// closed connections are removed from the pool
+			Expect(serverConn.Close()).To(Succeed())
+			Eventually(clientTransport.(*transport).connPool.Len).Should(BeZero())
+			conn3, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn3.Close()
+			Expect(conn3.(*sharedConn).conn).ToNot(BeIdenticalTo(conn1.(*sharedConn).conn))
+		})
+
+		It("closes idle connections after the timeout, once all handles are closed", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+			go func() {
+				for {
+					if _, err := ln.Accept(); err != nil {
+						return
+					}
+				}
+			}()
+
+			clientTransport, err := NewTransport(clientKey, WithConnPool(10, 100*time.Millisecond))
+			Expect(err).ToNot(HaveOccurred())
+			idleConn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(idleConn.Close()).To(Succeed())
+			Eventually(idleConn.(*sharedConn).conn.IsClosed).Should(BeTrue())
+			Expect(clientTransport.(*transport).connPool.Len()).To(BeZero())
+
+			// connections that are still used are removed from the pool, but kept open
+			usedConn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(clientTransport.(*transport).connPool.Len).Should(BeZero())
+			Consistently(usedConn.IsClosed, 200*time.Millisecond).Should(BeFalse())
+			Expect(usedConn.Close()).To(Succeed())
+			Eventually(usedConn.(*sharedConn).conn.IsClosed).Should(BeTrue())
+
+			// connections with open streams are kept in the pool
+			busyConn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+			Expect(err).ToNot(HaveOccurred())
+			str, err := busyConn.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(busyConn.Close()).To(Succeed())
+			Consistently(busyConn.(*sharedConn).conn.IsClosed, 300*time.Millisecond).Should(BeFalse())
+			Expect(clientTransport.(*transport).connPool.Len()).To(Equal(1))
+			Expect(str.Close()).To(Succeed())
+			Eventually(busyConn.(*sharedConn).conn.IsClosed).Should(BeTrue())
+		})
+
+		It("evicts the least recently used connection when the pool is full", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+			otherKey, _, err := ic.GenerateECDSAKeyPair(rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			otherID, err := peer.IDFromPrivateKey(otherKey)
+			Expect(err).ToNot(HaveOccurred())
+			otherTransport, err := NewTransport(otherKey)
+			Expect(err).ToNot(HaveOccurred())
+			otherAddr, _ := runServer(otherTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithConnPool(1, time.Minute))
+			Expect(err).ToNot(HaveOccurred())
+			conn1, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			conn2, err := clientTransport.Dial(context.Background(), otherAddr, otherID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn2.Close()
+			Expect(clientTransport.(*transport).connPool.Len()).To(Equal(1))
+			// the evicted connection is still used, so it isn't closed
+			Consistently(conn1.IsClosed, 200*time.Millisecond).Should(BeFalse())
+			Expect(conn1.Close()).To(Succeed())
+			Eventually(conn1.(*sharedConn).conn.IsClosed).Should(BeTrue())
+			Expect(conn2.IsClosed()).To(BeFalse())
+		})
+
+		Context("warm pool", func() {
+			origRedialBackoff := warmPoolRedialBackoff
+
+			BeforeEach(func() {
+				warmPoolRedialBackoff = 10 * time.Millisecond
+			})
+
+			AfterEach(func() {
+				warmPoolRedialBackoff = origRedialBackoff
+			})
+
+			It("keeps connections to the peers open", func() {
+				serverTransport, err := NewTransport(serverKey)
+				Expect(err).ToNot(HaveOccurred())
+				ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+				Expect(err).ToNot(HaveOccurred())
+				defer ln.Close()
+				serverConns := make(chan tpt.CapableConn, 10)
+				go func() {
+					for {
+						c, err := ln.Accept()
+						if err != nil {
+							return
+						}
+						serverConns <- c
+					}
+				}()
+
+				clientTransport, err := NewTransport(clientKey, WithConnPool(10, 50*time.Millisecond))
+				Expect(err).ToNot(HaveOccurred())
+				t := clientTransport.(*transport)
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				Expect(t.WarmPool(ctx, []peer.AddrInfo{{ID: serverID, Addrs: []ma.Multiaddr{ln.Multiaddr()}}})).To(Succeed())
+				var serverConn tpt.CapableConn
+				Eventually(serverConns).Should(Receive(&serverConn))
+				Eventually(t.connPool.Len).Should(Equal(1))
+				// the connection isn't expired by the pool's idle timeout
+				Consistently(t.connPool.Len, 200*time.Millisecond).Should(Equal(1))
+				dialed, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+				Expect(err).ToNot(HaveOccurred())
+				conn1 := dialed.(*sharedConn).conn
+				Expect(dialed.Close()).To(Succeed())
+				Expect(conn1.IsClosed()).To(BeFalse())
+
+				// the peer is re-dialed when the connection fails
+				Expect(serverConn.Close()).To(Succeed())
+				Eventually(conn1.IsClosed).Should(BeTrue())
+				Eventually(serverConns).Should(Receive(&serverConn))
+				defer serverConn.Close()
+				Eventually(func() *sharedConn { return t.connPool.Get(serverID, nil) }).ShouldNot(BeNil())
+				view := t.connPool.Get(serverID, nil)
+				defer view.Close()
+				Expect(view.conn).ToNot(BeIdenticalTo(conn1))
+				Expect(view.IsClosed()).To(BeFalse())
+				Expect(t.connPool.Len()).To(Equal(1))
+			})
+
+			It("keeps connections open when session sharing is enabled", func() {
+				clientTransport, err := NewTransport(clientKey, WithConnPool(10, 50*time.Millisecond), WithSessionSharing())
+				Expect(err).ToNot(HaveOccurred())
+				clientAddr, clientConnChan := runServer(clientTransport, "/ip4/127.0.0.1/udp/0/quic")
+				// the server dials the client, so the client's connection to the server isn't pooled
+				serverTransport, err := NewTransport(serverKey)
+				Expect(err).ToNot(HaveOccurred())
+				serverConn, err := serverTransport.Dial(context.Background(), clientAddr, clientID)
+				Expect(err).ToNot(HaveOccurred())
+				defer serverConn.Close()
+				var accepted tpt.CapableConn
+				Eventually(clientConnChan).Should(Receive(&accepted))
+
+				t := clientTransport.(*transport)
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				// Dial returns a view on the accepted connection, so the address is never dialed
+				addrs := []ma.Multiaddr{ma.StringCast("/ip4/127.0.0.1/udp/1/quic")}
+				Expect(t.WarmPool(ctx, []peer.AddrInfo{{ID: serverID, Addrs: addrs}})).To(Succeed())
+				numViews := func() int {
+					c := accepted.(*conn)
+					c.viewsMutex.Lock()
+					defer c.viewsMutex.Unlock()
+					return c.numViews
+				}
+				Eventually(numViews).Should(Equal(1))
+				// the warm pool's view keeps the session open
+				Expect(accepted.Close()).To(Succeed())
+				Consistently(serverConn.IsClosed, 200*time.Millisecond).Should(BeFalse())
+				cancel()
+				Eventually(serverConn.IsClosed).Should(BeTrue())
+			})
+
+			It("requires a connection pool", func() {
+				clientTransport, err := NewTransport(clientKey)
+				Expect(err).ToNot(HaveOccurred())
+				err = clientTransport.(*transport).WarmPool(context.Background(), []peer.AddrInfo{{ID: serverID}})
+				Expect(err).To(MatchError("warm pool requires a connection pool, see WithConnPool"))
+			})
+		})
+
+		It("rejects invalid parameters", func() {
+			_, err := NewTransport(clientKey, WithConnPool(0, time.Minute))
+			Expect(err).To(MatchError("connection pool size must be positive"))
+			_, err = NewTransport(clientKey, WithConnPool(1, 0))
+			Expect(err).To(MatchError("connection pool idle timeout must be positive"))
+		})
+	})
+
+	Context("keep-alives", func() {
+		origSendKeepAlive := sendKeepAlive
+
+		AfterEach(func() {
+			sendKeepAlive = origSendKeepAlive
+		})
+
+		It("sends keep-alives at the configured period", func() {
+			var keepAlives int32
+			sendKeepAlive = func(ctx context.Context, c *conn) error {
+				atomic.AddInt32(&keepAlives, 1)
+				return origSendKeepAlive(ctx, c)
+			}
+			serverTransport, err := NewTransport(serverKey, WithControlStreams())
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithKeepAlivePeriod(50*time.Millisecond), WithControlStreams())
+			Expect(err).ToNot(HaveOccurred())
+			clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			time.Sleep(275 * time.Millisecond)
+			Expect(atomic.LoadInt32(&keepAlives)).To(And(BeNumerically(">=", 4), BeNumerically("<=", 6)))
+			// no more keep-alives are sent once the connection is closed
+			Expect(clientConn.Close()).To(Succeed())
+			Eventually(clientConn.IsClosed).Should(BeTrue())
+			n := atomic.LoadInt32(&keepAlives)
+			Consistently(func() int32 { return atomic.LoadInt32(&keepAlives) }, 200*time.Millisecond).Should(Equal(n))
+		})
+
+		It("rejects periods longer than the idle timeout", func() {
+			_, err := NewTransport(clientKey, WithKeepAlivePeriod(time.Minute))
+			Expect(err).To(MatchError("keep-alive period (1m0s) must be shorter than the idle timeout (30s)"))
+			_, err = NewTransport(clientKey, WithKeepAlivePeriod(0))
+			Expect(err).To(MatchError("keep-alive period must be positive"))
+		})
+	})
+
+	Context("adaptive idle timeouts", func() {
+		const idleTimeout = 300 * time.Millisecond
+		var clientConn, serverConn tpt.CapableConn
+
+		BeforeEach(func() {
+			// the server doesn't support control streams
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+			clientTransport, err := NewTransport(clientKey, WithAdaptiveIdleTimeout(idleTimeout))
+			Expect(err).ToNot(HaveOccurred())
+			clientConn, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(serverConnChan).Should(Receive(&serverConn))
+		})
+
+		AfterEach(func() {
+			clientConn.Close()
+			serverConn.Close()
+		})
+
+		It("closes connections without open streams after the idle timeout", func() {
+			start := time.Now()
+			Eventually(clientConn.IsClosed, 3*idleTimeout).Should(BeTrue())
+			Expect(time.Since(start)).To(BeNumerically(">=", idleTimeout*9/10))
+			Expect(clientConn.(*conn).CloseError().(*ClosedError).Reason).To(Equal(CloseReasonIdleTimeout))
+			Eventually(serverConn.IsClosed).Should(BeTrue())
+		})
+
+		It("keeps connections with open streams alive", func() {
+			str, err := clientConn.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = str.Write([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			sstr, err := serverConn.AcceptStream()
+			Expect(err).ToNot(HaveOccurred())
+			Consistently(clientConn.IsClosed, 4*idleTimeout).Should(BeFalse())
+			Expect(serverConn.IsClosed()).To(BeFalse())
+
+			// once the streams are closed, the connection times out
+			Expect(str.Reset()).To(Succeed())
+			Expect(sstr.Reset()).To(Succeed())
+			Eventually(clientConn.IsClosed, 3*idleTimeout).Should(BeTrue())
+		})
+
+		It("refuses invalid timeouts", func() {
+			_, err := newConfig(WithAdaptiveIdleTimeout(0))
+			Expect(err).To(MatchError("idle timeout must be positive"))
+			_, err = newConfig(WithAdaptiveIdleTimeout(time.Second), WithKeepAlivePeriod(100*time.Millisecond))
+			Expect(err).To(MatchError("an adaptive idle timeout can't be used with a keep-alive period"))
+		})
+	})
+
+	It("limits the bandwidth of all connections", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		go func() {
+			defer GinkgoRecover()
+			for serverConn := range serverConnChan {
+				go func(serverConn tpt.CapableConn) {
+					sstr, err := serverConn.AcceptStream()
+					if err != nil {
+						return
+					}
+					ioutil.ReadAll(sstr)
+				}(serverConn)
+			}
+		}()
+
+		clientTransport, err := NewTransport(clientKey, WithGlobalBandwidthLimit(100<<10)) // 100 KB/s
+		Expect(err).ToNot(HaveOccurred())
+		var wg sync.WaitGroup
+		start := time.Now()
+		for i := 0; i < 2; i++ {
+			clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer clientConn.Close()
+			str, err := clientConn.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				n, err := str.Write(make([]byte, 50<<10))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(50 << 10))
+				Expect(str.Close()).To(Succeed())
+			}()
+		}
+		wg.Wait()
+		// 100 KB were written in total, on two connections
+		Expect(time.Since(start)).To(BeNumerically(">", 800*time.Millisecond))
+	})
+
+	It("rejects a bandwidth limit of 0", func() {
+		_, err := NewTransport(clientKey, WithGlobalBandwidthLimit(0))
+		Expect(err).To(MatchError("bandwidth limit must be positive"))
+	})
+
+	Context("dual-stack sockets", func() {
+		It("accepts IPv4 and IPv6 connections on a single listener", func() {
+			serverTransport, err := NewTransport(serverKey, WithDualStack())
+			Expect(err).ToNot(HaveOccurred())
+			ln, err := serverTransport.Listen(ma.StringCast("/ip6/::/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+			port, err := ln.Multiaddr().ValueForProtocol(ma.P_UDP)
+			Expect(err).ToNot(HaveOccurred())
+
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			for _, addr := range []string{"/ip4/127.0.0.1/udp/" + port + "/quic", "/ip6/::1/udp/" + port + "/quic"} {
+				clientConn, err := clientTransport.Dial(context.Background(), ma.StringCast(addr), serverID)
+				Expect(err).ToNot(HaveOccurred())
+				defer clientConn.Close()
+				serverConn, err := ln.Accept()
+				Expect(err).ToNot(HaveOccurred())
+				defer serverConn.Close()
+				// the remote multiaddr uses the address family of the peer
+				remoteAddr, _ := ma.SplitFirst(serverConn.RemoteMultiaddr())
+				localAddr, _ := ma.SplitFirst(clientConn.LocalMultiaddr())
+				Expect(remoteAddr.Protocol().Code).To(Equal(ma.StringCast(addr).Protocols()[0].Code))
+				Expect(localAddr.Protocol().Code).To(Equal(remoteAddr.Protocol().Code))
+			}
+		})
+
+		It("dials IPv4 and IPv6 peers from a single socket", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr4, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+			serverAddr6, _ := runServer(serverTransport, "/ip6/::1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithDualStack())
+			Expect(err).ToNot(HaveOccurred())
+			conn4, err := clientTransport.Dial(context.Background(), serverAddr4, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn4.Close()
+			conn6, err := clientTransport.Dial(context.Background(), serverAddr6, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn6.Close()
+			Expect(conn4.(*conn).LocalAddr()).To(Equal(conn6.(*conn).LocalAddr()))
+		})
+
+		It("can't be combined with a source IP", func() {
+			_, err := NewTransport(clientKey, WithDualStack(), WithSourceIP(net.ParseIP("127.0.0.1")))
+			Expect(err).To(MatchError("a source IP can't be used with a dual-stack socket"))
+		})
+	})
+
+	It("warns when the peer's certificate is about to expire", func() {
+		origCertValidityPeriod := certValidityPeriod
+		defer func() { certValidityPeriod = origCertValidityPeriod }()
+		certValidityPeriod = time.Hour
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		certValidityPeriod = origCertValidityPeriod
+		serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		type warning struct {
+			peer   peer.ID
+			expiry time.Time
+		}
+		warnings := make(chan warning, 1)
+		clientTransport, err := NewTransport(clientKey, WithPeerCertExpiryWarning(2*time.Hour, func(p peer.ID, expiry time.Time) {
+			warnings <- warning{peer: p, expiry: expiry}
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		expiry := clientConn.(*conn).PeerCertExpiry()
+		Expect(expiry).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+		var w warning
+		Eventually(warnings).Should(Receive(&w))
+		Expect(w.peer).To(Equal(serverID))
+		Expect(w.expiry).To(Equal(expiry))
+
+		// no warning if the certificate is valid for longer than the window
+		clientTransport2, err := NewTransport(clientKey, WithPeerCertExpiryWarning(30*time.Minute, func(p peer.ID, expiry time.Time) {
+			warnings <- warning{peer: p, expiry: expiry}
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		clientConn2, err := clientTransport2.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn2.Close()
+		Consistently(warnings).ShouldNot(Receive())
+	})
+
+	It("emits lifecycle events", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		events, unsubscribe := clientTransport.(*transport).Subscribe()
+		defer unsubscribe()
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		str, err := clientConn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.Close()).To(Succeed())
+		Expect(clientConn.Close()).To(Succeed())
+		<-serverConnChan
+
+		var types []EventType
+		for i := 0; i < 6; i++ {
+			var ev Event
+			Eventually(events).Should(Receive(&ev))
+			Expect(ev.Peer).To(Equal(serverID))
+			Expect(ev.Addr).To(Equal(serverAddr))
+			types = append(types, ev.Type)
+		}
+		Expect(types).To(Equal([]EventType{
+			EventDialStarted,
+			EventConnOpened,
+			EventDialSucceeded,
+			EventStreamOpened,
+			EventStreamClosed,
+			EventConnClosed,
+		}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = clientTransport.Dial(ctx, serverAddr, serverID)
+		Expect(err).To(HaveOccurred())
+		var ev Event
+		Eventually(events).Should(Receive(&ev))
+		Expect(ev.Type).To(Equal(EventDialStarted))
+		Eventually(events).Should(Receive(&ev))
+		Expect(ev.Type).To(Equal(EventDialFailed))
+		Expect(ev.Err).To(MatchError(err))
+	})
+
+	Context("reporting why connections were closed", func() {
+		nextClosedEvent := func(events <-chan Event) Event {
+			var ev Event
+			Eventually(func() EventType {
+				select {
+				case ev = <-events:
+					return ev.Type
+				default:
+					return 0
+				}
+			}).Should(Equal(EventConnClosed))
+			return ev
+		}
+
+		connect := func(serverTransport, clientTransport tpt.Transport) (tpt.CapableConn, tpt.CapableConn) {
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+			clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			var serverConn tpt.CapableConn
+			Eventually(serverConnChan).Should(Receive(&serverConn))
+			return serverConn, clientConn
+		}
+
+		It("reports local and remote closes", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverEvents, unsubscribe := serverTransport.(*transport).Subscribe()
+			defer unsubscribe()
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			clientEvents, unsubscribe := clientTransport.(*transport).Subscribe()
+			defer unsubscribe()
+			_, clientConn := connect(serverTransport, clientTransport)
+
+			Expect(clientConn.(*conn).closeWithError(0x42, errors.New("going away"))).To(Succeed())
+			ev := nextClosedEvent(clientEvents)
+			Expect(ev.CloseReason).To(Equal(CloseReasonLocal))
+			Expect(ev.Peer).To(Equal(serverID))
+			ev = nextClosedEvent(serverEvents)
+			Expect(ev.CloseReason).To(Equal(CloseReasonRemote))
+			Expect(ev.Peer).To(Equal(clientID))
+			Expect(ev.Err).To(BeAssignableToTypeOf(&ClosedError{}))
+			Expect(ev.Err.(*ClosedError).ErrorCode).To(Equal(quic.ErrorCode(0x42)))
+			Expect(ev.Err.(*ClosedError).ReasonPhrase).To(Equal("going away"))
+		})
+
+		It("reports transport errors", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverEvents, unsubscribe := serverTransport.(*transport).Subscribe()
+			defer unsubscribe()
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			_, clientConn := connect(serverTransport, clientTransport)
+
+			// PROTOCOL_VIOLATION
+			Expect(clientConn.(*conn).closeWithError(0xa, errors.New("protocol violation"))).To(Succeed())
+			ev := nextClosedEvent(serverEvents)
+			Expect(ev.CloseReason).To(Equal(CloseReasonTransportError))
+		})
+
+		It("reports idle timeouts", func() {
+			// the server abandons the connection without notifying the client
+			serverTransport, err := NewTransport(serverKey, WithConnectionClose(false))
+			Expect(err).ToNot(HaveOccurred())
+			origQuicConfig := quicConfig
+			defer func() { quicConfig = origQuicConfig }()
+			conf := *quicConfig
+			conf.IdleTimeout = 300 * time.Millisecond
+			conf.KeepAlive = false
+			quicConfig = &conf
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			quicConfig = origQuicConfig
+			clientEvents, unsubscribe := clientTransport.(*transport).Subscribe()
+			defer unsubscribe()
+			serverConn, _ := connect(serverTransport, clientTransport)
+
+			Expect(serverConn.Close()).To(Succeed())
+			ev := nextClosedEvent(clientEvents)
+			Expect(ev.CloseReason).To(Equal(CloseReasonIdleTimeout))
+		})
+	})
+
+	It("counts migrations", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		c := clientConn.(*conn)
+		// the connection didn't change paths
+		Expect(c.Migrations()).To(BeZero())
+
+		// simulate path changes, as reported by a path validation
+		c.pathChanged()
+		c.pathChanged()
+		Expect(c.Migrations()).To(Equal(2))
+	})
+
+	Context("checking the path after dialing", func() {
+		origListenUDP := listenUDP
+
+		AfterEach(func() {
+			listenUDP = origListenUDP
+		})
+
+		It("returns the connection if the path works in both directions", func() {
+			serverTransport, err := NewTransport(serverKey, WithControlStreams())
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithPostDialPathCheck(time.Second), WithControlStreams())
+			Expect(err).ToNot(HaveOccurred())
+			clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(clientConn.Close()).To(Succeed())
+		})
+
+		It("fails the dial if packets from the peer get lost after the handshake", func() {
+			listenUDP = func(network string, laddr *net.UDPAddr) (net.PacketConn, error) {
+				conn, err := origListenUDP(network, laddr)
+				if err != nil {
+					return nil, err
+				}
+				return &oneWayConn{PacketConn: conn}, nil
+			}
+			serverTransport, err := NewTransport(serverKey, WithControlStreams())
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithPostDialPathCheck(200*time.Millisecond), WithControlStreams())
+			Expect(err).ToNot(HaveOccurred())
+			_, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).To(MatchError(ErrPathNotValidated))
+			Eventually(clientTransport.(*transport).ReservedMemory).Should(BeZero())
+		})
+
+		It("rejects a non-positive timeout", func() {
+			_, err := NewTransport(clientKey, WithPostDialPathCheck(0))
+			Expect(err).To(MatchError("path check timeout must be positive"))
+		})
+	})
+
+	It("reports that datagrams were not negotiated", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+		// quic-go doesn't support datagrams yet, so they can't be enabled
+		_, err = clientConn.(*conn).MaxDatagramSize()
+		Expect(err).To(MatchError(ErrDatagramsNotNegotiated))
+		_, err = serverConn.(*conn).MaxDatagramSize()
+		Expect(err).To(MatchError(ErrDatagramsNotNegotiated))
+		Expect(clientConn.(*conn).SupportsDatagrams()).To(BeFalse())
+		Expect(serverConn.(*conn).SupportsDatagrams()).To(BeFalse())
+		Expect(clientConn.(*conn).SupportsExtension(ExtensionDatagrams)).To(BeFalse())
+		Expect(clientConn.(*conn).SupportsExtension("unknown")).To(BeFalse())
+	})
+
+	It("returns the connection IDs chosen by the peer", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+		// connection IDs are between 4 and 18 bytes long
+		Expect(len(clientConn.(*conn).RemoteConnectionID())).To(And(BeNumerically(">=", 4), BeNumerically("<=", 18)))
+		Expect(len(serverConn.(*conn).RemoteConnectionID())).To(And(BeNumerically(">=", 4), BeNumerically("<=", 18)))
+		Expect(clientConn.(*conn).RemoteConnectionID()).ToNot(Equal(serverConn.(*conn).RemoteConnectionID()))
+	})
+
+	Context("dialing with a version list", func() {
+		const draft19 quic.VersionNumber = 0xff000013
+		origQuicDialContext := quicDialContext
+
+		AfterEach(func() {
+			quicDialContext = origQuicDialContext
+		})
+
+		It("offers the configured versions", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			var offered []quic.VersionNumber
+			quicDialContext = func(ctx context.Context, pconn net.PacketConn, addr net.Addr, host string, tlsConf *tls.Config, config *quic.Config) (quic.Session, error) {
+				offered = config.Versions
+				return origQuicDialContext(ctx, pconn, addr, host, tlsConf, config)
+			}
+			clientTransport, err := NewTransport(clientKey, WithDialVersions([]quic.VersionNumber{draft19}))
+			Expect(err).ToNot(HaveOccurred())
+			clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer clientConn.Close()
+			Expect(offered).To(Equal([]quic.VersionNumber{draft19}))
+			Expect(clientConn.(*conn).Version()).To(Equal(draft19))
+			var serverConn tpt.CapableConn
+			Eventually(serverConnChan).Should(Receive(&serverConn))
+			Expect(serverConn.(*conn).Version()).To(Equal(draft19))
+		})
+
+		It("fails to dial when offering unsupported versions", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithDialVersions([]quic.VersionNumber{0x42}))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).To(MatchError(ContainSubstring("not a valid QUIC version")))
+		})
+
+		It("uses /quic in the multiaddrs, since all supported versions are identified by it", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+			clientTransport, err := NewTransport(clientKey, WithDialVersions([]quic.VersionNumber{draft19}))
+			Expect(err).ToNot(HaveOccurred())
+			clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer clientConn.Close()
+			Expect(clientConn.RemoteMultiaddr().Equal(serverAddr)).To(BeTrue())
+			Expect(clientConn.LocalMultiaddr().String()).To(HaveSuffix("/quic"))
+			var serverConn tpt.CapableConn
+			Eventually(serverConnChan).Should(Receive(&serverConn))
+			Expect(serverConn.LocalMultiaddr().Equal(serverAddr)).To(BeTrue())
+			Expect(serverConn.RemoteMultiaddr().String()).To(HaveSuffix("/quic"))
+		})
+
+		It("rejects an empty version list", func() {
+			_, err := NewTransport(clientKey, WithDialVersions(nil))
+			Expect(err).To(MatchError("no QUIC versions"))
+		})
+	})
+
+	It("reports if the handshake exceeded the anti-amplification limit", func() {
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+
+		// a small certificate chain fits into the limit
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+		Expect(serverConn.(*conn).AmplificationLimited()).To(BeFalse())
+		Expect(clientConn.(*conn).AmplificationLimited()).To(BeFalse())
+
+		// a certificate with many DNS names makes the server's flight large
+		var names []string
+		for i := 0; i < 200; i++ {
+			names = append(names, fmt.Sprintf("host-%d.a-rather-long-domain-name.example.com", i))
+		}
+		serverTransport, err = NewTransport(serverKey, WithDNSNames(names...))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan = runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		conn2, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn2.Close()
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+		Expect(serverConn.(*conn).AmplificationLimited()).To(BeTrue())
+	})
+
+	It("times handshakes", func() {
+		serverTransport, err := NewTransport(serverKey, WithHandshakeTiming())
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		clientTransport, err := NewTransport(clientKey, WithHandshakeTiming())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(clientTransport.(*transport).Stats().TimedHandshakes).To(BeZero())
+
+		for i := 0; i < 3; i++ {
+			conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			Eventually(serverConnChan).Should(Receive())
+		}
+		for _, tr := range []tpt.Transport{clientTransport, serverTransport} {
+			stats := tr.(*transport).Stats()
+			Expect(stats.TimedHandshakes).To(BeEquivalentTo(3))
+			Expect(stats.HandshakeTime).To(BeNumerically(">", 0))
+			Expect(stats.VerificationTime).To(And(BeNumerically(">", 0), BeNumerically("<", stats.HandshakeTime)))
+			Expect(stats.AverageHandshakeTime()).To(Equal(stats.HandshakeTime / 3))
+			Expect(stats.AverageVerificationTime()).To(Equal(stats.VerificationTime / 3))
+		}
+	})
+
+	Context("using a closed connection", func() {
+		It("returns ErrConnClosed when opening or accepting streams", func() {
+			serverTransport, err := NewTransport(serverKey, WithControlStreams())
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithControlStreams())
+			Expect(err).ToNot(HaveOccurred())
+			clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			var serverConn tpt.CapableConn
+			Eventually(serverConnChan).Should(Receive(&serverConn))
+
+			Expect(clientConn.Close()).To(Succeed())
+			_, err = clientConn.OpenStream()
+			Expect(errors.Is(err, ErrConnClosed)).To(BeTrue())
+			Expect(err.(*ClosedError).Reason).To(Equal(CloseReasonLocal))
+			_, err = clientConn.AcceptStream()
+			Expect(errors.Is(err, ErrConnClosed)).To(BeTrue())
+			_, err = clientConn.(*conn).OpenUniStream()
+			Expect(errors.Is(err, ErrConnClosed)).To(BeTrue())
+			_, err = clientConn.(*conn).AcceptUniStream()
+			Expect(errors.Is(err, ErrConnClosed)).To(BeTrue())
+
+			Eventually(serverConn.IsClosed).Should(BeTrue())
+			_, err = serverConn.OpenStream()
+			Expect(errors.Is(err, ErrConnClosed)).To(BeTrue())
+			Expect(err.(*ClosedError).Reason).To(Equal(CloseReasonRemote))
+			_, err = serverConn.AcceptStream()
+			Expect(errors.Is(err, ErrConnClosed)).To(BeTrue())
+		})
+
+		It("returns ErrConnClosed when opening streams races with closing", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(serverConnChan).Should(Receive())
+
+			var wg sync.WaitGroup
+			errChan := make(chan error, 10)
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						str, err := clientConn.OpenStream()
+						if err != nil {
+							errChan <- err
+							return
+						}
+						str.Reset()
+					}
+				}()
+			}
+			time.Sleep(10 * time.Millisecond)
+			Expect(clientConn.Close()).To(Succeed())
+			wg.Wait()
+			close(errChan)
+			for err := range errChan {
+				Expect(errors.Is(err, ErrConnClosed)).To(BeTrue(), fmt.Sprintf("unexpected error: %v", err))
+			}
+		})
+	})
+
+	It("says why a connection was closed", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+		Expect(clientConn.(*conn).CloseError()).ToNot(HaveOccurred())
+
+		Expect(clientConn.Close()).To(Succeed())
+		Expect(clientConn.(*conn).CloseError()).To(BeAssignableToTypeOf(&ClosedError{}))
+		Expect(clientConn.(*conn).CloseError().(*ClosedError).Reason).To(Equal(CloseReasonLocal))
+		Eventually(serverConn.IsClosed).Should(BeTrue())
+		Expect(serverConn.(*conn).CloseError().(*ClosedError).Reason).To(Equal(CloseReasonRemote))
+	})
+
+	It("detects when the client's NAT mapping changes", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		events, unsubscribe := serverTransport.(*transport).Subscribe()
+		defer unsubscribe()
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		udpAddr, err := fromQuicMultiaddr(serverAddr)
+		Expect(err).ToNot(HaveOccurred())
+		proxy, err := newRebindingProxy(udpAddr.(*net.UDPAddr))
+		Expect(err).ToNot(HaveOccurred())
+		defer proxy.Close()
+		proxyAddr, err := toQuicMultiaddr(proxy.LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), proxyAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+		pathChanges := func() []Event {
+			var evs []Event
+			for {
+				select {
+				case ev := <-events:
+					if ev.Type == EventPathChanged {
+						evs = append(evs, ev)
+					}
+				default:
+					return evs
+				}
+			}
+		}
+		str, err := clientConn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		Consistently(pathChanges).Should(BeEmpty())
+
+		newAddr, err := proxy.Rebind()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		var changes []Event
+		Eventually(func() []Event {
+			changes = append(changes, pathChanges()...)
+			return changes
+		}).Should(HaveLen(1))
+		Expect(changes[0].Peer).To(Equal(clientID))
+		expectedAddr, err := toQuicMultiaddr(newAddr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes[0].Addr).To(Equal(expectedAddr))
+		Expect(serverConn.(*conn).Migrations()).To(Equal(1))
+	})
+
+	Context("close callbacks", func() {
+		It("calls the callbacks when the connection is closed locally", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			reasons := make(chan error, 10)
+			clientConn.(*conn).OnClose(func(reason error) { reasons <- reason })
+			clientConn.(*conn).OnClose(func(reason error) { reasons <- reason })
+			Consistently(reasons, 50*time.Millisecond).ShouldNot(Receive())
+
+			Expect(clientConn.Close()).To(Succeed())
+			for i := 0; i < 2; i++ {
+				var reason error
+				Eventually(reasons).Should(Receive(&reason))
+				Expect(reason.(*ClosedError).Reason).To(Equal(CloseReasonLocal))
+			}
+			Consistently(reasons, 50*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("calls the callbacks when the peer closes the connection", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			var serverConn tpt.CapableConn
+			Eventually(serverConnChan).Should(Receive(&serverConn))
+			reasons := make(chan error, 1)
+			serverConn.(*conn).OnClose(func(reason error) { reasons <- reason })
+
+			Expect(clientConn.Close()).To(Succeed())
+			var reason error
+			Eventually(reasons).Should(Receive(&reason))
+			Expect(reason.(*ClosedError).Reason).To(Equal(CloseReasonRemote))
+		})
+
+		It("calls callbacks registered after the connection was closed right away", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			done := make(chan struct{})
+			clientConn.(*conn).OnClose(func(error) { close(done) })
+			Expect(clientConn.Close()).To(Succeed())
+			Eventually(done).Should(BeClosed())
+
+			var called bool
+			clientConn.(*conn).OnClose(func(error) { called = true })
+			Expect(called).To(BeTrue())
+		})
+	})
+
+	Context("flushing streams", func() {
+		var clientConn, serverConn tpt.CapableConn
+
+		BeforeEach(func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			clientConn, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(serverConnChan).Should(Receive(&serverConn))
+		})
+
+		AfterEach(func() {
+			clientConn.Close()
+			serverConn.Close()
+		})
+
+		It("returns once the peer read the data", func() {
+			str, err := clientConn.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = str.Write([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			flushed := make(chan error, 1)
+			go func() {
+				defer GinkgoRecover()
+				flushed <- str.(*stream).Flush(context.Background())
+			}()
+
+			sstr, err := serverConn.AcceptStream()
+			Expect(err).ToNot(HaveOccurred())
+			Consistently(flushed).ShouldNot(Receive())
+			data, err := ioutil.ReadAll(sstr)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]byte("foobar")))
+			Expect(sstr.Close()).To(Succeed())
+			Eventually(flushed).Should(Receive(BeNil()))
+		})
+
+		It("stops waiting when the context is done", func() {
+			str, err := clientConn.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = str.Write([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			Expect(str.(*stream).Flush(ctx)).To(MatchError(context.DeadlineExceeded))
+		})
+	})
+
+	It("unblocks stream reads when the connection is closed", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+		str, err := clientConn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		sstr, err := serverConn.AcceptStream()
+		Expect(err).ToNot(HaveOccurred())
+
+		readErr := make(chan error, 1)
+		go func() {
+			defer GinkgoRecover()
+			_, err := ioutil.ReadAll(sstr)
+			readErr <- err
+		}()
+		Consistently(readErr).ShouldNot(Receive())
+		Expect(serverConn.Close()).To(Succeed())
+		var err2 error
+		Eventually(readErr, 200*time.Millisecond).Should(Receive(&err2))
+		Expect(err2).To(BeAssignableToTypeOf(&ClosedError{}))
+		Expect(err2.(*ClosedError).Reason).To(Equal(CloseReasonLocal))
+
+		// the peer's blocked reads return as well
+		_, err = ioutil.ReadAll(str)
+		Expect(err).To(BeAssignableToTypeOf(&ClosedError{}))
+		Expect(err.(*ClosedError).Reason).To(Equal(CloseReasonRemote))
+	})
+
+	It("returns the application error the peer closed the connection with", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+
+		Expect(clientConn.(*conn).closeWithError(0x42, errors.New("going away"))).To(Succeed())
+		Eventually(serverConn.IsClosed).Should(BeTrue())
+		closeErr := serverConn.(*conn).CloseError().(*ClosedError)
+		Expect(closeErr.Reason).To(Equal(CloseReasonRemote))
+		Expect(closeErr.ErrorCode).To(Equal(quic.ErrorCode(0x42)))
+		Expect(closeErr.ReasonPhrase).To(Equal("going away"))
+	})
+
+	It("reads the error code and message of quic-go's error type", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+
+		Expect(clientConn.(*conn).closeWithError(0x42, errors.New("going away"))).To(Succeed())
+		Eventually(serverConn.IsClosed).Should(BeTrue())
+		// quicErrorCode and quicErrorMessage use reflection to read quic-go's unexported error type.
+		// If quic-go renames or changes these fields, this test fails.
+		sessErr := serverConn.(*conn).CloseError().(*ClosedError).Err
+		_, ok := quicErrorField(sessErr, "ErrorCode", reflect.Uint16)
+		Expect(ok).To(BeTrue(), fmt.Sprintf("%T doesn't have an ErrorCode field", sessErr))
+		_, ok = quicErrorField(sessErr, "ErrorMessage", reflect.String)
+		Expect(ok).To(BeTrue(), fmt.Sprintf("%T doesn't have an ErrorMessage field", sessErr))
+		code, ok := quicErrorCode(sessErr)
+		Expect(ok).To(BeTrue())
+		Expect(code).To(Equal(quic.ErrorCode(0x42)))
+		Expect(quicErrorMessage(sessErr)).To(Equal("going away"))
+	})
+
+	It("dials from the configured source IP", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey, WithSourceIP(net.ParseIP("127.0.0.2")))
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+		Expect(serverConn.(*conn).RemoteAddr().(*net.UDPAddr).IP.Equal(net.ParseIP("127.0.0.2"))).To(BeTrue())
+	})
+
+	It("calls the callback when the peer ID is verified", func() {
+		type verifiedPeer struct {
+			p      peer.ID
+			remote net.Addr
+		}
+		serverVerified := make(chan verifiedPeer, 1)
+		serverTransport, err := NewTransport(serverKey, OnPeerVerified(func(p peer.ID, remote net.Addr) {
+			serverVerified <- verifiedPeer{p: p, remote: remote}
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientVerified := make(chan verifiedPeer, 1)
+		clientTransport, err := NewTransport(clientKey, OnPeerVerified(func(p peer.ID, remote net.Addr) {
+			clientVerified <- verifiedPeer{p: p, remote: remote}
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+
+		var v verifiedPeer
+		Expect(clientVerified).To(Receive(&v))
+		Expect(v.p).To(Equal(serverID))
+		Expect(v.remote.String()).To(Equal(clientConn.(*conn).RemoteAddr().String()))
+		Expect(serverVerified).To(Receive(&v))
+		Expect(v.p).To(Equal(clientID))
+		Expect(v.remote.String()).To(Equal(serverConn.(*conn).RemoteAddr().String()))
+	})
+
+	It("resets streams that don't fit into the accept queue", func() {
+		serverTransport, err := NewTransport(serverKey, WithStreamAcceptQueueDepth(3))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+
+		readErrs := make(chan error, 5)
+		for i := 0; i < 5; i++ {
+			str, err := clientConn.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = str.Write([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			go func() {
+				_, err := str.Read(make([]byte, 1))
+				readErrs <- err
+			}()
+		}
+		// the streams beyond the queue depth are reset
+		for i := 0; i < 2; i++ {
+			var err error
+			Eventually(readErrs).Should(Receive(&err))
+			Expect(err).To(HaveOccurred())
+		}
+		Consistently(readErrs).ShouldNot(Receive())
+		// the queued streams can still be accepted
+		for i := 0; i < 3; i++ {
+			str, err := serverConn.AcceptStream()
+			Expect(err).ToNot(HaveOccurred())
+			data := make([]byte, 6)
+			_, err = io.ReadFull(str, data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]byte("foobar")))
+		}
+	})
+
+	Context("qlog", func() {
+		var qlogDir string
+
+		BeforeEach(func() {
+			var err error
+			qlogDir, err = ioutil.TempDir("", "qlog")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(qlogDir)).To(Succeed())
+		})
+
+		It("writes a qlog file for every connection", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithQlogDir(qlogDir))
+			Expect(err).ToNot(HaveOccurred())
+			clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(clientConn.Close()).To(Succeed())
+
+			var files []os.FileInfo
+			Eventually(func() []os.FileInfo {
+				files, err = ioutil.ReadDir(qlogDir)
+				Expect(err).ToNot(HaveOccurred())
+				return files
+			}).Should(HaveLen(1))
+			Expect(files[0].Name()).To(HavePrefix(serverID.Pretty() + "_"))
+			Expect(files[0].Name()).To(HaveSuffix(".qlog"))
+			Eventually(func() string {
+				data, err := ioutil.ReadFile(filepath.Join(qlogDir, files[0].Name()))
+				Expect(err).ToNot(HaveOccurred())
+				return string(data)
+			}).Should(And(
+				ContainSubstring("transport:connection_started"),
+				ContainSubstring("transport:connection_closed"),
+			))
+		})
+
+		It("limits the number of qlog files", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+
+			clientTransport, err := NewTransport(clientKey, WithQlogDir(qlogDir))
+			Expect(err).ToNot(HaveOccurred())
+			clientTransport.(*transport).qlogger.maxFiles = 2
+			for i := 0; i < 4; i++ {
+				clientConn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+				Expect(err).ToNot(HaveOccurred())
+				defer clientConn.Close()
+			}
+			files, err := ioutil.ReadDir(qlogDir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(HaveLen(2))
+		})
+
+		It("refuses a qlog directory that doesn't exist", func() {
+			_, err := NewTransport(clientKey, WithQlogDir(filepath.Join(qlogDir, "foobar")))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("records the timings of establishing a connection", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		timings := clientConn.(*conn).EstablishmentTimings()
+		Expect(timings).ToNot(BeNil())
+		Expect(timings.SocketReady).ToNot(BeTemporally("<", timings.Start))
+		Expect(timings.FirstPacketReceived).ToNot(BeTemporally("<", timings.SocketReady))
+		Expect(timings.HandshakeComplete).ToNot(BeTemporally("<", timings.FirstPacketReceived))
+		Expect(timings.SocketSetup()).To(BeNumerically(">=", 0))
+		Expect(timings.FirstFlight()).To(BeNumerically(">=", 0))
+		Expect(timings.Handshake()).To(BeNumerically(">=", timings.FirstFlight()))
+
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+		Expect(serverConn.(*conn).EstablishmentTimings()).To(BeNil())
+	})
+
+	It("records when a connection was opened", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		start := time.Now()
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		Expect(clientConn.(*conn).OpenedAt()).To(BeTemporally(">=", start))
+		Expect(clientConn.(*conn).OpenedAt()).To(BeTemporally("<=", time.Now()))
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+		Expect(serverConn.(*conn).OpenedAt()).To(BeTemporally(">=", start))
+
+		age := clientConn.(*conn).Age()
+		time.Sleep(20 * time.Millisecond)
+		Expect(clientConn.(*conn).Age()).To(BeNumerically(">=", age+20*time.Millisecond))
+		Expect(serverConn.(*conn).Age()).To(BeNumerically(">=", 20*time.Millisecond))
+	})
+
+	Context("validating the SNI", func() {
+		It("accepts clients using the expected server name", func() {
+			serverTransport, err := NewTransport(serverKey, WithDNSNames("example.com"), WithSNIValidation())
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithDNSNames("example.com"))
+			Expect(err).ToNot(HaveOccurred())
+			clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer clientConn.Close()
+			Eventually(serverConnChan).Should(Receive())
+		})
+
+		It("rejects clients using a different server name", func() {
+			serverTransport, err := NewTransport(serverKey, WithDNSNames("example.com"), WithSNIValidation())
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithDNSNames("other.example.com"))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).To(HaveOccurred())
+			Consistently(serverConnChan).ShouldNot(Receive())
+		})
+	})
+
+	Context("reporting rejected handshakes", func() {
+		var rejections chan HandshakeRejection
+
+		BeforeEach(func() {
+			rejections = make(chan HandshakeRejection, 10)
+		})
+
+		reportRejections := func() Option {
+			return OnHandshakeRejected(func(r HandshakeRejection) { rejections <- r })
+		}
+
+		listen := func(tr tpt.Transport) tpt.Listener {
+			ln, err := tr.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			return ln
+		}
+
+		It("reports peer ID mismatches", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			ln := listen(serverTransport)
+			defer ln.Close()
+
+			clientTransport, err := NewTransport(clientKey, reportRejections())
+			Expect(err).ToNot(HaveOccurred())
+			thirdPartyID, _ := createPeer()
+			_, err = clientTransport.Dial(context.Background(), ln.Multiaddr(), thirdPartyID)
+			Expect(err).To(HaveOccurred())
+			var r HandshakeRejection
+			Expect(rejections).To(Receive(&r))
+			Expect(r.Reason).To(Equal(RejectReasonPeerIDMismatch))
+			Expect(r.RemoteAddr.String()).To(Equal(ln.Addr().String()))
+			Expect(r.Err).To(MatchError("peer IDs don't match"))
+		})
+
+		It("reports invalid certificates", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			invalidateCertChain(serverTransport.(*transport).tlsConf)
+			ln := listen(serverTransport)
+			defer ln.Close()
+
+			clientTransport, err := NewTransport(clientKey, reportRejections())
+			Expect(err).ToNot(HaveOccurred())
+			_, err = clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+			Expect(err).To(HaveOccurred())
+			var r HandshakeRejection
+			Expect(rejections).To(Receive(&r))
+			Expect(r.Reason).To(Equal(RejectReasonInvalidCertificate))
+			Expect(r.RemoteAddr.String()).To(Equal(ln.Addr().String()))
+		})
+
+		It("reports keys that are too large", func() {
+			serverTransport, err := NewTransport(serverKey, WithMaxRSAKeySize(1024), reportRejections())
+			Expect(err).ToNot(HaveOccurred())
+			ln := listen(serverTransport)
+			defer ln.Close()
+
+			rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).ToNot(HaveOccurred())
+			largeKey, err := ic.UnmarshalRsaPrivateKey(x509.MarshalPKCS1PrivateKey(rsaKey))
+			Expect(err).ToNot(HaveOccurred())
+			clientTransport, err := NewTransport(largeKey)
+			Expect(err).ToNot(HaveOccurred())
+			clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+			var r HandshakeRejection
+			Eventually(rejections).Should(Receive(&r))
+			Expect(r.Reason).To(Equal(RejectReasonKeyTooLarge))
+			Expect(r.RemoteAddr).ToNot(BeNil())
+		})
+
+		It("reports unexpected server names", func() {
+			serverTransport, err := NewTransport(serverKey, WithDNSNames("example.com"), WithSNIValidation(), reportRejections())
+			Expect(err).ToNot(HaveOccurred())
+			ln := listen(serverTransport)
+			defer ln.Close()
+
+			clientTransport, err := NewTransport(clientKey, WithDNSNames("other.example.com"))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+			Expect(err).To(HaveOccurred())
+			var r HandshakeRejection
+			Eventually(rejections).Should(Receive(&r))
+			Expect(r.Reason).To(Equal(RejectReasonServerName))
+			Expect(r.Err).To(MatchError(`unexpected server name: "other.example.com"`))
+		})
+
+		It("reports rate limited handshakes", func() {
+			serverTransport, err := NewTransport(serverKey, WithMaxIncomingHandshakes(1), reportRejections())
+			Expect(err).ToNot(HaveOccurred())
+			ln := listen(serverTransport)
+			defer ln.Close()
+			// occupy the only handshake slot
+			_, ok := ln.(*listener).handshakeLimiter.acquire()
+			Expect(ok).To(BeTrue())
+
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+			Expect(err).To(HaveOccurred())
+			var r HandshakeRejection
+			Eventually(rejections).Should(Receive(&r))
+			Expect(r.Reason).To(Equal(RejectReasonRateLimited))
+		})
+
+		It("reports peers denied by a custom verification", func() {
+			serverTransport, err := NewTransport(serverKey, reportRejections())
+			Expect(err).ToNot(HaveOccurred())
+			ln, err := serverTransport.(*transport).ListenWithConfig(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"), ListenConfig{
+				VerifyPeerCertificate: func([][]byte, [][]*x509.Certificate) error { return errors.New("not on the allowlist") },
+			})
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+			var r HandshakeRejection
+			Eventually(rejections).Should(Receive(&r))
+			Expect(r.Reason).To(Equal(RejectReasonDenied))
+			Expect(r.Err).To(MatchError("not on the allowlist"))
+		})
+
+		Context("rejecting non-libp2p clients", func() {
+			dialNonLibp2p := func(ln tpt.Listener, tlsConf *tls.Config) {
+				sess, err := quic.DialAddr(ln.Addr().String(), tlsConf, &quic.Config{HandshakeTimeout: time.Second})
+				if err == nil {
+					sess.Close()
+				}
+			}
+
+			It("rejects clients offering ALPN protocols", func() {
+				serverTransport, err := NewTransport(serverKey, WithNonLibp2pRejection(), reportRejections())
+				Expect(err).ToNot(HaveOccurred())
+				ln := listen(serverTransport)
+				defer ln.Close()
+
+				dialNonLibp2p(ln, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h3-19"}})
+				var r HandshakeRejection
+				Eventually(rejections).Should(Receive(&r))
+				Expect(r.Reason).To(Equal(RejectReasonNotLibp2p))
+				Expect(r.Err).To(MatchError(`unexpected ALPN protocols: ["h3-19"]`))
+			})
+
+			It("rejects clients that don't present a certificate", func() {
+				serverTransport, err := NewTransport(serverKey, WithNonLibp2pRejection(), reportRejections())
+				Expect(err).ToNot(HaveOccurred())
+				ln := listen(serverTransport)
+				defer ln.Close()
+
+				dialNonLibp2p(ln, &tls.Config{InsecureSkipVerify: true})
+				var r HandshakeRejection
+				Eventually(rejections).Should(Receive(&r))
+				Expect(r.Reason).To(Equal(RejectReasonNotLibp2p))
+				Expect(r.Err).To(MatchError("no certificate presented"))
+			})
+
+			It("rejects clients presenting a single certificate", func() {
+				serverTransport, err := NewTransport(serverKey, WithNonLibp2pRejection(), reportRejections())
+				Expect(err).ToNot(HaveOccurred())
+				ln := listen(serverTransport)
+				defer ln.Close()
+
+				// present only the leaf of a libp2p certificate chain
+				clientTransport, err := NewTransport(clientKey)
+				Expect(err).ToNot(HaveOccurred())
+				cert := clientTransport.(*transport).tlsConf.Certificates[0]
+				cert.Certificate = cert.Certificate[:1]
+				dialNonLibp2p(ln, &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{cert}})
+				var r HandshakeRejection
+				Eventually(rejections).Should(Receive(&r))
+				Expect(r.Reason).To(Equal(RejectReasonNotLibp2p))
+				Expect(r.Err).To(MatchError("expected 2 certificates in the chain, got 1"))
+			})
+
+			It("accepts libp2p clients", func() {
+				serverTransport, err := NewTransport(serverKey, WithNonLibp2pRejection(), reportRejections())
+				Expect(err).ToNot(HaveOccurred())
+				serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+				clientTransport, err := NewTransport(clientKey)
+				Expect(err).ToNot(HaveOccurred())
+				conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+				Expect(err).ToNot(HaveOccurred())
+				defer conn.Close()
+				Eventually(serverConnChan).Should(Receive())
+				Consistently(rejections).ShouldNot(Receive())
+			})
+		})
+	})
+
+	Context("detecting host certificate changes", func() {
+		hostCertFingerprint := func(tr tpt.Transport) CertFingerprint {
+			chain := tr.(*transport).tlsConf.Certificates[0].Certificate
+			return sha256.Sum256(chain[len(chain)-1])
+		}
+
+		dial := func(tr tpt.Transport, addr ma.Multiaddr, connChan <-chan tpt.CapableConn) {
+			conn, err := tr.Dial(context.Background(), addr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			Eventually(connChan).Should(Receive())
+		}
+
+		It("reports when a peer presents a different host certificate", func() {
+			changes := make(chan HostCertChange, 10)
+			serverTransport, err := NewTransport(serverKey, OnHostCertChange(func(c HostCertChange) { changes <- c }))
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport1, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			dial(clientTransport1, serverAddr, serverConnChan)
+			// a different transport with the same key generates a new host certificate
+			clientTransport2, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			dial(clientTransport2, serverAddr, serverConnChan)
+
+			var c HostCertChange
+			Eventually(changes).Should(Receive(&c))
+			Expect(c.Peer).To(Equal(clientID))
+			Expect(c.RemoteAddr).ToNot(BeNil())
+			Expect(c.Previous).To(Equal(hostCertFingerprint(clientTransport1)))
+			Expect(c.Current).To(Equal(hostCertFingerprint(clientTransport2)))
+			Expect(changes).ToNot(Receive())
+		})
+
+		It("doesn't report rotated leaf certificates", func() {
+			changes := make(chan HostCertChange, 10)
+			serverTransport, err := NewTransport(serverKey, OnHostCertChange(func(c HostCertChange) { changes <- c }))
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport1, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			dial(clientTransport1, serverAddr, serverConnChan)
+			// generate a new leaf certificate, signed by the same host certificate
+			hostCert, err := x509.ParseCertificate(clientTransport1.(*transport).tlsConf.Certificates[0].Certificate[1])
+			Expect(err).ToNot(HaveOccurred())
+			signer, err := keyToSigner(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			conf, err := newConfig()
+			Expect(err).ToNot(HaveOccurred())
+			cert, err := generateLeafCertificate(hostCert, signer, conf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cert.Certificate[0]).ToNot(Equal(clientTransport1.(*transport).tlsConf.Certificates[0].Certificate[0]))
+			clientTransport2, err := NewTransport(clientKey, WithCertificate(cert))
+			Expect(err).ToNot(HaveOccurred())
+			dial(clientTransport2, serverAddr, serverConnChan)
+
+			Consistently(changes).ShouldNot(Receive())
+		})
+	})
+
+	Context("generating the certificate lazily", func() {
+		It("doesn't access the key until the first dial", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			key := &accessCountingKey{PrivKey: clientKey}
+			clientTransport, err := NewTransport(key, WithLazyCertificate())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(key.Accesses()).To(BeZero())
+			Expect(clientTransport.(*transport).tlsConf).To(BeNil())
+
+			conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			Eventually(serverConnChan).Should(Receive())
+			Expect(key.Accesses()).ToNot(BeZero())
+			Expect(clientTransport.(*transport).tlsConf).ToNot(BeNil())
+
+			// the certificate is only generated once
+			accesses := key.Accesses()
+			conn2, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn2.Close()
+			Expect(key.Accesses()).To(Equal(accesses))
+		})
+
+		It("generates the certificate when listening", func() {
+			key := &accessCountingKey{PrivKey: serverKey}
+			serverTransport, err := NewTransport(key, WithLazyCertificate())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(key.Accesses()).To(BeZero())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+			Expect(key.Accesses()).ToNot(BeZero())
+
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			Eventually(serverConnChan).Should(Receive())
+		})
+
+		It("retries when the key isn't available yet", func() {
+			key := &accessCountingKey{PrivKey: serverKey, locked: 1}
+			serverTransport, err := NewTransport(key, WithLazyCertificate())
+			Expect(err).ToNot(HaveOccurred())
+			_, err = serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).To(MatchError("key locked"))
+
+			atomic.StoreInt32(&key.locked, 0)
+			ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			ln.Close()
+		})
+	})
+
+	Context("trusting CAs", func() {
+		var (
+			caCert *x509.Certificate
+			caKey  *ecdsa.PrivateKey
+		)
+
+		BeforeEach(func() {
+			var err error
+			caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			tmpl := &x509.Certificate{
+				SerialNumber:          big.NewInt(1),
+				NotBefore:             time.Now().Add(-time.Hour),
+				NotAfter:              time.Now().Add(time.Hour),
+				IsCA:                  true,
+				BasicConstraintsValid: true,
+			}
+			der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, caKey.Public(), caKey)
+			Expect(err).ToNot(HaveOccurred())
+			caCert, err = x509.ParseCertificate(der)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		// certSignedByCA generates a certificate chain for the key, using a host certificate signed by the CA.
+		certSignedByCA := func(key ic.PrivKey) *tls.Certificate {
+			signer, err := keyToSigner(key)
+			Expect(err).ToNot(HaveOccurred())
+			tmpl := &x509.Certificate{
+				SerialNumber:          big.NewInt(2),
+				NotBefore:             time.Now().Add(-time.Hour),
+				NotAfter:              time.Now().Add(time.Hour),
+				IsCA:                  true,
+				BasicConstraintsValid: true,
+			}
+			der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, signer.Public(), caKey)
+			Expect(err).ToNot(HaveOccurred())
+			hostCert, err := x509.ParseCertificate(der)
+			Expect(err).ToNot(HaveOccurred())
+			conf, err := newConfig()
+			Expect(err).ToNot(HaveOccurred())
+			cert, err := generateLeafCertificate(hostCert, signer, conf)
+			Expect(err).ToNot(HaveOccurred())
+			return cert
+		}
+
+		caPool := func() *x509.CertPool {
+			pool := x509.NewCertPool()
+			pool.AddCert(caCert)
+			return pool
+		}
+
+		It("accepts clients whose host certificate is signed by a trusted CA", func() {
+			serverTransport, err := NewTransport(serverKey, WithTrustedCAs(caPool()))
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithCertificate(certSignedByCA(clientKey)))
+			Expect(err).ToNot(HaveOccurred())
+			conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			var serverConn tpt.CapableConn
+			Eventually(serverConnChan).Should(Receive(&serverConn))
+			Expect(serverConn.RemotePeer()).To(Equal(clientID))
+		})
+
+		It("accepts clients whose host certificate is trusted", func() {
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			hostCert, err := x509.ParseCertificate(clientTransport.(*transport).tlsConf.Certificates[0].Certificate[1])
+			Expect(err).ToNot(HaveOccurred())
+			pool := x509.NewCertPool()
+			pool.AddCert(hostCert)
+			serverTransport, err := NewTransport(serverKey, WithTrustedCAs(pool))
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			Eventually(serverConnChan).Should(Receive())
+		})
+
+		It("rejects clients whose host certificate isn't signed by a trusted CA", func() {
+			rejections := make(chan HandshakeRejection, 10)
+			serverTransport, err := NewTransport(serverKey, WithTrustedCAs(caPool()), OnHandshakeRejected(func(r HandshakeRejection) { rejections <- r }))
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			clientTransport.Dial(context.Background(), serverAddr, serverID)
+			var r HandshakeRejection
+			Eventually(rejections).Should(Receive(&r))
+			Expect(r.Reason).To(Equal(RejectReasonUntrustedCA))
+			Expect(r.Err.Error()).To(ContainSubstring("host certificate not trusted"))
+			Consistently(serverConnChan).ShouldNot(Receive())
+		})
+
+		It("refuses to dial servers whose host certificate isn't signed by a trusted CA", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithTrustedCAs(caPool()))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).To(MatchError(ContainSubstring("host certificate not trusted")))
+
+			// a server using a host certificate signed by the CA is accepted
+			serverTransport2, err := NewTransport(serverKey, WithCertificate(certSignedByCA(serverKey)))
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr2, serverConnChan2 := runServer(serverTransport2, "/ip4/127.0.0.1/udp/0/quic")
+			conn, err := clientTransport.Dial(context.Background(), serverAddr2, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			Eventually(serverConnChan2).Should(Receive())
+		})
+	})
+
+	Context("certificate pinning", func() {
+		serverPin := func(tr tpt.Transport) CertificatePin {
+			cert, err := x509.ParseCertificate(tr.(*transport).tlsConf.Certificates[0].Certificate[0])
+			Expect(err).ToNot(HaveOccurred())
+			return PinForCertificate(cert)
+		}
+
+		It("accepts a peer presenting a pinned certificate", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			var otherPin CertificatePin
+			ctx := WithCertificatePins(context.Background(), otherPin, serverPin(serverTransport))
+			conn, err := clientTransport.Dial(ctx, serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			Eventually(serverConnChan).Should(Receive())
+		})
+
+		It("rejects a peer presenting a certificate that isn't pinned, even if the peer ID matches", func() {
+			rejections := make(chan HandshakeRejection, 10)
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, OnHandshakeRejected(func(r HandshakeRejection) { rejections <- r }))
+			Expect(err).ToNot(HaveOccurred())
+			// a different transport with the same key uses a different certificate
+			otherTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			ctx := WithCertificatePins(context.Background(), serverPin(otherTransport))
+			_, err = clientTransport.Dial(ctx, serverAddr, serverID)
+			Expect(err).To(MatchError(ContainSubstring(ErrCertificatePinMismatch.Error())))
+			var r HandshakeRejection
+			Expect(rejections).To(Receive(&r))
+			Expect(r.Reason).To(Equal(RejectReasonPinMismatch))
+			Expect(r.Err).To(Equal(ErrCertificatePinMismatch))
+		})
+
+		for _, opt := range []Option{WithConnPool(10, time.Minute), WithSessionSharing()} {
+			opt := opt
+
+			It("checks the pins of pooled and shared connections", func() {
+				serverTransport, err := NewTransport(serverKey)
+				Expect(err).ToNot(HaveOccurred())
+				serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+				clientTransport, err := NewTransport(clientKey, opt)
+				Expect(err).ToNot(HaveOccurred())
+				conn1, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+				Expect(err).ToNot(HaveOccurred())
+				defer conn1.Close()
+
+				// the existing connection matches the pin
+				ctx := WithCertificatePins(context.Background(), serverPin(serverTransport))
+				conn2, err := clientTransport.Dial(ctx, serverAddr, serverID)
+				Expect(err).ToNot(HaveOccurred())
+				defer conn2.Close()
+				sessionOf := func(c tpt.CapableConn) *conn {
+					if v, ok := c.(*sharedConn); ok {
+						return v.conn
+					}
+					return c.(*conn)
+				}
+				Expect(sessionOf(conn2)).To(BeIdenticalTo(sessionOf(conn1)))
+
+				// the existing connection doesn't match the pin, so the peer is dialed
+				otherTransport, err := NewTransport(serverKey)
+				Expect(err).ToNot(HaveOccurred())
+				ctx = WithCertificatePins(context.Background(), serverPin(otherTransport))
+				_, err = clientTransport.Dial(ctx, serverAddr, serverID)
+				Expect(err).To(MatchError(ContainSubstring(ErrCertificatePinMismatch.Error())))
+			})
+		}
+	})
+
+	It("closes gracefully after all streams are closed", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+
+		str, err := clientConn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		closed := make(chan error)
+		go func() { closed <- clientConn.(*conn).CloseIdle(time.Minute) }()
+
+		Eventually(func() error {
+			_, err := clientConn.OpenStream()
+			return err
+		}).Should(MatchError(ErrConnClosing))
+		// the existing stream can still be used
+		_, err = str.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.Close()).To(Succeed())
+		serverStr, err := serverConn.AcceptStream()
+		Expect(err).ToNot(HaveOccurred())
+		data, err := ioutil.ReadAll(serverStr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal([]byte("foobar")))
+		Eventually(closed).Should(Receive(BeNil()))
+		Expect(clientConn.IsClosed()).To(BeTrue())
+	})
+
+	It("closes after the timeout if streams are still open", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = clientConn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		start := time.Now()
+		Expect(clientConn.(*conn).CloseIdle(100 * time.Millisecond)).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically(">=", 100*time.Millisecond))
+		Expect(clientConn.IsClosed()).To(BeTrue())
+	})
+
+	It("shares sockets between transports using the same ConnManager", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+
+		cm := NewConnManager()
+		_, otherKey := createPeer()
+		clientTransport1, err := NewTransport(clientKey, WithConnManager(cm))
+		Expect(err).ToNot(HaveOccurred())
+		clientTransport2, err := NewTransport(otherKey, WithConnManager(cm))
+		Expect(err).ToNot(HaveOccurred())
+		conn1, err := clientTransport1.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		conn2, err := clientTransport2.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conn1.(*conn).LocalAddr()).To(Equal(conn2.(*conn).LocalAddr()))
+
+		getRefCount := func() int {
+			cm.connManager.mutex.Lock()
+			defer cm.connManager.mutex.Unlock()
+			rconn, ok := cm.connManager.reuseConns["udp4"]
+			if !ok {
+				return 0
+			}
+			return rconn.refCount
+		}
+		Expect(getRefCount()).To(Equal(2))
+		Expect(conn1.Close()).To(Succeed())
+		Eventually(getRefCount).Should(Equal(1))
+		Expect(conn2.Close()).To(Succeed())
+		Eventually(getRefCount).Should(BeZero())
+	})
+
+	It("refuses to configure the port range when using a shared ConnManager", func() {
+		_, err := NewTransport(clientKey, WithConnManager(NewConnManager()), WithEphemeralPortRange(1000, 2000))
+		Expect(err).To(HaveOccurred())
+		_, err = NewTransport(clientKey, WithConnManager(NewConnManager()), OnReuseRefCountChange(func(string, int, string) {}))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reports changes of the reference count of the shared socket", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+
+		counts := make(chan int, 10)
+		clientTransport, err := NewTransport(clientKey, OnReuseRefCountChange(func(network string, count int, stack string) {
+			defer GinkgoRecover()
+			Expect(network).To(Equal("udp4"))
+			Expect(stack).To(ContainSubstring("connManager"))
+			counts <- count
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(counts).Should(Receive(Equal(1)))
+		Expect(conn.Close()).To(Succeed())
+		Eventually(counts).Should(Receive(Equal(0)))
+		Consistently(counts).ShouldNot(Receive())
+	})
+
+	It("marks the packets of different connection classes with different DSCPs", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+
+		clientTransport, err := NewTransport(
+			clientKey,
+			WithQoSProfile("control", QoSProfile{DSCP: 46}),
+			WithQoSProfile("bulk", QoSProfile{DSCP: 8}),
+		)
+		Expect(err).ToNot(HaveOccurred())
+		controlConn, err := clientTransport.Dial(WithConnClass(context.Background(), "control"), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer controlConn.Close()
+		bulkConn, err := clientTransport.Dial(WithConnClass(context.Background(), "bulk"), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer bulkConn.Close()
+		Expect(controlConn.(*conn).LocalAddr()).ToNot(Equal(bulkConn.(*conn).LocalAddr()))
+
+		getTOS := func(dscp uint8) int {
+			cm := clientTransport.(*transport).connManager
+			cm.mutex.Lock()
+			defer cm.mutex.Unlock()
+			rconn, ok := cm.reuseConns[reuseKey("udp4", dscp, noShard, "")]
+			Expect(ok).To(BeTrue())
+			tos, err := ipv4.NewConn(rconn.PacketConn.(*net.UDPConn)).TOS()
+			Expect(err).ToNot(HaveOccurred())
+			return tos
+		}
+		Expect(getTOS(46)).To(Equal(46 << 2))
+		Expect(getTOS(8)).To(Equal(8 << 2))
+
+		_, err = clientTransport.Dial(WithConnClass(context.Background(), "unknown"), ln.Multiaddr(), serverID)
+		Expect(err).To(MatchError("unknown connection class: unknown"))
+		_, err = NewTransport(clientKey, WithQoSProfile("invalid", QoSProfile{DSCP: 64}))
+		Expect(err).To(MatchError("invalid DSCP: 64"))
+	})
+
+	It("emits spans for dials", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		recorder := &spanRecorder{}
+		clientTransport, err := NewTransport(clientKey, WithTracer(recorder))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Eventually(serverConnChan).Should(Receive())
+
+		spans := recorder.Spans()
+		Expect(spans).To(HaveLen(2))
+		dialSpan, handshakeSpan := spans[0], spans[1]
+		Expect(dialSpan.name).To(Equal("quic.dial"))
+		Expect(dialSpan.parent).To(BeNil())
+		Expect(dialSpan.ended).To(BeTrue())
+		Expect(dialSpan.err).ToNot(HaveOccurred())
+		Expect(dialSpan.attributes).To(Equal(map[string]interface{}{
+			"peer.id":       serverID.Pretty(),
+			"net.peer.addr": serverAddr.String(),
+			"quic.version":  "draft-19",
+			"outcome":       "success",
+		}))
+		Expect(handshakeSpan.name).To(Equal("quic.tls_handshake"))
+		Expect(handshakeSpan.parent).To(Equal(dialSpan))
+		Expect(handshakeSpan.ended).To(BeTrue())
+		Expect(handshakeSpan.attributes).To(HaveKeyWithValue("outcome", "success"))
+
+		// failed dials are recorded as well
+		_, err = clientTransport.Dial(context.Background(), serverAddr, clientID)
+		Expect(err).To(HaveOccurred())
+		spans = recorder.Spans()
+		Expect(spans).To(HaveLen(4))
+		Expect(spans[2].attributes).To(HaveKeyWithValue("outcome", "failure"))
+		Expect(spans[2].err).To(HaveOccurred())
+		Expect(spans[3].parent).To(Equal(spans[2]))
+		Expect(spans[3].err).To(HaveOccurred())
+	})
+
+	It("refuses session tickets", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(clientTransport.(*transport).PrimeResumption(serverID, []byte("session ticket"))).To(MatchError(ErrResumptionUnsupported))
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Eventually(serverConnChan).Should(Receive())
+		_, ok := clientTransport.(*transport).ExportResumption(serverID)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("refuses new connections while draining", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		serverConn, err := ln.Accept()
+		Expect(err).ToNot(HaveOccurred())
+
+		clientTransport.(*transport).SetDraining(true)
+		serverTransport.(*transport).SetDraining(true)
+		_, err = clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).To(MatchError(ErrDraining))
+		_, err = serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).To(MatchError(ErrDraining))
+		// the listener closes connections that are established while draining
+		_, otherKey := createPeer()
+		otherTransport, err := NewTransport(otherKey)
+		Expect(err).ToNot(HaveOccurred())
+		otherConn, err := otherTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		if err == nil {
+			Eventually(otherConn.IsClosed).Should(BeTrue())
+		}
+
+		// existing connections keep working
+		str, err := clientConn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.Close()).To(Succeed())
+		sstr, err := serverConn.AcceptStream()
+		Expect(err).ToNot(HaveOccurred())
+		data, err := ioutil.ReadAll(sstr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal([]byte("foobar")))
+
+		clientTransport.(*transport).SetDraining(false)
+		serverTransport.(*transport).SetDraining(false)
+		conn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		_, err = ln.Accept()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Context("dialing multiple addresses", func() {
+		It("stops when the peer ID doesn't match", func() {
+			_, otherKey := createPeer()
+			otherTransport, err := NewTransport(otherKey)
+			Expect(err).ToNot(HaveOccurred())
+			otherAddr, _ := runServer(otherTransport, "/ip4/127.0.0.1/udp/0/quic")
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = clientTransport.(*transport).DialBest(context.Background(), []ma.Multiaddr{otherAddr, serverAddr}, serverID)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("peer IDs don't match"))
+			Consistently(serverConnChan).ShouldNot(Receive())
+		})
+
+		It("refuses to dial without addresses", func() {
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = clientTransport.(*transport).DialBest(context.Background(), nil, serverID)
+			Expect(err).To(MatchError("no addresses to dial"))
+		})
+	})
+
+	Context("shutting down", func() {
+		It("waits for connections to be closed", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			clientConn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+			Expect(err).ToNot(HaveOccurred())
+			serverConn, err := ln.Accept()
+			Expect(err).ToNot(HaveOccurred())
+
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				clientConn.Close()
+			}()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			start := time.Now()
+			Expect(serverTransport.(*transport).Shutdown(ctx)).To(Succeed())
+			Expect(time.Since(start)).To(And(
+				BeNumerically(">=", 100*time.Millisecond),
+				BeNumerically("<", time.Second),
+			))
+			Expect(serverConn.IsClosed()).To(BeTrue())
+			_, err = ln.Accept()
+			Expect(err).To(HaveOccurred())
+			Expect(serverTransport.(*transport).listeners()).To(BeEmpty())
+			_, err = serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).To(MatchError(ErrDraining))
+		})
+
+		It("closes the remaining connections and the sockets when the deadline is reached", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			var serverConn tpt.CapableConn
+			Eventually(serverConnChan).Should(Receive(&serverConn))
+			Expect(clientTransport.(*transport).connManager.reuseConns).ToNot(BeEmpty())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			Expect(clientTransport.(*transport).Shutdown(ctx)).To(MatchError(context.DeadlineExceeded))
+			Expect(time.Since(start)).To(And(
+				BeNumerically(">=", 200*time.Millisecond),
+				BeNumerically("<", time.Second),
+			))
+			Expect(clientConn.IsClosed()).To(BeTrue())
+			Eventually(serverConn.IsClosed).Should(BeTrue())
+			Expect(clientTransport.(*transport).connManager.reuseConns).To(BeEmpty())
+			_, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).To(MatchError(ErrDraining))
+		})
+
+		It("releases the connections of the connection pool", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithConnPool(10, time.Hour))
+			Expect(err).ToNot(HaveOccurred())
+			t := clientTransport.(*transport)
+			clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(clientConn.Close()).To(Succeed())
+			Eventually(serverConnChan).Should(Receive())
+			// the warm pool holds a view on the pooled connection
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			Expect(t.WarmPool(ctx, []peer.AddrInfo{{ID: serverID, Addrs: []ma.Multiaddr{serverAddr}}})).To(Succeed())
+			Eventually(func() int {
+				c := clientConn.(*sharedConn).conn
+				c.viewsMutex.Lock()
+				defer c.viewsMutex.Unlock()
+				return c.numViews
+			}).Should(Equal(1))
+			Expect(t.allConns()).To(HaveLen(1))
+			Expect(t.connPool.Len()).To(Equal(1))
+
+			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer shutdownCancel()
+			Expect(t.Shutdown(shutdownCtx)).To(Succeed())
+			Expect(t.allConns()).To(BeEmpty())
+			Expect(t.connPool.Len()).To(BeZero())
+		})
+
+		It("doesn't close the sockets of a shared ConnManager", func() {
+			cm := NewConnManager()
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithConnManager(cm))
+			Expect(err).ToNot(HaveOccurred())
+			otherTransport, err := NewTransport(clientKey, WithConnManager(cm))
+			Expect(err).ToNot(HaveOccurred())
+			otherConn, err := otherTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer otherConn.Close()
+
+			Expect(clientTransport.(*transport).Shutdown(context.Background())).To(Succeed())
+			Expect(cm.connManager.reuseConns).ToNot(BeEmpty())
+			Expect(otherConn.IsClosed()).To(BeFalse())
+		})
+	})
+
+	Context("filtering addresses", func() {
+		It("refuses to dial addresses rejected by the filter", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithAddressFilter(PublicAddressesOnly))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).To(MatchError(ErrDisallowedAddress))
+			Expect(clientTransport.(*transport).ValidateDialAddr(serverAddr)).To(HaveOccurred())
+		})
+
+		It("dials public addresses", func() {
+			clientTransport, err := NewTransport(clientKey, WithAddressFilter(PublicAddressesOnly))
+			Expect(err).ToNot(HaveOccurred())
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			// nothing is listening at this address, so the dial times out
+			_, err = clientTransport.Dial(ctx, ma.StringCast("/ip4/1.2.3.4/udp/1234/quic"), serverID)
+			Expect(err).To(HaveOccurred())
+			Expect(err).ToNot(MatchError(ErrDisallowedAddress))
+		})
+
+		It("dials addresses allowed by a custom filter", func() {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, WithAddressFilter(func(addr *net.UDPAddr) bool {
+				return addr.IP.IsLoopback()
+			}))
+			Expect(err).ToNot(HaveOccurred())
+			conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			conn.Close()
+		})
+
+		It("rejects a nil filter", func() {
+			_, err := NewTransport(clientKey, WithAddressFilter(nil))
+			Expect(err).To(MatchError("address filter must not be nil"))
+		})
+	})
+
+	Context("sending CONNECTION_CLOSE", func() {
+		dialAndClose := func(opts ...Option) (serverConn tpt.CapableConn) {
+			serverTransport, err := NewTransport(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey, opts...)
+			Expect(err).ToNot(HaveOccurred())
+			clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(serverConnChan).Should(Receive(&serverConn))
+			Expect(clientConn.Close()).To(Succeed())
+			Expect(clientConn.IsClosed()).To(BeTrue())
+			return serverConn
+		}
+
+		It("sends a CONNECTION_CLOSE by default", func() {
+			serverConn := dialAndClose()
+			Eventually(serverConn.IsClosed).Should(BeTrue())
+		})
+
+		It("abandons sessions silently", func() {
+			serverConn := dialAndClose(WithConnectionClose(false))
+			Consistently(serverConn.IsClosed, 500*time.Millisecond).Should(BeFalse())
+			serverConn.Close()
+		})
+
+		It("abandons the sessions accepted by a listener silently when shutting down", func() {
+			serverTransport, err := NewTransport(serverKey, WithConnectionClose(false))
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer clientConn.Close()
+			var serverConn tpt.CapableConn
+			Eventually(serverConnChan).Should(Receive(&serverConn))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			Expect(serverTransport.(*transport).Shutdown(ctx)).To(MatchError(context.DeadlineExceeded))
+			Expect(serverConn.IsClosed()).To(BeTrue())
+			Consistently(clientConn.IsClosed, 500*time.Millisecond).Should(BeFalse())
+		})
+	})
+
+	It("dials to ed25519 server", func() {
+		// Generate ED25519 credentials
+		serverKey2, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		serverID2, err := peer.IDFromPrivateKey(serverKey2)
+		Expect(err).ToNot(HaveOccurred())
+
+		// Create ED25519 server
+		serverTransport2, err := NewTransport(serverKey2)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr2, _ := runServer(serverTransport2, "/ip4/127.0.0.1/udp/0/quic")
+
+		// Dial to ED25519 server
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr2, serverID2)
+		Expect(err).ToNot(HaveOccurred())
+
+		// Verify the ID of the ED25519 server
+		Expect(conn.RemotePeer()).To(Equal(serverID2))
+	})
+	It("handshakes using secp256k1 keys", func() {
+		serverKey, _, err := ic.GenerateSecp256k1Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		serverID, err := peer.IDFromPrivateKey(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientKey, _, err := ic.GenerateSecp256k1Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		clientID, err := peer.IDFromPrivateKey(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		Expect(clientConn.RemotePeer()).To(Equal(serverID))
+		Expect(clientConn.RemotePublicKey()).To(Equal(serverKey.GetPublic()))
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+		Expect(serverConn.RemotePeer()).To(Equal(clientID))
+		Expect(serverConn.RemotePublicKey()).To(Equal(clientKey.GetPublic()))
+	})
+})
+
+// accessCountingKey counts how often the private key is accessed.
+type accessCountingKey struct {
+	ic.PrivKey
+	accesses int32 // must be accessed atomically
+	locked   int32 // if 1, accessing the key fails, must be accessed atomically
+}
+
+func (k *accessCountingKey) access() error {
+	atomic.AddInt32(&k.accesses, 1)
+	if atomic.LoadInt32(&k.locked) == 1 {
+		return errors.New("key locked")
+	}
+	return nil
+}
+
+func (k *accessCountingKey) Accesses() int32 { return atomic.LoadInt32(&k.accesses) }
+
+func (k *accessCountingKey) Bytes() ([]byte, error) {
+	if err := k.access(); err != nil {
+		return nil, err
+	}
+	return k.PrivKey.Bytes()
+}
+
+func (k *accessCountingKey) Sign(data []byte) ([]byte, error) {
+	if err := k.access(); err != nil {
+		return nil, err
+	}
+	return k.PrivKey.Sign(data)
+}
@@ -0,0 +1,38 @@
+package libp2pquic
+
+import (
+	"errors"
+
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+// The maximum number of unidirectional streams the peer may open concurrently when control streams are enabled.
+const maxIncomingControlStreams = 100
+
+// ErrControlStreamsDisabled is returned by the features that use control streams
+// (e.g. Ping and unidirectional streams), unless they are enabled using WithControlStreams.
+var ErrControlStreamsDisabled = errors.New("control streams are disabled, see WithControlStreams")
+
+// ErrControlStreamsUnsupported is returned by the features that use control streams,
+// if the peer doesn't allow us to open any unidirectional streams, e.g. because it didn't enable control streams.
+var ErrControlStreamsUnsupported = errors.New("peer doesn't support control streams")
+
+// withControlStreams returns a copy of the QUIC config that allows the peer to open unidirectional streams.
+func withControlStreams(conf *quic.Config) *quic.Config {
+	c := *conf
+	c.MaxIncomingUniStreams = maxIncomingControlStreams
+	return &c
+}
+
+// checkControlStreams returns an error if control streams can't be used on this connection.
+// Peers that don't use control streams don't allow us to open any unidirectional streams,
+// which they advertise in their transport parameters.
+func (c *conn) checkControlStreams() error {
+	if !c.controlStreams {
+		return ErrControlStreamsDisabled
+	}
+	if n, ok := c.PeerMaxUniStreams(); ok && n == 0 {
+		return ErrControlStreamsUnsupported
+	}
+	return nil
+}
@@ -9,6 +9,8 @@ import (
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"errors"
 	"fmt"
 	"math/big"
@@ -22,28 +24,200 @@ import (
 // mint certificate selection is broken.
 const hostname = "quic.ipfs"
 
-const certValidityPeriod = 180 * 24 * time.Hour
+var certValidityPeriod = 180 * 24 * time.Hour
 
-func generateConfig(privKey ic.PrivKey) (*tls.Config, error) {
-	key, hostCert, err := keyToCertificate(privKey)
+// The default maximum size of RSA keys in the peer's certificate chain.
+// Verifying signatures made by very large RSA keys is expensive, and would block the handshake.
+const defaultMaxRSAKeySize = 8192
+
+// The default limits of the peer's certificate chain.
+// libp2p chains consist of two certificates of about a kilobyte each.
+const (
+	defaultMaxCertChainLen   = 4
+	defaultMaxCertChainBytes = 64 << 10
+)
+
+// Generating an ephemeral key can fail transiently when the system is starved of entropy,
+// as can happen on embedded systems shortly after boot.
+// Key generation is therefore retried a few times, with an exponential backoff.
+const (
+	keyGenerationAttempts = 4
+	keyGenerationBackoff  = 10 * time.Millisecond
+)
+
+// A KeyGenerationError is returned when an ephemeral key couldn't be generated.
+type KeyGenerationError struct {
+	Err error // the error of the last attempt
+}
+
+func (e *KeyGenerationError) Error() string {
+	return fmt.Sprintf("failed to generate ephemeral key after %d attempts: %s", keyGenerationAttempts, e.Err)
+}
+
+func (e *KeyGenerationError) Unwrap() error { return e.Err }
+
+var generateECDSAKey = func(curve elliptic.Curve) (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(curve, rand.Reader)
+}
+
+// generateEphemeralKey generates an ECDSA key on the given curve, retrying on failure.
+func generateEphemeralKey(curve elliptic.Curve) (*ecdsa.PrivateKey, error) {
+	backoff := keyGenerationBackoff
+	var err error
+	for i := 0; i < keyGenerationAttempts; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		var key *ecdsa.PrivateKey
+		key, err = generateECDSAKey(curve)
+		if err == nil {
+			return key, nil
+		}
+	}
+	return nil, &KeyGenerationError{Err: err}
+}
+
+// generateConfig generates the tls.Config.
+// genCert is used to generate the certificate chain, unless a pre-generated chain is configured.
+func generateConfig(pubKey ic.PubKey, genCert func(*config) (*tls.Certificate, error), conf *config) (*tls.Config, error) {
+	cert := conf.certificate
+	if cert == nil {
+		var err error
+		cert, err = genCert(conf)
+		if err != nil {
+			return nil, err
+		}
+	} else if err := checkCertificate(cert, pubKey); err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		ServerName:         conf.dnsNames[0],
+		InsecureSkipVerify: true, // This is not insecure here. We will verify the cert chain ourselves.
+		ClientAuth:         tls.RequireAnyClientCert,
+		Certificates:       []tls.Certificate{*cert},
+	}, nil
+}
+
+// customConfig returns a copy of a custom tls.Config, set up for use by the transport.
+// The peer's certificate chain is checked to belong to a libp2p peer (using the verifiers, see WithPeerVerifier)
+// before calling the config's VerifyPeerCertificate.
+func customConfig(tlsConf *tls.Config, pubKey ic.PubKey, verifiers []PeerVerifier) (*tls.Config, error) {
+	if tlsConf == nil {
+		return nil, errors.New("no tls.Config")
+	}
+	if len(tlsConf.Certificates) == 0 {
+		return nil, errors.New("tls.Config doesn't contain a certificate")
+	}
+	if err := checkCertificate(&tlsConf.Certificates[0], pubKey); err != nil {
+		return nil, err
+	}
+	conf := tlsConf.Clone()
+	conf.InsecureSkipVerify = true // This is not insecure here. We will verify the cert chain ourselves.
+	conf.ClientAuth = tls.RequireAnyClientCert
+	verify := conf.VerifyPeerCertificate
+	if verify == nil {
+		verify = func([][]byte, [][]*x509.Certificate) error { return nil }
+	}
+	conf.VerifyPeerCertificate = withLibp2pVerification(func(chain []*x509.Certificate) (ic.PubKey, error) {
+		return getRemotePubKey(chain, verifiers)
+	}, verify)
+	return conf, nil
+}
+
+// GenerateCertificate generates the certificate chain used by the transport.
+// It can be passed to a transport using WithCertificate, such that the transport
+// doesn't need access to the host's private key.
+// Only options that affect the certificate are taken into account.
+func GenerateCertificate(privKey ic.PrivKey, opts ...Option) (*tls.Certificate, error) {
+	conf, err := newConfig(opts...)
 	if err != nil {
 		return nil, err
 	}
+	return keyToCertificate(privKey, conf)
+}
+
+// keyToCertificate generates the certificate chain for a libp2p private key.
+func keyToCertificate(privKey ic.PrivKey, conf *config) (*tls.Certificate, error) {
+	if privKey.Type() == pb.KeyType_Secp256k1 {
+		return generateCertificateWithExtension(privKey, conf)
+	}
+	signer, err := keyToSigner(privKey)
+	if err != nil {
+		return nil, err
+	}
+	return generateCertificate(signer, conf)
+}
+
+func generateCertificate(signer crypto.Signer, conf *config) (*tls.Certificate, error) {
+	hostCert, err := signerToCertificate(signer, nil)
+	if err != nil {
+		return nil, err
+	}
+	return generateLeafCertificate(hostCert, signer, conf)
+}
+
+// Go's x509 package can't sign certificates using every key type supported by libp2p (e.g. Secp256k1).
+// For those keys, the host certificate uses a certificate key, and carries the libp2p public key in an extension.
+// The extension also contains a signature of the certificate key, made using the host key,
+// proving that the certificate key belongs to the host.
+var extensionID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 53594, 1, 1}
+
+const extensionSignaturePrefix = "libp2p-tls-handshake:"
+
+type signedKey struct {
+	PubKey    []byte
+	Signature []byte
+}
+
+// generateCertificateWithExtension generates a certificate chain that carries the host key in an extension.
+func generateCertificateWithExtension(privKey ic.PrivKey, conf *config) (*tls.Certificate, error) {
+	certKey, err := generateEphemeralKey(conf.ephemeralKeyCurve)
+	if err != nil {
+		return nil, err
+	}
+	certKeyPKIX, err := x509.MarshalPKIXPublicKey(certKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	signature, err := privKey.Sign(append([]byte(extensionSignaturePrefix), certKeyPKIX...))
+	if err != nil {
+		return nil, err
+	}
+	pubKeyBytes, err := ic.MarshalPublicKey(privKey.GetPublic())
+	if err != nil {
+		return nil, err
+	}
+	value, err := asn1.Marshal(signedKey{PubKey: pubKeyBytes, Signature: signature})
+	if err != nil {
+		return nil, err
+	}
+	hostCert, err := signerToCertificate(certKey, []pkix.Extension{{Id: extensionID, Value: value}})
+	if err != nil {
+		return nil, err
+	}
+	return generateLeafCertificate(hostCert, certKey, conf)
+}
+
+// generateLeafCertificate generates a certificate for an ephemeral key, signed by the host certificate.
+func generateLeafCertificate(hostCert *x509.Certificate, signer crypto.Signer, conf *config) (*tls.Certificate, error) {
 	// The ephemeral key used just for a couple of connections (or a limited time).
-	ephemeralKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ephemeralKey, err := generateEphemeralKey(conf.ephemeralKeyCurve)
 	if err != nil {
 		return nil, err
 	}
 	// Sign the ephemeral key using the host key.
 	// This is the only time that the host's private key of the peer is needed.
-	// Note that this step could be done asynchronously, such that a running node doesn't need access its private key at all.
+	// Note that this step can be done in advance (see GenerateCertificate),
+	// such that a running node doesn't need access its private key at all.
 	certTemplate := &x509.Certificate{
-		DNSNames:     []string{hostname},
-		SerialNumber: big.NewInt(1),
-		NotBefore:    time.Now().Add(-24 * time.Hour),
-		NotAfter:     time.Now().Add(certValidityPeriod),
+		DNSNames:        conf.dnsNames,
+		SerialNumber:    big.NewInt(1),
+		NotBefore:       time.Now().Add(-24 * time.Hour),
+		NotAfter:        time.Now().Add(certValidityPeriod),
+		ExtraExtensions: conf.certExtensions,
 	}
-	certDER, err := x509.CreateCertificate(rand.Reader, certTemplate, hostCert, ephemeralKey.Public(), key)
+	certDER, err := x509.CreateCertificate(rand.Reader, certTemplate, hostCert, ephemeralKey.Public(), signer)
 	if err != nil {
 		return nil, err
 	}
@@ -51,86 +225,189 @@ func generateConfig(privKey ic.PrivKey) (*tls.Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &tls.Config{
-		ServerName:         hostname,
-		InsecureSkipVerify: true, // This is not insecure here. We will verify the cert chain ourselves.
-		ClientAuth:         tls.RequireAnyClientCert,
-		Certificates: []tls.Certificate{{
-			Certificate: [][]byte{cert.Raw, hostCert.Raw},
-			PrivateKey:  ephemeralKey,
-		}},
+	return &tls.Certificate{
+		Certificate: [][]byte{cert.Raw, hostCert.Raw},
+		PrivateKey:  ephemeralKey,
 	}, nil
 }
 
-func getRemotePubKey(chain []*x509.Certificate) (ic.PubKey, error) {
-	if len(chain) != 2 {
-		return nil, errors.New("expected 2 certificates in the chain")
+// checkCertificate checks that a certificate chain is valid, and that it belongs to the host key.
+func checkCertificate(cert *tls.Certificate, pubKey ic.PubKey) error {
+	if cert.PrivateKey == nil {
+		return errors.New("certificate doesn't have a private key")
+	}
+	chain := make([]*x509.Certificate, len(cert.Certificate))
+	for i, der := range cert.Certificate {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		chain[i] = c
+	}
+	certPubKey, err := getRemotePubKey(chain, nil)
+	if err != nil {
+		return err
+	}
+	if !certPubKey.Equals(pubKey) {
+		return errors.New("certificate doesn't belong to the host key")
+	}
+	return nil
+}
+
+// checkChainSize checks that the peer's certificate chain doesn't consist of more than maxCerts certificates
+// and maxBytes bytes, before any certificate is parsed.
+func checkChainSize(rawCerts [][]byte, maxCerts, maxBytes int) error {
+	if len(rawCerts) > maxCerts {
+		return fmt.Errorf("certificate chain too long: %d certificates (maximum: %d)", len(rawCerts), maxCerts)
+	}
+	var size int
+	for _, c := range rawCerts {
+		size += len(c)
+	}
+	if size > maxBytes {
+		return fmt.Errorf("certificate chain too large: %d bytes (maximum: %d)", size, maxBytes)
+	}
+	return nil
+}
+
+// parseCertChain parses the raw certificates sent by the peer.
+func parseCertChain(rawCerts [][]byte) ([]*x509.Certificate, error) {
+	chain := make([]*x509.Certificate, len(rawCerts))
+	for i := 0; i < len(rawCerts); i++ {
+		cert, err := x509.ParseCertificate(rawCerts[i])
+		if err != nil {
+			return nil, err
+		}
+		chain[i] = cert
 	}
-	pool := x509.NewCertPool()
-	pool.AddCert(chain[1])
-	if _, err := chain[0].Verify(x509.VerifyOptions{Roots: pool}); err != nil {
+	return chain, nil
+}
+
+// checkKeySizes checks that the certificates in the chain don't use RSA keys larger than maxRSABits,
+// before any expensive signature verification is done.
+func checkKeySizes(chain []*x509.Certificate, maxRSABits int) error {
+	for _, cert := range chain {
+		if pubKey, ok := cert.PublicKey.(*rsa.PublicKey); ok && pubKey.N.BitLen() > maxRSABits {
+			return fmt.Errorf("RSA key too large: %d bits (maximum: %d)", pubKey.N.BitLen(), maxRSABits)
+		}
+	}
+	return nil
+}
+
+// pubKeyFromExtension extracts the host key from the extension of the host certificate,
+// and checks that the certificate key was signed using the host key.
+func pubKeyFromExtension(hostCert *x509.Certificate, value []byte) (ic.PubKey, error) {
+	var sk signedKey
+	if _, err := asn1.Unmarshal(value, &sk); err != nil {
+		return nil, fmt.Errorf("unmarshalling signed key failed: %s", err)
+	}
+	pubKey, err := ic.UnmarshalPublicKey(sk.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	valid, err := pubKey.Verify(append([]byte(extensionSignaturePrefix), hostCert.RawSubjectPublicKeyInfo...), sk.Signature)
+	if err != nil {
 		return nil, err
 	}
+	if !valid {
+		return nil, errors.New("signature invalid")
+	}
+	return pubKey, nil
+}
 
-	switch remotePubKey := chain[1].PublicKey.(type) {
+// toLibp2pPubKey converts a public key used in a certificate to a libp2p public key.
+func toLibp2pPubKey(pubKey crypto.PublicKey) (ic.PubKey, error) {
+	switch pubKey := pubKey.(type) {
 	case *rsa.PublicKey:
-		remotePubKeyPKIX, err := x509.MarshalPKIXPublicKey(remotePubKey)
+		pubKeyPKIX, err := x509.MarshalPKIXPublicKey(pubKey)
 		if err != nil {
 			return nil, err
 		}
-		return ic.UnmarshalRsaPublicKey(remotePubKeyPKIX)
+		return ic.UnmarshalRsaPublicKey(pubKeyPKIX)
 	case ed25519.PublicKey:
-		return ic.UnmarshalEd25519PublicKey(remotePubKey)
+		return ic.UnmarshalEd25519PublicKey(pubKey)
 	default:
-		return nil, fmt.Errorf("unknown key type: %T", remotePubKey)
+		return nil, &UnsupportedRemoteKeyTypeError{KeyType: fmt.Sprintf("%T", pubKey)}
 	}
 }
 
-func keyToCertificate(sk ic.PrivKey) (interface{}, *x509.Certificate, error) {
-	sn, err := rand.Int(rand.Reader, big.NewInt(1<<62))
-	if err != nil {
-		return nil, nil, err
-	}
-	tmpl := &x509.Certificate{
-		SerialNumber:          sn,
-		NotBefore:             time.Now().Add(-24 * time.Hour),
-		NotAfter:              time.Now().Add(certValidityPeriod),
-		IsCA:                  true,
-		BasicConstraintsValid: true,
-	}
+// ErrUnsupportedRemoteKeyType is matched by an *UnsupportedRemoteKeyTypeError (using errors.Is).
+var ErrUnsupportedRemoteKeyType = errors.New("unsupported remote key type")
+
+// An UnsupportedRemoteKeyTypeError is returned when the peer's host certificate uses a key type that can't be converted to a libp2p key.
+// Such handshakes are counted in TransportStats.UnsupportedRemoteKeyTypes.
+type UnsupportedRemoteKeyTypeError struct {
+	// KeyType is the Go type of the public key, e.g. *dsa.PublicKey.
+	KeyType string
+}
+
+func (e *UnsupportedRemoteKeyTypeError) Error() string {
+	return fmt.Sprintf("unknown key type: %s", e.KeyType)
+}
 
-	var publicKey interface{}
-	var privateKey crypto.Signer
+// Is makes the error match ErrUnsupportedRemoteKeyType.
+func (e *UnsupportedRemoteKeyTypeError) Is(target error) bool {
+	return target == ErrUnsupportedRemoteKeyType
+}
+
+// keyToSigner extracts the key material from a libp2p private key.
+func keyToSigner(sk ic.PrivKey) (crypto.Signer, error) {
 	keyBytes, err := sk.Bytes()
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	pbmes := new(pb.PrivateKey)
 	if err := proto.Unmarshal(keyBytes, pbmes); err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	switch pbmes.GetType() {
 	case pb.KeyType_RSA:
-		k, err := x509.ParsePKCS1PrivateKey(pbmes.GetData())
-		if err != nil {
-			return nil, nil, err
-		}
-		publicKey = &k.PublicKey
-		privateKey = k
+		return x509.ParsePKCS1PrivateKey(pbmes.GetData())
 	case pb.KeyType_Ed25519:
-		privateKey = ed25519.PrivateKey(pbmes.GetData())
-		publicKey = privateKey.Public()
+		return ed25519.PrivateKey(pbmes.GetData()), nil
 	// TODO: add support for ECDSA
 	default:
-		return nil, nil, errors.New("unsupported key type for TLS")
+		return nil, &UnsupportedKeyTypeError{KeyType: pbmes.GetType()}
+	}
+}
+
+// An UnsupportedKeyTypeError is returned when the host key can't be used for TLS.
+type UnsupportedKeyTypeError struct {
+	KeyType pb.KeyType
+}
+
+func (e *UnsupportedKeyTypeError) Error() string {
+	return fmt.Sprintf("unsupported key type for TLS: %s", e.KeyType)
+}
+
+// checkKeyType checks that a host key of type t can be used for TLS.
+func checkKeyType(t pb.KeyType) error {
+	switch t {
+	case pb.KeyType_RSA, pb.KeyType_Ed25519, pb.KeyType_Secp256k1:
+		return nil
+	default:
+		return &UnsupportedKeyTypeError{KeyType: t}
 	}
-	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, publicKey, privateKey)
+}
+
+// signerToCertificate generates the self-signed host certificate.
+// The signer is used to sign it, so this works for keys that can't be exported (e.g. keys stored in an HSM).
+func signerToCertificate(signer crypto.Signer, extensions []pkix.Extension) (*x509.Certificate, error) {
+	sn, err := rand.Int(rand.Reader, big.NewInt(1<<62))
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	cert, err := x509.ParseCertificate(certDER)
+	tmpl := &x509.Certificate{
+		SerialNumber:          sn,
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().Add(certValidityPeriod),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		ExtraExtensions:       extensions,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, signer.Public(), signer)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	return privateKey, cert, nil
+	return x509.ParseCertificate(certDER)
 }
@@ -0,0 +1,318 @@
+package libp2pquic
+
+import (
+	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io"
+	"math/big"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// inaccessibleKey is a private key that doesn't allow access to the key material
+type inaccessibleKey struct {
+	ic.PrivKey
+}
+
+func (k *inaccessibleKey) Bytes() ([]byte, error) {
+	return nil, errors.New("key not accessible")
+}
+
+// opaqueSigner is a crypto.Signer that doesn't expose its key material, like a key stored in an HSM
+type opaqueSigner struct {
+	signer crypto.Signer
+}
+
+func (s *opaqueSigner) Public() crypto.PublicKey { return s.signer.Public() }
+
+func (s *opaqueSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.signer.Sign(rand, digest, opts)
+}
+
+var _ = Describe("Crypto", func() {
+	var key ic.PrivKey
+
+	generateConfigForKey := func(key ic.PrivKey, conf *config) (*tls.Config, error) {
+		return generateConfig(key.GetPublic(), func(conf *config) (*tls.Certificate, error) { return keyToCertificate(key, conf) }, conf)
+	}
+
+	BeforeEach(func() {
+		var err error
+		key, _, err = ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("uses the default hostname", func() {
+		conf, err := newConfig()
+		Expect(err).ToNot(HaveOccurred())
+		tlsConf, err := generateConfigForKey(key, conf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tlsConf.ServerName).To(Equal(hostname))
+		cert, err := x509.ParseCertificate(tlsConf.Certificates[0].Certificate[0])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cert.DNSNames).To(Equal([]string{hostname}))
+	})
+
+	It("uses custom DNS names", func() {
+		conf, err := newConfig(WithDNSNames("example.com", "foo.example.com"))
+		Expect(err).ToNot(HaveOccurred())
+		tlsConf, err := generateConfigForKey(key, conf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tlsConf.ServerName).To(Equal("example.com"))
+		cert, err := x509.ParseCertificate(tlsConf.Certificates[0].Certificate[0])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cert.DNSNames).To(Equal([]string{"example.com", "foo.example.com"}))
+		Expect(cert.VerifyHostname(tlsConf.ServerName)).To(Succeed())
+	})
+
+	Context("custom certificate extensions", func() {
+		orgExtensionID := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}
+
+		It("adds extensions to the leaf certificate", func() {
+			conf, err := newConfig(WithCertificateExtensions(pkix.Extension{Id: orgExtensionID, Value: []byte("org-42")}))
+			Expect(err).ToNot(HaveOccurred())
+			tlsConf, err := generateConfigForKey(key, conf)
+			Expect(err).ToNot(HaveOccurred())
+			chain, err := parseCertChain(tlsConf.Certificates[0].Certificate)
+			Expect(err).ToNot(HaveOccurred())
+			var found bool
+			for _, ext := range chain[0].Extensions {
+				if ext.Id.Equal(orgExtensionID) {
+					Expect(ext.Value).To(Equal([]byte("org-42")))
+					found = true
+				}
+			}
+			Expect(found).To(BeTrue())
+			// the peer can still derive our peer ID from the chain
+			pubKey, err := getRemotePubKey(chain, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pubKey.Equals(key.GetPublic())).To(BeTrue())
+		})
+
+		It("refuses critical extensions", func() {
+			_, err := newConfig(WithCertificateExtensions(pkix.Extension{Id: orgExtensionID, Critical: true}))
+			Expect(err).To(MatchError("extension 1.3.6.1.4.1.99999.1 must not be critical"))
+		})
+
+		It("refuses the libp2p extension", func() {
+			_, err := newConfig(WithCertificateExtensions(pkix.Extension{Id: extensionID}))
+			Expect(err).To(MatchError("extension 1.3.6.1.4.1.53594.1.1 is reserved for libp2p"))
+		})
+	})
+
+	It("uses a pre-generated certificate", func() {
+		cert, err := GenerateCertificate(key)
+		Expect(err).ToNot(HaveOccurred())
+		conf, err := newConfig(WithCertificate(cert))
+		Expect(err).ToNot(HaveOccurred())
+		tlsConf, err := generateConfigForKey(&inaccessibleKey{key}, conf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tlsConf.Certificates).To(Equal([]tls.Certificate{*cert}))
+	})
+
+	It("refuses a pre-generated certificate that doesn't belong to the host key", func() {
+		otherKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		cert, err := GenerateCertificate(otherKey)
+		Expect(err).ToNot(HaveOccurred())
+		conf, err := newConfig(WithCertificate(cert))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = generateConfigForKey(key, conf)
+		Expect(err).To(MatchError("certificate doesn't belong to the host key"))
+	})
+
+	It("generates a certificate using a crypto.Signer", func() {
+		ed25519Key, err := keyToSigner(key)
+		Expect(err).ToNot(HaveOccurred())
+		conf, err := newConfig()
+		Expect(err).ToNot(HaveOccurred())
+		tlsConf, err := generateConfig(key.GetPublic(), func(conf *config) (*tls.Certificate, error) {
+			return generateCertificate(&opaqueSigner{ed25519Key}, conf)
+		}, conf)
+		Expect(err).ToNot(HaveOccurred())
+		chain := make([]*x509.Certificate, 2)
+		for i, der := range tlsConf.Certificates[0].Certificate {
+			chain[i], err = x509.ParseCertificate(der)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		pubKey, err := getRemotePubKey(chain, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pubKey).To(Equal(key.GetPublic()))
+	})
+
+	It("carries secp256k1 keys in a certificate extension", func() {
+		key, _, err := ic.GenerateSecp256k1Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		conf, err := newConfig()
+		Expect(err).ToNot(HaveOccurred())
+		tlsConf, err := generateConfigForKey(key, conf)
+		Expect(err).ToNot(HaveOccurred())
+		chain, err := parseCertChain(tlsConf.Certificates[0].Certificate)
+		Expect(err).ToNot(HaveOccurred())
+		pubKey, err := getRemotePubKey(chain, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pubKey).To(Equal(key.GetPublic()))
+	})
+
+	It("refuses a certificate extension signed by a different key", func() {
+		key, _, err := ic.GenerateSecp256k1Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		cert, err := GenerateCertificate(key)
+		Expect(err).ToNot(HaveOccurred())
+		chain, err := parseCertChain(cert.Certificate)
+		Expect(err).ToNot(HaveOccurred())
+		// the host certificate of the other chain uses a different certificate key
+		otherCert, err := GenerateCertificate(key)
+		Expect(err).ToNot(HaveOccurred())
+		otherChain, err := parseCertChain(otherCert.Certificate)
+		Expect(err).ToNot(HaveOccurred())
+		var otherExtension []byte
+		for _, ext := range otherChain[1].Extensions {
+			if ext.Id.Equal(extensionID) {
+				otherExtension = ext.Value
+			}
+		}
+		Expect(otherExtension).ToNot(BeNil())
+		_, err = pubKeyFromExtension(chain[1], otherExtension)
+		Expect(err).To(MatchError("signature invalid"))
+	})
+
+	Context("generating ephemeral keys", func() {
+		origGenerateECDSAKey := generateECDSAKey
+
+		AfterEach(func() {
+			generateECDSAKey = origGenerateECDSAKey
+		})
+
+		It("retries when the entropy source fails", func() {
+			var attempts int
+			generateECDSAKey = func(curve elliptic.Curve) (*ecdsa.PrivateKey, error) {
+				attempts++
+				if attempts <= 2 {
+					return nil, errors.New("entropy source not ready")
+				}
+				return origGenerateECDSAKey(curve)
+			}
+			_, err := NewTransport(key)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(attempts).To(Equal(3))
+		})
+
+		It("gives up when the entropy source keeps failing", func() {
+			var attempts int
+			generateECDSAKey = func(curve elliptic.Curve) (*ecdsa.PrivateKey, error) {
+				attempts++
+				return nil, errors.New("entropy source not ready")
+			}
+			_, err := NewTransport(key)
+			Expect(err).To(HaveOccurred())
+			keyErr, ok := err.(*KeyGenerationError)
+			Expect(ok).To(BeTrue())
+			Expect(keyErr.Err).To(MatchError("entropy source not ready"))
+			Expect(attempts).To(Equal(keyGenerationAttempts))
+		})
+	})
+
+	It("refuses an empty list of DNS names", func() {
+		_, err := newConfig(WithDNSNames())
+		Expect(err).To(MatchError("at least one DNS name required"))
+	})
+
+	Context("choosing the ephemeral key curve", func() {
+		It("uses P-256 by default", func() {
+			tr, err := NewTransport(key)
+			Expect(err).ToNot(HaveOccurred())
+			cert := tr.(*transport).tlsConf.Certificates[0]
+			Expect(cert.PrivateKey.(*ecdsa.PrivateKey).Curve).To(Equal(elliptic.P256()))
+		})
+
+		It("uses the configured curve for the ephemeral certificate", func() {
+			tr, err := NewTransport(key, WithEphemeralKeyCurve(elliptic.P384()))
+			Expect(err).ToNot(HaveOccurred())
+			cert := tr.(*transport).tlsConf.Certificates[0]
+			Expect(cert.PrivateKey.(*ecdsa.PrivateKey).Curve).To(Equal(elliptic.P384()))
+			leaf, err := x509.ParseCertificate(cert.Certificate[0])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(leaf.PublicKey.(*ecdsa.PublicKey).Curve).To(Equal(elliptic.P384()))
+		})
+
+		It("refuses curves that can't be used with TLS 1.3", func() {
+			_, err := newConfig(WithEphemeralKeyCurve(elliptic.P224()))
+			Expect(err).To(MatchError("unsupported curve for the ephemeral key: P-224"))
+		})
+	})
+
+	Context("unsupported remote key types", func() {
+		// chainWithKey creates a certificate chain whose host certificate uses the given key, without a libp2p extension.
+		chainWithKey := func(hostKey *ecdsa.PrivateKey) []*x509.Certificate {
+			hostTmpl := &x509.Certificate{
+				SerialNumber:          big.NewInt(1),
+				NotBefore:             time.Now().Add(-time.Hour),
+				NotAfter:              time.Now().Add(time.Hour),
+				IsCA:                  true,
+				BasicConstraintsValid: true,
+			}
+			hostDER, err := x509.CreateCertificate(rand.Reader, hostTmpl, hostTmpl, hostKey.Public(), hostKey)
+			Expect(err).ToNot(HaveOccurred())
+			hostCert, err := x509.ParseCertificate(hostDER)
+			Expect(err).ToNot(HaveOccurred())
+			leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			leafTmpl := &x509.Certificate{
+				SerialNumber: big.NewInt(2),
+				NotBefore:    time.Now().Add(-time.Hour),
+				NotAfter:     time.Now().Add(time.Hour),
+			}
+			leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, hostCert, leafKey.Public(), hostKey)
+			Expect(err).ToNot(HaveOccurred())
+			chain, err := parseCertChain([][]byte{leafDER, hostDER})
+			Expect(err).ToNot(HaveOccurred())
+			return chain
+		}
+
+		It("returns the type of the key", func() {
+			_, err := toLibp2pPubKey(&dsa.PublicKey{})
+			Expect(err).To(MatchError("unknown key type: *dsa.PublicKey"))
+			Expect(errors.Is(err, ErrUnsupportedRemoteKeyType)).To(BeTrue())
+			var kerr *UnsupportedRemoteKeyTypeError
+			Expect(errors.As(err, &kerr)).To(BeTrue())
+			Expect(kerr.KeyType).To(Equal("*dsa.PublicKey"))
+		})
+
+		It("counts peers using an unsupported key type", func() {
+			hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			tr, err := NewTransport(key)
+			Expect(err).ToNot(HaveOccurred())
+			t := tr.(*transport)
+			_, err = t.getRemotePubKey(chainWithKey(hostKey))
+			Expect(errors.Is(err, ErrUnsupportedRemoteKeyType)).To(BeTrue())
+			Expect(err.(*UnsupportedRemoteKeyTypeError).KeyType).To(Equal("*ecdsa.PublicKey"))
+			Expect(t.Stats().UnsupportedRemoteKeyTypes).To(BeEquivalentTo(1))
+
+			// other errors are not counted
+			_, err = t.getRemotePubKey(chainWithKey(hostKey)[:1])
+			Expect(err).To(HaveOccurred())
+			Expect(t.Stats().UnsupportedRemoteKeyTypes).To(BeEquivalentTo(1))
+		})
+	})
+
+	It("checks the size of the certificate chain", func() {
+		chain := [][]byte{make([]byte, 100), make([]byte, 200)}
+		Expect(checkChainSize(chain, 2, 300)).To(Succeed())
+		Expect(checkChainSize(chain, 1, 300)).To(MatchError("certificate chain too long: 2 certificates (maximum: 1)"))
+		Expect(checkChainSize(chain, 2, 299)).To(MatchError("certificate chain too large: 300 bytes (maximum: 299)"))
+	})
+})
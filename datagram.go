@@ -0,0 +1,34 @@
+package libp2pquic
+
+import "errors"
+
+// ErrDatagramsNotNegotiated is returned by MaxDatagramSize when the peer didn't advertise support for datagrams.
+var ErrDatagramsNotNegotiated = errors.New("datagrams were not negotiated")
+
+// MaxDatagramSize returns the maximum size of a datagram frame, as advertised by the peer.
+// quic-go v0.11 doesn't implement the datagram extension, so it never negotiates datagrams,
+// and MaxDatagramSize always returns ErrDatagramsNotNegotiated.
+func (c *conn) MaxDatagramSize() (int, error) {
+	return 0, ErrDatagramsNotNegotiated
+}
+
+// ExtensionDatagrams is the name of the QUIC datagram extension, see SupportsExtension.
+const ExtensionDatagrams = "datagrams"
+
+// SupportsDatagrams says if both peers negotiated the datagram extension.
+// quic-go v0.11 doesn't implement the datagram extension, so this is always false.
+func (c *conn) SupportsDatagrams() bool {
+	_, err := c.MaxDatagramSize()
+	return err == nil
+}
+
+// SupportsExtension says if the QUIC extension called name was negotiated with the peer.
+// It returns false for extensions that are unknown to this transport.
+func (c *conn) SupportsExtension(name string) bool {
+	switch name {
+	case ExtensionDatagrams:
+		return c.SupportsDatagrams()
+	default:
+		return false
+	}
+}
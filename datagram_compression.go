@@ -0,0 +1,13 @@
+package libp2pquic
+
+import "errors"
+
+// ErrDatagramCompressionUnsupported is returned by WithDatagramCompression,
+// since quic-go v0.11 doesn't implement the datagram extension.
+var ErrDatagramCompressionUnsupported = errors.New("datagram compression is not supported")
+
+// A DatagramCodec compresses application datagrams (see WithDatagramCompression).
+type DatagramCodec interface {
+	Compress([]byte) ([]byte, error)
+	Decompress([]byte) ([]byte, error)
+}
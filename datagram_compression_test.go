@@ -0,0 +1,23 @@
+package libp2pquic
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type nopCodec struct{}
+
+func (nopCodec) Compress(b []byte) ([]byte, error)   { return b, nil }
+func (nopCodec) Decompress(b []byte) ([]byte, error) { return b, nil }
+
+var _ = Describe("Datagram compression", func() {
+	It("refuses a nil codec", func() {
+		_, err := newConfig(WithDatagramCompression(nil))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("refuses to enable compression, since datagrams are not supported", func() {
+		_, err := newConfig(WithDatagramCompression(nopCodec{}))
+		Expect(err).To(MatchError(ErrDatagramCompressionUnsupported))
+	})
+})
@@ -0,0 +1,59 @@
+package libp2pquic
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// The inclusive upper bounds of the buckets of the DatagramSizeHistogram.
+// quic-go v0.11 sends packets of up to 1252 bytes, see WithMaxPacketSize.
+var datagramSizeBounds = [...]int{64, 128, 256, 512, 1024, 1280, 1500}
+
+// A DatagramSizeHistogram counts UDP datagrams by their size.
+type DatagramSizeHistogram struct {
+	// Bounds are the inclusive upper bounds of the buckets, in bytes.
+	Bounds []int
+	// Counts[i] is the number of datagrams larger than Bounds[i-1], and not larger than Bounds[i].
+	// The last element counts the datagrams larger than the last bound.
+	Counts []uint64
+}
+
+// Total returns the number of datagrams counted.
+func (h DatagramSizeHistogram) Total() uint64 {
+	var total uint64
+	for _, n := range h.Counts {
+		total += n
+	}
+	return total
+}
+
+// datagramSizes counts datagrams by their size, in the buckets of datagramSizeBounds.
+type datagramSizes [len(datagramSizeBounds) + 1]uint64 // must be accessed atomically
+
+func (s *datagramSizes) add(n int) {
+	atomic.AddUint64(&s[sort.SearchInts(datagramSizeBounds[:], n)], 1)
+}
+
+// Histogram returns the counts. It is safe to call on a nil datagramSizes.
+func (s *datagramSizes) Histogram() DatagramSizeHistogram {
+	h := DatagramSizeHistogram{
+		Bounds: append([]int(nil), datagramSizeBounds[:]...),
+		Counts: make([]uint64, len(s)),
+	}
+	if s != nil {
+		for i := range s {
+			h.Counts[i] = atomic.LoadUint64(&s[i])
+		}
+	}
+	return h
+}
+
+// SentDatagramSizes returns the sizes of the UDP datagrams sent on this connection, e.g. for MTU and pacing analysis.
+// Like WireBytesSent, connections to the same address using the same socket share their counts.
+// All counts are 0 if the datagrams sent are unknown.
+func (c *conn) SentDatagramSizes() DatagramSizeHistogram {
+	if c.wire == nil {
+		return (*datagramSizes)(nil).Histogram()
+	}
+	return c.wire.sentSizes.Histogram()
+}
@@ -0,0 +1,60 @@
+package libp2pquic
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	quic "github.com/lucas-clemente/quic-go"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// A ConnSnapshot is the state of a connection at one point in time, see DiagnosticSnapshot.
+// Values that quic-go v0.11 doesn't expose are zero.
+type ConnSnapshot struct {
+	LocalPeer       peer.ID
+	RemotePeer      peer.ID
+	LocalMultiaddr  ma.Multiaddr
+	RemoteMultiaddr ma.Multiaddr
+	// The QUIC version, 0 if unknown.
+	Version quic.VersionNumber
+	// The RTT measured by the most recent Ping. 0 if no ping completed yet.
+	RTT           time.Duration
+	Streams       ConnStats
+	BytesSent     uint64
+	BytesReceived uint64
+	// The UDP payloads sent and received, see WireBytesSent.
+	WireBytesSent     uint64
+	WireBytesReceived uint64
+	// The sizes of the UDP datagrams sent, see SentDatagramSizes.
+	SentDatagramSizes DatagramSizeHistogram
+	Age               time.Duration
+	EncryptionLevel   EncryptionLevel
+	Handshake         HandshakeAlgorithms
+	// The error the connection was closed with, nil if it is still open.
+	CloseError error
+}
+
+// DiagnosticSnapshot returns the current state of the connection, e.g. for debugging endpoints.
+// BytesSent and BytesReceived count the data written to and read from streams.
+func (c *conn) DiagnosticSnapshot() ConnSnapshot {
+	return ConnSnapshot{
+		LocalPeer:         c.LocalPeer(),
+		RemotePeer:        c.RemotePeer(),
+		LocalMultiaddr:    c.LocalMultiaddr(),
+		RemoteMultiaddr:   c.RemoteMultiaddr(),
+		Version:           c.Version(),
+		RTT:               time.Duration(atomic.LoadInt64(&c.lastPingRTT)),
+		Streams:           c.Stats(),
+		BytesSent:         atomic.LoadUint64(&c.bytesSent),
+		BytesReceived:     atomic.LoadUint64(&c.bytesReceived),
+		WireBytesSent:     c.WireBytesSent(),
+		WireBytesReceived: c.WireBytesReceived(),
+		SentDatagramSizes: c.SentDatagramSizes(),
+		Age:               c.Age(),
+		EncryptionLevel:   c.EncryptionLevel(),
+		Handshake:         c.HandshakeAlgorithms(),
+		CloseError:        c.CloseError(),
+	}
+}
@@ -0,0 +1,33 @@
+package libp2pquic
+
+import (
+	"context"
+	"fmt"
+)
+
+type dialAffinityKey struct{}
+
+// WithDialAffinity returns a context that makes all dials using the same affinity key share a socket,
+// and therefore a source port, independent of the peer dialed. This is useful for NAT traversal,
+// when connections to a peer and to a relay helping to reach that peer need to use the same source port.
+// The affinity key takes precedence over the reuse policy (see WithReusePolicy) and shard hints (see WithShardHint).
+// Dials using the affinity key share a socket even if reuse is disabled.
+// An empty key is ignored.
+func WithDialAffinity(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, dialAffinityKey{}, key)
+}
+
+// dialAffinity returns the affinity key of a dial, or an empty string if none is set.
+func dialAffinity(ctx context.Context) string {
+	key, _ := ctx.Value(dialAffinityKey{}).(string)
+	return key
+}
+
+// affinityReuseKey returns the key of the socket shared by all dials of the network using the same DSCP and affinity key.
+func affinityReuseKey(network string, dscp uint8, affinity string) string {
+	key := network
+	if dscp != 0 {
+		key += fmt.Sprintf("/dscp-%d", dscp)
+	}
+	return key + "/affinity-" + affinity
+}
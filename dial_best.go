@@ -0,0 +1,47 @@
+package libp2pquic
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	tpt "github.com/libp2p/go-libp2p-core/transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// DialBest dials the peer on its addresses, one after the other, until a dial succeeds.
+// It only moves on to the next address if the dial failed in a way that suggests that the address is unreachable,
+// i.e. if the handshake timed out, or if the peer's host refused the connection (see WithPortUnreachableDetection).
+// All other errors are returned immediately. In particular, if the peer ID doesn't match,
+// the addresses are probably stale (or an attacker is involved), and the remaining addresses are not dialed.
+// If all dials fail, the error of the last dial is returned.
+func (t *transport) DialBest(ctx context.Context, addrs []ma.Multiaddr, p peer.ID) (tpt.CapableConn, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses to dial")
+	}
+	var err error
+	for _, addr := range addrs {
+		var c tpt.CapableConn
+		c, err = t.Dial(ctx, addr, p)
+		if err == nil {
+			return c, nil
+		}
+		if !isUnreachableError(ctx, err) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// isUnreachableError says if a dial failed because the address couldn't be reached.
+func isUnreachableError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, ErrConnRefused) {
+		return true
+	}
+	nerr, ok := err.(net.Error)
+	return ok && nerr.Timeout()
+}
@@ -0,0 +1,137 @@
+package libp2pquic
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	tpt "github.com/libp2p/go-libp2p-core/transport"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// A dialKey identifies dials that can be shared.
+// Besides the peer and address, it contains the per-dial options set on the context,
+// since dials using different options result in different connections.
+type dialKey struct {
+	peer peer.ID
+	addr string
+
+	connClass     interface{} // see WithConnClass
+	shardHint     interface{} // see WithShardHint
+	maxPacketSize interface{} // see WithMaxPacketSize
+	affinity      string      // see WithDialAffinity
+	pins          string      // see WithCertificatePins
+}
+
+func newDialKey(ctx context.Context, raddr ma.Multiaddr, p peer.ID) dialKey {
+	var pins []byte
+	for _, pin := range certificatePins(ctx) {
+		pins = append(pins, pin[:]...)
+	}
+	return dialKey{
+		peer:          p,
+		addr:          string(raddr.Bytes()),
+		connClass:     ctx.Value(connClassKey{}),
+		shardHint:     ctx.Value(shardHintKey{}),
+		maxPacketSize: ctx.Value(maxPacketSizeKey{}),
+		affinity:      dialAffinity(ctx),
+		pins:          string(pins),
+	}
+}
+
+// A detachedContext carries the values of a context, but isn't canceled when that context is.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+type pendingDial struct {
+	done chan struct{}
+	conn tpt.CapableConn
+	err  error
+
+	// protected by the dialCoalescer's mutex
+	waiters  int
+	finished bool
+	cancel   context.CancelFunc
+}
+
+// The dialCoalescer makes concurrent dials to the same peer and address share a single dial.
+type dialCoalescer struct {
+	mutex   sync.Mutex
+	pending map[dialKey]*pendingDial
+}
+
+func newDialCoalescer() *dialCoalescer {
+	return &dialCoalescer{pending: make(map[dialKey]*pendingDial)}
+}
+
+// Dial dials using the dial function, unless a dial to the same peer and address, using the same options, is already in progress.
+// In that case, it waits for the result of that dial.
+// The shared dial uses the values and the deadline of the context of the first caller.
+// It is only canceled when the contexts of all callers are canceled,
+// and the connection is closed if the dial succeeds after that.
+func (d *dialCoalescer) Dial(
+	ctx context.Context,
+	raddr ma.Multiaddr,
+	p peer.ID,
+	dial func(context.Context, ma.Multiaddr, peer.ID) (tpt.CapableConn, error),
+) (tpt.CapableConn, error) {
+	key := newDialKey(ctx, raddr, p)
+
+	d.mutex.Lock()
+	pd, ok := d.pending[key]
+	if !ok {
+		var dialCtx context.Context
+		var cancel context.CancelFunc
+		if deadline, ok := ctx.Deadline(); ok {
+			dialCtx, cancel = context.WithDeadline(detachedContext{ctx}, deadline)
+		} else {
+			dialCtx, cancel = context.WithCancel(detachedContext{ctx})
+		}
+		pd = &pendingDial{
+			done:   make(chan struct{}),
+			cancel: cancel,
+		}
+		d.pending[key] = pd
+		go func() {
+			c, err := dial(dialCtx, raddr, p)
+			cancel()
+			d.mutex.Lock()
+			delete(d.pending, key)
+			pd.conn, pd.err = c, err
+			pd.finished = true
+			orphaned := pd.waiters == 0
+			d.mutex.Unlock()
+			if orphaned && c != nil {
+				c.Close()
+			}
+			close(pd.done)
+		}()
+	}
+	pd.waiters++
+	d.mutex.Unlock()
+
+	select {
+	case <-pd.done:
+		return pd.conn, pd.err
+	case <-ctx.Done():
+		d.mutex.Lock()
+		pd.waiters--
+		// If the dial already finished, nobody else will use the connection.
+		orphaned := pd.waiters == 0 && pd.finished && pd.conn != nil
+		if pd.waiters == 0 {
+			pd.cancel()
+		}
+		d.mutex.Unlock()
+		if orphaned {
+			pd.conn.Close()
+		}
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,219 @@
+package libp2pquic
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	tpt "github.com/libp2p/go-libp2p-core/transport"
+
+	ma "github.com/multiformats/go-multiaddr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type closeCountingConn struct {
+	tpt.CapableConn
+	closed int32
+}
+
+func (c *closeCountingConn) Close() error {
+	atomic.AddInt32(&c.closed, 1)
+	return nil
+}
+
+var _ = Describe("Dial Coalescer", func() {
+	var (
+		d         *dialCoalescer
+		addr      ma.Multiaddr
+		numDials  int32
+		unblock   chan struct{}
+		dialCtxs  chan context.Context
+		dialedCon *conn
+	)
+
+	dial := func(ctx context.Context, _ ma.Multiaddr, _ peer.ID) (tpt.CapableConn, error) {
+		atomic.AddInt32(&numDials, 1)
+		dialCtxs <- ctx
+		select {
+		case <-unblock:
+			return dialedCon, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	numWaiters := func() int {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		var n int
+		for _, pd := range d.pending {
+			n += pd.waiters
+		}
+		return n
+	}
+
+	BeforeEach(func() {
+		d = newDialCoalescer()
+		var err error
+		addr, err = ma.NewMultiaddr("/ip4/127.0.0.1/udp/1234/quic")
+		Expect(err).ToNot(HaveOccurred())
+		numDials = 0
+		unblock = make(chan struct{})
+		dialCtxs = make(chan context.Context, 10)
+		dialedCon = &conn{}
+	})
+
+	type result struct {
+		conn tpt.CapableConn
+		err  error
+	}
+
+	startDial := func(ctx context.Context, p peer.ID) <-chan result {
+		c := make(chan result, 1)
+		go func() {
+			conn, err := d.Dial(ctx, addr, p, dial)
+			c <- result{conn: conn, err: err}
+		}()
+		return c
+	}
+
+	It("shares a dial between concurrent callers", func() {
+		var results []<-chan result
+		for i := 0; i < 5; i++ {
+			results = append(results, startDial(context.Background(), "peer"))
+		}
+		Eventually(numWaiters).Should(Equal(5))
+		close(unblock)
+		for _, c := range results {
+			var res result
+			Eventually(c).Should(Receive(&res))
+			Expect(res.err).ToNot(HaveOccurred())
+			Expect(res.conn).To(BeIdenticalTo(dialedCon))
+		}
+		Expect(atomic.LoadInt32(&numDials)).To(BeEquivalentTo(1))
+		Expect(d.pending).To(BeEmpty())
+	})
+
+	It("doesn't share dials to different peers", func() {
+		res1 := startDial(context.Background(), "peer1")
+		res2 := startDial(context.Background(), "peer2")
+		Eventually(func() int32 { return atomic.LoadInt32(&numDials) }).Should(BeEquivalentTo(2))
+		close(unblock)
+		Eventually(res1).Should(Receive())
+		Eventually(res2).Should(Receive())
+	})
+
+	It("doesn't cancel the shared dial if only one caller cancels", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		res1 := startDial(ctx, "peer")
+		res2 := startDial(context.Background(), "peer")
+		Eventually(numWaiters).Should(Equal(2))
+		cancel()
+		var res result
+		Eventually(res1).Should(Receive(&res))
+		Expect(res.err).To(MatchError(context.Canceled))
+		var dialCtx context.Context
+		Eventually(dialCtxs).Should(Receive(&dialCtx))
+		Expect(dialCtx.Err()).ToNot(HaveOccurred())
+
+		close(unblock)
+		Eventually(res2).Should(Receive(&res))
+		Expect(res.err).ToNot(HaveOccurred())
+		Expect(res.conn).To(BeIdenticalTo(dialedCon))
+	})
+
+	It("cancels the shared dial when all callers cancel", func() {
+		ctx1, cancel1 := context.WithCancel(context.Background())
+		ctx2, cancel2 := context.WithCancel(context.Background())
+		res1 := startDial(ctx1, "peer")
+		res2 := startDial(ctx2, "peer")
+		Eventually(numWaiters).Should(Equal(2))
+		cancel1()
+		cancel2()
+		Eventually(res1).Should(Receive())
+		Eventually(res2).Should(Receive())
+		var dialCtx context.Context
+		Eventually(dialCtxs).Should(Receive(&dialCtx))
+		Eventually(dialCtx.Done()).Should(BeClosed())
+	})
+
+	It("passes the values and the deadline of the context to the shared dial", func() {
+		deadline := time.Now().Add(time.Hour)
+		ctx, cancel := context.WithDeadline(WithDialAffinity(context.Background(), "foo"), deadline)
+		defer cancel()
+		res := startDial(ctx, "peer")
+		var dialCtx context.Context
+		Eventually(dialCtxs).Should(Receive(&dialCtx))
+		Expect(dialAffinity(dialCtx)).To(Equal("foo"))
+		dl, ok := dialCtx.Deadline()
+		Expect(ok).To(BeTrue())
+		Expect(dl).To(Equal(deadline))
+		close(unblock)
+		Eventually(res).Should(Receive())
+	})
+
+	It("doesn't share dials using different options", func() {
+		ctx := context.Background()
+		res1 := startDial(ctx, "peer")
+		res2 := startDial(WithConnClass(ctx, "bulk"), "peer")
+		res3 := startDial(WithShardHint(ctx, 1), "peer")
+		res4 := startDial(WithMaxPacketSize(ctx, 1200), "peer")
+		res5 := startDial(WithDialAffinity(ctx, "foo"), "peer")
+		res6 := startDial(WithCertificatePins(ctx, CertificatePin{1}), "peer")
+		Eventually(func() int32 { return atomic.LoadInt32(&numDials) }).Should(BeEquivalentTo(6))
+		// the same options are shared
+		res7 := startDial(WithCertificatePins(ctx, CertificatePin{1}), "peer")
+		Eventually(numWaiters).Should(Equal(7))
+		Expect(atomic.LoadInt32(&numDials)).To(BeEquivalentTo(6))
+		close(unblock)
+		for _, res := range []<-chan result{res1, res2, res3, res4, res5, res6, res7} {
+			Eventually(res).Should(Receive())
+		}
+	})
+
+	It("closes the connection if the dial succeeds after all callers canceled", func() {
+		c := &closeCountingConn{}
+		slowDial := func(context.Context, ma.Multiaddr, peer.ID) (tpt.CapableConn, error) {
+			<-unblock
+			return c, nil
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		errChan := make(chan error, 1)
+		go func() {
+			_, err := d.Dial(ctx, addr, "peer", slowDial)
+			errChan <- err
+		}()
+		Eventually(numWaiters).Should(Equal(1))
+		cancel()
+		Eventually(errChan).Should(Receive(MatchError(context.Canceled)))
+		close(unblock)
+		Eventually(func() int32 { return atomic.LoadInt32(&c.closed) }).Should(BeEquivalentTo(1))
+		Consistently(func() int32 { return atomic.LoadInt32(&c.closed) }).Should(BeEquivalentTo(1))
+	})
+
+	It("returns the error of the shared dial", func() {
+		testErr := errors.New("dial failed")
+		failingDial := func(context.Context, ma.Multiaddr, peer.ID) (tpt.CapableConn, error) {
+			<-unblock
+			return nil, testErr
+		}
+		c1 := make(chan error, 1)
+		c2 := make(chan error, 1)
+		go func() {
+			_, err := d.Dial(context.Background(), addr, "peer", failingDial)
+			c1 <- err
+		}()
+		go func() {
+			_, err := d.Dial(context.Background(), addr, "peer", failingDial)
+			c2 <- err
+		}()
+		Eventually(numWaiters).Should(Equal(2))
+		close(unblock)
+		Eventually(c1).Should(Receive(MatchError(testErr)))
+		Eventually(c2).Should(Receive(MatchError(testErr)))
+	})
+})
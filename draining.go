@@ -0,0 +1,24 @@
+package libp2pquic
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrDraining is returned when dialing or listening while the transport is draining, see SetDraining.
+var ErrDraining = errors.New("transport is draining")
+
+// SetDraining puts the transport into (or takes it out of) draining mode, e.g. for maintenance.
+// While draining, Dial and Listen return ErrDraining, and listeners close new connections.
+// Existing connections are not affected.
+func (t *transport) SetDraining(draining bool) {
+	var v int32
+	if draining {
+		v = 1
+	}
+	atomic.StoreInt32(&t.draining, v)
+}
+
+func (t *transport) isDraining() bool {
+	return atomic.LoadInt32(&t.draining) == 1
+}
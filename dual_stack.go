@@ -0,0 +1,23 @@
+package libp2pquic
+
+import "net"
+
+// socketNetwork returns the network of the socket used for dials of network.
+// With a dual-stack socket, IPv4 and IPv6 dials use the same socket.
+func (c *connManager) socketNetwork(network string) string {
+	if c.dualStack {
+		return "udp"
+	}
+	return network
+}
+
+// listenNetwork returns the network of the socket a listener for laddr uses.
+// If dual-stack sockets are enabled, a listener on the IPv6 wildcard address also accepts IPv4 connections.
+// The remote address of these connections is an IPv4-mapped IPv6 address,
+// which is converted to an /ip4 multiaddr (see toQuicMultiaddr).
+func (t *transport) listenNetwork(network string, laddr *net.UDPAddr) string {
+	if t.config.dualStack && network == "udp6" && laddr.IP.Equal(net.IPv6unspecified) {
+		return "udp"
+	}
+	return network
+}
@@ -0,0 +1,65 @@
+package libp2pquic
+
+import (
+	"errors"
+	"fmt"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ErrAlreadyListening is returned by Listen when the transport is already listening on the multiaddr,
+// unless the DuplicateListenShare policy is used.
+var ErrAlreadyListening = errors.New("already listening on this address")
+
+// A DuplicateListenPolicy determines what happens when Listen is called for a multiaddr
+// that the transport is already listening on, see WithDuplicateListenPolicy.
+type DuplicateListenPolicy int
+
+const (
+	// DuplicateListenReject makes Listen return ErrAlreadyListening.
+	DuplicateListenReject DuplicateListenPolicy = iota
+	// DuplicateListenShare makes Listen return the existing listener.
+	// The listener is reference counted: It is only closed once Close was called for every Listen call.
+	// Connections are returned by the Accept calls of all users.
+	DuplicateListenShare
+)
+
+func (p DuplicateListenPolicy) String() string {
+	switch p {
+	case DuplicateListenReject:
+		return "reject"
+	case DuplicateListenShare:
+		return "share"
+	default:
+		return fmt.Sprintf("unknown duplicate listen policy: %d", int(p))
+	}
+}
+
+// listenKey returns the key used to detect duplicate listens on addr.
+// Listens on port 0 are never duplicates, since every listener gets a new port.
+// Such listeners are registered using the multiaddr of the port they got.
+func listenKey(addr ma.Multiaddr) string {
+	port, err := addr.ValueForProtocol(ma.P_UDP)
+	if err != nil || port == "0" {
+		return ""
+	}
+	return addr.String()
+}
+
+// releaseListenAddr releases a reference to a listener.
+// It returns false if the listener is still used by other callers of Listen, and must not be closed yet.
+func (t *transport) releaseListenAddr(l *listener) bool {
+	if l.listenKey == "" {
+		return true
+	}
+	t.listenAddrsMutex.Lock()
+	defer t.listenAddrsMutex.Unlock()
+	l.refs--
+	if l.refs > 0 {
+		return false
+	}
+	if t.listenAddrs[l.listenKey] == l {
+		delete(t.listenAddrs, l.listenKey)
+	}
+	return true
+}
@@ -0,0 +1,18 @@
+package libp2pquic
+
+import "errors"
+
+// ErrEarlyDataUnsupported is returned by SetAcceptEarlyData when enabling early data,
+// since quic-go v0.11 doesn't support 0-RTT.
+var ErrEarlyDataUnsupported = errors.New("0-RTT data is not supported")
+
+// SetAcceptEarlyData sets if streams carrying 0-RTT data are returned by AcceptStream.
+// 0-RTT data can be replayed by an attacker, so it is only safe to accept for idempotent requests.
+// The version of quic-go currently used doesn't support 0-RTT, so no stream carries early data,
+// and accepting early data fails with ErrEarlyDataUnsupported.
+func (c *conn) SetAcceptEarlyData(accept bool) error {
+	if accept {
+		return ErrEarlyDataUnsupported
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package libp2pquic
+
+// An EncryptionLevel is the encryption level used to send data on a connection.
+type EncryptionLevel uint8
+
+const (
+	// EncryptionHandshake means that the handshake hasn't completed yet.
+	EncryptionHandshake EncryptionLevel = iota
+	// Encryption0RTT means that data is sent using 0-RTT keys, which don't provide forward secrecy or replay protection.
+	Encryption0RTT
+	// Encryption1RTT means that the handshake completed, and data is sent using 1-RTT keys.
+	Encryption1RTT
+)
+
+func (l EncryptionLevel) String() string {
+	switch l {
+	case EncryptionHandshake:
+		return "handshake"
+	case Encryption0RTT:
+		return "0-RTT"
+	case Encryption1RTT:
+		return "1-RTT"
+	default:
+		return "unknown encryption level"
+	}
+}
+
+// EncryptionLevel returns the encryption level currently used to send data on this connection.
+// quic-go v0.11 doesn't support 0-RTT, and only returns sessions from Dial and Accept once the handshake completed,
+// so connections usually report Encryption1RTT.
+func (c *conn) EncryptionLevel() EncryptionLevel {
+	if c.sess.ConnectionState().HandshakeComplete {
+		return Encryption1RTT
+	}
+	return EncryptionHandshake
+}
+
+// Is1RTT says if data is sent using 1-RTT keys, i.e. if the handshake completed.
+// Data sent at this level is protected against replays.
+func (c *conn) Is1RTT() bool {
+	return c.EncryptionLevel() == Encryption1RTT
+}
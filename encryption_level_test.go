@@ -0,0 +1,40 @@
+package libp2pquic
+
+import (
+	"crypto/tls"
+	"sync/atomic"
+
+	quic "github.com/lucas-clemente/quic-go"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// handshakingSession is a quic.Session that completes the handshake when handshakeComplete is set to 1.
+type handshakingSession struct {
+	quic.Session
+	handshakeComplete int32
+}
+
+func (s *handshakingSession) ConnectionState() tls.ConnectionState {
+	return tls.ConnectionState{HandshakeComplete: atomic.LoadInt32(&s.handshakeComplete) == 1}
+}
+
+var _ = Describe("Encryption Levels", func() {
+	It("switches to 1-RTT when the handshake completes", func() {
+		sess := &handshakingSession{}
+		c := &conn{sess: sess}
+		Expect(c.EncryptionLevel()).To(Equal(EncryptionHandshake))
+		Expect(c.Is1RTT()).To(BeFalse())
+		atomic.StoreInt32(&sess.handshakeComplete, 1)
+		Expect(c.EncryptionLevel()).To(Equal(Encryption1RTT))
+		Expect(c.Is1RTT()).To(BeTrue())
+	})
+
+	It("has a string representation", func() {
+		Expect(EncryptionHandshake.String()).To(Equal("handshake"))
+		Expect(Encryption0RTT.String()).To(Equal("0-RTT"))
+		Expect(Encryption1RTT.String()).To(Equal("1-RTT"))
+		Expect(EncryptionLevel(42).String()).To(Equal("unknown encryption level"))
+	})
+})
@@ -0,0 +1,86 @@
+package libp2pquic
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Application error codes are used when closing connections (see CloseConnsToPeer) and canceling streams (see ResetWithCode).
+// quic-go v0.11 limits them to 16 bits. The codes are reserved as follows:
+//
+//	0x0           no error
+//	0x1 - 0xff    the transport (e.g. StreamQueueFullErrorCode)
+//	0x100 - 0xffff  application protocols, see ReserveErrorCodes
+//
+// Protocols multiplexed over a connection should each reserve a distinct range,
+// so that a peer can tell which protocol closed the connection or canceled a stream.
+const (
+	// FirstApplicationErrorCode is the first error code available to application protocols.
+	FirstApplicationErrorCode StreamErrorCode = 0x100
+	// LastApplicationErrorCode is the last error code available to application protocols.
+	LastApplicationErrorCode StreamErrorCode = maxStreamErrorCode
+)
+
+// An ErrorCodeNamespace is a range of application error codes reserved for a protocol.
+type ErrorCodeNamespace struct {
+	protocol    string
+	first, last StreamErrorCode
+	releaseOnce sync.Once
+}
+
+var (
+	errorCodeNamespacesMutex sync.Mutex
+	errorCodeNamespaces      = make(map[*ErrorCodeNamespace]struct{})
+)
+
+// ReserveErrorCodes reserves the error codes from first to last (inclusive) for a protocol.
+// The range must be within the codes available to application protocols,
+// and must not overlap with a range reserved by another protocol.
+func ReserveErrorCodes(protocol string, first, last StreamErrorCode) (*ErrorCodeNamespace, error) {
+	if first > last {
+		return nil, fmt.Errorf("invalid error code range for %s: %#x-%#x", protocol, first, last)
+	}
+	if first < FirstApplicationErrorCode || last > LastApplicationErrorCode {
+		return nil, fmt.Errorf("error code range of %s (%#x-%#x) outside the application range (%#x-%#x)", protocol, first, last, FirstApplicationErrorCode, LastApplicationErrorCode)
+	}
+	errorCodeNamespacesMutex.Lock()
+	defer errorCodeNamespacesMutex.Unlock()
+	for ns := range errorCodeNamespaces {
+		if first <= ns.last && ns.first <= last {
+			return nil, fmt.Errorf("error code range of %s (%#x-%#x) overlaps with %s (%#x-%#x)", protocol, first, last, ns.protocol, ns.first, ns.last)
+		}
+	}
+	ns := &ErrorCodeNamespace{protocol: protocol, first: first, last: last}
+	errorCodeNamespaces[ns] = struct{}{}
+	return ns, nil
+}
+
+// Release releases the range, so it can be reserved by another protocol.
+func (n *ErrorCodeNamespace) Release() {
+	n.releaseOnce.Do(func() {
+		errorCodeNamespacesMutex.Lock()
+		delete(errorCodeNamespaces, n)
+		errorCodeNamespacesMutex.Unlock()
+	})
+}
+
+// Code returns the error code for an offset into the range, i.e. the first code of the range plus offset.
+func (n *ErrorCodeNamespace) Code(offset uint64) (StreamErrorCode, error) {
+	if offset > uint64(n.last-n.first) {
+		return 0, fmt.Errorf("error code offset %d outside the range of %s (%#x-%#x)", offset, n.protocol, n.first, n.last)
+	}
+	return n.first + StreamErrorCode(offset), nil
+}
+
+// Contains says if a code is within the range.
+func (n *ErrorCodeNamespace) Contains(code StreamErrorCode) bool {
+	return code >= n.first && code <= n.last
+}
+
+// Validate returns an error if a code is not within the range.
+func (n *ErrorCodeNamespace) Validate(code StreamErrorCode) error {
+	if !n.Contains(code) {
+		return fmt.Errorf("error code %#x outside the range of %s (%#x-%#x)", code, n.protocol, n.first, n.last)
+	}
+	return nil
+}
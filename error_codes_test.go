@@ -0,0 +1,58 @@
+package libp2pquic
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Error code namespaces", func() {
+	It("maps offsets to codes", func() {
+		ns, err := ReserveErrorCodes("foo", 0x100, 0x10f)
+		Expect(err).ToNot(HaveOccurred())
+		defer ns.Release()
+		code, err := ns.Code(0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(code).To(Equal(StreamErrorCode(0x100)))
+		code, err = ns.Code(0xf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(code).To(Equal(StreamErrorCode(0x10f)))
+		_, err = ns.Code(0x10)
+		Expect(err).To(MatchError("error code offset 16 outside the range of foo (0x100-0x10f)"))
+	})
+
+	It("rejects codes outside the range", func() {
+		ns, err := ReserveErrorCodes("foo", 0x200, 0x2ff)
+		Expect(err).ToNot(HaveOccurred())
+		defer ns.Release()
+		Expect(ns.Validate(0x200)).To(Succeed())
+		Expect(ns.Validate(0x2ff)).To(Succeed())
+		Expect(ns.Validate(0x1ff)).To(MatchError("error code 0x1ff outside the range of foo (0x200-0x2ff)"))
+		Expect(ns.Validate(0x300)).To(HaveOccurred())
+		Expect(ns.Contains(StreamErrorCode(StreamQueueFullErrorCode))).To(BeFalse())
+	})
+
+	It("refuses ranges outside the application range", func() {
+		_, err := ReserveErrorCodes("foo", 0x1, 0x100)
+		Expect(err).To(MatchError("error code range of foo (0x1-0x100) outside the application range (0x100-0xffff)"))
+		_, err = ReserveErrorCodes("foo", 0xff00, 0x10000)
+		Expect(err).To(HaveOccurred())
+		_, err = ReserveErrorCodes("foo", 0x200, 0x100)
+		Expect(err).To(MatchError("invalid error code range for foo: 0x200-0x100"))
+	})
+
+	It("refuses overlapping ranges", func() {
+		ns, err := ReserveErrorCodes("foo", 0x100, 0x1ff)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = ReserveErrorCodes("bar", 0x1f0, 0x2ff)
+		Expect(err).To(MatchError("error code range of bar (0x1f0-0x2ff) overlaps with foo (0x100-0x1ff)"))
+		bar, err := ReserveErrorCodes("bar", 0x200, 0x2ff)
+		Expect(err).ToNot(HaveOccurred())
+		defer bar.Release()
+
+		// released ranges can be reserved again
+		ns.Release()
+		ns, err = ReserveErrorCodes("baz", 0x100, 0x1ff)
+		Expect(err).ToNot(HaveOccurred())
+		ns.Release()
+	})
+})
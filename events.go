@@ -0,0 +1,176 @@
+package libp2pquic
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// The number of events buffered for every subscriber.
+// When a subscriber doesn't keep up, the oldest events are dropped.
+const eventBufferSize = 64
+
+// An EventType is the type of a transport lifecycle event.
+type EventType int
+
+const (
+	// EventDialStarted is emitted when Dial is called.
+	EventDialStarted EventType = iota + 1
+	// EventDialSucceeded is emitted when Dial returns a connection.
+	EventDialSucceeded
+	// EventDialFailed is emitted when Dial returns an error.
+	EventDialFailed
+	// EventConnOpened is emitted when a connection is established, for both dialed and accepted connections.
+	EventConnOpened
+	// EventConnClosed is emitted when a connection is closed.
+	// CloseReason says why it was closed, and Err is the *ClosedError returned by CloseError.
+	EventConnClosed
+	// EventListenerOpened is emitted when a new listener is created.
+	// Addr is the address the listener is bound to. When listening on port 0, it contains the port chosen by the OS.
+	EventListenerOpened
+	// EventListenerClosed is emitted when a listener is closed.
+	EventListenerClosed
+	// EventStreamOpened is emitted when a bidirectional stream is opened or accepted.
+	EventStreamOpened
+	// EventStreamClosed is emitted when a bidirectional stream is closed or reset by the application.
+	EventStreamClosed
+	// EventPathChanged is emitted when packets of an accepted connection arrive from a new remote address,
+	// e.g. because a NAT rebound the peer's mapping. Addr is the new address.
+	EventPathChanged
+	// EventHandlerPanicked is emitted when the handler passed to ServeConns panics.
+	// Err contains the value the handler panicked with.
+	EventHandlerPanicked
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventDialStarted:
+		return "dial started"
+	case EventDialSucceeded:
+		return "dial succeeded"
+	case EventDialFailed:
+		return "dial failed"
+	case EventConnOpened:
+		return "connection opened"
+	case EventConnClosed:
+		return "connection closed"
+	case EventListenerOpened:
+		return "listener opened"
+	case EventListenerClosed:
+		return "listener closed"
+	case EventStreamOpened:
+		return "stream opened"
+	case EventStreamClosed:
+		return "stream closed"
+	case EventPathChanged:
+		return "path changed"
+	case EventHandlerPanicked:
+		return "handler panicked"
+	default:
+		return fmt.Sprintf("unknown event type: %d", int(t))
+	}
+}
+
+// An Event is a transport lifecycle event, see Subscribe.
+type Event struct {
+	Type EventType
+	Time time.Time
+	// The remote peer. Not set for listener events.
+	Peer peer.ID
+	// The remote address for dial, connection and stream events, the local address for listener events.
+	Addr ma.Multiaddr
+	// The error of a failed dial, the *ClosedError of a closed connection, or the panic of a connection handler.
+	Err error
+	// Why a connection was closed. Only set for EventConnClosed.
+	CloseReason CloseReason
+}
+
+// An eventBus distributes events to its subscribers.
+type eventBus struct {
+	numSubscribers int32 // must be accessed atomically
+
+	mutex       sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func (b *eventBus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBufferSize)
+	b.mutex.Lock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan Event]struct{})
+	}
+	b.subscribers[ch] = struct{}{}
+	b.mutex.Unlock()
+	atomic.AddInt32(&b.numSubscribers, 1)
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			delete(b.subscribers, ch)
+			close(ch)
+			b.mutex.Unlock()
+			atomic.AddInt32(&b.numSubscribers, -1)
+		})
+	}
+}
+
+// Publish sends an event to all subscribers.
+// If the buffer of a subscriber is full, its oldest event is dropped.
+func (b *eventBus) Publish(ev Event) {
+	if atomic.LoadInt32(&b.numSubscribers) == 0 {
+		return
+	}
+	ev.Time = time.Now()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for ch := range b.subscribers {
+		for {
+			select {
+			case ch <- ev:
+			default:
+				// drop the oldest event, and try again
+				select {
+				case <-ch:
+				default:
+				}
+				continue
+			}
+			break
+		}
+	}
+}
+
+// Subscribe subscribes to the lifecycle events of the transport.
+// Events are buffered, and the oldest events are dropped if the subscriber doesn't keep up.
+// The returned function cancels the subscription and closes the channel.
+func (t *transport) Subscribe() (<-chan Event, func()) {
+	return t.events.Subscribe()
+}
+
+// publish publishes an event on the transport of the connection.
+func (c *conn) publish(typ EventType) {
+	c.publishEvent(Event{Type: typ})
+}
+
+// publishClosed publishes an EventConnClosed, carrying the reason why the connection was closed.
+func (c *conn) publishClosed(closeErr error) {
+	ev := Event{Type: EventConnClosed, Err: closeErr}
+	if cerr, ok := closeErr.(*ClosedError); ok {
+		ev.CloseReason = cerr.Reason
+	}
+	c.publishEvent(ev)
+}
+
+func (c *conn) publishEvent(ev Event) {
+	if t, ok := c.transport.(*transport); ok && t != nil {
+		ev.Peer = c.remotePeerID
+		ev.Addr = c.remoteMultiaddr
+		t.events.Publish(ev)
+	}
+}
@@ -8,7 +8,10 @@ require (
 	github.com/multiformats/go-multiaddr-net v0.0.1
 	github.com/onsi/ginkgo v1.7.0
 	github.com/onsi/gomega v1.4.3
+	github.com/prometheus/client_golang v1.0.0
 	github.com/whyrusleeping/mafmt v1.2.8
+	golang.org/x/net v0.0.0-20181114220301-adae6a3d119a
+	golang.org/x/sys v0.0.0-20190228124157-a34e9553db1e
 )
 
 go 1.13
@@ -0,0 +1,80 @@
+package libp2pquic
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sync"
+	"time"
+)
+
+// The handshake timeout used by quic-go, if quic.Config.HandshakeTimeout is not set.
+const defaultHandshakeTimeout = 10 * time.Second
+
+var errTooManyHandshakes = errors.New("too many incoming handshakes")
+
+// The handshakeLimiter bounds the number of concurrent incoming handshakes.
+// A slot is taken when the ClientHello is received. It is released when the client's
+// certificate chain is received, or when the handshake times out.
+// Handshakes beyond the limit are refused.
+type handshakeLimiter struct {
+	sem     chan struct{}
+	timeout time.Duration
+}
+
+func newHandshakeLimiter(n int) *handshakeLimiter {
+	return &handshakeLimiter{
+		sem:     make(chan struct{}, n),
+		timeout: defaultHandshakeTimeout,
+	}
+}
+
+// InProgress returns the number of handshakes currently in progress.
+func (h *handshakeLimiter) InProgress() int {
+	return len(h.sem)
+}
+
+func (h *handshakeLimiter) acquire() (release func(), ok bool) {
+	select {
+	case h.sem <- struct{}{}:
+	default:
+		return nil, false
+	}
+	var once sync.Once
+	release = func() { once.Do(func() { <-h.sem }) }
+	time.AfterFunc(h.timeout, release)
+	return release, true
+}
+
+// Apply returns a copy of the tls.Config that is subject to the handshake limit.
+func (h *handshakeLimiter) Apply(conf *tls.Config) *tls.Config {
+	conf = conf.Clone()
+	limited := conf.Clone()
+	limited.GetConfigForClient = func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
+		release, ok := h.acquire()
+		if !ok {
+			return nil, errTooManyHandshakes
+		}
+		c := conf.Clone()
+		if conf.GetConfigForClient != nil {
+			clientConf, err := conf.GetConfigForClient(chi)
+			if err != nil {
+				release()
+				return nil, err
+			}
+			if clientConf != nil {
+				c = clientConf
+			}
+		}
+		verify := c.VerifyPeerCertificate
+		c.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			release()
+			if verify != nil {
+				return verify(rawCerts, verifiedChains)
+			}
+			return nil
+		}
+		return c, nil
+	}
+	return limited
+}
@@ -0,0 +1,65 @@
+package libp2pquic
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+)
+
+// HandshakeAlgorithms are the cryptographic algorithms used by the handshake of a connection, e.g. for compliance audits.
+type HandshakeAlgorithms struct {
+	// The TLS version, always TLS 1.3 for QUIC.
+	TLSVersion  uint16
+	CipherSuite uint16
+	// KeyExchange is the group used for the (EC)DHE key exchange.
+	// quic-go v0.11 doesn't expose the negotiated group, so it is only known if the
+	// tls.Config of the transport allows a single group (see NewTransportWithTLSConfig). Otherwise, it is 0.
+	KeyExchange tls.CurveID
+	// PeerSignatureScheme is the scheme the peer signed the handshake with, using the key of its certificate.
+	// For ECDSA and Ed25519 keys, TLS 1.3 determines the scheme. For RSA keys, RSA-PSS with SHA-256 is assumed,
+	// which is the scheme Go uses. It is 0 if the peer didn't present a certificate.
+	PeerSignatureScheme tls.SignatureScheme
+	// PeerCertificateSignature is the algorithm the peer's certificate was signed with, i.e. the algorithm of its host key.
+	PeerCertificateSignature x509.SignatureAlgorithm
+}
+
+// HandshakeAlgorithms returns the cryptographic algorithms used by the handshake.
+func (c *conn) HandshakeAlgorithms() HandshakeAlgorithms {
+	state := c.sess.ConnectionState()
+	algs := HandshakeAlgorithms{
+		TLSVersion:  state.Version,
+		CipherSuite: state.CipherSuite,
+	}
+	if t, ok := c.transport.(*transport); ok && t != nil && t.tlsConf != nil && len(t.tlsConf.CurvePreferences) == 1 {
+		algs.KeyExchange = t.tlsConf.CurvePreferences[0]
+	}
+	if len(state.PeerCertificates) > 0 {
+		leaf := state.PeerCertificates[0]
+		algs.PeerSignatureScheme = signatureSchemeForKey(leaf.PublicKey)
+		algs.PeerCertificateSignature = leaf.SignatureAlgorithm
+	}
+	return algs
+}
+
+// signatureSchemeForKey returns the TLS 1.3 signature scheme used with a certificate key.
+func signatureSchemeForKey(pub interface{}) tls.SignatureScheme {
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return tls.ECDSAWithP256AndSHA256
+		case elliptic.P384():
+			return tls.ECDSAWithP384AndSHA384
+		case elliptic.P521():
+			return tls.ECDSAWithP521AndSHA512
+		}
+	case ed25519.PublicKey:
+		return tls.Ed25519
+	case *rsa.PublicKey:
+		return tls.PSSWithSHA256
+	}
+	return 0
+}
@@ -0,0 +1,39 @@
+package libp2pquic
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Handshake algorithms", func() {
+	It("determines the signature scheme from the certificate key", func() {
+		for curve, scheme := range map[elliptic.Curve]tls.SignatureScheme{
+			elliptic.P256(): tls.ECDSAWithP256AndSHA256,
+			elliptic.P384(): tls.ECDSAWithP384AndSHA384,
+			elliptic.P521(): tls.ECDSAWithP521AndSHA512,
+		} {
+			key, err := ecdsa.GenerateKey(curve, rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(signatureSchemeForKey(&key.PublicKey)).To(Equal(scheme))
+		}
+		edKey, _, err := ed25519.GenerateKey(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(signatureSchemeForKey(edKey)).To(Equal(tls.Ed25519))
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(signatureSchemeForKey(&rsaKey.PublicKey)).To(Equal(tls.PSSWithSHA256))
+	})
+
+	It("doesn't know the scheme for other keys", func() {
+		key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(signatureSchemeForKey(&key.PublicKey)).To(BeZero())
+	})
+})
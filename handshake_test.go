@@ -0,0 +1,57 @@
+package libp2pquic
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Handshake Limiter", func() {
+	It("refuses handshakes beyond the limit", func() {
+		h := newHandshakeLimiter(2)
+		conf := h.Apply(&tls.Config{})
+		c1, err := conf.GetConfigForClient(&tls.ClientHelloInfo{})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = conf.GetConfigForClient(&tls.ClientHelloInfo{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(h.InProgress()).To(Equal(2))
+		_, err = conf.GetConfigForClient(&tls.ClientHelloInfo{})
+		Expect(err).To(MatchError(errTooManyHandshakes))
+
+		// verifying the certificate chain finishes the handshake
+		Expect(c1.VerifyPeerCertificate(nil, nil)).To(Succeed())
+		Expect(h.InProgress()).To(Equal(1))
+		// calling it again doesn't release another slot
+		Expect(c1.VerifyPeerCertificate(nil, nil)).To(Succeed())
+		Expect(h.InProgress()).To(Equal(1))
+		_, err = conf.GetConfigForClient(&tls.ClientHelloInfo{})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("calls the original VerifyPeerCertificate", func() {
+		h := newHandshakeLimiter(1)
+		testErr := errors.New("test error")
+		conf := h.Apply(&tls.Config{
+			VerifyPeerCertificate: func([][]byte, [][]*x509.Certificate) error { return testErr },
+		})
+		c, err := conf.GetConfigForClient(&tls.ClientHelloInfo{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.GetConfigForClient).To(BeNil())
+		Expect(c.VerifyPeerCertificate(nil, nil)).To(MatchError(testErr))
+		Expect(h.InProgress()).To(BeZero())
+	})
+
+	It("releases the slot when the handshake times out", func() {
+		h := newHandshakeLimiter(1)
+		h.timeout = 50 * time.Millisecond
+		conf := h.Apply(&tls.Config{})
+		_, err := conf.GetConfigForClient(&tls.ClientHelloInfo{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(h.InProgress()).To(Equal(1))
+		Eventually(h.InProgress).Should(BeZero())
+	})
+})
@@ -0,0 +1,105 @@
+package libp2pquic
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// HandshakeProgress describes how far a handshake got before it failed.
+type HandshakeProgress struct {
+	// The number of packets and bytes (UDP payload) received from the peer.
+	PacketsReceived int
+	BytesReceived   uint64
+	// VersionNegotiation is set if the peer sent a Version Negotiation packet,
+	// i.e. if it doesn't support any of the QUIC versions we offered.
+	VersionNegotiation bool
+	// RetryReceived is set if the peer sent a Retry packet to validate our address.
+	RetryReceived bool
+	// ServerFlightReceived is set if the peer sent an Initial or a Handshake packet,
+	// i.e. if it started the handshake.
+	ServerFlightReceived bool
+}
+
+func (p HandshakeProgress) String() string {
+	if p.PacketsReceived == 0 {
+		return "no packets received"
+	}
+	parts := []string{fmt.Sprintf("%d packets (%d bytes) received", p.PacketsReceived, p.BytesReceived)}
+	if p.VersionNegotiation {
+		parts = append(parts, "version negotiation")
+	}
+	if p.RetryReceived {
+		parts = append(parts, "retry")
+	}
+	if p.ServerFlightReceived {
+		parts = append(parts, "server flight")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// A HandshakeTimeoutError is returned by Dial when the handshake timed out.
+// If no packet was received from the peer, UDP is probably blocked on the path to the peer,
+// and errors.Is(err, ErrUDPBlocked) is true.
+type HandshakeTimeoutError struct {
+	Progress HandshakeProgress
+	// Err is the error returned by quic-go.
+	Err error
+}
+
+var _ net.Error = &HandshakeTimeoutError{}
+
+func (e *HandshakeTimeoutError) Error() string {
+	return fmt.Sprintf("handshake timed out (%s): %s", e.Progress, e.Err)
+}
+
+func (e *HandshakeTimeoutError) Unwrap() error { return e.Err }
+
+// Is makes the error match ErrUDPBlocked if no packet was received from the peer.
+func (e *HandshakeTimeoutError) Is(target error) bool {
+	return target == ErrUDPBlocked && e.Progress.PacketsReceived == 0
+}
+
+func (e *HandshakeTimeoutError) Timeout() bool   { return true }
+func (e *HandshakeTimeoutError) Temporary() bool { return false }
+
+// The types of long header packets, as encoded in bits 5 and 6 of the first byte.
+const (
+	longHeaderTypeInitial   = 0x0
+	longHeaderTypeHandshake = 0x2
+	longHeaderTypeRetry     = 0x3
+)
+
+// recordPacket updates the handshake progress with a packet received from the peer.
+func (p *HandshakeProgress) recordPacket(b []byte) {
+	p.PacketsReceived++
+	p.BytesReceived += uint64(len(b))
+	// 1 byte type, 4 bytes version
+	if len(b) < 5 || b[0]&0x80 == 0 {
+		return
+	}
+	if b[1] == 0 && b[2] == 0 && b[3] == 0 && b[4] == 0 {
+		p.VersionNegotiation = true
+		return
+	}
+	switch (b[0] & 0x30) >> 4 {
+	case longHeaderTypeRetry:
+		p.RetryReceived = true
+	case longHeaderTypeInitial, longHeaderTypeHandshake:
+		p.ServerFlightReceived = true
+	}
+}
+
+// handshakeTimeoutError returns a *HandshakeTimeoutError if a failed dial timed out.
+// Dials that are aborted because the context expired are not considered.
+func handshakeTimeoutError(err error, w *packetWatch) error {
+	if err == context.DeadlineExceeded {
+		return err
+	}
+	nerr, ok := err.(net.Error)
+	if !ok || !nerr.Timeout() {
+		return err
+	}
+	return &HandshakeTimeoutError{Progress: w.Progress(), Err: err}
+}
@@ -0,0 +1,126 @@
+package libp2pquic
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A handshakeTimer records how long handshakes take, see WithHandshakeTiming.
+// Go doesn't measure the CPU time used by a goroutine. Certificate verification is CPU-bound and runs synchronously,
+// so the wall time spent verifying certificate chains approximates the CPU time spent on it.
+type handshakeTimer struct {
+	// must be accessed atomically
+	numHandshakes, handshakeNanos, verificationNanos uint64
+
+	mutex sync.Mutex
+	// when the ClientHello of incoming handshakes was received, keyed by the client's address
+	started map[string]time.Time
+}
+
+func newHandshakeTimer() *handshakeTimer {
+	return &handshakeTimer{started: make(map[string]time.Time)}
+}
+
+// Apply returns a copy of the tls.Config that records when incoming handshakes start,
+// and how long the verification steps configured on conf take.
+func (t *handshakeTimer) Apply(conf *tls.Config) *tls.Config {
+	base := conf.Clone()
+	conf = conf.Clone()
+	conf.GetConfigForClient = func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
+		if chi.Conn != nil {
+			t.start(chi.Conn.RemoteAddr())
+		}
+		c := base
+		if base.GetConfigForClient != nil {
+			clientConf, err := base.GetConfigForClient(chi)
+			if err != nil {
+				return nil, err
+			}
+			if clientConf != nil {
+				c = clientConf
+			}
+		}
+		c = c.Clone()
+		c.GetConfigForClient = nil
+		if verify := c.VerifyPeerCertificate; verify != nil {
+			c.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+				defer t.recordVerification(time.Now())
+				return verify(rawCerts, verifiedChains)
+			}
+		}
+		return c, nil
+	}
+	return conf
+}
+
+func (t *handshakeTimer) start(addr net.Addr) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.started) >= maxPendingConnIDs {
+		// evict an arbitrary handshake
+		for key := range t.started {
+			delete(t.started, key)
+			break
+		}
+	}
+	t.started[addr.String()] = time.Now()
+}
+
+// PopStart returns when the handshake with the client at addr started, and stops tracking it.
+func (t *handshakeTimer) PopStart(addr net.Addr) (time.Time, bool) {
+	if t == nil {
+		return time.Time{}, false
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	start, ok := t.started[addr.String()]
+	delete(t.started, addr.String())
+	return start, ok
+}
+
+// recordVerification records a verification that started at start.
+func (t *handshakeTimer) recordVerification(start time.Time) {
+	if t == nil {
+		return
+	}
+	atomic.AddUint64(&t.verificationNanos, uint64(time.Since(start)))
+}
+
+// recordHandshake records a completed handshake that took d.
+func (t *handshakeTimer) recordHandshake(d time.Duration) {
+	if t == nil {
+		return
+	}
+	atomic.AddUint64(&t.numHandshakes, 1)
+	atomic.AddUint64(&t.handshakeNanos, uint64(d))
+}
+
+// addStats adds the recorded times to the stats.
+func (t *handshakeTimer) addStats(stats *TransportStats) {
+	if t == nil {
+		return
+	}
+	stats.TimedHandshakes = atomic.LoadUint64(&t.numHandshakes)
+	stats.HandshakeTime = time.Duration(atomic.LoadUint64(&t.handshakeNanos))
+	stats.VerificationTime = time.Duration(atomic.LoadUint64(&t.verificationNanos))
+}
+
+// AverageHandshakeTime returns the average duration of the timed handshakes.
+func (s TransportStats) AverageHandshakeTime() time.Duration {
+	if s.TimedHandshakes == 0 {
+		return 0
+	}
+	return s.HandshakeTime / time.Duration(s.TimedHandshakes)
+}
+
+// AverageVerificationTime returns the average time spent verifying certificate chains per timed handshake.
+func (s TransportStats) AverageVerificationTime() time.Duration {
+	if s.TimedHandshakes == 0 {
+		return 0
+	}
+	return s.VerificationTime / time.Duration(s.TimedHandshakes)
+}
@@ -0,0 +1,67 @@
+package libp2pquic
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"net"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// The maximum number of peers whose host certificate is remembered, see OnHostCertChange.
+const maxTrackedHostCerts = 4096
+
+// A CertFingerprint is the SHA-256 hash of a certificate.
+type CertFingerprint [sha256.Size]byte
+
+// A HostCertChange is reported when a peer presents a different host certificate than before, see OnHostCertChange.
+type HostCertChange struct {
+	Peer       peer.ID
+	RemoteAddr net.Addr
+	// the fingerprints of the host certificate presented previously, and of the one presented now
+	Previous, Current CertFingerprint
+}
+
+// A hostCertTracker remembers the host certificate presented by every peer.
+// The host certificate is the last certificate of the chain, and is signed using the host key.
+// The leaf certificate is signed by the host certificate for an ephemeral key, and changes regularly.
+// If a peer ID presents a different host certificate, it either generated a new one
+// (e.g. after a restart, unless it uses WithCertificate), or another party has access to its private key.
+type hostCertTracker struct {
+	onChange func(HostCertChange)
+
+	mutex        sync.Mutex
+	fingerprints map[peer.ID]CertFingerprint
+}
+
+func newHostCertTracker(onChange func(HostCertChange)) *hostCertTracker {
+	return &hostCertTracker{
+		onChange:     onChange,
+		fingerprints: make(map[peer.ID]CertFingerprint),
+	}
+}
+
+// Check records the host certificate of the chain presented by p,
+// and calls the callback if it differs from the one presented before.
+func (t *hostCertTracker) Check(p peer.ID, addr net.Addr, chain []*x509.Certificate) {
+	if len(chain) == 0 {
+		return
+	}
+	fp := CertFingerprint(sha256.Sum256(chain[len(chain)-1].Raw))
+	t.mutex.Lock()
+	prev, ok := t.fingerprints[p]
+	if !ok && len(t.fingerprints) >= maxTrackedHostCerts {
+		// evict an arbitrary peer
+		for id := range t.fingerprints {
+			delete(t.fingerprints, id)
+			break
+		}
+	}
+	t.fingerprints[p] = fp
+	t.mutex.Unlock()
+
+	if ok && prev != fp {
+		t.onChange(HostCertChange{Peer: p, RemoteAddr: addr, Previous: prev, Current: fp})
+	}
+}
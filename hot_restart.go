@@ -0,0 +1,99 @@
+package libp2pquic
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	tpt "github.com/libp2p/go-libp2p-core/transport"
+)
+
+// ExportSockets returns duplicates of the UDP sockets of all listeners,
+// e.g. to pass them to a successor process (using SCM_RIGHTS, or os/exec's ExtraFiles) for a zero-downtime restart.
+// The successor can listen on them using NewTransportFromSockets.
+// Until the listeners of this transport are closed, packets are received by either process,
+// so they should be closed as soon as the successor is listening.
+// The caller must close the returned files.
+func (t *transport) ExportSockets() ([]*os.File, error) {
+	listeners := t.listeners()
+	files := make([]*os.File, 0, len(listeners))
+	for _, l := range listeners {
+		if l.socket == nil {
+			closeFiles(files)
+			return nil, errors.New("listener socket can't be exported")
+		}
+		f, err := l.socket.File()
+		if err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// NewTransportFromSockets creates a new QUIC transport, like NewTransport,
+// and listens on the UDP sockets exported by a predecessor process (see ExportSockets).
+// The files are not used after it returns, and can be closed by the caller.
+func NewTransportFromSockets(key ic.PrivKey, files []*os.File, opts ...Option) (tpt.Transport, []tpt.Listener, error) {
+	tr, err := NewTransport(key, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	t := tr.(*transport)
+	tlsConf, err := t.getTLSConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	listeners := make([]tpt.Listener, 0, len(files))
+	closeListeners := func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}
+	for _, f := range files {
+		ln, err := t.listenOnFile(f, tlsConf)
+		if err != nil {
+			closeListeners()
+			return nil, nil, err
+		}
+		listeners = append(listeners, ln)
+	}
+	return tr, listeners, nil
+}
+
+func (t *transport) listenOnFile(f *os.File, tlsConf *tls.Config) (tpt.Listener, error) {
+	pconn, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, err
+	}
+	conn, ok := pconn.(*net.UDPConn)
+	if !ok {
+		pconn.Close()
+		return nil, fmt.Errorf("%s is not a UDP socket", f.Name())
+	}
+	addr, err := toQuicMultiaddr(conn.LocalAddr())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ln, err := t.listenOnSocket(addr, conn, tlsConf, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// If the transport was already listening on this address (see DuplicateListenShare), the socket isn't used.
+	if l, ok := ln.(*listener); ok && l.socket != conn {
+		conn.Close()
+	}
+	return ln, nil
+}
@@ -0,0 +1,60 @@
+package libp2pquic
+
+import (
+	"context"
+	"crypto/rand"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	tpt "github.com/libp2p/go-libp2p-core/transport"
+	ma "github.com/multiformats/go-multiaddr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Hot restarts", func() {
+	It("accepts connections on sockets inherited from another transport", func() {
+		serverKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		serverID, err := peer.IDFromPrivateKey(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+
+		oldTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		oldLn, err := oldTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		files, err := oldTransport.(*transport).ExportSockets()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+		Expect(oldLn.Close()).To(Succeed())
+
+		newTransport, listeners, err := NewTransportFromSockets(serverKey, files)
+		Expect(err).ToNot(HaveOccurred())
+		closeFiles(files)
+		Expect(listeners).To(HaveLen(1))
+		ln := listeners[0]
+		defer ln.Close()
+		Expect(ln.Multiaddr()).To(Equal(oldLn.Multiaddr()))
+		Expect(ln.(*listener).transport).To(Equal(newTransport.(*transport)))
+
+		accepted := make(chan tpt.CapableConn, 1)
+		go func() {
+			defer GinkgoRecover()
+			conn, err := ln.Accept()
+			Expect(err).ToNot(HaveOccurred())
+			accepted <- conn
+		}()
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		var sconn tpt.CapableConn
+		Eventually(accepted).Should(Receive(&sconn))
+		defer sconn.Close()
+		Expect(sconn.RemotePeer()).To(Equal(conn.LocalPeer()))
+	})
+})
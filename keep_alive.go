@@ -0,0 +1,49 @@
+package libp2pquic
+
+import (
+	"context"
+	"time"
+)
+
+// quic-go closes connections after 30s without network activity, unless IdleTimeout is set.
+const defaultIdleTimeout = 30 * time.Second
+
+// sendKeepAlive sends a packet that keeps the connection alive.
+// quic-go v0.11 doesn't allow sending PING frames, so a ping is sent on a control stream (see Ping).
+// Any packet keeps the connection alive, so the pong doesn't need to be received.
+// It returns ErrControlStreamsUnsupported if the peer doesn't support control streams.
+var sendKeepAlive = func(ctx context.Context, c *conn) error {
+	_, err := c.Ping(ctx)
+	return err
+}
+
+// idleTimeout returns the idle timeout used by quic-go.
+func idleTimeout() time.Duration {
+	if quicConfig.IdleTimeout > 0 {
+		return quicConfig.IdleTimeout
+	}
+	return defaultIdleTimeout
+}
+
+// keepAlive sends a keep-alive every period, until the connection is closed.
+// quic-go v0.11 sends its keep-alives when half of the peer's idle timeout passed without activity,
+// and doesn't allow configuring this interval.
+// If the peer doesn't support control streams, only quic-go's keep-alives are sent.
+func (c *conn) keepAlive(period time.Duration) {
+	ctx := c.sess.Context()
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(ctx, period)
+			err := sendKeepAlive(pingCtx, c)
+			cancel()
+			if err == ErrControlStreamsUnsupported {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package libp2pquic
+
+import "crypto/tls"
+
+// getTLSConfig returns the tls.Config of the transport, generating it first if the certificate is generated lazily.
+func (t *transport) getTLSConfig() (*tls.Config, error) {
+	if t.genTLSConf == nil {
+		return t.tlsConf, nil
+	}
+	t.tlsConfMutex.Lock()
+	defer t.tlsConfMutex.Unlock()
+	if t.tlsConf == nil {
+		tlsConf, err := t.genTLSConf()
+		if err != nil {
+			return nil, err
+		}
+		t.tlsConf = tlsConf
+	}
+	return t.tlsConf, nil
+}
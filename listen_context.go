@@ -0,0 +1,35 @@
+package libp2pquic
+
+import (
+	"context"
+
+	tpt "github.com/libp2p/go-libp2p-core/transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ListenContext listens for new QUIC connections on the passed multiaddr, like Listen.
+// The listener is closed when ctx is canceled, or when Close is called, whichever happens first.
+// If the listener is shared (see DuplicateListenShare), canceling ctx releases this caller's use of it.
+// Canceling ctx also stops retrying after transient bind errors (see WithListenRetry).
+func (t *transport) ListenContext(ctx context.Context, addr ma.Multiaddr) (tpt.Listener, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	tlsConf, err := t.getTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	ln, err := t.listenWithRetry(ctx, addr, tlsConf, nil)
+	if err != nil {
+		return nil, err
+	}
+	l := ln.(*listener)
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.Close()
+		case <-l.stopAccepting:
+		}
+	}()
+	return ln, nil
+}
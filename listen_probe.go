@@ -0,0 +1,48 @@
+package libp2pquic
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// probeSocket checks that a listener's socket works, by sending a packet to itself and receiving it, see WithListenProbe.
+// Packets from other hosts that are received during the probe are dropped. They are retransmitted by the peer.
+func probeSocket(pconn net.PacketConn, timeout time.Duration) error {
+	laddr, ok := pconn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return errors.New("listen probe failed: socket isn't bound to a UDP address")
+	}
+	target := &net.UDPAddr{IP: laddr.IP, Port: laddr.Port, Zone: laddr.Zone}
+	if target.IP == nil || target.IP.IsUnspecified() {
+		if target.IP.To4() != nil {
+			target.IP = net.IPv4(127, 0, 0, 1)
+		} else {
+			target.IP = net.IPv6loopback
+		}
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	if _, err := pconn.WriteTo(nonce, target); err != nil {
+		return fmt.Errorf("listen probe failed: %s", err)
+	}
+	if err := pconn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	defer pconn.SetReadDeadline(time.Time{})
+	b := make([]byte, 1500)
+	for {
+		n, _, err := pconn.ReadFrom(b)
+		if err != nil {
+			return fmt.Errorf("listen probe failed: %s", err)
+		}
+		if bytes.Equal(b[:n], nonce) {
+			return nil
+		}
+	}
+}
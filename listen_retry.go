@@ -0,0 +1,38 @@
+package libp2pquic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"syscall"
+	"time"
+
+	tpt "github.com/libp2p/go-libp2p-core/transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// isTransientBindError says if binding a socket failed because the address isn't available (yet),
+// e.g. because the interface is still being configured after a network change.
+func isTransientBindError(err error) bool {
+	return errors.Is(err, syscall.EADDRNOTAVAIL)
+}
+
+// listenWithRetry listens on addr, retrying after transient bind errors (see WithListenRetry).
+// The backoff is doubled after every attempt. Retrying stops when ctx is canceled.
+func (t *transport) listenWithRetry(ctx context.Context, addr ma.Multiaddr, tlsConf *tls.Config, serverNames []string) (tpt.Listener, error) {
+	backoff := t.config.listenRetryBackoff
+	for attempt := 0; ; attempt++ {
+		ln, err := t.listen(addr, tlsConf, serverNames)
+		if err == nil || attempt >= t.config.listenRetries || !isTransientBindError(err) {
+			return ln, err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
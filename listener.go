@@ -2,7 +2,12 @@ package libp2pquic
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"net"
+	"sync"
+	"time"
 
 	ic "github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/peer"
@@ -15,69 +20,400 @@ import (
 
 var quicListenAddr = quic.ListenAddr
 
+var listenUDP = func(network string, laddr *net.UDPAddr) (net.PacketConn, error) {
+	return net.ListenUDP(network, laddr)
+}
+
+// The default number of connections that completed the handshake, but haven't been accepted yet.
+const defaultAcceptQueueLen = 16
+
+// An AcceptQueueFullPolicy determines what happens to new connections when the accept queue is full.
+type AcceptQueueFullPolicy int
+
+const (
+	// AcceptQueueBackpressure stops accepting connections from quic-go until Accept is called.
+	// Connections then queue up in quic-go, which refuses new handshakes once its own queue is full.
+	AcceptQueueBackpressure AcceptQueueFullPolicy = iota
+	// AcceptQueueReject closes connections that don't fit into the queue.
+	AcceptQueueReject
+)
+
+var errAcceptQueueFull = errors.New("accept queue full")
+
+var errStoppedAccepting = errors.New("listener stopped accepting connections")
+
 // A listener listens for QUIC connections.
 type listener struct {
-	quicListener quic.Listener
-	transport    tpt.Transport
+	// the socket, closed when the listener is closed
+	pconn net.PacketConn
+	// the UDP socket, nil if it isn't a *net.UDPConn, see ExportSockets
+	socket        *net.UDPConn
+	quicListener  quic.Listener
+	transport     *transport
+	tlsConf       *tls.Config
+	connIDConn    *connIDRecordingConn
+	pathConn      *pathTrackingConn
+	silencingConn *silencingConn
+	readRetryConn *readRetryConn
+	wireConn      *wireCountingConn
+	// counts the bytes sent during handshakes, see AmplificationLimited
+	amplificationConn *amplificationTrackingConn
 
 	privKey        ic.PrivKey
 	localPeer      peer.ID
 	localMultiaddr ma.Multiaddr
+
+	handshakeLimiter *handshakeLimiter   // nil if the number of incoming handshakes is not limited
+	sourceIPLimiter  *sourceIPLimiter    // nil if the number of handshakes per source IP is not limited
+	receiveDrops     *receiveDropCounter // nil if receive drops are not tracked
+
+	queue             chan tpt.CapableConn
+	stopAcceptingOnce sync.Once
+	stopAccepting     chan struct{}
+	acceptLoopDone    chan struct{}
+	acceptErr         error // set before acceptLoopDone is closed
+	closeOnce         sync.Once
+	closeSocketOnce   sync.Once
+
+	// The key used to detect duplicate listens, see listenKey.
+	listenKey string
+	// The number of Listen calls that returned this listener, see DuplicateListenShare.
+	// Protected by the transport's listenAddrsMutex.
+	refs int
 }
 
 var _ tpt.Listener = &listener{}
 
-func newListener(addr ma.Multiaddr, transport tpt.Transport, localPeer peer.ID, key ic.PrivKey, tlsConf *tls.Config) (tpt.Listener, error) {
-	lnet, host, err := manet.DialArgs(addr)
-	if err != nil {
-		return nil, err
+// newListener creates a listener on addr.
+// If pconn is nil, a new socket is created. Otherwise, the listener uses pconn, which must be bound to addr.
+func newListener(addr ma.Multiaddr, pconn net.PacketConn, t *transport, localPeer peer.ID, key ic.PrivKey, tlsConf *tls.Config, serverNames []string) (tpt.Listener, error) {
+	if pconn == nil {
+		lnet, host, err := manet.DialArgs(addr)
+		if err != nil {
+			return nil, err
+		}
+		laddr, err := net.ResolveUDPAddr(lnet, host)
+		if err != nil {
+			return nil, err
+		}
+		pconn, err = listenUDP(t.listenNetwork(lnet, laddr), laddr)
+		if err != nil {
+			return nil, err
+		}
 	}
-	laddr, err := net.ResolveUDPAddr(lnet, host)
-	if err != nil {
-		return nil, err
+	if t.config.listenProbeTimeout > 0 {
+		if err := probeSocket(pconn, t.config.listenProbeTimeout); err != nil {
+			pconn.Close()
+			return nil, err
+		}
 	}
-	conn, err := net.ListenUDP(lnet, laddr)
-	if err != nil {
-		return nil, err
+	amplificationConn := newAmplificationTrackingConn(pconn)
+	connIDConn := newConnIDRecordingConn(amplificationConn)
+	pathConn := newPathTrackingConn(connIDConn)
+	wireConn := &wireCountingConn{PacketConn: pathConn, counter: wireCounter{countUntracked: true}}
+	silencingConn := &silencingConn{PacketConn: wireConn}
+	readRetryConn := &readRetryConn{PacketConn: silencingConn, diagnostics: t.connManager.diagnostics}
+	tlsConf = withKeySizeLimit(tlsConf, t.config.maxRSAKeySize)
+	tlsConf = withChainSizeLimit(tlsConf, t.config.maxCertChainLen, t.config.maxCertChainBytes)
+	if t.config.rejectNonLibp2p {
+		tlsConf = withChainShapeCheck(tlsConf, len(t.config.peerVerifiers) > 0)
+	}
+	if t.config.trustedCAs != nil {
+		tlsConf = withTrustedCAs(tlsConf, t.config.trustedCAs)
+	}
+	if t.config.onPeerVerified != nil {
+		tlsConf = withPeerVerifiedCallback(tlsConf, t.getRemotePubKey, t.config.onPeerVerified)
+	}
+	if len(serverNames) > 0 {
+		tlsConf = withSNIValidation(tlsConf, serverNames)
+	} else if t.config.validateSNI {
+		tlsConf = withSNIValidation(tlsConf, t.config.dnsNames)
+	}
+	if t.config.rejectNonLibp2p {
+		tlsConf = withALPNCheck(tlsConf)
 	}
-	ln, err := quic.Listen(conn, tlsConf, quicConfig)
+	var handshakeLimiter *handshakeLimiter
+	if t.config.maxIncomingHandshakes > 0 {
+		handshakeLimiter = newHandshakeLimiter(t.config.maxIncomingHandshakes)
+		tlsConf = handshakeLimiter.Apply(tlsConf)
+	}
+	if t.handshakeTimer != nil {
+		tlsConf = t.handshakeTimer.Apply(tlsConf)
+	}
+	tlsConf = withRejectionReporting(tlsConf, t)
+	quicConf := t.listenConfig
+	var sourceIPLimiter *sourceIPLimiter
+	if t.config.maxHandshakesPerIP > 0 {
+		sourceIPLimiter = newSourceIPLimiter(t.config.maxHandshakesPerIP, defaultHandshakeTimeout)
+		quicConf = sourceIPLimiter.Apply(quicConf)
+	}
+	// applied last, so that a Retry sent for any other reason is recorded as well
+	if t.config.retryTokenLifetime > 0 {
+		quicConf = newRetryTokenValidator(t.config.retryTokenLifetime, t.config.retryTokenBinding).Apply(quicConf)
+	}
+	ln, err := quic.Listen(readRetryConn, tlsConf, quicConf)
 	if err != nil {
+		pconn.Close()
 		return nil, err
 	}
-	localMultiaddr, err := toQuicMultiaddr(ln.Addr())
+	localMultiaddr, err := t.localMultiaddr(ln.Addr())
 	if err != nil {
+		ln.Close()
+		pconn.Close()
 		return nil, err
 	}
-	return &listener{
-		quicListener:   ln,
-		transport:      transport,
-		privKey:        key,
-		localPeer:      localPeer,
-		localMultiaddr: localMultiaddr,
-	}, nil
+	var receiveDrops *receiveDropCounter
+	if t.config.trackReceiveDrops {
+		// If the socket's drop counter can't be read (e.g. on platforms other than Linux), drops are not tracked.
+		receiveDrops, _ = newReceiveDropCounter(pconn)
+	}
+	socket, _ := pconn.(*net.UDPConn)
+	l := &listener{
+		pconn:             pconn,
+		socket:            socket,
+		quicListener:      ln,
+		transport:         t,
+		tlsConf:           tlsConf,
+		connIDConn:        connIDConn,
+		pathConn:          pathConn,
+		silencingConn:     silencingConn,
+		readRetryConn:     readRetryConn,
+		wireConn:          wireConn,
+		amplificationConn: amplificationConn,
+		privKey:           key,
+		localPeer:         localPeer,
+		localMultiaddr:    localMultiaddr,
+		handshakeLimiter:  handshakeLimiter,
+		sourceIPLimiter:   sourceIPLimiter,
+		receiveDrops:      receiveDrops,
+		queue:             make(chan tpt.CapableConn, t.config.acceptQueueLen),
+		stopAccepting:     make(chan struct{}),
+		acceptLoopDone:    make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l, nil
 }
 
-// Accept accepts new connections.
-func (l *listener) Accept() (tpt.CapableConn, error) {
+// withPeerVerifiedCallback returns a copy of the tls.Config that calls cb
+// as soon as the peer ID of a client has been verified.
+func withPeerVerifiedCallback(conf *tls.Config, getRemotePubKey func([]*x509.Certificate) (ic.PubKey, error), cb func(peer.ID, net.Addr)) *tls.Config {
+	base := conf.Clone()
+	conf = conf.Clone()
+	conf.GetConfigForClient = func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
+		var remote net.Addr
+		if chi.Conn != nil {
+			remote = chi.Conn.RemoteAddr()
+		}
+		c := base.Clone()
+		c.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			if base.VerifyPeerCertificate != nil {
+				if err := base.VerifyPeerCertificate(rawCerts, verifiedChains); err != nil {
+					return err
+				}
+			}
+			chain, err := parseCertChain(rawCerts)
+			if err != nil {
+				return reject(RejectReasonInvalidCertificate, err)
+			}
+			remotePubKey, err := getRemotePubKey(chain)
+			if err != nil {
+				return reject(RejectReasonInvalidCertificate, err)
+			}
+			remotePeerID, err := peer.IDFromPublicKey(remotePubKey)
+			if err != nil {
+				return reject(RejectReasonInvalidCertificate, err)
+			}
+			cb(remotePeerID, remote)
+			return nil
+		}
+		return c, nil
+	}
+	return conf
+}
+
+// withKeySizeLimit returns a copy of the tls.Config that rejects clients using RSA keys larger than maxRSABits.
+// The check is done before the client's signature is verified.
+func withKeySizeLimit(conf *tls.Config, maxRSABits int) *tls.Config {
+	verify := conf.VerifyPeerCertificate
+	conf = conf.Clone()
+	conf.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		chain, err := parseCertChain(rawCerts)
+		if err != nil {
+			return reject(RejectReasonInvalidCertificate, err)
+		}
+		if err := checkKeySizes(chain, maxRSABits); err != nil {
+			return reject(RejectReasonKeyTooLarge, err)
+		}
+		if verify != nil {
+			return verify(rawCerts, verifiedChains)
+		}
+		return nil
+	}
+	return conf
+}
+
+// withChainSizeLimit returns a copy of the tls.Config that rejects clients presenting a certificate chain
+// with more than maxCerts certificates or maxBytes bytes. The check is done before the chain is parsed.
+func withChainSizeLimit(conf *tls.Config, maxCerts, maxBytes int) *tls.Config {
+	verify := conf.VerifyPeerCertificate
+	conf = conf.Clone()
+	conf.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if err := checkChainSize(rawCerts, maxCerts, maxBytes); err != nil {
+			return reject(RejectReasonChainTooLarge, err)
+		}
+		if verify != nil {
+			return verify(rawCerts, verifiedChains)
+		}
+		return nil
+	}
+	return conf
+}
+
+// withLibp2pVerification returns a VerifyPeerCertificate callback that calls verify
+// after checking that the certificate chain belongs to a libp2p peer.
+func withLibp2pVerification(getRemotePubKey func([]*x509.Certificate) (ic.PubKey, error), verify func([][]byte, [][]*x509.Certificate) error) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		chain, err := parseCertChain(rawCerts)
+		if err != nil {
+			return reject(RejectReasonInvalidCertificate, err)
+		}
+		if _, err := getRemotePubKey(chain); err != nil {
+			return reject(RejectReasonInvalidCertificate, err)
+		}
+		return verify(rawCerts, verifiedChains)
+	}
+}
+
+// withSNIValidation returns a copy of the tls.Config that rejects clients using a server name (SNI) not contained in names.
+func withSNIValidation(conf *tls.Config, names []string) *tls.Config {
+	getConfigForClient := conf.GetConfigForClient
+	conf = conf.Clone()
+	conf.GetConfigForClient = func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
+		var found bool
+		for _, name := range names {
+			if chi.ServerName == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, reject(RejectReasonServerName, fmt.Errorf("unexpected server name: %q", chi.ServerName))
+		}
+		if getConfigForClient != nil {
+			return getConfigForClient(chi)
+		}
+		return nil, nil
+	}
+	return conf
+}
+
+// setupIncomingConn sets up the connection for a session accepted by a listener.
+var setupIncomingConn = (*listener).setupConn
+
+// acceptLoop accepts sessions from the QUIC listener,
+// and queues them until they are returned by Accept.
+// Up to acceptWorkers sessions are set up concurrently (see WithAcceptWorkers),
+// so connections are not necessarily queued in the order they were accepted.
+func (l *listener) acceptLoop() {
+	defer close(l.acceptLoopDone)
+	numWorkers := l.transport.config.acceptWorkers
+	if numWorkers == 0 {
+		numWorkers = 1
+	}
+	workers := make(chan struct{}, numWorkers)
+	var wg sync.WaitGroup
+	defer wg.Wait()
 	for {
 		sess, err := l.quicListener.Accept()
 		if err != nil {
-			return nil, err
+			l.acceptErr = err
+			return
 		}
-		conn, err := l.setupConn(sess)
-		if err != nil {
-			sess.CloseWithError(0, err)
+		if l.sourceIPLimiter != nil {
+			l.sourceIPLimiter.Complete(sess.RemoteAddr())
+		}
+		if l.transport.isDraining() {
+			sess.CloseWithError(0, ErrDraining)
 			continue
 		}
+		workers <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-workers }()
+			l.handleSession(sess)
+		}()
+	}
+}
+
+// handleSession sets up the connection for an accepted session, and queues it.
+func (l *listener) handleSession(sess quic.Session) {
+	conn, err := setupIncomingConn(l, sess)
+	if err != nil {
+		sess.CloseWithError(0, err)
+		return
+	}
+	if l.transport.config.acceptQueuePolicy == AcceptQueueReject {
+		select {
+		case l.queue <- conn:
+		default:
+			sess.CloseWithError(0, errAcceptQueueFull)
+		}
+		return
+	}
+	select {
+	case l.queue <- conn:
+	case <-l.stopAccepting:
+		sess.CloseWithError(0, errStoppedAccepting)
+	}
+}
+
+// Accept accepts new connections.
+func (l *listener) Accept() (tpt.CapableConn, error) {
+	select {
+	case <-l.stopAccepting:
+		return nil, errStoppedAccepting
+	default:
+	}
+	select {
+	case conn := <-l.queue:
 		return conn, nil
+	case <-l.stopAccepting:
+		return nil, errStoppedAccepting
+	case <-l.acceptLoopDone:
+		return nil, l.acceptErr
+	}
+}
+
+// DrainPending stops accepting new connections,
+// and returns all connections that completed the handshake but weren't accepted yet.
+// After calling DrainPending, Accept returns an error.
+// Note that closing the listener closes all connections accepted on it,
+// including the ones returned here.
+func (l *listener) DrainPending() []tpt.CapableConn {
+	l.stopAcceptingOnce.Do(func() { close(l.stopAccepting) })
+	var conns []tpt.CapableConn
+	for {
+		select {
+		case conn := <-l.queue:
+			conns = append(conns, conn)
+		default:
+			return conns
+		}
 	}
 }
 
 func (l *listener) setupConn(sess quic.Session) (tpt.CapableConn, error) {
-	remotePubKey, err := getRemotePubKey(sess.ConnectionState().PeerCertificates)
+	peerCerts := sess.ConnectionState().PeerCertificates
+	verifyStart := time.Now()
+	remotePubKey, err := l.transport.getRemotePubKey(peerCerts)
+	l.transport.handshakeTimer.recordVerification(verifyStart)
 	if err != nil {
 		return nil, err
 	}
+	if start, ok := l.transport.handshakeTimer.PopStart(sess.RemoteAddr()); ok {
+		l.transport.handshakeTimer.recordHandshake(time.Since(start))
+	}
 	remotePeerID, err := peer.IDFromPublicKey(remotePubKey)
 	if err != nil {
 		return nil, err
@@ -86,21 +422,70 @@ func (l *listener) setupConn(sess quic.Session) (tpt.CapableConn, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &conn{
-		sess:            sess,
-		transport:       l.transport,
-		localPeer:       l.localPeer,
-		localMultiaddr:  l.localMultiaddr,
-		privKey:         l.privKey,
-		remoteMultiaddr: remoteMultiaddr,
-		remotePeerID:    remotePeerID,
-		remotePubKey:    remotePubKey,
-	}, nil
+	if err := l.transport.memory.Reserve(l.transport.receiveBufferSize); err != nil {
+		return nil, err
+	}
+	remoteConnID, version := l.connIDConn.PopConnID(sess.RemoteAddr())
+	wireBytes, stopWireCount := l.wireConn.counter.Track(sess.RemoteAddr())
+	c := &conn{
+		sess:                  sess,
+		transport:             l.transport,
+		localPeer:             l.localPeer,
+		localMultiaddr:        l.localMultiaddr,
+		privKey:               l.privKey,
+		remoteMultiaddr:       remoteMultiaddr,
+		remotePeerID:          remotePeerID,
+		remotePubKey:          remotePubKey,
+		pings:                 newPingManager(),
+		uniStreams:            make(chan quic.ReceiveStream, uniStreamQueueLen),
+		maxIncomingUniStreams: int32(l.transport.config.maxIncomingUniStreams),
+		controlStreams:        l.transport.config.controlStreams,
+		remoteConnID:          remoteConnID,
+		version:               version,
+		streamQueue:           l.transport.newStreamQueue(),
+		openedAt:              time.Now(),
+		peerCertExpiry:        chainExpiry(peerCerts),
+		silencer:              &l.silencingConn.silencer,
+		wire:                  wireBytes,
+	}
+	// The handshake is complete, so the server won't send any more handshake packets.
+	c.amplificationLimited = l.amplificationConn.PopLimited(sess.RemoteAddr())
+	if m := l.transport.config.metrics; m != nil {
+		m.HandshakeCompleted(true)
+	}
+	l.pathConn.Track(c, sess.RemoteAddr())
+	c.OnClose(func(error) {
+		l.pathConn.Untrack(c)
+		stopWireCount()
+	})
+	l.transport.addConn(c)
+	return c, nil
 }
 
 // Close closes the listener.
+// If the listener is shared (see DuplicateListenShare), it is only closed when all users closed it.
 func (l *listener) Close() error {
-	return l.quicListener.Close()
+	if !l.transport.releaseListenAddr(l) {
+		return nil
+	}
+	l.closeOnce.Do(func() {
+		l.transport.releaseListener()
+		l.transport.events.Publish(Event{Type: EventListenerClosed, Addr: l.localMultiaddr})
+	})
+	l.stopAcceptingOnce.Do(func() { close(l.stopAccepting) })
+	// quic-go closes all sessions accepted on this listener
+	if l.transport.config.silentClose {
+		l.silencingConn.silencer.SilenceAll()
+	}
+	err := l.quicListener.Close()
+	<-l.acceptLoopDone
+	// quic-go doesn't close sockets passed to quic.Listen
+	l.closeSocketOnce.Do(func() {
+		if cerr := l.pconn.Close(); err == nil {
+			err = cerr
+		}
+	})
+	return err
 }
 
 // Addr returns the address of this listener.
@@ -1,32 +1,126 @@
 package libp2pquic
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
 	tpt "github.com/libp2p/go-libp2p-core/transport"
+	quic "github.com/lucas-clemente/quic-go"
 
 	ma "github.com/multiformats/go-multiaddr"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
+// droppingConn is a net.PacketConn that drops all packets sent.
+type droppingConn struct {
+	net.PacketConn
+}
+
+func (c *droppingConn) WriteTo(b []byte, addr net.Addr) (int, error) { return len(b), nil }
+
+type temporaryError struct{}
+
+func (e *temporaryError) Error() string   { return "temporary error" }
+func (e *temporaryError) Timeout() bool   { return false }
+func (e *temporaryError) Temporary() bool { return true }
+
+// faultyConn is a net.PacketConn that returns the errors from errs before reading from the underlying conn
+type faultyConn struct {
+	net.PacketConn
+
+	mutex sync.Mutex
+	errs  []error
+	reads int
+}
+
+func (c *faultyConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.mutex.Lock()
+	c.reads++
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		c.mutex.Unlock()
+		return 0, nil, err
+	}
+	c.mutex.Unlock()
+	return c.PacketConn.ReadFrom(b)
+}
+
+func (c *faultyConn) Reads() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.reads
+}
+
+// customAddr is a net.Addr that isn't a *net.UDPAddr
+type customAddr struct {
+	*net.UDPAddr
+}
+
+func (a *customAddr) Network() string { return "custom" }
+
+// customAddrConn is a net.PacketConn whose local address is a *customAddr
+type customAddrConn struct {
+	net.PacketConn
+}
+
+func (c *customAddrConn) LocalAddr() net.Addr {
+	return &customAddr{c.PacketConn.LocalAddr().(*net.UDPAddr)}
+}
+
 var _ = Describe("Listener", func() {
-	var t tpt.Transport
+	var (
+		t   tpt.Transport
+		key ic.PrivKey
+	)
 
 	BeforeEach(func() {
 		rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
 		Expect(err).ToNot(HaveOccurred())
-		key, err := ic.UnmarshalRsaPrivateKey(x509.MarshalPKCS1PrivateKey(rsaKey))
+		key, err = ic.UnmarshalRsaPrivateKey(x509.MarshalPKCS1PrivateKey(rsaKey))
 		Expect(err).ToNot(HaveOccurred())
 		t, err = NewTransport(key)
 		Expect(err).ToNot(HaveOccurred())
 	})
 
+	Context("tracking receive drops", func() {
+		It("doesn't track drops by default", func() {
+			ln, err := t.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+			Expect(ln.(*listener).receiveDrops).To(BeNil())
+			Expect(ln.(*listener).Stats().ReceiveDrops).To(BeZero())
+		})
+
+		It("reports the drops of the listener's socket", func() {
+			tr, err := NewTransport(key, WithReceiveDropTracking())
+			Expect(err).ToNot(HaveOccurred())
+			ln, err := tr.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+			if runtime.GOOS == "linux" {
+				Expect(ln.(*listener).receiveDrops).ToNot(BeNil())
+			}
+			Expect(ln.(*listener).Stats().ReceiveDrops).To(BeZero())
+		})
+	})
+
 	Context("listening on the right address", func() {
 		It("returns the address it is listening on", func() {
 			localAddr, err := ma.NewMultiaddr("/ip4/127.0.0.1/udp/0/quic")
@@ -62,6 +156,103 @@ var _ = Describe("Listener", func() {
 			Expect(port).ToNot(BeZero())
 			Expect(ln.Multiaddr().String()).To(Equal(fmt.Sprintf("/ip6/::/udp/%d/quic", port)))
 		})
+
+		It("emits an event carrying the port that was chosen", func() {
+			events, unsubscribe := t.(*transport).Subscribe()
+			defer unsubscribe()
+			ln, err := t.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			var ev Event
+			Eventually(events).Should(Receive(&ev))
+			Expect(ev.Type).To(Equal(EventListenerOpened))
+			Expect(ev.Addr).To(Equal(ln.Multiaddr()))
+			port, err := ev.Addr.ValueForProtocol(ma.P_UDP)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(port).To(Equal(strconv.Itoa(ln.Addr().(*net.UDPAddr).Port)))
+			Expect(port).ToNot(Equal("0"))
+
+			Expect(ln.Close()).To(Succeed())
+			Eventually(events).Should(Receive(&ev))
+			Expect(ev.Type).To(Equal(EventListenerClosed))
+			Expect(ev.Addr).To(Equal(ln.Multiaddr()))
+		})
+
+		It("closes the socket", func() {
+			ln, err := t.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			addr := ln.Addr().(*net.UDPAddr)
+			Expect(ln.Close()).To(Succeed())
+			conn, err := net.ListenUDP("udp4", addr)
+			Expect(err).ToNot(HaveOccurred())
+			conn.Close()
+		})
+	})
+
+	Context("advertising addresses", func() {
+		origInterfaceAddrs := interfaceAddrs
+
+		BeforeEach(func() {
+			interfaceAddrs = func() ([]net.Addr, error) {
+				var addrs []net.Addr
+				for _, s := range []string{"127.0.0.1/8", "192.168.1.2/24", "::1/128", "2001:db8::1/64", "fe80::1/64"} {
+					ip, ipnet, err := net.ParseCIDR(s)
+					Expect(err).ToNot(HaveOccurred())
+					ipnet.IP = ip
+					addrs = append(addrs, ipnet)
+				}
+				return addrs, nil
+			}
+		})
+
+		AfterEach(func() {
+			interfaceAddrs = origInterfaceAddrs
+		})
+
+		advertiseAddrs := func(tr tpt.Transport, addr string, includeLoopback bool) []string {
+			ln, err := tr.Listen(ma.StringCast(addr))
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+			port := ln.Addr().(*net.UDPAddr).Port
+			addrs, err := ln.(*listener).AdvertiseAddrs(includeLoopback)
+			Expect(err).ToNot(HaveOccurred())
+			strs := make([]string, len(addrs))
+			for i, a := range addrs {
+				strs[i] = strings.Replace(a.String(), fmt.Sprintf("/udp/%d/", port), "/udp/<port>/", 1)
+			}
+			return strs
+		}
+
+		It("expands IPv4 wildcard addresses", func() {
+			Expect(advertiseAddrs(t, "/ip4/0.0.0.0/udp/0/quic", false)).To(Equal([]string{"/ip4/192.168.1.2/udp/<port>/quic"}))
+			Expect(advertiseAddrs(t, "/ip4/0.0.0.0/udp/0/quic", true)).To(Equal([]string{
+				"/ip4/127.0.0.1/udp/<port>/quic",
+				"/ip4/192.168.1.2/udp/<port>/quic",
+			}))
+		})
+
+		It("expands IPv6 wildcard addresses", func() {
+			Expect(advertiseAddrs(t, "/ip6/::/udp/0/quic", false)).To(Equal([]string{"/ip6/2001:db8::1/udp/<port>/quic"}))
+			Expect(advertiseAddrs(t, "/ip6/::/udp/0/quic", true)).To(Equal([]string{
+				"/ip6/::1/udp/<port>/quic",
+				"/ip6/2001:db8::1/udp/<port>/quic",
+			}))
+		})
+
+		It("filters loopback addresses", func() {
+			Expect(advertiseAddrs(t, "/ip4/127.0.0.1/udp/0/quic", false)).To(BeEmpty())
+			Expect(advertiseAddrs(t, "/ip4/127.0.0.1/udp/0/quic", true)).To(Equal([]string{"/ip4/127.0.0.1/udp/<port>/quic"}))
+		})
+
+		It("uses the announced addresses", func() {
+			tr, err := NewTransport(key, WithAnnounceAddrs(ma.StringCast("/ip4/1.2.3.4/udp/1234/quic")))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(advertiseAddrs(tr, "/ip4/0.0.0.0/udp/0/quic", false)).To(Equal([]string{"/ip4/1.2.3.4/udp/1234/quic"}))
+		})
+
+		It("refuses to announce non-QUIC addresses", func() {
+			_, err := NewTransport(key, WithAnnounceAddrs(ma.StringCast("/ip4/1.2.3.4/tcp/1234")))
+			Expect(err).To(MatchError("not a QUIC address: /ip4/1.2.3.4/tcp/1234"))
+		})
 	})
 
 	Context("accepting connections", func() {
@@ -96,5 +287,703 @@ var _ = Describe("Listener", func() {
 			_, err = ln.Accept()
 			Expect(err).To(HaveOccurred())
 		})
+
+		Context("read errors", func() {
+			origListenUDP := listenUDP
+
+			AfterEach(func() {
+				listenUDP = origListenUDP
+			})
+
+			injectErrors := func(errs ...error) *faultyConn {
+				fconn := &faultyConn{errs: errs}
+				listenUDP = func(network string, laddr *net.UDPAddr) (net.PacketConn, error) {
+					conn, err := net.ListenUDP(network, laddr)
+					fconn.PacketConn = conn
+					return fconn, err
+				}
+				return fconn
+			}
+
+			It("retries reading after transient errors", func() {
+				fconn := injectErrors(&temporaryError{}, &temporaryError{})
+				ln, err := t.Listen(localAddr)
+				Expect(err).ToNot(HaveOccurred())
+				defer ln.Close()
+				Eventually(fconn.Reads).Should(BeNumerically(">", 2))
+				Expect(ln.(*listener).Stats().TransientReadErrors).To(BeEquivalentTo(2))
+
+				serverID, err := peer.IDFromPrivateKey(key)
+				Expect(err).ToNot(HaveOccurred())
+				clientKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+				Expect(err).ToNot(HaveOccurred())
+				clientTransport, err := NewTransport(clientKey)
+				Expect(err).ToNot(HaveOccurred())
+				conn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+				Expect(err).ToNot(HaveOccurred())
+				defer conn.Close()
+				sconn, err := ln.Accept()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sconn.RemotePeer()).To(Equal(conn.LocalPeer()))
+			})
+
+			It("reports transient errors to the socket diagnostics", func() {
+				injectErrors(&temporaryError{})
+				ln, err := t.Listen(localAddr)
+				Expect(err).ToNot(HaveOccurred())
+				defer ln.Close()
+				var ev SocketEvent
+				Eventually(t.(*transport).SocketDiagnostics()).Should(Receive(&ev))
+				Expect(ev.Type).To(Equal(SocketReadError))
+				Expect(ev.Err).To(BeAssignableToTypeOf(&temporaryError{}))
+			})
+
+			It("returns fatal errors from Accept", func() {
+				testErr := errors.New("fatal error")
+				injectErrors(testErr)
+				ln, err := t.Listen(localAddr)
+				Expect(err).ToNot(HaveOccurred())
+				defer ln.Close()
+				_, err = ln.Accept()
+				Expect(err).To(MatchError(testErr))
+			})
+		})
+
+		Context("custom socket types", func() {
+			origListenUDP := listenUDP
+
+			BeforeEach(func() {
+				listenUDP = func(network string, laddr *net.UDPAddr) (net.PacketConn, error) {
+					conn, err := net.ListenUDP(network, laddr)
+					if err != nil {
+						return nil, err
+					}
+					return &customAddrConn{PacketConn: conn}, nil
+				}
+			})
+
+			AfterEach(func() {
+				listenUDP = origListenUDP
+			})
+
+			It("fails to compute the multiaddr by default", func() {
+				_, err := t.Listen(localAddr)
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("uses the address mapper", func() {
+				tr, err := NewTransport(key, WithLocalAddrMapper(func(addr net.Addr) (ma.Multiaddr, error) {
+					return toQuicMultiaddr(addr.(*customAddr).UDPAddr)
+				}))
+				Expect(err).ToNot(HaveOccurred())
+				ln, err := tr.Listen(localAddr)
+				Expect(err).ToNot(HaveOccurred())
+				defer ln.Close()
+				port := ln.Addr().(*customAddr).Port
+				Expect(ln.Multiaddr().String()).To(Equal(fmt.Sprintf("/ip4/127.0.0.1/udp/%d/quic", port)))
+			})
+		})
+
+		Context("accept queue", func() {
+			dial := func(ln tpt.Listener) tpt.CapableConn {
+				serverID, err := peer.IDFromPrivateKey(key)
+				Expect(err).ToNot(HaveOccurred())
+				clientKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+				Expect(err).ToNot(HaveOccurred())
+				clientTransport, err := NewTransport(clientKey)
+				Expect(err).ToNot(HaveOccurred())
+				conn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+				Expect(err).ToNot(HaveOccurred())
+				return conn
+			}
+
+			It("applies backpressure when the queue is full", func() {
+				t, err := NewTransport(key, WithAcceptQueueSize(2, AcceptQueueBackpressure))
+				Expect(err).ToNot(HaveOccurred())
+				ln, err := t.Listen(localAddr)
+				Expect(err).ToNot(HaveOccurred())
+				defer ln.Close()
+				var conns []tpt.CapableConn
+				for i := 0; i < 3; i++ {
+					conns = append(conns, dial(ln))
+				}
+				Eventually(func() int { return len(ln.(*listener).queue) }).Should(Equal(2))
+				Consistently(func() int { return len(ln.(*listener).queue) }).Should(Equal(2))
+				// the third connection is accepted as soon as there's space in the queue
+				for i := 0; i < 3; i++ {
+					_, err := ln.Accept()
+					Expect(err).ToNot(HaveOccurred())
+				}
+				for _, c := range conns {
+					Expect(c.IsClosed()).To(BeFalse())
+					c.Close()
+				}
+			})
+
+			It("rejects connections when the queue is full", func() {
+				t, err := NewTransport(key, WithAcceptQueueSize(2, AcceptQueueReject))
+				Expect(err).ToNot(HaveOccurred())
+				ln, err := t.Listen(localAddr)
+				Expect(err).ToNot(HaveOccurred())
+				defer ln.Close()
+				var conns []tpt.CapableConn
+				for i := 0; i < 2; i++ {
+					conns = append(conns, dial(ln))
+				}
+				Eventually(func() int { return len(ln.(*listener).queue) }).Should(Equal(2))
+				rejected := dial(ln)
+				Eventually(rejected.IsClosed).Should(BeTrue())
+				for i := 0; i < 2; i++ {
+					_, err := ln.Accept()
+					Expect(err).ToNot(HaveOccurred())
+				}
+				for _, c := range conns {
+					Expect(c.IsClosed()).To(BeFalse())
+					c.Close()
+				}
+			})
+
+			It("refuses an invalid queue size", func() {
+				_, err := NewTransport(key, WithAcceptQueueSize(0, AcceptQueueReject))
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		It("limits the number of concurrent incoming handshakes", func() {
+			serverKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			serverID, err := peer.IDFromPrivateKey(serverKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverTransport, err := NewTransport(serverKey, WithMaxIncomingHandshakes(2))
+			Expect(err).ToNot(HaveOccurred())
+			ln, err := serverTransport.Listen(localAddr)
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+			limiter := ln.(*listener).handshakeLimiter
+			Expect(limiter).ToNot(BeNil())
+
+			var maxInProgress int
+			done := make(chan struct{})
+			samplerDone := make(chan struct{})
+			go func() {
+				defer close(samplerDone)
+				for {
+					select {
+					case <-done:
+						return
+					default:
+						if n := limiter.InProgress(); n > maxInProgress {
+							maxInProgress = n
+						}
+					}
+				}
+			}()
+
+			const num = 10
+			errChan := make(chan error, num)
+			for i := 0; i < num; i++ {
+				go func() {
+					defer GinkgoRecover()
+					clientKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+					Expect(err).ToNot(HaveOccurred())
+					clientTransport, err := NewTransport(clientKey)
+					Expect(err).ToNot(HaveOccurred())
+					_, err = clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+					errChan <- err
+				}()
+			}
+			var succeeded int
+			for i := 0; i < num; i++ {
+				var err error
+				Eventually(errChan, 5*time.Second).Should(Receive(&err))
+				if err == nil {
+					succeeded++
+				}
+			}
+			close(done)
+			<-samplerDone
+			Expect(succeeded).ToNot(BeZero())
+			Expect(maxInProgress).To(BeNumerically("<=", 2))
+		})
+
+		It("drains connections that weren't accepted yet", func() {
+			ln, err := t.Listen(localAddr)
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+
+			clientKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			clientID, err := peer.IDFromPrivateKey(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			for i := 0; i < 3; i++ {
+				_, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), t.(*transport).localPeer)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Eventually(func() int { return len(ln.(*listener).queue) }).Should(Equal(3))
+
+			conns := ln.(*listener).DrainPending()
+			Expect(conns).To(HaveLen(3))
+			for _, c := range conns {
+				Expect(c.RemotePeer()).To(Equal(clientID))
+				Expect(c.IsClosed()).To(BeFalse())
+			}
+			_, err = ln.Accept()
+			Expect(err).To(MatchError(errStoppedAccepting))
+		})
+	})
+
+	Context("accept workers", func() {
+		origSetupIncomingConn := setupIncomingConn
+
+		AfterEach(func() {
+			setupIncomingConn = origSetupIncomingConn
+		})
+
+		// acceptConcurrently dials the listener from numConns clients at the same time,
+		// and returns the maximum number of connections that were set up concurrently.
+		acceptConcurrently := func(opts []Option, numConns int) int32 {
+			var active, maxActive int32
+			setupIncomingConn = func(l *listener, sess quic.Session) (tpt.CapableConn, error) {
+				n := atomic.AddInt32(&active, 1)
+				defer atomic.AddInt32(&active, -1)
+				for {
+					max := atomic.LoadInt32(&maxActive)
+					if n <= max || atomic.CompareAndSwapInt32(&maxActive, max, n) {
+						break
+					}
+				}
+				time.Sleep(100 * time.Millisecond)
+				return origSetupIncomingConn(l, sess)
+			}
+
+			serverTransport, err := NewTransport(key, opts...)
+			Expect(err).ToNot(HaveOccurred())
+			ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+			serverID, err := peer.IDFromPrivateKey(key)
+			Expect(err).ToNot(HaveOccurred())
+
+			clientConns := make(chan tpt.CapableConn, numConns)
+			for i := 0; i < numConns; i++ {
+				go func() {
+					defer GinkgoRecover()
+					clientKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+					Expect(err).ToNot(HaveOccurred())
+					clientTransport, err := NewTransport(clientKey)
+					Expect(err).ToNot(HaveOccurred())
+					conn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+					Expect(err).ToNot(HaveOccurred())
+					clientConns <- conn
+				}()
+			}
+			for i := 0; i < numConns; i++ {
+				conn, err := ln.Accept()
+				Expect(err).ToNot(HaveOccurred())
+				defer conn.Close()
+				clientConn := <-clientConns
+				defer clientConn.Close()
+			}
+			return atomic.LoadInt32(&maxActive)
+		}
+
+		It("sets up connections one after the other by default", func() {
+			Expect(acceptConcurrently(nil, 4)).To(BeEquivalentTo(1))
+		})
+
+		It("sets up connections concurrently, using a bounded number of workers", func() {
+			Expect(acceptConcurrently([]Option{WithAcceptWorkers(4)}, 12)).To(BeEquivalentTo(4))
+		})
+
+		It("rejects an invalid number of workers", func() {
+			_, err := NewTransport(key, WithAcceptWorkers(0))
+			Expect(err).To(MatchError("number of accept workers must be positive"))
+		})
+	})
+
+	Context("limiting the handshakes per source IP", func() {
+		It("counts handshakes until the connection is accepted", func() {
+			serverTransport, err := NewTransport(key, WithMaxHandshakesPerIP(2))
+			Expect(err).ToNot(HaveOccurred())
+			ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+			limiter := ln.(*listener).sourceIPLimiter
+			Expect(limiter).ToNot(BeNil())
+
+			serverID, err := peer.IDFromPrivateKey(key)
+			Expect(err).ToNot(HaveOccurred())
+			clientKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			for i := 0; i < 3; i++ {
+				conn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+				Expect(err).ToNot(HaveOccurred())
+				defer conn.Close()
+				_, err = ln.Accept()
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(limiter.InProgress(net.IPv4(127, 0, 0, 1))).To(BeZero())
+		})
+
+		It("rejects an invalid limit", func() {
+			_, err := NewTransport(key, WithMaxHandshakesPerIP(0))
+			Expect(err).To(MatchError("maximum number of handshakes per IP must be positive"))
+		})
+	})
+
+	Context("retry tokens", func() {
+		for _, b := range []RetryTokenBinding{RetryTokenBindIPAndPort, RetryTokenBindIP} {
+			binding := b
+
+			It("validates the client's address before the handshake", func() {
+				serverTransport, err := NewTransport(key, WithRetryTokens(time.Minute, binding))
+				Expect(err).ToNot(HaveOccurred())
+				ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+				Expect(err).ToNot(HaveOccurred())
+				defer ln.Close()
+
+				serverID, err := peer.IDFromPrivateKey(key)
+				Expect(err).ToNot(HaveOccurred())
+				clientKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+				Expect(err).ToNot(HaveOccurred())
+				clientTransport, err := NewTransport(clientKey)
+				Expect(err).ToNot(HaveOccurred())
+				conn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+				Expect(err).ToNot(HaveOccurred())
+				defer conn.Close()
+				_, err = ln.Accept()
+				Expect(err).ToNot(HaveOccurred())
+			})
+		}
+
+		It("rejects invalid parameters", func() {
+			_, err := NewTransport(key, WithRetryTokens(0, RetryTokenBindIP))
+			Expect(err).To(MatchError("retry token lifetime must be positive"))
+			_, err = NewTransport(key, WithRetryTokens(time.Minute, 42))
+			Expect(err).To(MatchError("invalid retry token binding: 42"))
+		})
+	})
+
+	Context("limiting the number of listeners", func() {
+		It("refuses to create more listeners than allowed", func() {
+			t, err := NewTransport(key, WithMaxListeners(2))
+			Expect(err).ToNot(HaveOccurred())
+			localAddr := ma.StringCast("/ip4/127.0.0.1/udp/0/quic")
+			ln1, err := t.Listen(localAddr)
+			Expect(err).ToNot(HaveOccurred())
+			ln2, err := t.Listen(localAddr)
+			Expect(err).ToNot(HaveOccurred())
+			defer ln2.Close()
+			_, err = t.Listen(localAddr)
+			Expect(err).To(MatchError(&TooManyListenersError{Limit: 2}))
+
+			// closing a listener frees its slot, even if Close is called multiple times
+			Expect(ln1.Close()).To(Succeed())
+			ln1.Close()
+			ln3, err := t.Listen(localAddr)
+			Expect(err).ToNot(HaveOccurred())
+			defer ln3.Close()
+			_, err = t.Listen(localAddr)
+			Expect(err).To(BeAssignableToTypeOf(&TooManyListenersError{}))
+		})
+
+		It("frees the slot if listening fails", func() {
+			t, err := NewTransport(key, WithMaxListeners(1))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = t.Listen(ma.StringCast("/ip4/1.2.3.4/udp/0/quic"))
+			Expect(err).To(HaveOccurred())
+			ln, err := t.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			ln.Close()
+		})
+	})
+
+	Context("serving connections", func() {
+		It("calls the handler for every connection, even if it panics", func() {
+			events, unsubscribe := t.(*transport).Subscribe()
+			defer unsubscribe()
+			ln, err := t.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			handled := make(chan tpt.CapableConn, 2)
+			serveErr := make(chan error, 1)
+			go func() {
+				serveErr <- ln.(*listener).ServeConns(func(c tpt.CapableConn) {
+					handled <- c
+					panic("handler failed")
+				})
+			}()
+
+			clientKey, _, err := ic.GenerateECDSAKeyPair(rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			clientID, err := peer.IDFromPrivateKey(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			serverID, err := peer.IDFromPrivateKey(key)
+			Expect(err).ToNot(HaveOccurred())
+			for i := 0; i < 2; i++ {
+				clientConn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+				Expect(err).ToNot(HaveOccurred())
+				defer clientConn.Close()
+				var c tpt.CapableConn
+				Eventually(handled).Should(Receive(&c))
+				Expect(c.RemotePeer()).To(Equal(clientConn.LocalPeer()))
+				// the connection of the panicking handler is closed
+				Eventually(clientConn.IsClosed).Should(BeTrue())
+			}
+			var panicked []Event
+			for len(panicked) < 2 {
+				var ev Event
+				Eventually(events).Should(Receive(&ev))
+				if ev.Type == EventHandlerPanicked {
+					panicked = append(panicked, ev)
+				}
+			}
+			for _, ev := range panicked {
+				Expect(ev.Peer).To(Equal(clientID))
+				Expect(ev.Err).To(MatchError("handler panicked: handler failed"))
+			}
+
+			Expect(ln.Close()).To(Succeed())
+			Eventually(serveErr).Should(Receive(HaveOccurred()))
+		})
+	})
+
+	Context("probing the socket", func() {
+		origListenUDP := listenUDP
+
+		AfterEach(func() {
+			listenUDP = origListenUDP
+		})
+
+		It("listens if the socket works", func() {
+			tr, err := NewTransport(key, WithListenProbe(time.Second))
+			Expect(err).ToNot(HaveOccurred())
+			ln, err := tr.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+			ln, err = tr.Listen(ma.StringCast("/ip4/0.0.0.0/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+		})
+
+		It("fails if the socket doesn't receive the probe", func() {
+			var pconn net.PacketConn
+			listenUDP = func(network string, laddr *net.UDPAddr) (net.PacketConn, error) {
+				conn, err := origListenUDP(network, laddr)
+				if err != nil {
+					return nil, err
+				}
+				pconn = &droppingConn{PacketConn: conn}
+				return pconn, nil
+			}
+			tr, err := NewTransport(key, WithListenProbe(50*time.Millisecond))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = tr.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).To(MatchError(ContainSubstring("listen probe failed")))
+			// the socket was closed
+			_, err = pconn.(*droppingConn).PacketConn.WriteTo([]byte("foobar"), pconn.LocalAddr())
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("refuses invalid timeouts", func() {
+			_, err := newConfig(WithListenProbe(0))
+			Expect(err).To(MatchError("listen probe timeout must be positive"))
+		})
+	})
+
+	Context("retrying after transient bind errors", func() {
+		origListenUDP := listenUDP
+		var attempts int32
+
+		AfterEach(func() {
+			listenUDP = origListenUDP
+		})
+
+		// failBinds makes the first n binds fail with err.
+		failBinds := func(n int32, err error) {
+			atomic.StoreInt32(&attempts, 0)
+			listenUDP = func(network string, laddr *net.UDPAddr) (net.PacketConn, error) {
+				if atomic.AddInt32(&attempts, 1) <= n {
+					return nil, &net.OpError{Op: "listen", Net: network, Err: os.NewSyscallError("bind", err)}
+				}
+				return origListenUDP(network, laddr)
+			}
+		}
+
+		It("retries until the address is available", func() {
+			failBinds(2, syscall.EADDRNOTAVAIL)
+			tr, err := NewTransport(key, WithListenRetry(3, 10*time.Millisecond))
+			Expect(err).ToNot(HaveOccurred())
+			ln, err := tr.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+			Expect(atomic.LoadInt32(&attempts)).To(BeEquivalentTo(3))
+		})
+
+		It("doesn't retry by default", func() {
+			failBinds(1, syscall.EADDRNOTAVAIL)
+			_, err := t.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).To(HaveOccurred())
+			Expect(atomic.LoadInt32(&attempts)).To(BeEquivalentTo(1))
+		})
+
+		It("gives up after the configured number of retries", func() {
+			failBinds(10, syscall.EADDRNOTAVAIL)
+			tr, err := NewTransport(key, WithListenRetry(2, time.Millisecond))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = tr.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(errors.Is(err, syscall.EADDRNOTAVAIL)).To(BeTrue())
+			Expect(atomic.LoadInt32(&attempts)).To(BeEquivalentTo(3))
+		})
+
+		It("doesn't retry after permanent errors", func() {
+			failBinds(10, syscall.EADDRINUSE)
+			tr, err := NewTransport(key, WithListenRetry(3, time.Millisecond))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = tr.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(errors.Is(err, syscall.EADDRINUSE)).To(BeTrue())
+			Expect(atomic.LoadInt32(&attempts)).To(BeEquivalentTo(1))
+		})
+
+		It("stops retrying when the context is canceled", func() {
+			failBinds(10, syscall.EADDRNOTAVAIL)
+			tr, err := NewTransport(key, WithListenRetry(3, time.Hour))
+			Expect(err).ToNot(HaveOccurred())
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, err = tr.(*transport).ListenContext(ctx, ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+			Expect(atomic.LoadInt32(&attempts)).To(BeEquivalentTo(1))
+		})
+
+		It("rejects invalid parameters", func() {
+			_, err := NewTransport(key, WithListenRetry(0, time.Second))
+			Expect(err).To(MatchError("number of listen retries must be positive"))
+			_, err = NewTransport(key, WithListenRetry(1, 0))
+			Expect(err).To(MatchError("listen retry backoff must be positive"))
+		})
+	})
+
+	Context("listening with a context", func() {
+		It("closes the listener when the context is canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			ln, err := t.(*transport).ListenContext(ctx, ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			errChan := make(chan error, 1)
+			go func() {
+				_, err := ln.Accept()
+				errChan <- err
+			}()
+			Consistently(errChan).ShouldNot(Receive())
+			cancel()
+			Eventually(errChan).Should(Receive(HaveOccurred()))
+			Eventually(ln.(*listener).acceptLoopDone).Should(BeClosed())
+			// the socket was closed, so the address can be used again
+			conn, err := net.ListenUDP("udp", ln.Addr().(*net.UDPAddr))
+			Expect(err).ToNot(HaveOccurred())
+			conn.Close()
+		})
+
+		It("can be closed before the context is canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ln, err := t.(*transport).ListenContext(ctx, ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ln.Close()).To(Succeed())
+			_, err = ln.Accept()
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("doesn't listen if the context is already canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := t.(*transport).ListenContext(ctx, ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).To(MatchError(context.Canceled))
+		})
+	})
+
+	Context("listening on the same address twice", func() {
+		It("refuses to listen twice by default", func() {
+			ln, err := t.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = t.Listen(ln.Multiaddr())
+			Expect(err).To(MatchError(ErrAlreadyListening))
+			// once the listener is closed, the address can be used again
+			Expect(ln.Close()).To(Succeed())
+			ln, err = t.Listen(ln.Multiaddr())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ln.Close()).To(Succeed())
+		})
+
+		It("shares the listener", func() {
+			t, err := NewTransport(key, WithDuplicateListenPolicy(DuplicateListenShare))
+			Expect(err).ToNot(HaveOccurred())
+			ln1, err := t.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			ln2, err := t.Listen(ln1.Multiaddr())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ln2).To(BeIdenticalTo(ln1))
+			ln3, err := t.Listen(ln1.Multiaddr())
+			Expect(err).ToNot(HaveOccurred())
+
+			// the listener is only closed when all users closed it
+			Expect(ln1.Close()).To(Succeed())
+			Expect(ln2.Close()).To(Succeed())
+			Consistently(ln3.(*listener).acceptLoopDone).ShouldNot(BeClosed())
+			Expect(ln3.Close()).To(Succeed())
+			Expect(ln3.(*listener).acceptLoopDone).To(BeClosed())
+		})
+
+		It("rejects invalid policies", func() {
+			_, err := NewTransport(key, WithDuplicateListenPolicy(42))
+			Expect(err).To(MatchError("invalid duplicate listen policy: 42"))
+		})
+	})
+
+	Context("per-listener TLS configuration", func() {
+		It("uses a different TLS configuration for every listener", func() {
+			localAddr := ma.StringCast("/ip4/127.0.0.1/udp/0/quic")
+			ln1, err := t.(*transport).ListenWithConfig(localAddr, ListenConfig{
+				NextProtos: []string{"proto-a"},
+				VerifyPeerCertificate: func([][]byte, [][]*x509.Certificate) error {
+					return errors.New("rejected by listener 1")
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			defer ln1.Close()
+			var verified int32
+			ln2, err := t.(*transport).ListenWithConfig(localAddr, ListenConfig{
+				NextProtos: []string{"proto-b"},
+				VerifyPeerCertificate: func([][]byte, [][]*x509.Certificate) error {
+					atomic.AddInt32(&verified, 1)
+					return nil
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			defer ln2.Close()
+			Expect(ln1.(*listener).tlsConf.NextProtos).To(Equal([]string{"proto-a"}))
+			Expect(ln2.(*listener).tlsConf.NextProtos).To(Equal([]string{"proto-b"}))
+			Expect(t.(*transport).tlsConf.NextProtos).To(BeEmpty())
+
+			serverID, err := peer.IDFromPrivateKey(key)
+			Expect(err).ToNot(HaveOccurred())
+			clientKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			clientTransport, err := NewTransport(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = clientTransport.Dial(context.Background(), ln1.Multiaddr(), serverID)
+			Expect(err).To(HaveOccurred())
+			conn, err := clientTransport.Dial(context.Background(), ln2.Multiaddr(), serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			_, err = ln2.Accept()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(atomic.LoadInt32(&verified)).To(BeEquivalentTo(1))
+		})
 	})
 })
@@ -0,0 +1,43 @@
+package libp2pquic
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrMemoryLimitExceeded is returned when a new connection would exceed the memory limit.
+var ErrMemoryLimitExceeded = errors.New("memory limit exceeded")
+
+// The amount of memory reserved for the receive buffers of a connection, unless configured using WithReceiveWindowBounds.
+// The connection-level flow control window bounds the data buffered on all streams of the connection,
+// so the stream-level windows don't need to be accounted for separately.
+var connReceiveBufferSize = int64(quicConfig.MaxReceiveConnectionFlowControlWindow)
+
+type memoryManager struct {
+	mutex    sync.Mutex
+	limit    int64 // 0 means no limit
+	reserved int64
+}
+
+func (m *memoryManager) Reserve(size int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.limit > 0 && m.reserved+size > m.limit {
+		return ErrMemoryLimitExceeded
+	}
+	m.reserved += size
+	return nil
+}
+
+func (m *memoryManager) Release(size int64) {
+	m.mutex.Lock()
+	m.reserved -= size
+	m.mutex.Unlock()
+}
+
+func (m *memoryManager) Reserved() int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.reserved
+}
@@ -0,0 +1,30 @@
+package libp2pquic
+
+import "sync"
+
+// metadata holds arbitrary key-value pairs attached to a connection or a stream.
+// It is only used locally (e.g. for logging), and never sent on the wire.
+// It is safe for concurrent use.
+type metadata struct {
+	mutex sync.RWMutex
+	meta  map[string]interface{}
+}
+
+// SetMeta attaches a value to the key.
+func (m *metadata) SetMeta(key string, val interface{}) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.meta == nil {
+		m.meta = make(map[string]interface{})
+	}
+	m.meta[key] = val
+}
+
+// Meta returns the value attached to the key, or nil if there's none.
+func (m *metadata) Meta(key string) interface{} {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.meta[key]
+}
@@ -0,0 +1,50 @@
+package libp2pquic
+
+import (
+	"strconv"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metadata", func() {
+	It("sets and reads metadata on connections", func() {
+		c := &conn{}
+		Expect(c.Meta("foo")).To(BeNil())
+		c.SetMeta("foo", "bar")
+		c.SetMeta("answer", 42)
+		Expect(c.Meta("foo")).To(Equal("bar"))
+		Expect(c.Meta("answer")).To(Equal(42))
+		c.SetMeta("foo", "baz")
+		Expect(c.Meta("foo")).To(Equal("baz"))
+	})
+
+	It("sets and reads metadata on streams", func() {
+		str := &stream{}
+		Expect(str.Meta("foo")).To(BeNil())
+		str.SetMeta("foo", "bar")
+		Expect(str.Meta("foo")).To(Equal("bar"))
+	})
+
+	It("is safe for concurrent use", func() {
+		c := &conn{}
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				key := strconv.Itoa(i)
+				for j := 0; j < 100; j++ {
+					c.SetMeta(key, j)
+					c.Meta(key)
+					c.Meta("other")
+				}
+			}(i)
+		}
+		wg.Wait()
+		for i := 0; i < 10; i++ {
+			Expect(c.Meta(strconv.Itoa(i))).To(Equal(99))
+		}
+	})
+})
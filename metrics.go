@@ -0,0 +1,30 @@
+package libp2pquic
+
+// A MetricsTracer is notified about the dials, connections and traffic of a transport, see WithMetrics.
+// The prometheus subpackage provides an implementation exporting Prometheus metrics.
+// Implementations must be safe for concurrent use.
+type MetricsTracer interface {
+	// DialStarted is called when Dial is called.
+	DialStarted()
+	// DialFinished is called when Dial returns. err is nil if the dial succeeded.
+	DialFinished(err error)
+	// HandshakeCompleted is called when a handshake completed, for both dialed and accepted connections.
+	HandshakeCompleted(incoming bool)
+	// ConnOpened and ConnClosed are called when a connection is established and when it is closed.
+	ConnOpened()
+	ConnClosed()
+	// BytesSent and BytesReceived are called with the number of bytes written to and read from streams.
+	BytesSent(n int)
+	BytesReceived(n int)
+	// PacketLost is called when a packet is declared lost.
+	// quic-go v0.11 doesn't expose its loss detection, so this is currently never called.
+	PacketLost()
+}
+
+// metricsTracer returns the MetricsTracer of the connection's transport, or nil if metrics are disabled.
+func (c *conn) metricsTracer() MetricsTracer {
+	if t, ok := c.transport.(*transport); ok && t != nil && t.config != nil {
+		return t.config.metrics
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package libp2pquic
+
+import "sync/atomic"
+
+// pathChanged is called each time the connection migrated to a new path.
+// quic-go v0.11 doesn't support connection migration and doesn't emit path events,
+// so this is only called for path changes detected by a listener's socket (see pathTrackingConn).
+func (c *conn) pathChanged() {
+	atomic.AddInt32(&c.migrations, 1)
+}
+
+// Migrations returns how often the connection migrated to a new path.
+// Since quic-go v0.11 doesn't support connection migration (see pathChanged),
+// only path changes of accepted connections are counted, e.g. when the client's NAT mapping changes.
+func (c *conn) Migrations() int {
+	return int(atomic.LoadInt32(&c.migrations))
+}
@@ -0,0 +1,46 @@
+package libp2pquic
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// inNetNamespace calls fn on an OS thread that joined the network namespace referred to by fd.
+// Sockets created by fn belong to that namespace, even after the thread left it again.
+func inNetNamespace(fd int, fn func() error) error {
+	errChan := make(chan error, 1)
+	go func() {
+		// If switching back to the original namespace fails, the thread is not unlocked.
+		// The Go runtime then terminates it when this goroutine returns,
+		// so that no other goroutine runs in the wrong namespace.
+		runtime.LockOSThread()
+		orig, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", syscall.Gettid()))
+		if err != nil {
+			runtime.UnlockOSThread()
+			errChan <- err
+			return
+		}
+		defer orig.Close()
+		if err := setns(fd); err != nil {
+			runtime.UnlockOSThread()
+			errChan <- err
+			return
+		}
+		fnErr := fn()
+		if err := setns(int(orig.Fd())); err != nil {
+			errChan <- fmt.Errorf("failed to restore network namespace: %s", err)
+			return
+		}
+		runtime.UnlockOSThread()
+		errChan <- fnErr
+	}()
+	return <-errChan
+}
+
+func setns(fd int) error {
+	return unix.Setns(fd, unix.CLONE_NEWNET)
+}
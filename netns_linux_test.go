@@ -0,0 +1,83 @@
+package libp2pquic
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// newNetNamespace creates a new network namespace, without switching to it.
+func newNetNamespace() (*os.File, error) {
+	type result struct {
+		ns  *os.File
+		err error
+	}
+	resChan := make(chan result, 1)
+	go func() {
+		runtime.LockOSThread()
+		orig, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", syscall.Gettid()))
+		if err != nil {
+			runtime.UnlockOSThread()
+			resChan <- result{err: err}
+			return
+		}
+		defer orig.Close()
+		if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			resChan <- result{err: err}
+			return
+		}
+		ns, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", syscall.Gettid()))
+		if err := unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET); err != nil {
+			// don't unlock the thread, so it is terminated
+			resChan <- result{err: err}
+			return
+		}
+		runtime.UnlockOSThread()
+		resChan <- result{ns: ns, err: err}
+	}()
+	res := <-resChan
+	return res.ns, res.err
+}
+
+var _ = Describe("Network namespaces", func() {
+	It("creates dial sockets in the network namespace", func() {
+		ns, err := newNetNamespace()
+		if err == unix.EPERM {
+			Skip("creating network namespaces requires CAP_SYS_ADMIN")
+		}
+		Expect(err).ToNot(HaveOccurred())
+		defer ns.Close()
+
+		cm := &connManager{netNamespace: int(ns.Fd()), useNetNamespace: true}
+		conn, release, err := cm.GetConnForAddr("udp4", 0, noShard, "")
+		Expect(err).ToNot(HaveOccurred())
+		defer release()
+		port := conn.LocalAddr().(*net.UDPAddr).Port
+
+		// the port is only in use in the other namespace
+		defaultConn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: port})
+		Expect(err).ToNot(HaveOccurred())
+		defaultConn.Close()
+		err = inNetNamespace(int(ns.Fd()), func() error {
+			c, err := net.ListenUDP("udp4", &net.UDPAddr{Port: port})
+			if err == nil {
+				c.Close()
+			}
+			return err
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("refuses to use a network namespace with a shared ConnManager", func() {
+		_, err := newConfig(WithConnManager(NewConnManager()), WithNetNamespace(3))
+		Expect(err).To(HaveOccurred())
+	})
+})
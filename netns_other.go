@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package libp2pquic
+
+import "errors"
+
+// Network namespaces are only supported on Linux.
+
+func inNetNamespace(int, func() error) error {
+	return errors.New("network namespaces are only supported on Linux")
+}
@@ -0,0 +1,53 @@
+package libp2pquic
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// withChainShapeCheck returns a copy of the tls.Config that rejects clients whose certificate chain
+// can't possibly be a libp2p chain, before it is parsed: Clients that don't present any certificate,
+// and, unless other schemes are supported (see WithPeerVerifier), chains that don't consist of two certificates.
+// Clients without a certificate are allowed to complete the TLS handshake up to the verification,
+// so that they are rejected with RejectReasonNotLibp2p instead of a generic TLS error.
+func withChainShapeCheck(conf *tls.Config, otherSchemes bool) *tls.Config {
+	verify := conf.VerifyPeerCertificate
+	conf = conf.Clone()
+	conf.ClientAuth = tls.RequestClientCert
+	conf.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return reject(RejectReasonNotLibp2p, errors.New("no certificate presented"))
+		}
+		if !otherSchemes && len(rawCerts) != 2 {
+			return reject(RejectReasonNotLibp2p, fmt.Errorf("expected 2 certificates in the chain, got %d", len(rawCerts)))
+		}
+		if verify != nil {
+			return verify(rawCerts, verifiedChains)
+		}
+		return nil
+	}
+	return conf
+}
+
+// withALPNCheck returns a copy of the tls.Config that rejects clients offering ALPN protocols
+// if the listener doesn't use ALPN, as libp2p clients don't offer any protocol in that case.
+// This rejects e.g. HTTP/3 clients before any certificate is exchanged.
+func withALPNCheck(conf *tls.Config) *tls.Config {
+	if len(conf.NextProtos) > 0 {
+		return conf
+	}
+	getConfigForClient := conf.GetConfigForClient
+	conf = conf.Clone()
+	conf.GetConfigForClient = func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
+		if len(chi.SupportedProtos) > 0 {
+			return nil, reject(RejectReasonNotLibp2p, fmt.Errorf("unexpected ALPN protocols: %q", chi.SupportedProtos))
+		}
+		if getConfigForClient != nil {
+			return getConfigForClient(chi)
+		}
+		return nil, nil
+	}
+	return conf
+}
@@ -0,0 +1,51 @@
+package libp2pquic
+
+import "sync"
+
+// A closeNotifier calls the callbacks registered using OnClose once a connection is closed.
+type closeNotifier struct {
+	mutex     sync.Mutex
+	closed    bool
+	reason    error
+	callbacks []func(reason error)
+}
+
+// Register registers a callback.
+// If the connection is already closed, the callback is called right away.
+func (n *closeNotifier) Register(cb func(reason error)) {
+	n.mutex.Lock()
+	if n.closed {
+		reason := n.reason
+		n.mutex.Unlock()
+		cb(reason)
+		return
+	}
+	n.callbacks = append(n.callbacks, cb)
+	n.mutex.Unlock()
+}
+
+// Notify calls all registered callbacks. Only the first call has an effect.
+func (n *closeNotifier) Notify(reason error) {
+	n.mutex.Lock()
+	if n.closed {
+		n.mutex.Unlock()
+		return
+	}
+	n.closed = true
+	n.reason = reason
+	callbacks := n.callbacks
+	n.callbacks = nil
+	n.mutex.Unlock()
+
+	for _, cb := range callbacks {
+		cb(reason)
+	}
+}
+
+// OnClose registers a callback that is called once the connection is closed,
+// no matter if it was closed by us, by the peer, or timed out.
+// The reason is the connection's CloseError.
+// Every callback is called exactly once. If the connection is already closed, it is called right away.
+func (c *conn) OnClose(cb func(reason error)) {
+	c.closeNotifier.Register(cb)
+}
@@ -0,0 +1,1195 @@
+package libp2pquic
+
+import (
+	"crypto/elliptic"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	quic "github.com/lucas-clemente/quic-go"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/whyrusleeping/mafmt"
+)
+
+// An Option configures the QUIC transport.
+type Option func(*config) error
+
+type config struct {
+	// dnsNames are the DNS names (SANs) of the generated certificate.
+	// The first name is used as the server name (SNI) when dialing.
+	dnsNames []string
+	// Additional extensions of the generated leaf certificate.
+	certExtensions []pkix.Extension
+	// The curve used for the ephemeral key of the generated certificate.
+	ephemeralKeyCurve elliptic.Curve
+	// memoryLimit is the maximum amount of receive buffer memory reserved for all connections.
+	// 0 means no limit.
+	memoryLimit int64
+	// The range of local ports used for dialing.
+	// If maxPort is 0, the OS chooses the port.
+	minPort, maxPort int
+	// The local IP address used for dialing.
+	// If nil, the OS chooses the address.
+	sourceIP net.IP
+	// The number of times a dial is retried on transient errors.
+	dialRetries      int
+	dialRetryBackoff time.Duration
+	// disableReuse makes every dial use its own socket.
+	disableReuse bool
+	// The maximum number of concurrent incoming handshakes per listener.
+	// 0 means no limit.
+	maxIncomingHandshakes int
+	// The maximum number of concurrent incoming handshakes from a single source IP, on every listener.
+	// 0 means that the number is not limited.
+	maxHandshakesPerIP int
+	// The maximum number of open unidirectional streams opened by the peer, per connection.
+	// 0 means that the number is only limited by quic-go.
+	maxIncomingUniStreams int
+	// The number of accepted sessions that are set up concurrently by every listener.
+	// 0 means that sessions are set up one after the other.
+	acceptWorkers int
+	// The number of times binding a listener's socket is retried after transient errors, see WithListenRetry.
+	listenRetries      int
+	listenRetryBackoff time.Duration
+	// trackReceiveDrops makes listeners track the packets dropped by the kernel, see WithReceiveDropTracking.
+	trackReceiveDrops bool
+	// If set, the sockets used for dialing simulate adverse network conditions, see WithChaos.
+	chaos *ChaosParams
+	// A pre-generated certificate chain.
+	// If nil, the certificate is generated using the host key.
+	certificate *tls.Certificate
+	// coalesceDials makes concurrent dials to the same peer and address share a single dial.
+	coalesceDials bool
+	// onPeerVerified is called as soon as the peer ID of a new connection has been verified.
+	onPeerVerified func(peer.ID, net.Addr)
+	// The maximum number of streams opened by the peer that haven't been accepted yet.
+	// 0 means that the number is only limited by the stream limit.
+	streamQueueDepth int
+	// The addresses returned by the listener's AdvertiseAddrs.
+	// If empty, the addresses are derived from the address the listener is bound to.
+	announceAddrs []ma.Multiaddr
+	// disableCertCache disables caching of certificate chain verifications.
+	disableCertCache bool
+	// The directory qlog files are written to.
+	// If empty, no qlog files are written.
+	qlogDir string
+	// validateSNI makes listeners reject clients that don't use one of dnsNames as the server name (SNI).
+	validateSNI bool
+	// A ConnManager shared with other transports.
+	// If nil, the transport uses its own.
+	connManager *ConnManager
+	// onReuseRefCountChange is called every time the reference count of a shared dial socket changes.
+	onReuseRefCountChange func(network string, count int, stack string)
+	// The QoS profiles that can be selected for a dial using WithConnClass, keyed by class name.
+	qosProfiles map[string]QoSProfile
+	// The maximum number of simultaneous listeners.
+	// 0 means no limit.
+	maxListeners int
+	// The maximum size of RSA keys in the peer's certificate chain, in bits.
+	maxRSAKeySize int
+	// The maximum number of certificates and bytes of the peer's certificate chain.
+	maxCertChainLen, maxCertChainBytes int
+	// The tracer used to emit spans for dials.
+	// If nil, no spans are emitted.
+	tracer Tracer
+	// detectPortUnreachable makes dials fail as soon as the peer's host reports that the port is closed.
+	detectPortUnreachable bool
+	// The number of connections that completed the handshake, but haven't been accepted yet,
+	// and what happens when this number is reached.
+	acceptQueueLen    int
+	acceptQueuePolicy AcceptQueueFullPolicy
+	// The QUIC versions offered when dialing, in order of preference.
+	// If empty, quic-go's default versions are offered.
+	dialVersions []quic.VersionNumber
+	// reuseSharding makes dials with different shard hints (see WithShardHint) use different sockets.
+	reuseSharding bool
+	// reusePolicy determines which dials share a socket.
+	reusePolicy ReusePolicy
+	// The time that a dialed connection has to confirm that the path works in both directions.
+	// 0 means that the path is not checked.
+	postDialPathCheckTimeout time.Duration
+	// The file descriptor of the network namespace that dial sockets are created in.
+	// Only used if useNetNamespace is set.
+	netNamespace    int
+	useNetNamespace bool
+	// localAddrMapper converts the local addresses of sockets to multiaddrs.
+	// If nil, toQuicMultiaddr is used.
+	localAddrMapper func(net.Addr) (ma.Multiaddr, error)
+	// externalAddrMapper maps the local multiaddr of a connection to the address the peer sees, see WithExternalAddrMapper.
+	externalAddrMapper func(local, remote ma.Multiaddr) ma.Multiaddr
+	// onHandshakeRejected is called every time we reject a handshake.
+	onHandshakeRejected func(HandshakeRejection)
+	// onDialSocketSelected is called every time a dial selected its socket.
+	onDialSocketSelected func(network string, laddr *net.UDPAddr, reused bool)
+	// dualStack makes the transport use dual-stack sockets, serving both IPv4 and IPv6.
+	dualStack bool
+	// onPeerCertExpiring is called when the certificate chain of a connection's peer
+	// expires within peerCertExpiryWindow.
+	onPeerCertExpiring   func(peer.ID, time.Time)
+	peerCertExpiryWindow time.Duration
+	// duplicateListenPolicy determines what happens when listening on the same multiaddr twice.
+	duplicateListenPolicy DuplicateListenPolicy
+	// The number of dial sockets kept open, see WithReuseSocketBudget.
+	reuseSocketBudget int
+	// The bounds of the stream-level receive window auto-tuning.
+	// If maxReceiveWindow is 0, the default windows are used.
+	minReceiveWindow, maxReceiveWindow uint64
+	// metrics is notified about dials, connections and traffic. nil if metrics are disabled.
+	metrics MetricsTracer
+	// globalBandwidthLimit is the rate at which data is written on the streams of all connections, in bytes per second.
+	// 0 if not limited.
+	globalBandwidthLimit uint64
+	// keepAlivePeriod is the interval at which keep-alives are sent. 0 if only quic-go's keep-alives are used.
+	keepAlivePeriod time.Duration
+	// connPoolSize is the maximum number of dialed connections kept for reuse by Dial. 0 if pooling is disabled.
+	connPoolSize int
+	// sessionSharing makes Dial return a view on an existing session to the peer, see WithSessionSharing.
+	sessionSharing bool
+	// silentClose makes Close and Shutdown abandon sessions without sending a CONNECTION_CLOSE, see WithConnectionClose.
+	silentClose bool
+	// retryTokenLifetime is how long the tokens sent in a Retry are valid, see WithRetryTokens.
+	// 0 if clients' addresses are not validated.
+	retryTokenLifetime time.Duration
+	retryTokenBinding  RetryTokenBinding
+	// addressFilter says which addresses may be dialed, see WithAddressFilter. nil if all addresses may be dialed.
+	addressFilter AddressFilter
+	// rawSocketOptions is called for every socket created for dialing, see WithRawSocketOptions.
+	rawSocketOptions func(network string, fd uintptr) error
+	// rejectNonLibp2p makes listeners reject clients that don't look like libp2p peers early, see WithNonLibp2pRejection.
+	rejectNonLibp2p bool
+	// statsInterval is the interval at which onStats is called, see WithStatsInterval
+	statsInterval time.Duration
+	onStats       func(TransportStats)
+	// onHostCertChange is called when a peer presents a different host certificate, see OnHostCertChange
+	onHostCertChange func(HostCertChange)
+	// writeFanOut is the number of sockets used for sending by every dial socket, see WithWriteFanOut
+	writeFanOut int
+	// trustedCAs must contain or have signed the peer's host certificate, see WithTrustedCAs
+	trustedCAs *x509.CertPool
+	// timeHandshakes records the duration of handshakes, see WithHandshakeTiming
+	timeHandshakes bool
+	// lazyCertificate defers generating the certificate chain until it is first needed, see WithLazyCertificate
+	lazyCertificate bool
+	// listenProbeTimeout is the time Listen waits for the packet sent to the new socket, see WithListenProbe.
+	// If 0, the socket isn't probed.
+	listenProbeTimeout time.Duration
+	// adaptiveIdleTimeout is the idle timeout of connections without open streams, see WithAdaptiveIdleTimeout.
+	// If 0, quic-go keeps all connections alive.
+	adaptiveIdleTimeout time.Duration
+	// connPoolIdleTimeout is the time after which pooled connections that weren't used are closed.
+	connPoolIdleTimeout time.Duration
+	// peerVerifiers are tried before the certificate scheme used by this transport, see WithPeerVerifier.
+	peerVerifiers []PeerVerifier
+	// controlStreams allows the peer to open unidirectional streams, which are used for control messages, see WithControlStreams.
+	controlStreams bool
+}
+
+func newConfig(opts ...Option) (*config, error) {
+	conf := &config{
+		dnsNames:          []string{hostname},
+		ephemeralKeyCurve: elliptic.P256(),
+		maxRSAKeySize:     defaultMaxRSAKeySize,
+		maxCertChainLen:   defaultMaxCertChainLen,
+		maxCertChainBytes: defaultMaxCertChainBytes,
+		acceptQueueLen:    defaultAcceptQueueLen,
+	}
+	for _, opt := range opts {
+		if err := opt(conf); err != nil {
+			return nil, err
+		}
+	}
+	if conf.connManager != nil && (conf.maxPort != 0 || conf.sourceIP != nil || conf.onReuseRefCountChange != nil || conf.detectPortUnreachable || conf.useNetNamespace || conf.dualStack || conf.reuseSocketBudget != 0 || conf.chaos != nil || conf.rawSocketOptions != nil || conf.writeFanOut != 0) {
+		return nil, errors.New("the socket options (ephemeral port range, source IP, reference count callback, port unreachable detection, network namespace, dual-stack socket, socket budget, chaos mode, raw socket options, write fan-out) can't be configured when using a shared ConnManager")
+	}
+	if conf.dualStack && conf.sourceIP != nil {
+		return nil, errors.New("a source IP can't be used with a dual-stack socket")
+	}
+	if conf.adaptiveIdleTimeout > 0 && conf.keepAlivePeriod > 0 {
+		return nil, errors.New("an adaptive idle timeout can't be used with a keep-alive period")
+	}
+	if !conf.controlStreams {
+		switch {
+		case conf.keepAlivePeriod > 0:
+			return nil, errors.New("a keep-alive period requires control streams, see WithControlStreams")
+		case conf.postDialPathCheckTimeout > 0:
+			return nil, errors.New("the post-dial path check requires control streams, see WithControlStreams")
+		case conf.maxIncomingUniStreams > 0:
+			return nil, errors.New("limiting incoming unidirectional streams requires control streams, see WithControlStreams")
+		}
+	}
+	return conf, nil
+}
+
+// WithDNSNames sets the DNS names (SANs) of the generated certificate.
+// The first name is also used as the server name (SNI) when dialing,
+// such that the name we announce matches the certificate we present.
+func WithDNSNames(names ...string) Option {
+	return func(c *config) error {
+		if len(names) == 0 {
+			return errors.New("at least one DNS name required")
+		}
+		c.dnsNames = names
+		return nil
+	}
+}
+
+// WithCertificateExtensions adds extensions to the leaf certificate presented during the handshake,
+// e.g. to carry an organization identifier required by compliance rules.
+// The extensions must not be critical, since peers would fail to verify the certificate otherwise,
+// and must not use the OID of the libp2p extension.
+func WithCertificateExtensions(exts ...pkix.Extension) Option {
+	return func(c *config) error {
+		for _, ext := range exts {
+			if ext.Id.Equal(extensionID) {
+				return fmt.Errorf("extension %s is reserved for libp2p", ext.Id)
+			}
+			if ext.Critical {
+				return fmt.Errorf("extension %s must not be critical", ext.Id)
+			}
+		}
+		c.certExtensions = append(c.certExtensions, exts...)
+		return nil
+	}
+}
+
+// WithMemoryLimit limits the receive buffer memory reserved for all connections of the transport.
+// New connections are refused once the limit would be exceeded.
+func WithMemoryLimit(limit int64) Option {
+	return func(c *config) error {
+		if limit <= 0 {
+			return errors.New("memory limit must be positive")
+		}
+		c.memoryLimit = limit
+		return nil
+	}
+}
+
+// WithEphemeralPortRange restricts the local ports used for dialing to the range [min, max].
+// The first free port in the range is used.
+func WithEphemeralPortRange(min, max int) Option {
+	return func(c *config) error {
+		if min <= 0 || max > 65535 || min > max {
+			return fmt.Errorf("invalid port range: %d-%d", min, max)
+		}
+		c.minPort = min
+		c.maxPort = max
+		return nil
+	}
+}
+
+// WithDialRetries retries dials that fail with a transient network error up to n times.
+// The backoff is doubled after every attempt.
+// Dials are never retried if the peer's identity doesn't match, or if the context is canceled.
+func WithDialRetries(n int, backoff time.Duration) Option {
+	return func(c *config) error {
+		if n < 0 {
+			return errors.New("number of dial retries must not be negative")
+		}
+		c.dialRetries = n
+		c.dialRetryBackoff = backoff
+		return nil
+	}
+}
+
+// DisableReuse makes every dial use a new socket, instead of sharing a socket between all dials.
+// The socket is closed when the connection is closed.
+func DisableReuse() Option {
+	return func(c *config) error {
+		c.disableReuse = true
+		return nil
+	}
+}
+
+// WithMaxIncomingHandshakes limits the number of concurrent incoming handshakes on every listener.
+// Handshakes beyond this limit are refused.
+// Handshakes are CPU-heavy, so this protects a node from being overloaded by many connection attempts.
+func WithMaxIncomingHandshakes(n int) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return errors.New("maximum number of incoming handshakes must be positive")
+		}
+		c.maxIncomingHandshakes = n
+		return nil
+	}
+}
+
+// WithCertificate makes the transport use a pre-generated certificate chain (see GenerateCertificate),
+// instead of generating one using the host key.
+// The transport then never accesses the raw bytes of the host key.
+func WithCertificate(cert *tls.Certificate) Option {
+	return func(c *config) error {
+		c.certificate = cert
+		return nil
+	}
+}
+
+// WithDialCoalescing makes concurrent dials to the same peer and address share a single QUIC dial.
+// All callers receive the same connection (or error).
+// The shared dial is only canceled if the contexts of all callers are canceled.
+func WithDialCoalescing() Option {
+	return func(c *config) error {
+		c.coalesceDials = true
+		return nil
+	}
+}
+
+// WithSourceIP binds the sockets used for dialing to a local IP address.
+// The port is chosen by the OS (or from the range configured by WithEphemeralPortRange).
+// The IP is only used for dials to addresses of the same address family.
+func WithSourceIP(ip net.IP) Option {
+	return func(c *config) error {
+		if ip == nil || ip.IsUnspecified() {
+			return errors.New("invalid source IP")
+		}
+		c.sourceIP = ip
+		return nil
+	}
+}
+
+// OnPeerVerified sets a callback that is called as soon as the peer ID of a new connection has been verified
+// during the handshake, for both dialed and accepted connections.
+// It is called before the connection is returned by Dial or Accept,
+// and the handshake doesn't complete until it returns, so it must be fast.
+func OnPeerVerified(cb func(p peer.ID, remote net.Addr)) Option {
+	return func(c *config) error {
+		c.onPeerVerified = cb
+		return nil
+	}
+}
+
+// WithStreamAcceptQueueDepth limits the number of streams opened by the peer that haven't been accepted yet.
+// Streams beyond this limit are reset with StreamQueueFullErrorCode.
+func WithStreamAcceptQueueDepth(depth int) Option {
+	return func(c *config) error {
+		if depth <= 0 {
+			return errors.New("stream accept queue depth must be positive")
+		}
+		c.streamQueueDepth = depth
+		return nil
+	}
+}
+
+// WithAnnounceAddrs sets the addresses that listeners advertise to other peers,
+// instead of deriving them from the address a listener is bound to.
+// This is useful when the node is reachable via an address that's not bound locally,
+// e.g. when a port is forwarded.
+func WithAnnounceAddrs(addrs ...ma.Multiaddr) Option {
+	return func(c *config) error {
+		for _, addr := range addrs {
+			if !mafmt.QUIC.Matches(addr) {
+				return fmt.Errorf("not a QUIC address: %s", addr)
+			}
+		}
+		c.announceAddrs = addrs
+		return nil
+	}
+}
+
+// DisableCertCache disables caching of certificate chain verifications.
+// By default, the result of verifying a peer's certificate chain is cached,
+// such that repeated handshakes using the same certificate chain don't need to verify it again.
+func DisableCertCache() Option {
+	return func(c *config) error {
+		c.disableCertCache = true
+		return nil
+	}
+}
+
+// WithGrease configures if GREASE transport parameters are sent during the handshake.
+// The version of quic-go currently used doesn't support GREASE, so enabling it fails.
+func WithGrease(enable bool) Option {
+	return func(c *config) error {
+		if enable {
+			return errors.New("GREASE is not supported by quic-go v0.11")
+		}
+		return nil
+	}
+}
+
+// WithQlogDir makes the transport write a qlog file for every connection into dir.
+// The file names contain the peer ID and the time the connection was established.
+// Files are rotated when they grow too large, and the oldest files are removed
+// when the directory contains too many qlog files.
+func WithQlogDir(dir string) Option {
+	return func(c *config) error {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		c.qlogDir = dir
+		return nil
+	}
+}
+
+// WithSNIValidation makes listeners reject connections from clients whose server name (SNI)
+// doesn't match any of the DNS names of the certificate (see WithDNSNames).
+func WithSNIValidation() Option {
+	return func(c *config) error {
+		c.validateSNI = true
+		return nil
+	}
+}
+
+// WithConnManager makes the transport use a ConnManager that is shared with other transports,
+// such that dials of all these transports use the same sockets.
+// This saves ports, and makes all transports use the same NAT mapping.
+func WithConnManager(m *ConnManager) Option {
+	return func(c *config) error {
+		c.connManager = m
+		return nil
+	}
+}
+
+// OnReuseRefCountChange sets a callback that is called every time the reference count of a socket
+// shared by multiple dials changes, with the new count and a hint of the call stack that caused the change.
+// Once all connections using a socket are closed, the count drops to zero and the socket is closed,
+// so a count that never returns to zero indicates a leak.
+// The callback is called synchronously with the change, so it must not block.
+func OnReuseRefCountChange(cb func(network string, count int, stack string)) Option {
+	return func(c *config) error {
+		c.onReuseRefCountChange = cb
+		return nil
+	}
+}
+
+// WithQoSProfile registers a QoS profile for a class of connections.
+// Dials select the class using WithConnClass.
+func WithQoSProfile(class string, profile QoSProfile) Option {
+	return func(c *config) error {
+		if class == "" {
+			return errors.New("empty connection class")
+		}
+		if profile.DSCP > 63 {
+			return fmt.Errorf("invalid DSCP: %d", profile.DSCP)
+		}
+		if c.qosProfiles == nil {
+			c.qosProfiles = make(map[string]QoSProfile)
+		}
+		c.qosProfiles[class] = profile
+		return nil
+	}
+}
+
+// WithMaxListeners limits the number of listeners that can be open at the same time.
+// Listen returns a *TooManyListenersError once the limit is reached.
+// Closing a listener frees its slot.
+func WithMaxListeners(n int) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return errors.New("maximum number of listeners must be positive")
+		}
+		c.maxListeners = n
+		return nil
+	}
+}
+
+// WithMaxRSAKeySize sets the maximum size (in bits) of RSA keys in the peer's certificate chain.
+// Handshakes with peers using larger keys are aborted before verifying any signature,
+// since verifying signatures made by very large keys is expensive.
+// The default is 8192 bits.
+func WithMaxRSAKeySize(bits int) Option {
+	return func(c *config) error {
+		if bits <= 0 {
+			return errors.New("maximum RSA key size must be positive")
+		}
+		c.maxRSAKeySize = bits
+		return nil
+	}
+}
+
+// WithTracer makes the transport emit a span for every dial, with a child span for the handshake.
+func WithTracer(tracer Tracer) Option {
+	return func(c *config) error {
+		c.tracer = tracer
+		return nil
+	}
+}
+
+// WithPortUnreachableDetection makes dials fail with ErrConnRefused as soon as the peer's host
+// reports that the UDP port is closed (using an ICMP port unreachable message),
+// instead of waiting for the handshake to time out.
+// This is only supported on Linux, where it uses IP_RECVERR. On other platforms, it has no effect.
+func WithPortUnreachableDetection() Option {
+	return func(c *config) error {
+		c.detectPortUnreachable = true
+		return nil
+	}
+}
+
+// WithDatagramCompression compresses application datagrams using codec.
+// Both peers need to enable compression, datagrams are rejected otherwise.
+// The version of quic-go currently used doesn't support datagrams (see MaxDatagramSize),
+// so applying this option fails with ErrDatagramCompressionUnsupported.
+func WithDatagramCompression(codec DatagramCodec) Option {
+	return func(c *config) error {
+		if codec == nil {
+			return errors.New("datagram codec must not be nil")
+		}
+		return ErrDatagramCompressionUnsupported
+	}
+}
+
+// WithAcceptQueueSize sets the number of connections that completed the handshake, but haven't been accepted yet.
+// The policy determines what happens to new connections when the queue is full.
+// By default, 16 connections are queued, and the listener uses AcceptQueueBackpressure.
+func WithAcceptQueueSize(n int, policy AcceptQueueFullPolicy) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return errors.New("accept queue size must be positive")
+		}
+		if policy != AcceptQueueBackpressure && policy != AcceptQueueReject {
+			return fmt.Errorf("invalid accept queue policy: %d", policy)
+		}
+		c.acceptQueueLen = n
+		c.acceptQueuePolicy = policy
+		return nil
+	}
+}
+
+// WithDialVersions sets the QUIC versions offered when dialing, in order of preference.
+// The version used by a connection can be obtained using its Version method.
+// Note that quic-go v0.11 only supports QUIC draft-19, dials offering any other version fail.
+func WithDialVersions(versions []quic.VersionNumber) Option {
+	return func(c *config) error {
+		if len(versions) == 0 {
+			return errors.New("no QUIC versions")
+		}
+		c.dialVersions = append([]quic.VersionNumber(nil), versions...)
+		return nil
+	}
+}
+
+// WithReuseSharding makes dials use a separate socket per shard hint, see WithShardHint.
+// Dials without a shard hint keep using the shared socket.
+//
+// This is meant for NUMA-aware deployments that pin the goroutines dialing (and handling the connections)
+// to a core, and want the packets of these connections to be processed on the same core.
+// Note that the Go runtime doesn't do any such pinning on its own: it's up to the caller to choose
+// a shard hint that matches the core its goroutine is running on.
+// Every shard uses its own socket, and therefore its own port, which increases the number of
+// file descriptors used and makes NAT traversal less effective, as the peer sees a different port per shard.
+func WithReuseSharding() Option {
+	return func(c *config) error {
+		c.reuseSharding = true
+		return nil
+	}
+}
+
+// WithPostDialPathCheck makes Dial confirm that the path to the peer works in both directions,
+// before returning the connection. This catches asymmetric paths that allow the handshake to complete,
+// but drop packets later on, as is sometimes the case with carrier-grade NATs.
+// The path is checked using a ping (see Ping), so it requires control streams (see WithControlStreams).
+// If no pong is received within the timeout, the connection is closed, and Dial returns ErrPathNotValidated.
+// If the peer doesn't support control streams, Dial returns ErrControlStreamsUnsupported.
+func WithPostDialPathCheck(timeout time.Duration) Option {
+	return func(c *config) error {
+		if timeout <= 0 {
+			return errors.New("path check timeout must be positive")
+		}
+		c.postDialPathCheckTimeout = timeout
+		return nil
+	}
+}
+
+// WithNetNamespace makes the transport create its dial sockets in a network namespace.
+// fd is a file descriptor referring to the namespace, e.g. obtained by opening /var/run/netns/<name>.
+// The caller must keep it open for as long as the transport is used.
+// Network namespaces are only supported on Linux, and switching namespaces requires CAP_SYS_ADMIN.
+// Sockets of listeners are not affected: they are created in the namespace of the calling process.
+func WithNetNamespace(fd int) Option {
+	return func(c *config) error {
+		if fd < 0 {
+			return fmt.Errorf("invalid network namespace file descriptor: %d", fd)
+		}
+		c.netNamespace = fd
+		c.useNetNamespace = true
+		return nil
+	}
+}
+
+// WithLocalAddrMapper sets the function used to compute the local multiaddrs of listeners and connections
+// from the local address of their socket. This is needed for sockets whose local address isn't a *net.UDPAddr.
+// By default, the address is converted to a /ip4/.../udp/.../quic or /ip6/.../udp/.../quic multiaddr.
+func WithLocalAddrMapper(mapper func(net.Addr) (ma.Multiaddr, error)) Option {
+	return func(c *config) error {
+		if mapper == nil {
+			return errors.New("nil address mapper")
+		}
+		c.localAddrMapper = mapper
+		return nil
+	}
+}
+
+// WithExternalAddrMapper sets a function that maps the local multiaddr of a connection to the address
+// the peer sees us at, e.g. using the external mapping of a NAT learned from AutoNAT or announced addresses.
+// It is consulted every time LocalMultiaddr is called, so the mapping may change over time.
+// If it returns nil, the local multiaddr of the socket is used. LocalAddr always returns the address of the socket.
+func WithExternalAddrMapper(mapper func(local, remote ma.Multiaddr) ma.Multiaddr) Option {
+	return func(c *config) error {
+		if mapper == nil {
+			return errors.New("nil address mapper")
+		}
+		c.externalAddrMapper = mapper
+		return nil
+	}
+}
+
+// OnHandshakeRejected sets a callback that is called every time we reject a handshake,
+// for both dials and incoming connections, e.g. for security monitoring.
+// The handshake doesn't fail until it returns, so it must be fast.
+func OnHandshakeRejected(cb func(HandshakeRejection)) Option {
+	return func(c *config) error {
+		c.onHandshakeRejected = cb
+		return nil
+	}
+}
+
+// WithZeroLengthConnectionIDs makes dials use zero-length connection IDs,
+// saving a few bytes on every packet sent by the peer.
+// This is only useful for point-to-point links: Without connection IDs,
+// connections can't migrate, and load balancers can't route packets by connection ID.
+// quic-go v0.11 only uses zero-length connection IDs on sockets it creates itself,
+// and substitutes 4 byte connection IDs on the shared sockets used by this transport,
+// so applying this option fails.
+func WithZeroLengthConnectionIDs() Option {
+	return func(c *config) error {
+		return errors.New("zero-length connection IDs are not supported on shared sockets by quic-go v0.11")
+	}
+}
+
+// WithReusePolicy sets which dials share a socket, and therefore use the same source port.
+// By default, all dials share a socket (ReuseGlobal), which makes NAT traversal work best,
+// but allows peers to link our connections to different destinations by the source port.
+// ReusePerPeer uses a separate socket for every peer dialed, trading the NAT traversal benefits for unlinkability.
+func WithReusePolicy(policy ReusePolicy) Option {
+	return func(c *config) error {
+		if policy != ReuseGlobal && policy != ReusePerPeer {
+			return fmt.Errorf("invalid reuse policy: %d", int(policy))
+		}
+		c.reusePolicy = policy
+		return nil
+	}
+}
+
+// OnDialSocketSelected sets a callback that is called every time a dial selected the socket it uses,
+// e.g. to observe the local ports used for NAT experiments.
+// reused says if the socket was already used by another dial (see WithReusePolicy and DisableReuse).
+func OnDialSocketSelected(cb func(network string, laddr *net.UDPAddr, reused bool)) Option {
+	return func(c *config) error {
+		c.onDialSocketSelected = cb
+		return nil
+	}
+}
+
+// WithDualStack makes the transport use a single dual-stack socket (with IPV6_V6ONLY disabled)
+// for both IPv4 and IPv6, saving a file descriptor.
+// All dials use one socket bound to the IPv6 wildcard address.
+// A listener on the IPv6 wildcard address (/ip6/::/udp/.../quic) also accepts connections from IPv4 peers.
+// This requires a platform that supports IPv4-mapped IPv6 addresses, and can't be combined with WithSourceIP.
+func WithDualStack() Option {
+	return func(c *config) error {
+		c.dualStack = true
+		return nil
+	}
+}
+
+// WithPeerCertExpiryWarning sets a callback that is called when the certificate chain presented by the peer
+// of a connection expires within window. For connections established with a certificate that expires even sooner,
+// it is called right away. The callback is not called for connections that are closed before.
+func WithPeerCertExpiryWarning(window time.Duration, cb func(p peer.ID, expiry time.Time)) Option {
+	return func(c *config) error {
+		if window < 0 {
+			return errors.New("negative certificate expiry window")
+		}
+		c.onPeerCertExpiring = cb
+		c.peerCertExpiryWindow = window
+		return nil
+	}
+}
+
+// WithDuplicateListenPolicy sets what happens when Listen is called for a multiaddr the transport is already listening on.
+// By default, Listen returns ErrAlreadyListening (DuplicateListenReject).
+// Listens on port 0 are never considered duplicates.
+func WithDuplicateListenPolicy(policy DuplicateListenPolicy) Option {
+	return func(c *config) error {
+		if policy != DuplicateListenReject && policy != DuplicateListenShare {
+			return fmt.Errorf("invalid duplicate listen policy: %d", int(policy))
+		}
+		c.duplicateListenPolicy = policy
+		return nil
+	}
+}
+
+// WithReuseSocketBudget keeps up to n dial sockets open, even when they aren't used by any connection,
+// so that frequently used sockets (e.g. of a shard, see WithReuseSharding) don't have to be recreated.
+// Every socket has a usage score, which counts the dials using it, decaying with a half-life of one minute.
+// When a new socket would exceed the budget, the unused socket with the lowest score is closed.
+// Sockets in use are never closed, so the number of sockets can exceed the budget.
+// By default, sockets are closed as soon as they aren't used any more.
+func WithReuseSocketBudget(n int) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return errors.New("socket budget must be positive")
+		}
+		c.reuseSocketBudget = n
+		return nil
+	}
+}
+
+// WithReceiveWindowBounds sets the bounds of the stream-level receive window auto-tuning.
+// The connection-level window is 1.5 times the stream-level window.
+// The memory reserved per connection (see WithMemoryLimit) is adjusted accordingly.
+// quic-go v0.11 always starts with a window of 512 KB, and doesn't allow configuring the initial window.
+// The minimum is therefore only validated, and must not be larger than 512 KB.
+func WithReceiveWindowBounds(min, max uint64) Option {
+	return func(c *config) error {
+		if min > max {
+			return fmt.Errorf("minimum receive window (%d) larger than maximum (%d)", min, max)
+		}
+		if min > initialReceiveWindow {
+			return fmt.Errorf("minimum receive window (%d) larger than the initial window (%d)", min, initialReceiveWindow)
+		}
+		if max < initialReceiveWindow {
+			return fmt.Errorf("maximum receive window (%d) smaller than the initial window (%d)", max, initialReceiveWindow)
+		}
+		c.minReceiveWindow = min
+		c.maxReceiveWindow = max
+		return nil
+	}
+}
+
+// WithMetrics makes the transport report dials, connections and traffic to the MetricsTracer.
+// See the prometheus subpackage for a tracer exporting Prometheus metrics.
+func WithMetrics(tracer MetricsTracer) Option {
+	return func(c *config) error {
+		if tracer == nil {
+			return errors.New("nil metrics tracer")
+		}
+		c.metrics = tracer
+		return nil
+	}
+}
+
+// WithClientHelloPadding configures if the Initial packets carrying the ClientHello are padded
+// to 1200 bytes, the minimum size that lifts the server's anti-amplification limit.
+// Padding is enabled by default. The version of quic-go currently used always pads these packets,
+// and splits a ClientHello that doesn't fit into a single packet without offering any control over the fragmentation,
+// so disabling padding fails.
+func WithClientHelloPadding(enable bool) Option {
+	return func(c *config) error {
+		if !enable {
+			return errors.New("disabling ClientHello padding is not supported by quic-go v0.11")
+		}
+		return nil
+	}
+}
+
+// WithGlobalBandwidthLimit limits the rate at which data is written on the streams of all connections combined,
+// in bytes per second. Like SetMaxPacingRate, the limit is enforced by delaying writes before they are passed to quic-go.
+// It applies in addition to the pacing rate of every connection.
+func WithGlobalBandwidthLimit(bytesPerSec uint64) Option {
+	return func(c *config) error {
+		if bytesPerSec == 0 {
+			return errors.New("bandwidth limit must be positive")
+		}
+		c.globalBandwidthLimit = bytesPerSec
+		return nil
+	}
+}
+
+// WithKeepAlivePeriod sends a keep-alive on every connection at the given interval.
+// quic-go sends keep-alives after half of the idle timeout passed without network activity,
+// and doesn't allow configuring this interval, so the keep-alives are sent on a control stream (see WithControlStreams).
+// If the peer doesn't support control streams, only quic-go's keep-alives are sent on the connection.
+// The period must be shorter than the idle timeout.
+func WithKeepAlivePeriod(period time.Duration) Option {
+	return func(c *config) error {
+		if period <= 0 {
+			return errors.New("keep-alive period must be positive")
+		}
+		if timeout := idleTimeout(); period >= timeout {
+			return fmt.Errorf("keep-alive period (%s) must be shorter than the idle timeout (%s)", period, timeout)
+		}
+		c.keepAlivePeriod = period
+		return nil
+	}
+}
+
+// WithConnPool makes Dial return an existing connection to the peer, if one was dialed before and is still open.
+// Every call to Dial returns a separate handle on the pooled connection, which is closed once the pool
+// and all handles returned by Dial are done with it. Closing a handle doesn't affect the other handles.
+// Up to maxIdle dialed connections are kept in the pool. When the pool is full, the least recently used connection
+// is removed from the pool. Connections that weren't returned by Dial for idleTimeout are removed from the pool
+// as soon as they don't have any open streams.
+func WithConnPool(maxIdle int, idleTimeout time.Duration) Option {
+	return func(c *config) error {
+		if maxIdle <= 0 {
+			return errors.New("connection pool size must be positive")
+		}
+		if idleTimeout <= 0 {
+			return errors.New("connection pool idle timeout must be positive")
+		}
+		c.connPoolSize = maxIdle
+		c.connPoolIdleTimeout = idleTimeout
+		return nil
+	}
+}
+
+// WithMaxHandshakesPerIP limits the number of concurrent incoming handshakes from a single source IP on every listener,
+// to mitigate handshake floods from a single host. Established connections don't count towards this limit.
+// Handshakes beyond the limit are refused before any state is created for them, by sending a Retry.
+func WithMaxHandshakesPerIP(n int) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return errors.New("maximum number of handshakes per IP must be positive")
+		}
+		c.maxHandshakesPerIP = n
+		return nil
+	}
+}
+
+// WithAcceptWorkers sets the number of accepted connections that every listener sets up concurrently.
+// quic-go runs the handshakes of incoming connections concurrently, but by default they are then set up and
+// queued for Accept one after the other, so a single slow connection (e.g. waiting for a full accept queue)
+// delays all following connections. With multiple workers, connections may be returned by Accept out of order.
+func WithAcceptWorkers(n int) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return errors.New("number of accept workers must be positive")
+		}
+		c.acceptWorkers = n
+		return nil
+	}
+}
+
+// WithListenRetry makes Listen retry binding the socket up to retries times when it fails with a transient error,
+// i.e. when the address is not available (yet), as it happens during network changes.
+// The first retry happens after backoff, which is doubled for every following retry.
+// Other errors, e.g. for malformed addresses or ports that are in use, are returned right away.
+// Use ListenContext to abort retrying.
+func WithListenRetry(retries int, backoff time.Duration) Option {
+	return func(c *config) error {
+		if retries <= 0 {
+			return errors.New("number of listen retries must be positive")
+		}
+		if backoff <= 0 {
+			return errors.New("listen retry backoff must be positive")
+		}
+		c.listenRetries = retries
+		c.listenRetryBackoff = backoff
+		return nil
+	}
+}
+
+// WithReceiveDropTracking makes listeners track the number of packets the kernel dropped
+// because the receive buffer of the listener's socket was full, see ListenerStats.
+// A growing number of drops is a sign that the receive buffer is too small, or that the host can't keep up with the load.
+// This is only supported on Linux, where the counter is read from /proc/net/udp. On other platforms, it has no effect.
+func WithReceiveDropTracking() Option {
+	return func(c *config) error {
+		c.trackReceiveDrops = true
+		return nil
+	}
+}
+
+// WithEphemeralKeyCurve sets the curve used for the ephemeral ECDSA key of the generated certificate.
+// The default is P-256. TLS 1.3 only defines ECDSA signature schemes for P-256, P-384 and P-521.
+// This doesn't affect the key exchange, which always uses the curves negotiated by TLS.
+func WithEphemeralKeyCurve(curve elliptic.Curve) Option {
+	return func(c *config) error {
+		switch curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+		default:
+			return fmt.Errorf("unsupported curve for the ephemeral key: %s", curveName(curve))
+		}
+		c.ephemeralKeyCurve = curve
+		return nil
+	}
+}
+
+func curveName(curve elliptic.Curve) string {
+	if curve == nil || curve.Params() == nil {
+		return "none"
+	}
+	return curve.Params().Name
+}
+
+// WithMaxIncomingUniStreams limits the number of unidirectional streams opened by the peer that are open at the same time,
+// counting both streams that are waiting to be accepted and accepted streams that haven't been read completely or canceled.
+// Streams beyond this limit are refused with UniStreamLimitErrorCode.
+// The limit is enforced independently of the limit for bidirectional streams.
+// Unidirectional streams used internally by the transport (e.g. for pings) are not counted.
+// It requires control streams, see WithControlStreams.
+func WithMaxIncomingUniStreams(n int) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return errors.New("maximum number of incoming unidirectional streams must be positive")
+		}
+		c.maxIncomingUniStreams = n
+		return nil
+	}
+}
+
+// WithChaos makes the sockets used for dialing drop, delay and reorder the packets they send,
+// as configured by params. This allows testing how applications behave under adverse network conditions.
+// It must not be used in production.
+func WithChaos(params ChaosParams) Option {
+	return func(c *config) error {
+		if err := params.validate(); err != nil {
+			return err
+		}
+		c.chaos = &params
+		return nil
+	}
+}
+
+// WithSessionSharing makes Dial return a lightweight view on an existing connection to the peer, if there is one,
+// instead of establishing a new session. Streams opened on the view are multiplexed over the existing session.
+// Views can be closed independently: The session is only closed once the connection and all views on it are closed.
+// Streams opened by the peer are delivered to whichever view (or the connection itself) accepts them first.
+func WithSessionSharing() Option {
+	return func(c *config) error {
+		c.sessionSharing = true
+		return nil
+	}
+}
+
+// WithConnectionClose controls whether closing a connection (using Close, or when shutting down the transport)
+// sends a CONNECTION_CLOSE frame to the peer. This is the default.
+// If disabled, sessions are abandoned silently, and the peer only notices when its idle timeout fires.
+// This avoids sending packets to a large number of peers, e.g. during a fast restart.
+func WithConnectionClose(send bool) Option {
+	return func(c *config) error {
+		c.silentClose = !send
+		return nil
+	}
+}
+
+// WithRetryTokens makes listeners validate the address of every client before starting the handshake:
+// Clients are sent a Retry containing a token, and have to present the token to continue.
+// Tokens are valid for lifetime, and are bound to the client's address as configured by binding.
+// Since the time a token was issued is encoded with a resolution of one second, lifetime is only accurate to a second.
+func WithRetryTokens(lifetime time.Duration, binding RetryTokenBinding) Option {
+	return func(c *config) error {
+		if lifetime <= 0 {
+			return errors.New("retry token lifetime must be positive")
+		}
+		if binding != RetryTokenBindIPAndPort && binding != RetryTokenBindIP {
+			return fmt.Errorf("invalid retry token binding: %d", int(binding))
+		}
+		c.retryTokenLifetime = lifetime
+		c.retryTokenBinding = binding
+		return nil
+	}
+}
+
+// WithAddressFilter makes Dial refuse to dial addresses rejected by filter, returning ErrDisallowedAddress.
+// The filter is applied to the resolved address. PublicAddressesOnly can be used to only allow public addresses.
+func WithAddressFilter(filter AddressFilter) Option {
+	return func(c *config) error {
+		if filter == nil {
+			return errors.New("address filter must not be nil")
+		}
+		c.addressFilter = filter
+		return nil
+	}
+}
+
+// WithMaxCertChainSize limits the peer's certificate chain to maxCerts certificates and maxBytes bytes.
+// Handshakes with peers presenting larger chains are aborted before the transport parses the chain.
+// Note that the TLS stack itself parses the certificates before passing them to the transport,
+// but the size of the chain is bounded by the maximum TLS handshake message size in that case.
+// The default is 4 certificates and 64 KB.
+func WithMaxCertChainSize(maxCerts, maxBytes int) Option {
+	return func(c *config) error {
+		if maxCerts <= 0 {
+			return errors.New("maximum number of certificates must be positive")
+		}
+		if maxBytes <= 0 {
+			return errors.New("maximum certificate chain size must be positive")
+		}
+		c.maxCertChainLen = maxCerts
+		c.maxCertChainBytes = maxBytes
+		return nil
+	}
+}
+
+// WithRawSocketOptions calls fn for every socket created for dialing, before it is used.
+// network is the network of the socket ("udp4", "udp6", or "udp" for dual-stack sockets, see WithDualStack),
+// fd is its file descriptor (a handle on Windows). This allows setting socket options that have no dedicated option.
+// If fn returns an error, the socket is closed, and the dial fails with that error.
+// Socket options are platform-specific: fn needs to check which options are available on the platform it runs on.
+// fn must not close the file descriptor, or keep it after returning.
+func WithRawSocketOptions(fn func(network string, fd uintptr) error) Option {
+	return func(c *config) error {
+		if fn == nil {
+			return errors.New("raw socket options callback must not be nil")
+		}
+		c.rawSocketOptions = fn
+		return nil
+	}
+}
+
+// WithNonLibp2pRejection makes listeners reject clients that obviously aren't libp2p peers
+// (e.g. other QUIC applications sharing the port) as early as possible, with RejectReasonNotLibp2p:
+// Clients offering ALPN protocols are rejected when processing the ClientHello, unless the listener uses ALPN (see ListenConfig).
+// Clients presenting no certificate, or a chain that doesn't consist of two certificates, are rejected before the chain is parsed.
+// The chain length is not checked if additional certificate schemes are supported (see WithPeerVerifier).
+func WithNonLibp2pRejection() Option {
+	return func(c *config) error {
+		c.rejectNonLibp2p = true
+		return nil
+	}
+}
+
+// WithStatsInterval calls cb with a snapshot of the transport's statistics (see Stats) every interval,
+// until the transport is shut down (see Shutdown).
+// cb is called from a single goroutine. If it blocks, snapshots are skipped.
+func WithStatsInterval(interval time.Duration, cb func(TransportStats)) Option {
+	return func(c *config) error {
+		if interval <= 0 {
+			return errors.New("stats interval must be positive")
+		}
+		if cb == nil {
+			return errors.New("stats callback must not be nil")
+		}
+		c.statsInterval = interval
+		c.onStats = cb
+		return nil
+	}
+}
+
+// OnHostCertChange sets a callback that is called when a peer presents a different host certificate than on a previous connection.
+// Leaf certificates are ephemeral and rotated regularly, but the host certificate is only regenerated when the peer restarts
+// (unless it uses a pre-generated certificate, see WithCertificate).
+// A change can therefore mean that another party has access to the peer's private key.
+// The host certificates of the last 4096 peers are remembered. The callback must be fast.
+func OnHostCertChange(cb func(HostCertChange)) Option {
+	return func(c *config) error {
+		c.onHostCertChange = cb
+		return nil
+	}
+}
+
+// WithWriteFanOut makes every socket used for dialing spread the packets it sends across n sockets,
+// all bound to the same local address using SO_REUSEPORT. This can increase the egress throughput
+// when the send path of a single socket is the bottleneck.
+// Since all packets are sent from the same address, the connection still uses a single path,
+// and the peer doesn't need to support multipath.
+// Note that quic-go sends the packets of a connection from a single goroutine:
+// This mostly helps when many connections share a socket, which is the default (see DisableReuse).
+// It is only supported on Linux, on other platforms NewTransport returns ErrWriteFanOutUnsupported.
+// On Linux, the port of a socket with SO_REUSEPORT can be shared by other sockets of the same user.
+func WithWriteFanOut(n int) Option {
+	return func(c *config) error {
+		if n < 1 {
+			return errors.New("write fan-out must be at least 1")
+		}
+		if !writeFanOutSupported {
+			return ErrWriteFanOutUnsupported
+		}
+		c.writeFanOut = n
+		return nil
+	}
+}
+
+// WithTrustedCAs only allows peers whose host certificate (the last certificate of the chain) is contained in the pool,
+// or is signed by a certificate in the pool. This applies to both dials and incoming connections,
+// in addition to the peer ID check.
+// Peers need to use a host certificate signed by the CA, see WithCertificate.
+func WithTrustedCAs(pool *x509.CertPool) Option {
+	return func(c *config) error {
+		if pool == nil {
+			return errors.New("CA pool must not be nil")
+		}
+		c.trustedCAs = pool
+		return nil
+	}
+}
+
+// WithHandshakeTiming records how long handshakes take, and how much time is spent verifying certificate chains,
+// e.g. for capacity planning. The totals are reported by Stats.
+func WithHandshakeTiming() Option {
+	return func(c *config) error {
+		c.timeHandshakes = true
+		return nil
+	}
+}
+
+// WithLazyCertificate defers generating the certificate chain until the first Dial or Listen,
+// so that NewTransport doesn't access the host key. This is useful for keys in a store that is slow or unlocked later.
+// If generating the certificate chain fails, Dial and Listen return the error, and it is generated again on the next call.
+func WithLazyCertificate() Option {
+	return func(c *config) error {
+		c.lazyCertificate = true
+		return nil
+	}
+}
+
+// WithListenProbe makes Listen check that the new socket works before returning the listener,
+// by sending a packet to the socket's own address (using the loopback address if it is bound to the wildcard address),
+// and waiting up to timeout to receive it. If the packet isn't received, Listen fails.
+// This catches broken sockets (e.g. a firewall on the host dropping UDP packets) when the listener is set up,
+// instead of when the first peer tries to connect. It doesn't check that the socket is reachable from other hosts.
+func WithListenProbe(timeout time.Duration) Option {
+	return func(c *config) error {
+		if timeout <= 0 {
+			return errors.New("listen probe timeout must be positive")
+		}
+		c.listenProbeTimeout = timeout
+		return nil
+	}
+}
+
+// WithAdaptiveIdleTimeout closes connections that have no open streams after timeout without stream data being sent or received,
+// while connections with open streams (e.g. long-polling requests) are kept alive for as long as the streams are open.
+// By default, quic-go keeps all connections alive.
+// quic-go v0.11 doesn't allow changing the idle timeout of a connection, so quic-go keeps connections alive,
+// and idle connections are closed by the transport. This works with peers that don't support control streams.
+// Connections closed this way report CloseReasonIdleTimeout, the peer sees a regular close.
+// It can't be combined with WithKeepAlivePeriod.
+func WithAdaptiveIdleTimeout(timeout time.Duration) Option {
+	return func(c *config) error {
+		if timeout <= 0 {
+			return errors.New("idle timeout must be positive")
+		}
+		c.adaptiveIdleTimeout = timeout
+		return nil
+	}
+}
+
+// WithPeerVerifier adds a verifier for an alternative certificate scheme.
+// Verifiers are tried in the order they were added, before the scheme used by this transport.
+// They apply to both dialed and accepted connections of this transport.
+func WithPeerVerifier(v PeerVerifier) Option {
+	return func(c *config) error {
+		if v == nil {
+			return errors.New("peer verifier must not be nil")
+		}
+		c.peerVerifiers = append(c.peerVerifiers, v)
+		return nil
+	}
+}
+
+// WithControlStreams enables control streams: unidirectional streams carrying control messages
+// in a format private to this transport. They are used by Ping, WithKeepAlivePeriod and WithPostDialPathCheck,
+// and are shared with the unidirectional streams opened by the application (see OpenUniStream).
+// Without control streams, the peer isn't allowed to open any unidirectional streams.
+// Both peers need to enable control streams. Whether the peer enabled them is derived from its transport parameters,
+// and the features using them return ErrControlStreamsUnsupported if it didn't.
+func WithControlStreams() Option {
+	return func(c *config) error {
+		c.controlStreams = true
+		return nil
+	}
+}
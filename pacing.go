@@ -0,0 +1,92 @@
+package libp2pquic
+
+import (
+	"sync"
+	"time"
+)
+
+// Writes on paced connections are split into chunks of this size, so that they are spread out evenly.
+const pacingChunkSize = 4 << 10 // 4 KB
+
+// A pacer limits the rate at which data is written on the streams of a connection.
+type pacer struct {
+	mutex sync.Mutex
+	rate  uint64    // in bytes per second, 0 if not limited
+	next  time.Time // when the next byte may be written
+}
+
+func (p *pacer) SetRate(bytesPerSec uint64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.rate = bytesPerSec
+	p.next = time.Time{}
+}
+
+func (p *pacer) Rate() uint64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.rate
+}
+
+// Reserve reserves n bytes, and returns how long the caller has to wait before writing them.
+func (p *pacer) Reserve(n int) time.Duration {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.rate == 0 {
+		return 0
+	}
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	delay := p.next.Sub(now)
+	p.next = p.next.Add(time.Duration(uint64(n) * uint64(time.Second) / p.rate))
+	return delay
+}
+
+// PacingRate returns the maximum rate data is written on this connection's streams, in bytes per second.
+// It returns 0 if the rate is not limited.
+// quic-go v0.11 doesn't expose the pacing rate of its congestion controller,
+// so this is only the limit set using SetMaxPacingRate.
+func (c *conn) PacingRate() uint64 {
+	return c.pacer.Rate()
+}
+
+// SetMaxPacingRate limits the rate at which data is written on this connection's bidirectional streams, in bytes per second.
+// quic-go v0.11 doesn't allow configuring its pacer, so writes are delayed before they are passed to quic-go.
+// A rate of 0 removes the limit.
+func (c *conn) SetMaxPacingRate(bytesPerSec uint64) {
+	c.pacer.SetRate(bytesPerSec)
+}
+
+// pacedWrite writes b using write, spreading the chunks of b according to the pacing rate
+// and the transport-wide bandwidth limit.
+func (c *conn) pacedWrite(b []byte, write func([]byte) (int, error)) (int, error) {
+	global := c.globalPacer()
+	if c.pacer.Rate() == 0 && global == nil {
+		return write(b)
+	}
+	var written int
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > pacingChunkSize {
+			chunk = chunk[:pacingChunkSize]
+		}
+		delay := c.pacer.Reserve(len(chunk))
+		if global != nil {
+			if d := global.Reserve(len(chunk)); d > delay {
+				delay = d
+			}
+		}
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		n, err := write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,78 @@
+package libp2pquic
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// quic-go v0.11 doesn't allow configuring the maximum packet size.
+// It uses a fixed size, depending on the address family of the peer's address.
+// For addresses that are not a *net.UDPAddr, it uses the minimum packet size allowed by QUIC.
+// This is used to reduce the packet size of a dial: quic-go is passed a smallPacketAddr,
+// which the dial socket unwraps before sending a packet.
+const (
+	maxPacketSizeIPv4 = 1252
+	maxPacketSizeIPv6 = 1232
+	minPacketSize     = 1200
+)
+
+type maxPacketSizeKey struct{}
+
+// WithMaxPacketSize returns a context that limits the size of the packets sent on a dialed connection,
+// e.g. for peers behind a tunnel with a small MTU.
+// quic-go v0.11 only supports two packet sizes: the default size (1252 bytes for IPv4, 1232 bytes for IPv6),
+// and the minimum size allowed by QUIC (1200 bytes). The largest supported size that doesn't exceed size is used.
+// Dial fails if size is smaller than 1200 bytes.
+func WithMaxPacketSize(ctx context.Context, size int) context.Context {
+	return context.WithValue(ctx, maxPacketSizeKey{}, size)
+}
+
+// A smallPacketAddr is a peer's address that makes quic-go use the minimum packet size.
+type smallPacketAddr struct {
+	*net.UDPAddr
+}
+
+// dialAddrForPacketSize returns the address passed to quic-go when dialing addr,
+// taking the maximum packet size set on the context into account.
+func dialAddrForPacketSize(ctx context.Context, addr net.Addr) (net.Addr, error) {
+	size, ok := ctx.Value(maxPacketSizeKey{}).(int)
+	if !ok {
+		return addr, nil
+	}
+	if size < minPacketSize {
+		return nil, fmt.Errorf("maximum packet size too small: %d bytes (minimum: %d)", size, minPacketSize)
+	}
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok || size >= defaultMaxPacketSize(udpAddr) {
+		return addr, nil
+	}
+	return &smallPacketAddr{UDPAddr: udpAddr}, nil
+}
+
+func defaultMaxPacketSize(addr *net.UDPAddr) int {
+	if addr.IP.To4() == nil {
+		return maxPacketSizeIPv6
+	}
+	return maxPacketSizeIPv4
+}
+
+// unwrapAddr returns the UDP address of a smallPacketAddr.
+func unwrapAddr(addr net.Addr) net.Addr {
+	if a, ok := addr.(*smallPacketAddr); ok {
+		return a.UDPAddr
+	}
+	return addr
+}
+
+// MaxPacketSize returns the maximum size of the packets sent on this connection.
+func (c *conn) MaxPacketSize() int {
+	switch addr := c.sess.RemoteAddr().(type) {
+	case *smallPacketAddr:
+		return minPacketSize
+	case *net.UDPAddr:
+		return defaultMaxPacketSize(addr)
+	default:
+		return minPacketSize
+	}
+}
@@ -0,0 +1,122 @@
+package libp2pquic
+
+import (
+	"net"
+	"sync"
+)
+
+// quic-go v0.11 routes packets to sessions by their connection ID, but ignores the address they were sent from:
+// When a NAT rebinds the peer's mapping, the session keeps sending to the old address.
+// To detect such path changes, the listener's socket records the connection IDs we chose for our sessions
+// (from the source connection ID of the long header packets we send),
+// and checks the address of short header packets sent to these connection IDs.
+
+// A pathTrackingConn is the socket of a listener.
+// It detects when packets of an established connection arrive from a new remote address.
+type pathTrackingConn struct {
+	net.PacketConn
+
+	mutex      sync.Mutex
+	connIDLen  int                     // the length of our connection IDs, 0 until the first packet was sent
+	paths      map[string]*trackedPath // keyed by our connection ID
+	numPending int                     // the number of paths that don't belong to a connection yet
+}
+
+type trackedPath struct {
+	addr net.Addr
+	conn *conn // nil until the handshake completes
+}
+
+func newPathTrackingConn(c net.PacketConn) *pathTrackingConn {
+	return &pathTrackingConn{
+		PacketConn: c,
+		paths:      make(map[string]*trackedPath),
+	}
+}
+
+func (c *pathTrackingConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if connID, _, ok := parseSrcConnID(b); ok {
+		c.mutex.Lock()
+		c.connIDLen = len(connID)
+		if _, ok := c.paths[string(connID)]; !ok {
+			if c.numPending >= maxPendingConnIDs {
+				c.evictPending()
+			}
+			c.paths[string(connID)] = &trackedPath{addr: addr}
+			c.numPending++
+		}
+		c.mutex.Unlock()
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+// evictPending forgets about a random path that doesn't belong to a connection.
+func (c *pathTrackingConn) evictPending() {
+	for id, p := range c.paths {
+		if p.conn == nil {
+			delete(c.paths, id)
+			c.numPending--
+			return
+		}
+	}
+}
+
+func (c *pathTrackingConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	// only short header packets are sent after the handshake
+	if err != nil || n == 0 || b[0]&0x80 != 0 {
+		return n, addr, err
+	}
+	c.mutex.Lock()
+	if c.connIDLen == 0 || n < 1+c.connIDLen {
+		c.mutex.Unlock()
+		return n, addr, err
+	}
+	p, ok := c.paths[string(b[1:1+c.connIDLen])]
+	if !ok || p.conn == nil || p.addr.String() == addr.String() {
+		c.mutex.Unlock()
+		return n, addr, err
+	}
+	p.addr = addr
+	conn := p.conn
+	c.mutex.Unlock()
+	conn.remoteAddrChanged(addr)
+	return n, addr, err
+}
+
+// Track starts reporting path changes of a connection whose handshake completed.
+func (c *pathTrackingConn) Track(conn *conn, remoteAddr net.Addr) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, p := range c.paths {
+		if p.conn == nil && p.addr.String() == remoteAddr.String() {
+			p.conn = conn
+			c.numPending--
+		}
+	}
+}
+
+// Untrack stops tracking a connection.
+func (c *pathTrackingConn) Untrack(conn *conn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for id, p := range c.paths {
+		if p.conn == conn {
+			delete(c.paths, id)
+		}
+	}
+}
+
+// remoteAddrChanged is called when packets of the connection arrive from a new remote address.
+func (c *conn) remoteAddrChanged(addr net.Addr) {
+	c.pathChanged()
+	t, ok := c.transport.(*transport)
+	if !ok || t == nil {
+		return
+	}
+	maddr, err := toQuicMultiaddr(addr)
+	if err != nil {
+		return
+	}
+	t.events.Publish(Event{Type: EventPathChanged, Peer: c.remotePeerID, Addr: maddr})
+}
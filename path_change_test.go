@@ -0,0 +1,91 @@
+package libp2pquic
+
+import (
+	"net"
+	"sync"
+)
+
+// A rebindingProxy forwards packets between a client and a server.
+// Rebind simulates a NAT rebinding: Packets from the client are then forwarded from a new port.
+type rebindingProxy struct {
+	conn       *net.UDPConn // the socket the client sends to
+	serverAddr *net.UDPAddr
+
+	mutex      sync.Mutex
+	clientAddr net.Addr
+	upstream   *net.UDPConn   // the socket used to forward packets to the server
+	upstreams  []*net.UDPConn // all sockets used so far
+}
+
+func newRebindingProxy(serverAddr *net.UDPAddr) (*rebindingProxy, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	p := &rebindingProxy{conn: conn, serverAddr: serverAddr}
+	if _, err := p.Rebind(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go p.runDownstream()
+	return p, nil
+}
+
+func (p *rebindingProxy) LocalAddr() *net.UDPAddr { return p.conn.LocalAddr().(*net.UDPAddr) }
+
+// Rebind starts forwarding packets from the client using a new socket, and returns its address.
+func (p *rebindingProxy) Rebind() (net.Addr, error) {
+	upstream, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	p.mutex.Lock()
+	p.upstream = upstream
+	p.upstreams = append(p.upstreams, upstream)
+	p.mutex.Unlock()
+	// packets sent by the server to the old socket are still forwarded
+	go p.runUpstream(upstream)
+	return upstream.LocalAddr(), nil
+}
+
+// runDownstream forwards packets from the client to the server.
+func (p *rebindingProxy) runDownstream() {
+	b := make([]byte, 1500)
+	for {
+		n, addr, err := p.conn.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		p.mutex.Lock()
+		p.clientAddr = addr
+		upstream := p.upstream
+		p.mutex.Unlock()
+		upstream.WriteTo(b[:n], p.serverAddr)
+	}
+}
+
+// runUpstream forwards packets from the server to the client.
+func (p *rebindingProxy) runUpstream(upstream *net.UDPConn) {
+	b := make([]byte, 1500)
+	for {
+		n, _, err := upstream.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		p.mutex.Lock()
+		clientAddr := p.clientAddr
+		p.mutex.Unlock()
+		if clientAddr != nil {
+			p.conn.WriteTo(b[:n], clientAddr)
+		}
+	}
+}
+
+func (p *rebindingProxy) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.conn.Close()
+	for _, upstream := range p.upstreams {
+		upstream.Close()
+	}
+}
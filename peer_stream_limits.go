@@ -0,0 +1,41 @@
+package libp2pquic
+
+import (
+	"reflect"
+
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+// PeerMaxBidiStreams returns the maximum number of bidirectional streams the peer allows us to open concurrently,
+// as advertised in its transport parameters.
+// It returns false if the transport parameters are not available.
+func (c *conn) PeerMaxBidiStreams() (int, bool) {
+	return peerTransportParameter(c.sess, "MaxBidiStreams")
+}
+
+// PeerMaxUniStreams returns the maximum number of unidirectional streams the peer allows us to open concurrently,
+// as advertised in its transport parameters.
+// It returns false if the transport parameters are not available.
+// Note that the control streams used e.g. by Ping count towards this limit.
+func (c *conn) PeerMaxUniStreams() (int, bool) {
+	return peerTransportParameter(c.sess, "MaxUniStreams")
+}
+
+// peerTransportParameter reads a transport parameter received from the peer.
+// quic-go doesn't expose the peer's transport parameters, so we need to use reflection.
+// They are received during the handshake, and never modified afterwards.
+func peerTransportParameter(sess quic.Session, name string) (int, bool) {
+	v := reflect.ValueOf(sess)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return 0, false
+	}
+	params := v.Elem().FieldByName("peerParams")
+	if !params.IsValid() || params.Kind() != reflect.Ptr || params.IsNil() || params.Elem().Kind() != reflect.Struct {
+		return 0, false
+	}
+	f := params.Elem().FieldByName(name)
+	if !f.IsValid() || f.Kind() != reflect.Uint64 {
+		return 0, false
+	}
+	return int(f.Uint()), true
+}
@@ -0,0 +1,66 @@
+package libp2pquic
+
+import (
+	"crypto/x509"
+	"errors"
+	"sync/atomic"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+)
+
+// ErrUnsupportedChain is returned by a PeerVerifier if a certificate chain doesn't use its scheme.
+var ErrUnsupportedChain = errors.New("certificate chain doesn't use this scheme")
+
+// A PeerVerifier derives the public key of a peer from the certificate chain it presented during the handshake.
+// This allows supporting certificate formats that encode the peer's identity differently, see WithPeerVerifier.
+type PeerVerifier interface {
+	// PubKey verifies the chain, and returns the public key of the peer.
+	// It returns ErrUnsupportedChain if the chain doesn't use the verifier's scheme, so that the next verifier is tried.
+	PubKey(chain []*x509.Certificate) (ic.PubKey, error)
+}
+
+// twoCertVerifier verifies the chains generated by this transport:
+// The host certificate carries the host key (or a signed extension containing it),
+// and is used to sign the certificate used in the handshake.
+type twoCertVerifier struct{}
+
+func (twoCertVerifier) PubKey(chain []*x509.Certificate) (ic.PubKey, error) {
+	if len(chain) != 2 {
+		return nil, errors.New("expected 2 certificates in the chain")
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(chain[1])
+	if _, err := chain[0].Verify(x509.VerifyOptions{Roots: pool}); err != nil {
+		return nil, err
+	}
+
+	for _, ext := range chain[1].Extensions {
+		if ext.Id.Equal(extensionID) {
+			return pubKeyFromExtension(chain[1], ext.Value)
+		}
+	}
+	return toLibp2pPubKey(chain[1].PublicKey)
+}
+
+// getRemotePubKey verifies the chain, and returns the public key of the peer.
+// The verifiers are tried first, followed by twoCertVerifier.
+func getRemotePubKey(chain []*x509.Certificate, verifiers []PeerVerifier) (ic.PubKey, error) {
+	for _, v := range verifiers {
+		pubKey, err := v.PubKey(chain)
+		if err == ErrUnsupportedChain {
+			continue
+		}
+		return pubKey, err
+	}
+	return twoCertVerifier{}.PubKey(chain)
+}
+
+// getRemotePubKey verifies the chain using the transport's verifiers and certificate cache,
+// and returns the public key of the peer. It counts the chains using an unsupported key type.
+func (t *transport) getRemotePubKey(chain []*x509.Certificate) (ic.PubKey, error) {
+	pubKey, err := t.certCache.getRemotePubKey(chain, t.config.peerVerifiers)
+	if errors.Is(err, ErrUnsupportedRemoteKeyType) {
+		atomic.AddUint64(&t.unsupportedRemoteKeyTypes, 1)
+	}
+	return pubKey, err
+}
@@ -0,0 +1,128 @@
+package libp2pquic
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var identityExtensionID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 53594, 42}
+
+// extensionVerifier derives the peer's public key from an extension of a single self-signed certificate.
+type extensionVerifier struct{}
+
+func (extensionVerifier) PubKey(chain []*x509.Certificate) (ic.PubKey, error) {
+	if len(chain) != 1 {
+		return nil, ErrUnsupportedChain
+	}
+	for _, ext := range chain[0].Extensions {
+		if ext.Id.Equal(identityExtensionID) {
+			if err := chain[0].CheckSignatureFrom(chain[0]); err != nil {
+				return nil, err
+			}
+			return ic.UnmarshalPublicKey(ext.Value)
+		}
+	}
+	return nil, ErrUnsupportedChain
+}
+
+var _ = Describe("Peer Verifiers", func() {
+	generateExtensionCert := func(key ic.PrivKey) *x509.Certificate {
+		keyBytes, err := key.GetPublic().Bytes()
+		Expect(err).ToNot(HaveOccurred())
+		certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		tmpl := &x509.Certificate{
+			SerialNumber:    big.NewInt(1),
+			NotBefore:       time.Now().Add(-time.Hour),
+			NotAfter:        time.Now().Add(time.Hour),
+			ExtraExtensions: []pkix.Extension{{Id: identityExtensionID, Value: keyBytes}},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, certKey.Public(), certKey)
+		Expect(err).ToNot(HaveOccurred())
+		cert, err := x509.ParseCertificate(der)
+		Expect(err).ToNot(HaveOccurred())
+		return cert
+	}
+
+	It("uses the verifiers", func() {
+		key, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		chain := []*x509.Certificate{generateExtensionCert(key)}
+		_, err = getRemotePubKey(chain, nil)
+		Expect(err).To(MatchError("expected 2 certificates in the chain"))
+
+		pubKey, err := getRemotePubKey(chain, []PeerVerifier{extensionVerifier{}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pubKey.Equals(key.GetPublic())).To(BeTrue())
+	})
+
+	It("falls back to the standard scheme", func() {
+		key, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		conf, err := newConfig()
+		Expect(err).ToNot(HaveOccurred())
+		cert, err := keyToCertificate(key, conf)
+		Expect(err).ToNot(HaveOccurred())
+		chain, err := parseCertChain(cert.Certificate)
+		Expect(err).ToNot(HaveOccurred())
+		pubKey, err := getRemotePubKey(chain, []PeerVerifier{extensionVerifier{}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pubKey.Equals(key.GetPublic())).To(BeTrue())
+	})
+
+	It("only uses the verifiers of the transport they were added to", func() {
+		key, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		chain := []*x509.Certificate{generateExtensionCert(key)}
+		hostKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		tr, err := NewTransport(hostKey, WithPeerVerifier(extensionVerifier{}))
+		Expect(err).ToNot(HaveOccurred())
+		pubKey, err := tr.(*transport).getRemotePubKey(chain)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pubKey.Equals(key.GetPublic())).To(BeTrue())
+
+		otherTransport, err := NewTransport(hostKey)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = otherTransport.(*transport).getRemotePubKey(chain)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("uses the verifiers with a custom tls.Config", func() {
+		key, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		rawCerts := [][]byte{generateExtensionCert(key).Raw}
+		hostKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		cert, err := GenerateCertificate(hostKey)
+		Expect(err).ToNot(HaveOccurred())
+		tlsConf := &tls.Config{Certificates: []tls.Certificate{*cert}}
+
+		tr, err := NewTransportWithTLSConfig(hostKey, tlsConf, WithPeerVerifier(extensionVerifier{}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tr.(*transport).tlsConf.VerifyPeerCertificate(rawCerts, nil)).To(Succeed())
+
+		otherTransport, err := NewTransportWithTLSConfig(hostKey, tlsConf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(otherTransport.(*transport).tlsConf.VerifyPeerCertificate(rawCerts, nil)).ToNot(Succeed())
+	})
+
+	It("rejects nil verifiers", func() {
+		key, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = NewTransport(key, WithPeerVerifier(nil))
+		Expect(err).To(MatchError("peer verifier must not be nil"))
+	})
+})
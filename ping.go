@@ -0,0 +1,170 @@
+package libp2pquic
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+// Unidirectional streams are used for control messages between two peers running this transport,
+// and by the application (see OpenUniStream).
+// The first byte of a unidirectional stream is its type.
+const (
+	controlStreamTypePing byte = iota
+	controlStreamTypePong
+	controlStreamTypeApplication
+)
+
+const pingNonceLen = 8
+
+// ErrPathNotValidated is returned by Dial when the path to the peer couldn't be validated, see WithPostDialPathCheck.
+var ErrPathNotValidated = errors.New("path to peer not validated")
+
+type pingManager struct {
+	mutex   sync.Mutex
+	pending map[[pingNonceLen]byte]chan struct{}
+}
+
+func newPingManager() *pingManager {
+	return &pingManager{pending: make(map[[pingNonceLen]byte]chan struct{})}
+}
+
+func (m *pingManager) add(nonce [pingNonceLen]byte) <-chan struct{} {
+	c := make(chan struct{})
+	m.mutex.Lock()
+	m.pending[nonce] = c
+	m.mutex.Unlock()
+	return c
+}
+
+func (m *pingManager) remove(nonce [pingNonceLen]byte) {
+	m.mutex.Lock()
+	delete(m.pending, nonce)
+	m.mutex.Unlock()
+}
+
+func (m *pingManager) receivedPong(nonce [pingNonceLen]byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if c, ok := m.pending[nonce]; ok {
+		close(c)
+		delete(m.pending, nonce)
+	}
+}
+
+// Ping sends a ping to the peer, and measures the time until the pong is received.
+// Pings are sent on control streams, so both peers need to enable them (see WithControlStreams).
+// Ping returns ErrControlStreamsDisabled or ErrControlStreamsUnsupported otherwise.
+func (c *conn) Ping(ctx context.Context) (time.Duration, error) {
+	if err := c.checkControlStreams(); err != nil {
+		return 0, err
+	}
+	var nonce [pingNonceLen]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return 0, err
+	}
+	pong := c.pings.add(nonce)
+	defer c.pings.remove(nonce)
+
+	start := time.Now()
+	str, err := c.sess.OpenUniStream()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := str.Write(append([]byte{controlStreamTypePing}, nonce[:]...)); err != nil {
+		return 0, err
+	}
+	if err := str.Close(); err != nil {
+		return 0, err
+	}
+	select {
+	case <-pong:
+		rtt := time.Since(start)
+		atomic.StoreInt64(&c.lastPingRTT, int64(rtt))
+		return rtt, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-c.sess.Context().Done():
+		return 0, errors.New("connection closed")
+	}
+}
+
+// checkPath checks that the path to the peer works in both directions by sending a ping.
+func (c *conn) checkPath(ctx context.Context, timeout time.Duration) error {
+	pingCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if _, err := c.Ping(pingCtx); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == ErrControlStreamsUnsupported {
+			return err
+		}
+		return ErrPathNotValidated
+	}
+	return nil
+}
+
+// handleControlStreams accepts control streams opened by the peer.
+// Streams opened by the application are queued until they are accepted.
+// It returns when the session is closed.
+func (c *conn) handleControlStreams() {
+	for {
+		str, err := c.sess.AcceptUniStream()
+		if err != nil {
+			return
+		}
+		go func() {
+			t := make([]byte, 1)
+			if _, err := io.ReadFull(str, t); err != nil {
+				str.CancelRead(0)
+				return
+			}
+			if t[0] == controlStreamTypeApplication {
+				c.queueUniStream(str)
+				return
+			}
+			var nonce [pingNonceLen]byte
+			if _, err := io.ReadFull(str, nonce[:]); err != nil {
+				str.CancelRead(0)
+				return
+			}
+			switch t[0] {
+			case controlStreamTypePing:
+				finishControlStream(str)
+				c.sendPong(nonce)
+			case controlStreamTypePong:
+				finishControlStream(str)
+				c.pings.receivedPong(nonce)
+			default:
+				str.CancelRead(0)
+			}
+		}()
+	}
+}
+
+// finishControlStream reads a ping or pong stream to the end.
+// quic-go only deletes a stream (and allows the peer to open a new one) once the FIN was read,
+// and the FIN might be sent in a separate frame. Streams carrying more data than expected are canceled.
+func finishControlStream(str quic.ReceiveStream) {
+	if n, err := str.Read(make([]byte, 1)); n > 0 || err != io.EOF {
+		str.CancelRead(0)
+	}
+}
+
+func (c *conn) sendPong(nonce [pingNonceLen]byte) {
+	str, err := c.sess.OpenUniStream()
+	if err != nil {
+		return
+	}
+	if _, err := str.Write(append([]byte{controlStreamTypePong}, nonce[:]...)); err != nil {
+		return
+	}
+	str.Close()
+}
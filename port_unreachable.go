@@ -0,0 +1,28 @@
+package libp2pquic
+
+import (
+	"errors"
+	"syscall"
+)
+
+// ErrConnRefused is returned by Dial when the peer's host reported that the UDP port is closed (ICMP port unreachable).
+// This is only detected if enabled using WithPortUnreachableDetection, and only on Linux.
+var ErrConnRefused = errors.New("connection refused: port unreachable")
+
+func isConnRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// handleRefused marks all watches of addresses that refused packets.
+// It is called when reading from the socket failed with ECONNREFUSED.
+func (c *trackingConn) handleRefused() {
+	addrs := readErrQueue(c.PacketConn)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, addr := range addrs {
+		c.diagnostics.Report(SocketPortUnreachable, c.PacketConn, addr, ErrConnRefused)
+		for w := range c.watches[addr.String()] {
+			w.refusedOnce.Do(func() { close(w.refused) })
+		}
+	}
+}
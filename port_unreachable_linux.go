@@ -0,0 +1,83 @@
+package libp2pquic
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// enableRecvErr enables IP_RECVERR on a socket.
+// The kernel then reports ICMP errors for unconnected UDP sockets,
+// as an error returned by the next read, and as a message on the socket's error queue.
+func enableRecvErr(conn *net.UDPConn, network string) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		if network != "udp6" {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_RECVERR, 1)
+		}
+		// dual-stack sockets (network "udp") receive errors for both address families
+		if serr == nil && network != "udp4" {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVERR, 1)
+		}
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+// readErrQueue reads all messages from the socket's error queue,
+// and returns the addresses that refused packets.
+func readErrQueue(c net.PacketConn) []net.Addr {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var addrs []net.Addr
+	buf := make([]byte, 1500)
+	oob := make([]byte, 512)
+	rc.Read(func(fd uintptr) bool {
+		for {
+			_, oobn, _, from, err := syscall.Recvmsg(int(fd), buf, oob, syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+			if err != nil {
+				// the error queue is empty
+				return true
+			}
+			if !isConnRefusedMsg(oob[:oobn]) {
+				continue
+			}
+			// For messages on the error queue, the address is the destination of the packet that caused the error.
+			switch sa := from.(type) {
+			case *syscall.SockaddrInet4:
+				addrs = append(addrs, &net.UDPAddr{IP: net.IP(sa.Addr[:]), Port: sa.Port})
+			case *syscall.SockaddrInet6:
+				addrs = append(addrs, &net.UDPAddr{IP: net.IP(sa.Addr[:]), Port: sa.Port})
+			}
+		}
+	})
+	return addrs
+}
+
+// isConnRefusedMsg says if the control messages of an error queue message report ECONNREFUSED.
+func isConnRefusedMsg(oob []byte) bool {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return false
+	}
+	for _, msg := range msgs {
+		isRecvErr := (msg.Header.Level == syscall.IPPROTO_IP && msg.Header.Type == syscall.IP_RECVERR) ||
+			(msg.Header.Level == syscall.IPPROTO_IPV6 && msg.Header.Type == syscall.IPV6_RECVERR)
+		// The message data is a struct sock_extended_err, starting with the errno (in host byte order).
+		if isRecvErr && len(msg.Data) >= 4 && syscall.Errno(*(*uint32)(unsafe.Pointer(&msg.Data[0]))) == syscall.ECONNREFUSED {
+			return true
+		}
+	}
+	return false
+}
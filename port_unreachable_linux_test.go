@@ -0,0 +1,103 @@
+package libp2pquic
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Port unreachable detection", func() {
+	var (
+		t        *transport
+		serverID peer.ID
+		addr     ma.Multiaddr
+	)
+
+	BeforeEach(func() {
+		key, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		tr, err := NewTransport(key, WithPortUnreachableDetection())
+		Expect(err).ToNot(HaveOccurred())
+		t = tr.(*transport)
+		serverKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		serverID, err = peer.IDFromPrivateKey(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+
+		// find a closed port
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		addr, err = toQuicMultiaddr(conn.LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conn.Close()).To(Succeed())
+	})
+
+	It("fails fast when dialing a closed port", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		start := time.Now()
+		_, err := t.Dial(ctx, addr, serverID)
+		Expect(err).To(MatchError(ErrConnRefused))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+
+	It("moves on to the next address when a dial is refused", func() {
+		serverKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		serverID, err := peer.IDFromPrivateKey(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		go func() {
+			defer GinkgoRecover()
+			sconn, err := ln.Accept()
+			Expect(err).ToNot(HaveOccurred())
+			sconn.Close()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := t.DialBest(ctx, []ma.Multiaddr{addr, ln.Multiaddr()}, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Expect(conn.RemoteMultiaddr()).To(Equal(ln.Multiaddr()))
+	})
+
+	It("doesn't affect other connections using the same socket", func() {
+		serverKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		serverID, err := peer.IDFromPrivateKey(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		conn, err := t.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		_, err = t.Dial(context.Background(), addr, serverID)
+		Expect(err).To(MatchError(ErrConnRefused))
+
+		str, err := conn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		sconn, err := ln.Accept()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = sconn.AcceptStream()
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
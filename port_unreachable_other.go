@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package libp2pquic
+
+import "net"
+
+// Detecting ICMP port unreachable messages is only supported on Linux.
+
+func enableRecvErr(*net.UDPConn, string) error { return nil }
+
+func readErrQueue(net.PacketConn) []net.Addr { return nil }
@@ -0,0 +1,99 @@
+// Package prometheus provides a libp2pquic.MetricsTracer exporting Prometheus metrics.
+//
+//	tr, err := libp2pquic.NewTransport(key, libp2pquic.WithMetrics(prometheus.NewTracer(registry)))
+package prometheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	libp2pquic "github.com/libp2p/go-libp2p-quic-transport"
+)
+
+const namespace = "libp2p_quic"
+
+// A Tracer is a libp2pquic.MetricsTracer exporting Prometheus metrics.
+type Tracer struct {
+	dials             *prometheus.CounterVec
+	handshakes        *prometheus.CounterVec
+	activeConnections prometheus.Gauge
+	bytes             *prometheus.CounterVec
+	packetsLost       prometheus.Counter
+}
+
+var _ libp2pquic.MetricsTracer = &Tracer{}
+
+// NewTracer creates a new Tracer and registers its collectors with reg.
+// If the collectors were already registered, e.g. because multiple transports use the same registry,
+// the existing collectors are used.
+func NewTracer(reg prometheus.Registerer) *Tracer {
+	t := &Tracer{
+		dials: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "dials_total",
+			Help:      "Number of dials, by result",
+		}, []string{"result"}),
+		handshakes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "handshakes_total",
+			Help:      "Number of completed handshakes, by direction",
+		}, []string{"direction"}),
+		activeConnections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "connections_active",
+			Help:      "Number of open connections",
+		}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "bytes_total",
+			Help:      "Number of bytes sent and received on streams, by direction",
+		}, []string{"direction"}),
+		packetsLost: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "packets_lost_total",
+			Help:      "Number of packets declared lost",
+		}),
+	}
+	t.dials = register(reg, t.dials).(*prometheus.CounterVec)
+	t.handshakes = register(reg, t.handshakes).(*prometheus.CounterVec)
+	t.activeConnections = register(reg, t.activeConnections).(prometheus.Gauge)
+	t.bytes = register(reg, t.bytes).(*prometheus.CounterVec)
+	t.packetsLost = register(reg, t.packetsLost).(prometheus.Counter)
+	return t
+}
+
+// register registers c with reg, and returns the collector that is registered.
+func register(reg prometheus.Registerer, c prometheus.Collector) prometheus.Collector {
+	if err := reg.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+		panic(err)
+	}
+	return c
+}
+
+func (t *Tracer) DialStarted() {}
+
+func (t *Tracer) DialFinished(err error) {
+	if err != nil {
+		t.dials.WithLabelValues("failure").Inc()
+		return
+	}
+	t.dials.WithLabelValues("success").Inc()
+}
+
+func (t *Tracer) HandshakeCompleted(incoming bool) {
+	if incoming {
+		t.handshakes.WithLabelValues("incoming").Inc()
+		return
+	}
+	t.handshakes.WithLabelValues("outgoing").Inc()
+}
+
+func (t *Tracer) ConnOpened() { t.activeConnections.Inc() }
+func (t *Tracer) ConnClosed() { t.activeConnections.Dec() }
+
+func (t *Tracer) BytesSent(n int)     { t.bytes.WithLabelValues("sent").Add(float64(n)) }
+func (t *Tracer) BytesReceived(n int) { t.bytes.WithLabelValues("received").Add(float64(n)) }
+
+func (t *Tracer) PacketLost() { t.packetsLost.Inc() }
@@ -0,0 +1,70 @@
+package prometheus
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	libp2pquic "github.com/libp2p/go-libp2p-quic-transport"
+	"github.com/libp2p/go-libp2p-quic-transport/quictest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	ma "github.com/multiformats/go-multiaddr"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tracer", func() {
+	createPeer := func() (peer.ID, ic.PrivKey) {
+		priv, _, err := ic.GenerateECDSAKeyPair(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		id, err := peer.IDFromPrivateKey(priv)
+		Expect(err).ToNot(HaveOccurred())
+		return id, priv
+	}
+
+	It("exports metrics for a loopback dial", func() {
+		reg := prometheus.NewRegistry()
+		serverID, serverKey := createPeer()
+		_, clientKey := createPeer()
+		serverTransport, err := libp2pquic.NewTransport(serverKey, libp2pquic.WithMetrics(NewTracer(reg)))
+		Expect(err).ToNot(HaveOccurred())
+		server, err := quictest.NewEchoServer(serverTransport, ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer server.Close()
+
+		// both transports share the registry, so the client reuses the collectors registered by the server
+		tracer := NewTracer(reg)
+		clientTransport, err := libp2pquic.NewTransport(clientKey, libp2pquic.WithMetrics(tracer))
+		Expect(err).ToNot(HaveOccurred())
+		data := []byte("foobar")
+		_, err = quictest.Echo(context.Background(), clientTransport, server.Multiaddr(), serverID, bytes.NewReader(data), &bytes.Buffer{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(testutil.ToFloat64(tracer.dials.WithLabelValues("success"))).To(BeEquivalentTo(1))
+		Expect(testutil.ToFloat64(tracer.handshakes.WithLabelValues("outgoing"))).To(BeEquivalentTo(1))
+		Eventually(func() float64 { return testutil.ToFloat64(tracer.handshakes.WithLabelValues("incoming")) }).Should(BeEquivalentTo(1))
+		// the client sent the data, and the server echoed it
+		Eventually(func() float64 { return testutil.ToFloat64(tracer.bytes.WithLabelValues("sent")) }).Should(BeEquivalentTo(2 * len(data)))
+		Eventually(func() float64 { return testutil.ToFloat64(tracer.bytes.WithLabelValues("received")) }).Should(BeEquivalentTo(2 * len(data)))
+
+		mfs, err := reg.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		var names []string
+		for _, mf := range mfs {
+			names = append(names, mf.GetName())
+		}
+		Expect(names).To(ContainElement("libp2p_quic_dials_total"))
+		Expect(names).To(ContainElement("libp2p_quic_connections_active"))
+	})
+
+	It("reports failed dials", func() {
+		tracer := NewTracer(prometheus.NewRegistry())
+		tracer.DialFinished(context.Canceled)
+		Expect(testutil.ToFloat64(tracer.dials.WithLabelValues("failure"))).To(BeEquivalentTo(1))
+		Expect(testutil.ToFloat64(tracer.dials.WithLabelValues("success"))).To(BeZero())
+	})
+})
@@ -0,0 +1,171 @@
+package libp2pquic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// The maximum number of qlog files kept in the qlog directory.
+	defaultQlogMaxFiles = 100
+	// The maximum size of a qlog file. Once it is reached, the file is rotated.
+	defaultQlogMaxFileSize = 10 * (1 << 20) // 10 MB
+)
+
+const qlogFileSuffix = ".qlog"
+
+// A qlogger writes a qlog file for every connection.
+// quic-go v0.11 doesn't provide a tracer, so the qlog files only contain the events
+// that are visible to this transport (connection establishment and closing),
+// not the packet-level events.
+type qlogger struct {
+	dir         string
+	maxFiles    int
+	maxFileSize int64
+
+	mutex sync.Mutex // serializes the cleanup of the qlog directory
+}
+
+func newQlogger(dir string) *qlogger {
+	return &qlogger{
+		dir:         dir,
+		maxFiles:    defaultQlogMaxFiles,
+		maxFileSize: defaultQlogMaxFileSize,
+	}
+}
+
+// NewConnLog creates the qlog file for a new connection,
+// and removes the oldest files if the maximum number of files is exceeded.
+func (q *qlogger) NewConnLog(c *conn) (*connLog, error) {
+	name := fmt.Sprintf("%s_%s%s", c.remotePeerID.Pretty(), time.Now().UTC().Format("20060102T150405.000000000"), qlogFileSuffix)
+	l := &connLog{
+		path:    filepath.Join(q.dir, name),
+		maxSize: q.maxFileSize,
+		start:   time.Now(),
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	q.cleanup()
+	return l, nil
+}
+
+// cleanup removes the oldest qlog files, such that at most maxFiles files are left.
+func (q *qlogger) cleanup() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	infos, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return
+	}
+	var files []os.FileInfo
+	for _, info := range infos {
+		// also count rotated files
+		if !info.IsDir() && strings.Contains(info.Name(), qlogFileSuffix) {
+			files = append(files, info)
+		}
+	}
+	if len(files) <= q.maxFiles {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].ModTime().Equal(files[j].ModTime()) {
+			return files[i].ModTime().Before(files[j].ModTime())
+		}
+		return files[i].Name() < files[j].Name()
+	})
+	for _, info := range files[:len(files)-q.maxFiles] {
+		os.Remove(filepath.Join(q.dir, info.Name()))
+	}
+}
+
+// A connLog is the qlog file of a single connection.
+// The events are written as newline-delimited JSON.
+type connLog struct {
+	path    string
+	maxSize int64
+	start   time.Time
+
+	mutex   sync.Mutex
+	file    *os.File
+	written int64
+}
+
+type qlogEvent struct {
+	Time float64                `json:"time"` // in ms, relative to the start of the connection
+	Name string                 `json:"name"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+func (l *connLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	l.file = f
+	l.written = 0
+	return l.write(map[string]interface{}{
+		"qlog_format":    "NDJSON",
+		"qlog_version":   "draft-02",
+		"title":          "libp2p QUIC transport",
+		"reference_time": float64(l.start.UnixNano()) / 1e6,
+	})
+}
+
+func (l *connLog) write(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	n, err := l.file.Write(append(b, '\n'))
+	l.written += int64(n)
+	return err
+}
+
+// Event writes an event to the qlog file.
+// If the file has grown larger than the maximum file size, it is rotated first:
+// The current file is renamed (replacing any previously rotated file), and a new file is started.
+func (l *connLog) Event(name string, data map[string]interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return
+	}
+	if l.written >= l.maxSize {
+		l.file.Close()
+		l.file = nil
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return
+		}
+		if err := l.open(); err != nil {
+			return
+		}
+	}
+	l.write(qlogEvent{
+		Time: float64(time.Since(l.start).Nanoseconds()) / 1e6,
+		Name: name,
+		Data: data,
+	})
+}
+
+// Close closes the qlog file.
+func (l *connLog) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,56 @@
+package libp2pquic
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("qlog", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "qlog")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("rotates files that grow too large", func() {
+		l := &connLog{path: filepath.Join(dir, "test.qlog"), maxSize: 1000}
+		Expect(l.open()).To(Succeed())
+		for i := 0; i < 100; i++ {
+			l.Event("test", map[string]interface{}{"data": strings.Repeat("a", 50)})
+		}
+		Expect(l.Close()).To(Succeed())
+		files, err := ioutil.ReadDir(dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(HaveLen(2))
+		for _, f := range files {
+			Expect(f.Size()).To(BeNumerically("<", 1200))
+		}
+	})
+
+	It("removes the oldest files", func() {
+		q := newQlogger(dir)
+		q.maxFiles = 3
+		for _, name := range []string{"a.qlog", "b.qlog", "c.qlog", "d.qlog", "e.qlog.1", "other"} {
+			Expect(ioutil.WriteFile(filepath.Join(dir, name), []byte("foobar"), 0644)).To(Succeed())
+		}
+		q.cleanup()
+		files, err := ioutil.ReadDir(dir)
+		Expect(err).ToNot(HaveOccurred())
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		Expect(names).To(ConsistOf("c.qlog", "d.qlog", "e.qlog.1", "other"))
+	})
+})
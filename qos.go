@@ -0,0 +1,56 @@
+package libp2pquic
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// A QoSProfile describes how the packets of a class of connections are marked.
+// quic-go v0.11 doesn't allow configuring congestion control or pacing per connection,
+// so a profile currently only sets the DSCP.
+type QoSProfile struct {
+	// DSCP is the Differentiated Services Code Point set on all packets sent (0-63).
+	DSCP uint8
+}
+
+type connClassKey struct{}
+
+// WithConnClass returns a context that makes Dial use the QoS profile registered for the class name (see WithQoSProfile).
+// Connections of different classes use different sockets, since the DSCP is set per socket.
+func WithConnClass(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, connClassKey{}, name)
+}
+
+// dscpForDial returns the DSCP for a dial, as selected by the connection class set on the context.
+// Dials without a connection class use the default DSCP 0.
+func (t *transport) dscpForDial(ctx context.Context) (uint8, error) {
+	class, ok := ctx.Value(connClassKey{}).(string)
+	if !ok {
+		return 0, nil
+	}
+	profile, ok := t.config.qosProfiles[class]
+	if !ok {
+		return 0, fmt.Errorf("unknown connection class: %s", class)
+	}
+	return profile.DSCP, nil
+}
+
+// setDSCP sets the DSCP of all packets sent on a socket.
+// Dual-stack sockets (network "udp") send both IPv4 and IPv6 packets, so both fields are set.
+func setDSCP(conn *net.UDPConn, network string, dscp uint8) error {
+	// The DSCP occupies the upper 6 bits of the TOS / traffic class field.
+	if network == "udp4" {
+		return ipv4.NewConn(conn).SetTOS(int(dscp) << 2)
+	}
+	if err := ipv6.NewConn(conn).SetTrafficClass(int(dscp) << 2); err != nil {
+		return err
+	}
+	if network == "udp" {
+		return ipv4.NewConn(conn).SetTOS(int(dscp) << 2)
+	}
+	return nil
+}
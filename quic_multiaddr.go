@@ -17,14 +17,37 @@ func init() {
 	}
 }
 
+// toQuicMultiaddr converts a net.Addr to a QUIC multiaddr.
+// IPv4-mapped IPv6 addresses (e.g. ::ffff:1.2.3.4) are converted to /ip4 multiaddrs.
 func toQuicMultiaddr(na net.Addr) (ma.Multiaddr, error) {
-	udpMA, err := manet.FromNetAddr(na)
+	udpMA, err := manet.FromNetAddr(normalizeAddr(na))
 	if err != nil {
 		return nil, err
 	}
 	return udpMA.Encapsulate(quicMA), nil
 }
 
+// localMultiaddr converts the local address of a socket to a multiaddr, see WithLocalAddrMapper.
+func (t *transport) localMultiaddr(na net.Addr) (ma.Multiaddr, error) {
+	if t.config.localAddrMapper != nil {
+		return t.config.localAddrMapper(na)
+	}
+	return toQuicMultiaddr(na)
+}
+
 func fromQuicMultiaddr(addr ma.Multiaddr) (net.Addr, error) {
 	return manet.ToNetAddr(addr.Decapsulate(quicMA))
 }
+
+// normalizeAddr converts IPv4-mapped IPv6 addresses, as returned by dual-stack sockets, to IPv4 addresses.
+func normalizeAddr(na net.Addr) net.Addr {
+	udpAddr, ok := na.(*net.UDPAddr)
+	if !ok {
+		return na
+	}
+	ip4 := udpAddr.IP.To4()
+	if ip4 == nil || len(udpAddr.IP) == net.IPv4len {
+		return na
+	}
+	return &net.UDPAddr{IP: ip4, Port: udpAddr.Port}
+}
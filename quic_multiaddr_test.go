@@ -27,4 +27,28 @@ var _ = Describe("QUIC Multiaddr", func() {
 		Expect(udpAddr.IP).To(Equal(net.IPv4(192, 168, 0, 42)))
 		Expect(udpAddr.Port).To(Equal(1337))
 	})
+	It("converts an IPv4-mapped IPv6 net.Addr to an IPv4 QUIC Multiaddr", func() {
+		addr := &net.UDPAddr{IP: net.ParseIP("::ffff:1.2.3.4"), Port: 1337}
+		maddr, err := toQuicMultiaddr(addr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(maddr.String()).To(Equal("/ip4/1.2.3.4/udp/1337/quic"))
+	})
+
+	It("normalizes IPv4-mapped IPv6 QUIC Multiaddrs when converting back and forth", func() {
+		maddr, err := ma.NewMultiaddr("/ip6/::ffff:1.2.3.4/udp/1337/quic")
+		Expect(err).ToNot(HaveOccurred())
+		addr, err := fromQuicMultiaddr(maddr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(addr.(*net.UDPAddr).IP.Equal(net.IPv4(1, 2, 3, 4))).To(BeTrue())
+		maddr, err = toQuicMultiaddr(addr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(maddr.String()).To(Equal("/ip4/1.2.3.4/udp/1337/quic"))
+	})
+
+	It("doesn't modify IPv6 addresses", func() {
+		addr := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1337}
+		maddr, err := toQuicMultiaddr(addr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(maddr.String()).To(Equal("/ip6/2001:db8::1/udp/1337/quic"))
+	})
 })
@@ -0,0 +1,117 @@
+// Package quictest provides helpers for testing and benchmarking QUIC transports.
+package quictest
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/libp2p/go-libp2p-core/peer"
+	tpt "github.com/libp2p/go-libp2p-core/transport"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// An EchoServer accepts connections and streams, and echoes all bytes received on a stream.
+type EchoServer struct {
+	ln tpt.Listener
+
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewEchoServer starts an EchoServer listening on addr.
+func NewEchoServer(tr tpt.Transport, addr ma.Multiaddr) (*EchoServer, error) {
+	ln, err := tr.Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &EchoServer{ln: ln}
+	s.wg.Add(1)
+	go s.acceptLoop()
+	return s, nil
+}
+
+func (s *EchoServer) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go s.handleConn(conn)
+	}
+}
+
+func (s *EchoServer) handleConn(conn tpt.CapableConn) {
+	defer s.wg.Done()
+	defer conn.Close()
+	for {
+		str, err := conn.AcceptStream()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			echo(str)
+		}()
+	}
+}
+
+func echo(str mux.MuxedStream) {
+	if _, err := io.Copy(str, str); err != nil {
+		str.Reset()
+		return
+	}
+	str.Close()
+}
+
+// Multiaddr returns the address the EchoServer is listening on.
+func (s *EchoServer) Multiaddr() ma.Multiaddr {
+	return s.ln.Multiaddr()
+}
+
+// Close stops the EchoServer, and closes all its connections.
+func (s *EchoServer) Close() error {
+	s.closeOnce.Do(func() {
+		s.closeErr = s.ln.Close()
+		s.wg.Wait()
+	})
+	return s.closeErr
+}
+
+// Echo dials the EchoServer at addr, sends all data read from r on a new stream,
+// and returns the number of bytes echoed back. The echoed bytes are written to w, if w is not nil.
+func Echo(ctx context.Context, tr tpt.Transport, addr ma.Multiaddr, p peer.ID, r io.Reader, w io.Writer) (int64, error) {
+	conn, err := tr.Dial(ctx, addr, p)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	str, err := conn.OpenStream()
+	if err != nil {
+		return 0, err
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+	sendErr := make(chan error, 1)
+	go func() {
+		if _, err := io.Copy(str, r); err != nil {
+			str.Reset()
+			sendErr <- err
+			return
+		}
+		sendErr <- str.Close()
+	}()
+	n, err := io.Copy(w, str)
+	if serr := <-sendErr; serr != nil {
+		return n, serr
+	}
+	return n, err
+}
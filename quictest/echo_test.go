@@ -0,0 +1,63 @@
+package quictest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	libp2pquic "github.com/libp2p/go-libp2p-quic-transport"
+
+	ma "github.com/multiformats/go-multiaddr"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Echo Server", func() {
+	createPeer := func() (peer.ID, ic.PrivKey) {
+		priv, _, err := ic.GenerateECDSAKeyPair(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		id, err := peer.IDFromPrivateKey(priv)
+		Expect(err).ToNot(HaveOccurred())
+		return id, priv
+	}
+
+	It("echoes data", func() {
+		serverID, serverKey := createPeer()
+		_, clientKey := createPeer()
+		serverTransport, err := libp2pquic.NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		server, err := NewEchoServer(serverTransport, ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer server.Close()
+
+		clientTransport, err := libp2pquic.NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		data := make([]byte, 1<<20)
+		rand.Read(data)
+		var buf bytes.Buffer
+		n, err := Echo(context.Background(), clientTransport, server.Multiaddr(), serverID, bytes.NewReader(data), &buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(BeEquivalentTo(len(data)))
+		Expect(buf.Bytes()).To(Equal(data))
+	})
+
+	It("stops when closed", func() {
+		serverID, serverKey := createPeer()
+		_, clientKey := createPeer()
+		serverTransport, err := libp2pquic.NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		server, err := NewEchoServer(serverTransport, ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server.Close()).To(Succeed())
+
+		clientTransport, err := libp2pquic.NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		_, err = Echo(ctx, clientTransport, server.Multiaddr(), serverID, bytes.NewReader([]byte("foobar")), nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,13 @@
+package quictest
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestQuictest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "quictest Suite")
+}
@@ -0,0 +1,18 @@
+package libp2pquic
+
+import "net"
+
+// applyRawSocketOptions calls fn with the file descriptor of the socket, see WithRawSocketOptions.
+func applyRawSocketOptions(conn *net.UDPConn, network string, fn func(network string, fd uintptr) error) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = fn(network, fd)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
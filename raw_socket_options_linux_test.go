@@ -0,0 +1,48 @@
+package libp2pquic
+
+import (
+	"errors"
+	"net"
+	"syscall"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Raw socket options", func() {
+	It("sets socket options on dial sockets", func() {
+		var networks []string
+		cm := &connManager{rawSocketOptions: func(network string, fd uintptr) error {
+			networks = append(networks, network)
+			return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, 42)
+		}}
+		conn, release, err := cm.GetConnForAddr("udp4", 0, noShard, "")
+		Expect(err).ToNot(HaveOccurred())
+		defer release()
+		Expect(networks).To(Equal([]string{"udp4"}))
+
+		rc, err := conn.PacketConn.(*net.UDPConn).SyscallConn()
+		Expect(err).ToNot(HaveOccurred())
+		var ttl int
+		var serr error
+		Expect(rc.Control(func(fd uintptr) {
+			ttl, serr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL)
+		})).To(Succeed())
+		Expect(serr).ToNot(HaveOccurred())
+		Expect(ttl).To(Equal(42))
+	})
+
+	It("fails when the callback returns an error", func() {
+		cm := &connManager{rawSocketOptions: func(string, uintptr) error {
+			return errors.New("test error")
+		}}
+		_, _, err := cm.GetConnForAddr("udp4", 0, noShard, "")
+		Expect(err).To(MatchError("test error"))
+		Expect(cm.reuseConns).To(BeEmpty())
+	})
+
+	It("refuses to set raw socket options with a shared ConnManager", func() {
+		_, err := newConfig(WithConnManager(NewConnManager()), WithRawSocketOptions(func(string, uintptr) error { return nil }))
+		Expect(err).To(HaveOccurred())
+	})
+})
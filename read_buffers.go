@@ -0,0 +1,34 @@
+package libp2pquic
+
+import "sync/atomic"
+
+// A readBufferCounter counts the buffers on the read path that hold a packet that quic-go hasn't read yet.
+// quic-go v0.11 reads every packet into a buffer from its internal pool, and returns it to the pool once the packet was processed.
+// This pool isn't observable, so only the buffers owned by this package are counted.
+// These are the read buffers of the sockets used for a write fan-out (see WithWriteFanOut),
+// which hold a packet until quic-go reads it. Without fan-out, packets are read directly into quic-go's buffers.
+type readBufferCounter struct {
+	inFlight, highWaterMark int64 // must be accessed atomically
+}
+
+// Acquire records that a buffer now holds a packet.
+func (c *readBufferCounter) Acquire() {
+	n := atomic.AddInt64(&c.inFlight, 1)
+	for {
+		max := atomic.LoadInt64(&c.highWaterMark)
+		if n <= max || atomic.CompareAndSwapInt64(&c.highWaterMark, max, n) {
+			return
+		}
+	}
+}
+
+// Release records that quic-go read the packet held by a buffer.
+func (c *readBufferCounter) Release() {
+	atomic.AddInt64(&c.inFlight, -1)
+}
+
+// addStats adds the buffer utilization to the stats.
+func (c *readBufferCounter) addStats(stats *TransportStats) {
+	stats.ReadBuffersInFlight = int(atomic.LoadInt64(&c.inFlight))
+	stats.ReadBuffersHighWaterMark = int(atomic.LoadInt64(&c.highWaterMark))
+}
@@ -0,0 +1,51 @@
+package libp2pquic
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// The backoff after a transient read error on a listener's socket.
+// It is doubled for every consecutive error, up to maxReadRetryBackoff.
+var (
+	initialReadRetryBackoff = time.Millisecond
+	maxReadRetryBackoff     = 100 * time.Millisecond
+)
+
+// A readRetryConn is the socket of a listener.
+// quic-go closes the listener on any error returned by ReadFrom.
+// readRetryConn therefore retries reading after transient errors (those that are Temporary),
+// so only fatal errors close the listener, and are then returned by Accept.
+// Transient errors are counted (see ListenerStats) and reported to the socket diagnostics.
+type readRetryConn struct {
+	net.PacketConn
+	// nil if errors are not reported, see SocketDiagnostics
+	diagnostics *socketDiagnostics
+
+	transientErrors uint64 // must be accessed atomically
+}
+
+// TransientErrors returns the number of transient errors that reading was retried after.
+func (c *readRetryConn) TransientErrors() uint64 {
+	return atomic.LoadUint64(&c.transientErrors)
+}
+
+func (c *readRetryConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	backoff := initialReadRetryBackoff
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err == nil {
+			return n, addr, nil
+		}
+		c.diagnostics.Report(SocketReadError, c.PacketConn, nil, err)
+		if nerr, ok := err.(net.Error); !ok || !nerr.Temporary() {
+			return n, addr, err
+		}
+		atomic.AddUint64(&c.transientErrors, 1)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxReadRetryBackoff {
+			backoff = maxReadRetryBackoff
+		}
+	}
+}
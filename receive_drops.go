@@ -0,0 +1,50 @@
+package libp2pquic
+
+import (
+	"errors"
+	"net"
+)
+
+var errReceiveDropsUnsupported = errors.New("receive drop tracking not supported on this platform")
+
+// ListenerStats are statistics about a listener's socket.
+type ListenerStats struct {
+	// ReceiveDrops is the number of packets the kernel dropped because the socket's receive buffer was full.
+	// It is only tracked if WithReceiveDropTracking is used, and only on Linux.
+	ReceiveDrops uint64
+	// TransientReadErrors is the number of transient errors reading from the socket.
+	// The listener keeps reading after these errors.
+	TransientReadErrors uint64
+}
+
+// A receiveDropCounter reads the number of packets dropped by the kernel for a socket.
+type receiveDropCounter struct {
+	inode uint64
+}
+
+// newReceiveDropCounter returns a counter for the socket used by c.
+func newReceiveDropCounter(c net.PacketConn) (*receiveDropCounter, error) {
+	inode, err := socketInode(c)
+	if err != nil {
+		return nil, err
+	}
+	return &receiveDropCounter{inode: inode}, nil
+}
+
+// Drops returns the number of packets dropped since the socket was created.
+// It returns 0 if the counter is nil.
+func (c *receiveDropCounter) Drops() uint64 {
+	if c == nil {
+		return 0
+	}
+	drops, _ := readReceiveDrops(c.inode)
+	return drops
+}
+
+// Stats returns statistics about the listener's socket.
+func (l *listener) Stats() ListenerStats {
+	return ListenerStats{
+		ReceiveDrops:        l.receiveDrops.Drops(),
+		TransientReadErrors: l.readRetryConn.TransientErrors(),
+	}
+}
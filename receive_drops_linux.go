@@ -0,0 +1,71 @@
+package libp2pquic
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// socketInode returns the inode number of the socket used by c.
+// It identifies the socket in /proc/net/udp.
+func socketInode(c net.PacketConn) (uint64, error) {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return 0, errReceiveDropsUnsupported
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var stat syscall.Stat_t
+	var serr error
+	if err := rc.Control(func(fd uintptr) { serr = syscall.Fstat(int(fd), &stat) }); err != nil {
+		return 0, err
+	}
+	if serr != nil {
+		return 0, serr
+	}
+	return stat.Ino, nil
+}
+
+// readReceiveDrops reads the drop counter of the socket with the given inode from /proc/net/udp and /proc/net/udp6.
+func readReceiveDrops(inode uint64) (uint64, error) {
+	for _, file := range []string{"/proc/net/udp", "/proc/net/udp6"} {
+		drops, found, err := readReceiveDropsFrom(file, inode)
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			return drops, nil
+		}
+	}
+	return 0, fmt.Errorf("socket %d not found", inode)
+}
+
+func readReceiveDropsFrom(file string, inode uint64) (drops uint64, found bool, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	defer f.Close()
+	want := strconv.FormatUint(inode, 10)
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // skip the header
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ref pointer drops
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 13 || fields[9] != want {
+			continue
+		}
+		drops, err := strconv.ParseUint(fields[12], 10, 64)
+		return drops, true, err
+	}
+	return 0, false, scanner.Err()
+}
@@ -0,0 +1,32 @@
+package libp2pquic
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Receive drops", func() {
+	It("counts packets dropped because the receive buffer is full", func() {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		// the kernel enforces a minimum buffer size
+		Expect(conn.SetReadBuffer(1)).To(Succeed())
+		counter, err := newReceiveDropCounter(conn)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(counter.Drops()).To(BeZero())
+
+		sender, err := net.DialUDP("udp4", nil, conn.LocalAddr().(*net.UDPAddr))
+		Expect(err).ToNot(HaveOccurred())
+		defer sender.Close()
+		// don't read from conn, so the receive buffer overflows
+		for i := 0; i < 100; i++ {
+			_, err := sender.Write(make([]byte, 1000))
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Eventually(counter.Drops).Should(BeNumerically(">", 0))
+	})
+
+})
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package libp2pquic
+
+import "net"
+
+// Tracking receive drops is only supported on Linux.
+
+func socketInode(net.PacketConn) (uint64, error) { return 0, errReceiveDropsUnsupported }
+
+func readReceiveDrops(uint64) (uint64, error) { return 0, errReceiveDropsUnsupported }
@@ -0,0 +1,19 @@
+package libp2pquic
+
+import quic "github.com/lucas-clemente/quic-go"
+
+// The stream-level flow control window quic-go v0.11 starts with.
+// quic-go doesn't allow configuring it, so auto-tuning can't start below this value.
+const initialReceiveWindow = 512 << 10 // 512 KB
+
+// The connection-level flow control window is larger than the stream-level window,
+// so that a single stream can't use up the whole connection window.
+const connectionWindowMultiplier = 1.5
+
+// withReceiveWindowBounds returns a copy of the QUIC config with the maximum receive windows set according to max.
+func withReceiveWindowBounds(conf *quic.Config, max uint64) *quic.Config {
+	c := *conf
+	c.MaxReceiveStreamFlowControlWindow = max
+	c.MaxReceiveConnectionFlowControlWindow = uint64(float64(max) * connectionWindowMultiplier)
+	return &c
+}
@@ -0,0 +1,142 @@
+package libp2pquic
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+)
+
+// A RejectReason says why a handshake was rejected.
+type RejectReason int
+
+const (
+	// RejectReasonInvalidCertificate means that the peer's certificate chain isn't a valid libp2p certificate chain.
+	RejectReasonInvalidCertificate RejectReason = iota + 1
+	// RejectReasonPeerIDMismatch means that the peer's certificate belongs to a different peer than the one dialed.
+	RejectReasonPeerIDMismatch
+	// RejectReasonKeyTooLarge means that the peer's certificate chain uses an RSA key that is too large (see WithMaxRSAKeySize).
+	RejectReasonKeyTooLarge
+	// RejectReasonServerName means that the client used an unexpected server name (see WithSNIValidation).
+	RejectReasonServerName
+	// RejectReasonRateLimited means that too many handshakes were in progress (see WithMaxIncomingHandshakes).
+	RejectReasonRateLimited
+	// RejectReasonDenied means that a custom verification (e.g. ListenConfig.VerifyPeerCertificate) denied the peer.
+	RejectReasonDenied
+	// RejectReasonPinMismatch means that the peer's certificate chain didn't match any of the pins set on the dial (see WithCertificatePins).
+	RejectReasonPinMismatch
+	// RejectReasonChainTooLarge means that the peer's certificate chain has too many certificates or bytes (see WithMaxCertChainSize).
+	RejectReasonChainTooLarge
+	// RejectReasonNotLibp2p means that the client doesn't look like a libp2p peer (see WithNonLibp2pRejection).
+	RejectReasonNotLibp2p
+	// RejectReasonUntrustedCA means that the peer's host certificate isn't signed by a trusted CA (see WithTrustedCAs).
+	RejectReasonUntrustedCA
+)
+
+func (r RejectReason) String() string {
+	switch r {
+	case RejectReasonInvalidCertificate:
+		return "invalid certificate"
+	case RejectReasonPeerIDMismatch:
+		return "peer ID mismatch"
+	case RejectReasonKeyTooLarge:
+		return "key too large"
+	case RejectReasonServerName:
+		return "unexpected server name"
+	case RejectReasonRateLimited:
+		return "rate limited"
+	case RejectReasonDenied:
+		return "denied"
+	case RejectReasonPinMismatch:
+		return "certificate pin mismatch"
+	case RejectReasonChainTooLarge:
+		return "certificate chain too large"
+	case RejectReasonNotLibp2p:
+		return "not a libp2p peer"
+	case RejectReasonUntrustedCA:
+		return "untrusted CA"
+	default:
+		return fmt.Sprintf("unknown reject reason: %d", int(r))
+	}
+}
+
+// A HandshakeRejection is reported when we reject a handshake, see OnHandshakeRejected.
+type HandshakeRejection struct {
+	Reason     RejectReason
+	RemoteAddr net.Addr
+	// Err is the error the handshake was rejected with.
+	Err error
+}
+
+// A rejectionError is an error returned by a verification step, tagged with the reason for the rejection.
+type rejectionError struct {
+	reason RejectReason
+	err    error
+}
+
+func (e *rejectionError) Error() string { return e.err.Error() }
+
+func (e *rejectionError) Unwrap() error { return e.err }
+
+func reject(reason RejectReason, err error) error {
+	return &rejectionError{reason: reason, err: err}
+}
+
+// rejectReason returns the reason for a rejection, and the untagged error.
+// Errors that are not tagged with a reason were returned by a custom verification.
+func rejectReason(err error) (RejectReason, error) {
+	if err == errTooManyHandshakes {
+		return RejectReasonRateLimited, err
+	}
+	if rerr, ok := err.(*rejectionError); ok {
+		return rerr.reason, rerr.err
+	}
+	return RejectReasonDenied, err
+}
+
+// reportRejection calls the OnHandshakeRejected callback, if set.
+func (t *transport) reportRejection(err error, remote net.Addr) {
+	if t.config.onHandshakeRejected == nil {
+		return
+	}
+	reason, err := rejectReason(err)
+	t.config.onHandshakeRejected(HandshakeRejection{Reason: reason, RemoteAddr: remote, Err: err})
+}
+
+// withRejectionReporting returns a copy of the tls.Config that reports the rejections
+// of all verification steps configured on conf.
+// It must be applied after all other modifications of the tls.Config.
+func withRejectionReporting(conf *tls.Config, t *transport) *tls.Config {
+	base := conf.Clone()
+	conf = conf.Clone()
+	conf.GetConfigForClient = func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
+		var remote net.Addr
+		if chi.Conn != nil {
+			remote = chi.Conn.RemoteAddr()
+		}
+		c := base
+		if base.GetConfigForClient != nil {
+			clientConf, err := base.GetConfigForClient(chi)
+			if err != nil {
+				t.reportRejection(err, remote)
+				return nil, err
+			}
+			if clientConf != nil {
+				c = clientConf
+			}
+		}
+		c = c.Clone()
+		c.GetConfigForClient = nil
+		if verify := c.VerifyPeerCertificate; verify != nil {
+			c.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+				err := verify(rawCerts, verifiedChains)
+				if err != nil {
+					t.reportRejection(err, remote)
+				}
+				return err
+			}
+		}
+		return c, nil
+	}
+	return conf
+}
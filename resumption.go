@@ -0,0 +1,24 @@
+package libp2pquic
+
+import (
+	"errors"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ErrResumptionUnsupported is returned by PrimeResumption,
+// since quic-go v0.11 doesn't allow resuming a session from a serialized ticket.
+var ErrResumptionUnsupported = errors.New("session resumption is not supported")
+
+// PrimeResumption stores a session ticket for a peer, e.g. one that was persisted using ExportResumption.
+// The version of quic-go currently used doesn't allow resuming a session from a ticket,
+// so it fails with ErrResumptionUnsupported, and every dial performs a full handshake.
+func (t *transport) PrimeResumption(p peer.ID, ticket []byte) error {
+	return ErrResumptionUnsupported
+}
+
+// ExportResumption returns the session ticket of a peer, such that it can be persisted.
+// The version of quic-go currently used doesn't expose session tickets, so no ticket is ever returned.
+func (t *transport) ExportResumption(p peer.ID) ([]byte, bool) {
+	return nil, false
+}
@@ -0,0 +1,151 @@
+package libp2pquic
+
+import (
+	"container/list"
+	"net"
+	"sync"
+	"time"
+
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+// RetryTokenBinding says which part of the client's address a retry token is bound to, see WithRetryTokens.
+type RetryTokenBinding int
+
+const (
+	// RetryTokenBindIPAndPort only accepts tokens from the exact IP and port the Retry was sent to.
+	RetryTokenBindIPAndPort RetryTokenBinding = iota
+	// RetryTokenBindIP accepts tokens from any port of the IP the Retry was sent to.
+	// This allows clients behind NATs that change the port during the handshake to connect.
+	RetryTokenBindIP
+)
+
+// quic-go encodes the time a token was issued with a resolution of one second.
+const retryTokenTimeResolution = time.Second
+
+// The maximum number of issued tokens that are recorded, in total and per IP.
+// Retries are sent in response to floods of (possibly spoofed) Initials, so the records are bounded.
+// When a limit is reached, the oldest record is removed, and the client needs to request a new token.
+const (
+	maxIssuedRetryTokens      = 10000
+	maxIssuedRetryTokensPerIP = 16
+)
+
+// A retryTokenValidator makes a listener validate the client's address using a Retry before starting a handshake.
+// quic-go generates the tokens, but only encodes the client's IP and the time the token was issued.
+// To bind tokens to the port as well, the validator records the ports Retries were sent to.
+type retryTokenValidator struct {
+	lifetime time.Duration
+	binding  RetryTokenBinding
+
+	mutex  sync.Mutex
+	order  *list.List                 // of *issuedRetryToken, oldest first
+	issued map[string][]*list.Element // keyed by the client's IP, oldest first
+}
+
+type issuedRetryToken struct {
+	ip       string
+	port     int
+	issuedAt time.Time
+}
+
+func newRetryTokenValidator(lifetime time.Duration, binding RetryTokenBinding) *retryTokenValidator {
+	return &retryTokenValidator{
+		lifetime: lifetime,
+		binding:  binding,
+		order:    list.New(),
+		issued:   make(map[string][]*list.Element),
+	}
+}
+
+// issue records that a Retry carrying a new token is sent to addr.
+func (v *retryTokenValidator) issue(addr net.Addr) {
+	if v.binding != RetryTokenBindIPAndPort {
+		return
+	}
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	ip := sourceIP(addr)
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	// records are ordered by age, so only expired records need to be visited
+	for e := v.order.Front(); e != nil; e = v.order.Front() {
+		t := e.Value.(*issuedRetryToken)
+		if now.Sub(t.issuedAt) <= v.lifetime+retryTokenTimeResolution {
+			break
+		}
+		v.removeOldestLocked(t.ip)
+	}
+	if len(v.issued[ip]) >= maxIssuedRetryTokensPerIP {
+		v.removeOldestLocked(ip)
+	}
+	if v.order.Len() >= maxIssuedRetryTokens {
+		v.removeOldestLocked(v.order.Front().Value.(*issuedRetryToken).ip)
+	}
+	e := v.order.PushBack(&issuedRetryToken{ip: ip, port: udpAddr.Port, issuedAt: now})
+	v.issued[ip] = append(v.issued[ip], e)
+}
+
+// removeOldestLocked removes the oldest record of a token issued to ip.
+func (v *retryTokenValidator) removeOldestLocked(ip string) {
+	tokens := v.issued[ip]
+	v.order.Remove(tokens[0])
+	if len(tokens) == 1 {
+		delete(v.issued, ip)
+		return
+	}
+	v.issued[ip] = tokens[1:]
+}
+
+// Validate says if the token presented by the client at addr is valid.
+func (v *retryTokenValidator) Validate(addr net.Addr, cookie *quic.Cookie) bool {
+	if cookie == nil {
+		return false
+	}
+	if time.Since(cookie.SentTime) > v.lifetime {
+		return false
+	}
+	ip := sourceIP(addr)
+	if cookie.RemoteAddr != ip {
+		return false
+	}
+	if v.binding != RetryTokenBindIPAndPort {
+		return true
+	}
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	for _, e := range v.issued[ip] {
+		t := e.Value.(*issuedRetryToken)
+		if t.port != udpAddr.Port {
+			continue
+		}
+		// The time encoded in the token is truncated, and might have been taken slightly after the record was made.
+		if d := cookie.SentTime.Sub(t.issuedAt.Truncate(retryTokenTimeResolution)); d >= -retryTokenTimeResolution && d <= retryTokenTimeResolution {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns a copy of the quic.Config that requires a valid token for every handshake.
+// Clients without a valid token are sent a Retry containing a new token.
+func (v *retryTokenValidator) Apply(conf *quic.Config) *quic.Config {
+	validated := *conf
+	acceptCookie := conf.AcceptCookie
+	validated.AcceptCookie = func(clientAddr net.Addr, cookie *quic.Cookie) bool {
+		if v.Validate(clientAddr, cookie) && (acceptCookie == nil || acceptCookie(clientAddr, cookie)) {
+			return true
+		}
+		// quic-go sends a Retry whenever a handshake is refused
+		v.issue(clientAddr)
+		return false
+	}
+	return &validated
+}
@@ -0,0 +1,104 @@
+package libp2pquic
+
+import (
+	"net"
+	"time"
+
+	quic "github.com/lucas-clemente/quic-go"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Retry tokens", func() {
+	addr := func(ip string, port int) net.Addr {
+		return &net.UDPAddr{IP: net.ParseIP(ip), Port: port}
+	}
+
+	// token returns the token quic-go would decode for a Retry sent to ip at t.
+	token := func(ip string, t time.Time) *quic.Cookie {
+		return &quic.Cookie{RemoteAddr: ip, SentTime: time.Unix(t.Unix(), 0)}
+	}
+
+	for _, b := range []RetryTokenBinding{RetryTokenBindIPAndPort, RetryTokenBindIP} {
+		binding := b
+
+		It("sends a Retry to clients without a token", func() {
+			conf := newRetryTokenValidator(time.Minute, binding).Apply(&quic.Config{})
+			Expect(conf.AcceptCookie(addr("1.2.3.4", 1000), nil)).To(BeFalse())
+		})
+
+		It("accepts tokens within their lifetime", func() {
+			conf := newRetryTokenValidator(time.Minute, binding).Apply(&quic.Config{})
+			Expect(conf.AcceptCookie(addr("1.2.3.4", 1000), nil)).To(BeFalse())
+			Expect(conf.AcceptCookie(addr("1.2.3.4", 1000), token("1.2.3.4", time.Now()))).To(BeTrue())
+		})
+
+		It("rejects expired tokens", func() {
+			conf := newRetryTokenValidator(10*time.Second, binding).Apply(&quic.Config{})
+			Expect(conf.AcceptCookie(addr("1.2.3.4", 1000), token("1.2.3.4", time.Now().Add(-11*time.Second)))).To(BeFalse())
+		})
+
+		It("rejects tokens issued for a different IP", func() {
+			conf := newRetryTokenValidator(time.Minute, binding).Apply(&quic.Config{})
+			Expect(conf.AcceptCookie(addr("1.2.3.4", 1000), nil)).To(BeFalse())
+			Expect(conf.AcceptCookie(addr("5.6.7.8", 1000), token("1.2.3.4", time.Now()))).To(BeFalse())
+		})
+	}
+
+	It("binds tokens to the port", func() {
+		conf := newRetryTokenValidator(time.Minute, RetryTokenBindIPAndPort).Apply(&quic.Config{})
+		Expect(conf.AcceptCookie(addr("1.2.3.4", 1000), nil)).To(BeFalse())
+		Expect(conf.AcceptCookie(addr("1.2.3.4", 1001), token("1.2.3.4", time.Now()))).To(BeFalse())
+		Expect(conf.AcceptCookie(addr("1.2.3.4", 1000), token("1.2.3.4", time.Now()))).To(BeTrue())
+	})
+
+	It("accepts tokens from a different port when only binding to the IP", func() {
+		conf := newRetryTokenValidator(time.Minute, RetryTokenBindIP).Apply(&quic.Config{})
+		Expect(conf.AcceptCookie(addr("1.2.3.4", 1000), nil)).To(BeFalse())
+		Expect(conf.AcceptCookie(addr("1.2.3.4", 1001), token("1.2.3.4", time.Now()))).To(BeTrue())
+	})
+
+	It("forgets expired tokens", func() {
+		v := newRetryTokenValidator(time.Millisecond, RetryTokenBindIPAndPort)
+		v.issue(addr("1.2.3.4", 1000))
+		Expect(v.issued).To(HaveLen(1))
+		time.Sleep(retryTokenTimeResolution + 10*time.Millisecond)
+		v.issue(addr("5.6.7.8", 1000))
+		Expect(v.issued).To(HaveLen(1))
+		Expect(v.issued).To(HaveKey("5.6.7.8"))
+	})
+
+	It("limits the number of recorded tokens per IP", func() {
+		v := newRetryTokenValidator(time.Minute, RetryTokenBindIPAndPort)
+		conf := v.Apply(&quic.Config{})
+		for port := 1000; port < 1000+2*maxIssuedRetryTokensPerIP; port++ {
+			v.issue(addr("1.2.3.4", port))
+		}
+		Expect(v.issued["1.2.3.4"]).To(HaveLen(maxIssuedRetryTokensPerIP))
+		Expect(v.order.Len()).To(Equal(maxIssuedRetryTokensPerIP))
+		// the oldest records were removed
+		Expect(conf.AcceptCookie(addr("1.2.3.4", 1000), token("1.2.3.4", time.Now()))).To(BeFalse())
+		Expect(conf.AcceptCookie(addr("1.2.3.4", 1000+2*maxIssuedRetryTokensPerIP-1), token("1.2.3.4", time.Now()))).To(BeTrue())
+	})
+
+	It("limits the total number of recorded tokens", func() {
+		v := newRetryTokenValidator(time.Minute, RetryTokenBindIPAndPort)
+		for i := 0; i < maxIssuedRetryTokens+10; i++ {
+			v.issue(&net.UDPAddr{IP: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)), Port: 1000})
+		}
+		Expect(v.order.Len()).To(Equal(maxIssuedRetryTokens))
+		Expect(v.issued).To(HaveLen(maxIssuedRetryTokens))
+		Expect(v.issued).ToNot(HaveKey("10.0.0.0"))
+		Expect(v.issued).To(HaveKey("10.0.39.25")) // the last one issued
+	})
+
+	It("sends a Retry when the wrapped config refuses the handshake", func() {
+		v := newRetryTokenValidator(time.Minute, RetryTokenBindIPAndPort)
+		conf := v.Apply(&quic.Config{
+			AcceptCookie: func(net.Addr, *quic.Cookie) bool { return false },
+		})
+		Expect(conf.AcceptCookie(addr("1.2.3.4", 1000), token("1.2.3.4", time.Now()))).To(BeFalse())
+		Expect(v.issued["1.2.3.4"]).To(HaveLen(1))
+	})
+})
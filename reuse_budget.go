@@ -0,0 +1,57 @@
+package libp2pquic
+
+import (
+	"math"
+	"time"
+)
+
+// The half-life of the usage score of a socket, see WithReuseSocketBudget.
+const reuseScoreHalfLife = time.Minute
+
+// use records that a dial used the socket.
+func (r *reuseConn) use(now time.Time) {
+	r.score = r.scoreAt(now) + 1
+	r.lastUsed = now
+}
+
+// scoreAt returns the usage score of the socket: the number of dials that used it,
+// with every use decaying exponentially with reuseScoreHalfLife.
+func (r *reuseConn) scoreAt(now time.Time) float64 {
+	if r.lastUsed.IsZero() {
+		return 0
+	}
+	return r.score * math.Exp2(-float64(now.Sub(r.lastUsed))/float64(reuseScoreHalfLife))
+}
+
+// reclaimIdle closes the unused socket with the lowest usage score.
+// It returns false if all sockets are in use.
+// It must be called with the mutex held.
+func (c *connManager) reclaimIdle() bool {
+	now := time.Now()
+	var lowestKey string
+	var lowest *reuseConn
+	for key, rconn := range c.reuseConns {
+		if rconn.refCount > 0 {
+			continue
+		}
+		if lowest == nil || rconn.scoreAt(now) < lowest.scoreAt(now) {
+			lowestKey = key
+			lowest = rconn
+		}
+	}
+	if lowest == nil {
+		return false
+	}
+	lowest.Close()
+	delete(c.reuseConns, lowestKey)
+	return true
+}
+
+// enforceSocketBudget reclaims unused sockets until the number of sockets is within the budget,
+// leaving room for another socket if needed.
+// Sockets that are in use are never closed, so the budget can be exceeded.
+// It must be called with the mutex held.
+func (c *connManager) enforceSocketBudget(needed int) {
+	for len(c.reuseConns)+needed > c.socketBudget && c.reclaimIdle() {
+	}
+}
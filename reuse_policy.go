@@ -0,0 +1,40 @@
+package libp2pquic
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// A ReusePolicy determines which dials share a socket, see WithReusePolicy.
+type ReusePolicy int
+
+const (
+	// ReuseGlobal makes all dials share a socket, so every peer sees the same source port.
+	// This makes NAT traversal (hole punching) work best.
+	ReuseGlobal ReusePolicy = iota
+	// ReusePerPeer makes dials to different peers use different sockets,
+	// so that peers can't link our connections by the source port.
+	// Dials to the same peer still share a socket.
+	ReusePerPeer
+)
+
+func (p ReusePolicy) String() string {
+	switch p {
+	case ReuseGlobal:
+		return "global"
+	case ReusePerPeer:
+		return "per peer"
+	default:
+		return fmt.Sprintf("unknown reuse policy: %d", int(p))
+	}
+}
+
+// reusePeer returns the peer whose dials use a separate socket when dialing p.
+// It returns an empty peer ID if the dial uses the socket shared by all peers.
+func (t *transport) reusePeer(p peer.ID) peer.ID {
+	if t.config.reusePolicy != ReusePerPeer {
+		return ""
+	}
+	return p
+}
@@ -0,0 +1,27 @@
+package libp2pquic
+
+import "context"
+
+// noShard is the shard of dials that don't use a separate socket per shard.
+const noShard = -1
+
+type shardHintKey struct{}
+
+// WithShardHint returns a context that makes Dial use the socket of the shard,
+// if the transport was configured using WithReuseSharding.
+// Dials using the same shard hint share a socket. Shard hints must not be negative.
+func WithShardHint(ctx context.Context, shard int) context.Context {
+	return context.WithValue(ctx, shardHintKey{}, shard)
+}
+
+// shardForDial returns the shard of the socket used for a dial.
+func (t *transport) shardForDial(ctx context.Context) int {
+	if !t.config.reuseSharding {
+		return noShard
+	}
+	shard, ok := ctx.Value(shardHintKey{}).(int)
+	if !ok || shard < 0 {
+		return noShard
+	}
+	return shard
+}
@@ -0,0 +1,41 @@
+package libp2pquic
+
+import (
+	"fmt"
+
+	tpt "github.com/libp2p/go-libp2p-core/transport"
+)
+
+// ServeConns accepts connections, and calls handler for every connection in a new goroutine.
+// It blocks until Accept returns an error (e.g. because the listener was closed), and returns that error.
+// If the handler panics, the connection is closed and an EventHandlerPanicked is published.
+// ServeConns must not be combined with other calls to Accept.
+func (l *listener) ServeConns(handler func(tpt.CapableConn)) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go l.serveConn(c, handler)
+	}
+}
+
+func (l *listener) serveConn(c tpt.CapableConn, handler func(tpt.CapableConn)) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := fmt.Errorf("handler panicked: %v", r)
+			l.transport.events.Publish(Event{
+				Type: EventHandlerPanicked,
+				Peer: c.RemotePeer(),
+				Addr: c.RemoteMultiaddr(),
+				Err:  err,
+			})
+			if qc, ok := c.(*conn); ok {
+				qc.closeWithError(0, err)
+				return
+			}
+			c.Close()
+		}
+	}()
+	handler(c)
+}
@@ -0,0 +1,102 @@
+package libp2pquic
+
+import (
+	"sync/atomic"
+
+	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/libp2p/go-libp2p-core/peer"
+	tpt "github.com/libp2p/go-libp2p-core/transport"
+)
+
+// errSharedConnClosed is returned by a view that was closed, while the session might still be used by other views.
+var errSharedConnClosed = ErrConnClosed
+
+// A sharedConn is a view on the session of a connection, returned by Dial when session sharing is enabled
+// (see WithSessionSharing), or when using a connection pool (see WithConnPool).
+// Streams opened on it are multiplexed over the existing session.
+// Streams opened by the peer are delivered to whichever view (or the connection itself) accepts them first.
+type sharedConn struct {
+	*conn
+	closed int32 // must be accessed atomically
+}
+
+var _ tpt.CapableConn = &sharedConn{}
+
+// Close closes the view. The session is closed once the connection and all its views are closed.
+func (s *sharedConn) Close() error {
+	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		return nil
+	}
+	if s.conn.releaseView() {
+		return s.conn.closeSession()
+	}
+	return nil
+}
+
+// IsClosed says if the view or the underlying session is closed.
+func (s *sharedConn) IsClosed() bool {
+	return atomic.LoadInt32(&s.closed) == 1 || s.conn.IsClosed()
+}
+
+func (s *sharedConn) OpenStream() (mux.MuxedStream, error) {
+	if atomic.LoadInt32(&s.closed) == 1 {
+		return nil, errSharedConnClosed
+	}
+	return s.conn.OpenStream()
+}
+
+func (s *sharedConn) AcceptStream() (mux.MuxedStream, error) {
+	if atomic.LoadInt32(&s.closed) == 1 {
+		return nil, errSharedConnClosed
+	}
+	return s.conn.AcceptStream()
+}
+
+// newView returns a view on the connection's session.
+// It returns nil if the session is closed, or about to be closed.
+func (c *conn) newView() *sharedConn {
+	c.viewsMutex.Lock()
+	defer c.viewsMutex.Unlock()
+	if c.handleClosed || c.IsClosed() {
+		return nil
+	}
+	c.numViews++
+	return &sharedConn{conn: c}
+}
+
+// releaseView is called when a view is closed.
+// It says if the session should be closed, i.e. if this was the last handle to it.
+func (c *conn) releaseView() bool {
+	c.viewsMutex.Lock()
+	defer c.viewsMutex.Unlock()
+	c.numViews--
+	return c.numViews == 0 && c.handleClosed
+}
+
+// releaseHandle is called when the connection is closed.
+// It says if the session should be closed, i.e. if no views are open.
+func (c *conn) releaseHandle() bool {
+	c.viewsMutex.Lock()
+	defer c.viewsMutex.Unlock()
+	c.handleClosed = true
+	return c.numViews == 0
+}
+
+// sharedConnTo returns a view on an open connection to the peer whose certificates match the pins, or nil if there is none.
+func (t *transport) sharedConnTo(p peer.ID, pins []CertificatePin) *sharedConn {
+	t.connsMutex.Lock()
+	conns := make([]*conn, 0, len(t.conns[p]))
+	for c := range t.conns[p] {
+		conns = append(conns, c)
+	}
+	t.connsMutex.Unlock()
+	for _, c := range conns {
+		if !c.matchesCertificatePins(pins) {
+			continue
+		}
+		if v := c.newView(); v != nil {
+			return v
+		}
+	}
+	return nil
+}
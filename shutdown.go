@@ -0,0 +1,116 @@
+package libp2pquic
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// How often Shutdown checks if all dials completed and all connections were closed.
+var shutdownPollInterval = 10 * time.Millisecond
+
+// Shutdown shuts the transport down gracefully:
+// It puts the transport into draining mode (see SetDraining) and stops all listeners from accepting connections.
+// Connections completing their handshake from now on are closed.
+// Connections kept open by the connection pool (see WithConnPool and WarmPool) are released.
+// It then waits for the dials in progress to complete, and for all connections to be closed by the application or the peer.
+// When ctx is done, the remaining connections are closed.
+// Finally, all listeners and the sockets used for dialing are closed,
+// unless the sockets belong to a ConnManager shared with other transports (see WithConnManager).
+// The stats callback (see WithStatsInterval) isn't called any more once Shutdown returns.
+// It returns the first error encountered, which is ctx.Err() if connections had to be closed.
+func (t *transport) Shutdown(ctx context.Context) error {
+	t.SetDraining(true)
+	if t.stopStatsReporter != nil {
+		defer t.stopStatsReporter()
+	}
+	listeners := t.listeners()
+	for _, l := range listeners {
+		l.stopAcceptingOnce.Do(func() { close(l.stopAccepting) })
+	}
+	if t.connPool != nil {
+		t.connPool.Close()
+	}
+
+	var firstErr error
+	if err := t.waitForConns(ctx); err != nil {
+		firstErr = err
+		for _, c := range t.allConns() {
+			if err := c.closeSession(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	for _, l := range listeners {
+		if err := l.forceClose(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if t.config.connManager == nil {
+		if err := t.connManager.closeAll(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// waitForConns waits until no dials are in progress and all connections are closed.
+// It returns ctx.Err() if ctx is done before.
+func (t *transport) waitForConns(ctx context.Context) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if atomic.LoadInt32(&t.dialsInProgress) == 0 && len(t.allConns()) == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (t *transport) allConns() []*conn {
+	t.connsMutex.Lock()
+	defer t.connsMutex.Unlock()
+	var conns []*conn
+	for _, cs := range t.conns {
+		for c := range cs {
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
+
+func (t *transport) listeners() []*listener {
+	t.listenAddrsMutex.Lock()
+	defer t.listenAddrsMutex.Unlock()
+	listeners := make([]*listener, 0, len(t.listenAddrs))
+	for _, l := range t.listenAddrs {
+		listeners = append(listeners, l)
+	}
+	return listeners
+}
+
+// forceClose closes the listener, even if it is shared (see DuplicateListenShare).
+func (l *listener) forceClose() error {
+	l.transport.listenAddrsMutex.Lock()
+	l.refs = 1
+	l.transport.listenAddrsMutex.Unlock()
+	return l.Close()
+}
+
+// closeAll closes all sockets used for dialing.
+func (c *connManager) closeAll() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var firstErr error
+	for key, rconn := range c.reuseConns {
+		if err := rconn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(c.reuseConns, key)
+	}
+	return firstErr
+}
@@ -0,0 +1,75 @@
+package libp2pquic
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// quic-go keeps closed sessions around for this long, and replies to packets for them with a CONNECTION_CLOSE.
+// When closing connections silently (see WithConnectionClose), packets to the peer are dropped for this long.
+const silentCloseDuration = 5 * time.Second
+
+// A silencer drops the packets sent to silenced addresses.
+type silencer struct {
+	all         int32 // set to 1 when all packets are dropped, must be accessed atomically
+	numSilenced int32 // must be accessed atomically
+	mutex       sync.Mutex
+	until       map[string]time.Time
+}
+
+// Silence drops all packets sent to addr for the duration d.
+func (s *silencer) Silence(addr net.Addr, d time.Duration) {
+	key := unwrapAddr(addr).String()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.until == nil {
+		s.until = make(map[string]time.Time)
+	}
+	if _, ok := s.until[key]; !ok {
+		atomic.AddInt32(&s.numSilenced, 1)
+	}
+	s.until[key] = time.Now().Add(d)
+}
+
+// SilenceAll drops all packets, regardless of their destination.
+func (s *silencer) SilenceAll() {
+	atomic.StoreInt32(&s.all, 1)
+}
+
+// IsSilenced says if packets sent to addr are dropped.
+func (s *silencer) IsSilenced(addr net.Addr) bool {
+	if atomic.LoadInt32(&s.all) == 1 {
+		return true
+	}
+	if atomic.LoadInt32(&s.numSilenced) == 0 {
+		return false
+	}
+	key := unwrapAddr(addr).String()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	until, ok := s.until[key]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(s.until, key)
+		atomic.AddInt32(&s.numSilenced, -1)
+		return false
+	}
+	return true
+}
+
+// A silencingConn is a net.PacketConn that drops the packets sent to silenced addresses.
+type silencingConn struct {
+	net.PacketConn
+	silencer silencer
+}
+
+func (c *silencingConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.silencer.IsSilenced(addr) {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
@@ -0,0 +1,47 @@
+package libp2pquic
+
+import (
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Silencing", func() {
+	It("drops the packets sent to silenced addresses", func() {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		peer, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer peer.Close()
+
+		c := &silencingConn{PacketConn: conn}
+		c.silencer.Silence(peer.LocalAddr(), 100*time.Millisecond)
+		n, err := c.WriteTo([]byte("foobar"), peer.LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(6))
+		peer.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		_, _, err = peer.ReadFrom(make([]byte, 100))
+		Expect(err).To(HaveOccurred())
+
+		// the silence expires
+		time.Sleep(100 * time.Millisecond)
+		_, err = c.WriteTo([]byte("foobar"), peer.LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+		peer.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err = peer.ReadFrom(make([]byte, 100))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(6))
+		Expect(c.silencer.numSilenced).To(BeZero())
+	})
+
+	It("drops all packets", func() {
+		var s silencer
+		addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
+		Expect(s.IsSilenced(addr)).To(BeFalse())
+		s.SilenceAll()
+		Expect(s.IsSilenced(addr)).To(BeTrue())
+	})
+})
@@ -0,0 +1,80 @@
+package libp2pquic
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// The number of socket events buffered, see SocketDiagnostics.
+// When the consumer doesn't keep up, new events are dropped.
+const socketDiagnosticsBufferSize = 64
+
+// A SocketEventType is the type of a socket event.
+type SocketEventType int
+
+const (
+	// SocketReadError is emitted when reading from a socket failed.
+	SocketReadError SocketEventType = iota + 1
+	// SocketWriteError is emitted when writing to a socket failed.
+	SocketWriteError
+	// SocketPortUnreachable is emitted when a peer's host reported that the UDP port is closed (ICMP port unreachable).
+	// This is only detected if enabled using WithPortUnreachableDetection, and only on Linux.
+	SocketPortUnreachable
+)
+
+func (t SocketEventType) String() string {
+	switch t {
+	case SocketReadError:
+		return "read error"
+	case SocketWriteError:
+		return "write error"
+	case SocketPortUnreachable:
+		return "port unreachable"
+	default:
+		return fmt.Sprintf("unknown socket event type: %d", int(t))
+	}
+}
+
+// A SocketEvent is a non-fatal socket error, see SocketDiagnostics.
+type SocketEvent struct {
+	Type SocketEventType
+	Time time.Time
+	// The local address of the socket.
+	LocalAddr net.Addr
+	// The address of the peer, if known.
+	RemoteAddr net.Addr
+	Err        error
+}
+
+// socketDiagnostics collects the socket events of a transport.
+// A nil *socketDiagnostics discards all events.
+type socketDiagnostics struct {
+	events chan SocketEvent
+}
+
+func newSocketDiagnostics() *socketDiagnostics {
+	return &socketDiagnostics{events: make(chan SocketEvent, socketDiagnosticsBufferSize)}
+}
+
+// Report emits an event. If the buffer is full, the event is dropped.
+// Errors caused by closing the socket are not reported.
+func (d *socketDiagnostics) Report(typ SocketEventType, conn net.PacketConn, raddr net.Addr, err error) {
+	if d == nil || errors.Is(err, net.ErrClosed) {
+		return
+	}
+	select {
+	case d.events <- SocketEvent{Type: typ, Time: time.Now(), LocalAddr: conn.LocalAddr(), RemoteAddr: raddr, Err: err}:
+	default:
+	}
+}
+
+// SocketDiagnostics returns a channel that receives the non-fatal errors of the transport's sockets,
+// e.g. transient read and write errors, and ICMP errors.
+// quic-go retries or ignores most of these errors, so they are not visible otherwise.
+// The channel is buffered, and events are dropped if they are not consumed quickly enough.
+// Transports sharing a ConnManager (see WithConnManager) share the events of the dial sockets.
+func (t *transport) SocketDiagnostics() <-chan SocketEvent {
+	return t.connManager.diagnostics.events
+}
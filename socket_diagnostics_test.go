@@ -0,0 +1,97 @@
+package libp2pquic
+
+import (
+	"errors"
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// failingWriteConn is a net.PacketConn that fails all writes with writeErr.
+type failingWriteConn struct {
+	net.PacketConn
+	writeErr error
+}
+
+func (c *failingWriteConn) WriteTo([]byte, net.Addr) (int, error) {
+	return 0, c.writeErr
+}
+
+var _ = Describe("Socket Diagnostics", func() {
+	var udpConn *net.UDPConn
+
+	BeforeEach(func() {
+		var err error
+		udpConn, err = net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		udpConn.Close()
+	})
+
+	It("reports write errors", func() {
+		d := newSocketDiagnostics()
+		testErr := errors.New("no buffer space available")
+		tconn := newTrackingConn(&failingWriteConn{PacketConn: udpConn, writeErr: testErr})
+		tconn.diagnostics = d
+		raddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
+		_, err := tconn.WriteTo([]byte("foobar"), raddr)
+		Expect(err).To(MatchError(testErr))
+		var ev SocketEvent
+		Expect(d.events).To(Receive(&ev))
+		Expect(ev.Type).To(Equal(SocketWriteError))
+		Expect(ev.Err).To(MatchError(testErr))
+		Expect(ev.LocalAddr).To(Equal(udpConn.LocalAddr()))
+		Expect(ev.RemoteAddr).To(Equal(raddr))
+		Expect(ev.Time).ToNot(BeZero())
+	})
+
+	It("reports read errors", func() {
+		d := newSocketDiagnostics()
+		testErr := &temporaryError{}
+		conn := &readRetryConn{
+			PacketConn:  &faultyConn{PacketConn: udpConn, errs: []error{testErr}},
+			diagnostics: d,
+		}
+		_, err := udpConn.WriteTo([]byte("foobar"), udpConn.LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = conn.ReadFrom(make([]byte, 100))
+		Expect(err).ToNot(HaveOccurred())
+		var ev SocketEvent
+		Expect(d.events).To(Receive(&ev))
+		Expect(ev.Type).To(Equal(SocketReadError))
+		Expect(ev.Err).To(Equal(testErr))
+	})
+
+	It("doesn't report errors caused by closing the socket", func() {
+		d := newSocketDiagnostics()
+		tconn := newTrackingConn(udpConn)
+		tconn.diagnostics = d
+		Expect(udpConn.Close()).To(Succeed())
+		_, _, err := tconn.ReadFrom(make([]byte, 100))
+		Expect(err).To(HaveOccurred())
+		Expect(d.events).ToNot(Receive())
+	})
+
+	It("drops events when the buffer is full", func() {
+		d := newSocketDiagnostics()
+		for i := 0; i < 2*socketDiagnosticsBufferSize; i++ {
+			d.Report(SocketWriteError, udpConn, nil, errors.New("test error"))
+		}
+		Expect(d.events).To(HaveLen(socketDiagnosticsBufferSize))
+	})
+
+	It("discards events if diagnostics are disabled", func() {
+		var d *socketDiagnostics
+		d.Report(SocketWriteError, udpConn, nil, errors.New("test error"))
+	})
+
+	It("has a string representation", func() {
+		Expect(SocketReadError.String()).To(Equal("read error"))
+		Expect(SocketWriteError.String()).To(Equal("write error"))
+		Expect(SocketPortUnreachable.String()).To(Equal("port unreachable"))
+		Expect(SocketEventType(42).String()).To(Equal("unknown socket event type: 42"))
+	})
+})
@@ -0,0 +1,103 @@
+package libp2pquic
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+// A sourceIPLimiter bounds the number of concurrent incoming handshakes from a single source IP.
+// A handshake is counted from its first Initial packet, when quic-go asks if the client's address is validated,
+// until the session is accepted or the handshake timeout expires.
+// New handshakes beyond the limit are refused at this stage: quic-go then sends a Retry instead of starting the handshake,
+// so no state is kept for them.
+type sourceIPLimiter struct {
+	limit   int
+	timeout time.Duration
+
+	mutex   sync.Mutex
+	pending map[string][]*time.Timer // keyed by the source IP, the oldest handshake first
+}
+
+func newSourceIPLimiter(limit int, timeout time.Duration) *sourceIPLimiter {
+	return &sourceIPLimiter{
+		limit:   limit,
+		timeout: timeout,
+		pending: make(map[string][]*time.Timer),
+	}
+}
+
+func sourceIP(addr net.Addr) string {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr.IP.String()
+	}
+	return addr.String()
+}
+
+// acquire counts a new handshake from addr. It returns false if the limit for the source IP is reached.
+func (l *sourceIPLimiter) acquire(addr net.Addr) bool {
+	ip := sourceIP(addr)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if len(l.pending[ip]) >= l.limit {
+		return false
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(l.timeout, func() { l.remove(ip, timer) })
+	l.pending[ip] = append(l.pending[ip], timer)
+	return true
+}
+
+// Complete marks the oldest handshake from addr as completed.
+func (l *sourceIPLimiter) Complete(addr net.Addr) {
+	ip := sourceIP(addr)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if timers := l.pending[ip]; len(timers) > 0 {
+		timers[0].Stop()
+		l.removeLocked(ip, timers[0])
+	}
+}
+
+func (l *sourceIPLimiter) remove(ip string, timer *time.Timer) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.removeLocked(ip, timer)
+}
+
+func (l *sourceIPLimiter) removeLocked(ip string, timer *time.Timer) {
+	timers := l.pending[ip]
+	for i, t := range timers {
+		if t == timer {
+			timers = append(timers[:i], timers[i+1:]...)
+			break
+		}
+	}
+	if len(timers) == 0 {
+		delete(l.pending, ip)
+		return
+	}
+	l.pending[ip] = timers
+}
+
+// InProgress returns the number of handshakes from the source IP currently in progress.
+func (l *sourceIPLimiter) InProgress(ip net.IP) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.pending[ip.String()])
+}
+
+// Apply returns a copy of the quic.Config that is subject to the limit.
+func (l *sourceIPLimiter) Apply(conf *quic.Config) *quic.Config {
+	limited := *conf
+	acceptCookie := conf.AcceptCookie
+	limited.AcceptCookie = func(clientAddr net.Addr, cookie *quic.Cookie) bool {
+		if acceptCookie != nil && !acceptCookie(clientAddr, cookie) {
+			return false
+		}
+		return l.acquire(clientAddr)
+	}
+	return &limited
+}
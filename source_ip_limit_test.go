@@ -0,0 +1,58 @@
+package libp2pquic
+
+import (
+	"net"
+	"time"
+
+	quic "github.com/lucas-clemente/quic-go"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Source IP Limiter", func() {
+	addr := func(ip string, port int) net.Addr {
+		return &net.UDPAddr{IP: net.ParseIP(ip), Port: port}
+	}
+
+	It("limits the number of handshakes from a single IP", func() {
+		l := newSourceIPLimiter(3, time.Hour)
+		conf := l.Apply(&quic.Config{})
+		for i := 0; i < 3; i++ {
+			Expect(conf.AcceptCookie(addr("1.2.3.4", 1000+i), nil)).To(BeTrue())
+		}
+		// handshakes from other ports of the same IP are refused
+		for i := 3; i < 10; i++ {
+			Expect(conf.AcceptCookie(addr("1.2.3.4", 1000+i), nil)).To(BeFalse())
+		}
+		Expect(l.InProgress(net.ParseIP("1.2.3.4"))).To(Equal(3))
+		// other IPs are not affected
+		Expect(conf.AcceptCookie(addr("5.6.7.8", 1000), nil)).To(BeTrue())
+		Expect(conf.AcceptCookie(addr("::1", 1000), nil)).To(BeTrue())
+
+		// once a handshake completed, a new one can be started
+		l.Complete(addr("1.2.3.4", 1000))
+		Expect(l.InProgress(net.ParseIP("1.2.3.4"))).To(Equal(2))
+		Expect(conf.AcceptCookie(addr("1.2.3.4", 2000), nil)).To(BeTrue())
+		Expect(conf.AcceptCookie(addr("1.2.3.4", 2001), nil)).To(BeFalse())
+	})
+
+	It("frees the slot when the handshake times out", func() {
+		l := newSourceIPLimiter(1, 50*time.Millisecond)
+		conf := l.Apply(&quic.Config{})
+		Expect(conf.AcceptCookie(addr("1.2.3.4", 1000), nil)).To(BeTrue())
+		Expect(conf.AcceptCookie(addr("1.2.3.4", 1001), nil)).To(BeFalse())
+		Eventually(func() int { return l.InProgress(net.ParseIP("1.2.3.4")) }).Should(BeZero())
+		Expect(conf.AcceptCookie(addr("1.2.3.4", 1001), nil)).To(BeTrue())
+	})
+
+	It("doesn't count handshakes refused by the original config", func() {
+		l := newSourceIPLimiter(1, time.Hour)
+		conf := l.Apply(&quic.Config{
+			AcceptCookie: func(_ net.Addr, cookie *quic.Cookie) bool { return cookie != nil },
+		})
+		Expect(conf.AcceptCookie(addr("1.2.3.4", 1000), nil)).To(BeFalse())
+		Expect(l.InProgress(net.ParseIP("1.2.3.4"))).To(BeZero())
+		Expect(conf.AcceptCookie(addr("1.2.3.4", 1000), &quic.Cookie{})).To(BeTrue())
+	})
+})
@@ -0,0 +1,53 @@
+package libp2pquic
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// TransportStats are statistics about the connections established by a transport.
+// There are no statistics about 0-RTT: quic-go v0.11 doesn't support it, so every connection is established using 1-RTT.
+type TransportStats struct {
+	// BytesSent and BytesReceived count the data written to and read from the streams of all connections.
+	BytesSent, BytesReceived uint64
+	// WireBytesSent and WireBytesReceived count the UDP payloads sent and received by all connections,
+	// including the handshake and the overhead of QUIC, see conn.WireBytesSent.
+	WireBytesSent, WireBytesReceived uint64
+	// TimedHandshakes is the number of handshakes that completed while handshake timing was enabled (see WithHandshakeTiming).
+	TimedHandshakes uint64
+	// HandshakeTime is the total duration of the timed handshakes: For dials, from sending the first packet
+	// until the handshake completed, for incoming connections, from receiving the ClientHello until the connection was set up.
+	HandshakeTime time.Duration
+	// VerificationTime is the total time spent verifying the peers' certificate chains,
+	// an approximation of the CPU time used by handshakes. Failed handshakes are included.
+	VerificationTime time.Duration
+	// ReadBuffersInFlight is the number of read buffers holding a packet that quic-go hasn't read yet,
+	// and ReadBuffersHighWaterMark is the largest number of such buffers at any time.
+	// Only the buffers used for a write fan-out are counted (see WithWriteFanOut), quic-go's internal buffer pool isn't observable.
+	ReadBuffersInFlight, ReadBuffersHighWaterMark int
+	// UnsupportedRemoteKeyTypes is the number of peers whose host certificate used an unsupported key type,
+	// see UnsupportedRemoteKeyTypeError.
+	UnsupportedRemoteKeyTypes uint64
+}
+
+// Stats returns statistics about the connections dialed and accepted by this transport.
+func (t *transport) Stats() TransportStats {
+	stats := TransportStats{
+		BytesSent:                 t.streamBytes.Sent(),
+		BytesReceived:             t.streamBytes.Received(),
+		UnsupportedRemoteKeyTypes: atomic.LoadUint64(&t.unsupportedRemoteKeyTypes),
+	}
+	t.handshakeTimer.addStats(&stats)
+	t.connManager.readBuffers.addStats(&stats)
+	t.connsMutex.Lock()
+	defer t.connsMutex.Unlock()
+	stats.WireBytesSent = t.closedWireBytes.Sent()
+	stats.WireBytesReceived = t.closedWireBytes.Received()
+	for _, conns := range t.conns {
+		for c := range conns {
+			stats.WireBytesSent += c.wire.Sent()
+			stats.WireBytesReceived += c.wire.Received()
+		}
+	}
+	return stats
+}
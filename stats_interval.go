@@ -0,0 +1,33 @@
+package libp2pquic
+
+import (
+	"sync"
+	"time"
+)
+
+// startStatsReporter calls cb with a snapshot of the transport's statistics every interval (see WithStatsInterval).
+// The stop function stops the reporter. It waits until a callback that is currently running returned.
+func (t *transport) startStatsReporter(interval time.Duration, cb func(TransportStats)) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				cb(t.Stats())
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
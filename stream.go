@@ -1,19 +1,145 @@
 package libp2pquic
 
 import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
 	"github.com/libp2p/go-libp2p-core/mux"
 
 	quic "github.com/lucas-clemente/quic-go"
 )
 
+// A write is considered blocked if it doesn't complete within this duration.
+var writeBlockedThreshold = 100 * time.Millisecond
+
 type stream struct {
 	quic.Stream
+	metadata
+
+	conn *conn
+	// done is called when the stream is closed or reset by the application.
+	// nil if the stream is not tracked.
+	done func()
+	// The number of bytes passed to Write that haven't been handed to quic-go yet, see SendBufferLen.
+	// Must be accessed atomically.
+	unsent int64
 }
 
 var _ mux.MuxedStream = &stream{}
 
+func (s *stream) Read(b []byte) (int, error) {
+	n, err := s.Stream.Read(b)
+	s.conn.countReceived(n)
+	if m := s.conn.metricsTracer(); m != nil && n > 0 {
+		m.BytesReceived(n)
+	}
+	return n, s.conn.streamError(err)
+}
+
+func (s *stream) Write(b []byte) (int, error) {
+	timer := time.AfterFunc(writeBlockedThreshold, func() {
+		atomic.AddInt32(&s.conn.blockedWrites, 1)
+	})
+	atomic.AddInt64(&s.unsent, int64(len(b)))
+	n, err := s.conn.pacedWrite(b, func(chunk []byte) (int, error) {
+		n, err := s.Stream.Write(chunk)
+		atomic.AddInt64(&s.unsent, -int64(n))
+		return n, err
+	})
+	// bytes that weren't written because of an error are not buffered any more
+	atomic.AddInt64(&s.unsent, -int64(len(b)-n))
+	if !timer.Stop() {
+		// The timer already fired, so this write was counted as blocked.
+		atomic.AddInt32(&s.conn.blockedWrites, -1)
+	}
+	s.conn.countSent(n)
+	if m := s.conn.metricsTracer(); m != nil && n > 0 {
+		m.BytesSent(n)
+	}
+	return n, s.conn.streamError(err)
+}
+
+// SendBufferLen returns the number of bytes that were passed to Write, but haven't been sent yet.
+// quic-go v0.11 doesn't expose the occupancy of its send buffer. However, its Write only returns
+// once all data was packed into packets, so data that is still buffered belongs to a pending Write.
+// This is an approximation: quic-go consumes the data of a Write in steps, but it is only counted
+// as sent once the Write (or, when pacing, a chunk of it) returns. Data that was sent, but not acknowledged, is not counted.
+func (s *stream) SendBufferLen() int {
+	return int(atomic.LoadInt64(&s.unsent))
+}
+
+// streamError converts the error returned by a stream's Read or Write, or when opening or accepting a stream.
+// When the session is closed, quic-go unblocks all streams with the error the session was closed with.
+// This error is replaced by a *ClosedError, see CloseError.
+// All other errors (e.g. stream resets and deadlines) are returned unchanged.
+func (c *conn) streamError(err error) error {
+	if err == nil {
+		return nil
+	}
+	// Only the errors a session is closed with carry a QUIC error code.
+	if _, ok := quicErrorCode(err); !ok {
+		return err
+	}
+	// Streams are unblocked just before the session's context is cancelled.
+	<-c.sess.Context().Done()
+	return c.CloseError()
+}
+
+func (s *stream) Close() error {
+	if s.done != nil {
+		s.done()
+	}
+	return s.Stream.Close()
+}
+
+// A StreamErrorCode is an application error code used to cancel a stream.
+// Unlike quic.ErrorCode, its size doesn't depend on the version of quic-go.
+type StreamErrorCode uint64
+
+// The largest error code that quic-go v0.11 can send.
+const maxStreamErrorCode = math.MaxUint16
+
+// CloseWrite closes the stream for writing. The stream can still be read from.
+func (s *stream) CloseWrite() error {
+	return s.Close()
+}
+
+// CancelRead aborts receiving on the stream, and asks the peer to stop sending.
+func (s *stream) CancelRead(code StreamErrorCode) error {
+	if code > maxStreamErrorCode {
+		return fmt.Errorf("stream error code too large: %d", code)
+	}
+	s.Stream.CancelRead(quic.ErrorCode(code))
+	return nil
+}
+
+// CancelWrite aborts sending on the stream.
+// Data that was already written, but not yet delivered to the peer, is discarded.
+func (s *stream) CancelWrite(code StreamErrorCode) error {
+	if code > maxStreamErrorCode {
+		return fmt.Errorf("stream error code too large: %d", code)
+	}
+	s.Stream.CancelWrite(quic.ErrorCode(code))
+	return nil
+}
+
+// Reset aborts sending and receiving on the stream, using error code 0.
 func (s *stream) Reset() error {
-	s.Stream.CancelRead(0)
-	s.Stream.CancelWrite(0)
+	return s.ResetWithCode(0)
+}
+
+// ResetWithCode aborts sending and receiving on the stream.
+// The code is sent to the peer in both directions, so it learns why the stream was reset.
+func (s *stream) ResetWithCode(code StreamErrorCode) error {
+	if code > maxStreamErrorCode {
+		return fmt.Errorf("stream error code too large: %d", code)
+	}
+	if s.done != nil {
+		s.done()
+	}
+	s.Stream.CancelRead(quic.ErrorCode(code))
+	s.Stream.CancelWrite(quic.ErrorCode(code))
 	return nil
 }
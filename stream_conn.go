@@ -0,0 +1,38 @@
+package libp2pquic
+
+import (
+	"context"
+	"net"
+)
+
+// A streamConn is a net.Conn using a single QUIC stream.
+type streamConn struct {
+	*stream
+}
+
+var _ net.Conn = &streamConn{}
+
+// OpenStreamConn opens a new stream, and returns it as a net.Conn.
+// The local and remote address are the addresses of the connection,
+// deadlines apply to the stream.
+// Closing the net.Conn closes the stream in both directions.
+func (c *conn) OpenStreamConn(ctx context.Context) (net.Conn, error) {
+	str, err := c.OpenStreamContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &streamConn{stream: str.(*stream)}, nil
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *streamConn) Close() error {
+	c.stream.CancelRead(0)
+	return c.stream.Close()
+}
@@ -0,0 +1,35 @@
+package libp2pquic
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// Flush closes the stream for writing, and blocks until the peer confirmed that it received the data written,
+// or until the context is done.
+// quic-go v0.11 doesn't expose when stream data is acknowledged, so this is a best effort:
+// Flush waits for the peer's FIN, i.e. until the peer closed the stream for writing.
+// This relies on the peer closing the stream only after reading all data, as most request/response protocols do.
+// Flush reads the stream until the end, data sent by the peer that wasn't read yet is discarded.
+func (s *stream) Flush(ctx context.Context) error {
+	if err := s.CloseWrite(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// unblock the Read
+			s.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	_, err := io.Copy(ioutil.Discard, s)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package libp2pquic
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+
+	"github.com/libp2p/go-libp2p-core/mux"
+
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+// openStreamSync opens a bidirectional stream.
+// If the peer's stream limit is reached, it blocks until the peer allows opening a new stream.
+func (c *conn) openStreamSync() (quic.Stream, error) {
+	str, err := c.sess.OpenStream()
+	if !isStreamLimitError(err) {
+		return str, err
+	}
+	atomic.AddUint32(&c.blockedStreamOpens, 1)
+	return c.sess.OpenStreamSync()
+}
+
+// OpenStreamContext opens a new stream.
+// If the peer's stream limit is reached, it blocks until the peer allows opening a new stream,
+// or until ctx is done, in which case ctx.Err() is returned.
+func (c *conn) OpenStreamContext(ctx context.Context) (mux.MuxedStream, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	type result struct {
+		str mux.MuxedStream
+		err error
+	}
+	resChan := make(chan result, 1)
+	go func() {
+		str, err := c.OpenStream()
+		resChan <- result{str: str, err: err}
+	}()
+	select {
+	case res := <-resChan:
+		if res.err != nil {
+			return nil, res.err
+		}
+		return res.str, nil
+	case <-ctx.Done():
+		// Opening the stream might still succeed. Reset it in that case.
+		go func() {
+			if res := <-resChan; res.err == nil {
+				res.str.Reset()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// openUniStreamSync is the equivalent of openStreamSync for unidirectional streams.
+func (c *conn) openUniStreamSync() (quic.SendStream, error) {
+	str, err := c.sess.OpenUniStream()
+	if !isStreamLimitError(err) {
+		return str, err
+	}
+	atomic.AddUint32(&c.blockedStreamOpens, 1)
+	return c.sess.OpenUniStreamSync()
+}
+
+// isStreamLimitError says if opening a stream failed because the peer's stream limit is reached.
+// quic-go doesn't export this error, but marks it as temporary.
+func isStreamLimitError(err error) bool {
+	nerr, ok := err.(net.Error)
+	return ok && nerr.Temporary()
+}
@@ -0,0 +1,58 @@
+package libp2pquic
+
+import (
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+// StreamQueueFullErrorCode is the error code used to reset streams opened by the peer
+// when the stream accept queue is full.
+const StreamQueueFullErrorCode quic.ErrorCode = 0x1
+
+// A streamQueue holds the streams opened by the peer until they are accepted by the application.
+// Streams that don't fit into the queue are reset.
+type streamQueue struct {
+	queue chan quic.Stream
+	done  chan struct{}
+	err   error // set before done is closed
+}
+
+func newStreamQueue(depth int) *streamQueue {
+	return &streamQueue{
+		queue: make(chan quic.Stream, depth),
+		done:  make(chan struct{}),
+	}
+}
+
+// run accepts streams from the session until it is closed.
+func (q *streamQueue) run(sess quic.Session) {
+	defer close(q.done)
+	for {
+		str, err := sess.AcceptStream()
+		if err != nil {
+			q.err = err
+			return
+		}
+		select {
+		case q.queue <- str:
+		default:
+			str.CancelRead(StreamQueueFullErrorCode)
+			str.CancelWrite(StreamQueueFullErrorCode)
+		}
+	}
+}
+
+// Accept returns the next queued stream.
+func (q *streamQueue) Accept() (quic.Stream, error) {
+	select {
+	case str := <-q.queue:
+		return str, nil
+	case <-q.done:
+	}
+	// Streams might have been queued right before the session was closed.
+	select {
+	case str := <-q.queue:
+		return str, nil
+	default:
+		return nil, q.err
+	}
+}
@@ -0,0 +1,144 @@
+package libp2pquic
+
+import (
+	"errors"
+
+	quic "github.com/lucas-clemente/quic-go"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// mockStream is a quic.Stream that records how it was closed and canceled.
+type mockStream struct {
+	quic.Stream
+
+	closed                          bool
+	canceledRead, canceledWrite     bool
+	cancelReadCode, cancelWriteCode quic.ErrorCode
+	write                           func([]byte) (int, error)
+}
+
+func (s *mockStream) Write(b []byte) (int, error) { return s.write(b) }
+
+func (s *mockStream) Close() error { s.closed = true; return nil }
+
+func (s *mockStream) CancelRead(code quic.ErrorCode) {
+	s.canceledRead = true
+	s.cancelReadCode = code
+}
+
+func (s *mockStream) CancelWrite(code quic.ErrorCode) {
+	s.canceledWrite = true
+	s.cancelWriteCode = code
+}
+
+// windowStream is a mockStream that supports changing its receive window.
+type windowStream struct {
+	*mockStream
+	window uint64
+}
+
+func (s *windowStream) SetReceiveWindow(size uint64) error { s.window = size; return nil }
+
+var _ = Describe("Stream", func() {
+	var (
+		qstr *mockStream
+		str  *stream
+		done bool
+	)
+
+	BeforeEach(func() {
+		qstr = &mockStream{}
+		done = false
+		str = &stream{Stream: qstr, conn: &conn{}, done: func() { done = true }}
+	})
+
+	It("closes the stream for writing", func() {
+		Expect(str.CloseWrite()).To(Succeed())
+		Expect(qstr.closed).To(BeTrue())
+		Expect(qstr.canceledRead).To(BeFalse())
+		Expect(done).To(BeTrue())
+	})
+
+	It("cancels reading", func() {
+		Expect(str.CancelRead(42)).To(Succeed())
+		Expect(qstr.canceledRead).To(BeTrue())
+		Expect(qstr.cancelReadCode).To(Equal(quic.ErrorCode(42)))
+		Expect(qstr.canceledWrite).To(BeFalse())
+	})
+
+	It("cancels writing", func() {
+		Expect(str.CancelWrite(1337)).To(Succeed())
+		Expect(qstr.canceledWrite).To(BeTrue())
+		Expect(qstr.cancelWriteCode).To(Equal(quic.ErrorCode(1337)))
+		Expect(qstr.canceledRead).To(BeFalse())
+	})
+
+	It("resets the stream with an error code", func() {
+		Expect(str.ResetWithCode(42)).To(Succeed())
+		Expect(qstr.canceledRead).To(BeTrue())
+		Expect(qstr.cancelReadCode).To(Equal(quic.ErrorCode(42)))
+		Expect(qstr.canceledWrite).To(BeTrue())
+		Expect(qstr.cancelWriteCode).To(Equal(quic.ErrorCode(42)))
+		Expect(done).To(BeTrue())
+	})
+
+	It("counts data that is buffered, but not sent", func() {
+		unblock := make(chan struct{})
+		qstr.write = func(b []byte) (int, error) {
+			<-unblock
+			return len(b), nil
+		}
+		written := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(written)
+			n, err := str.Write(make([]byte, 1000))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(1000))
+		}()
+		Eventually(str.SendBufferLen).Should(Equal(1000))
+		Consistently(str.SendBufferLen).Should(Equal(1000))
+		close(unblock)
+		Eventually(written).Should(BeClosed())
+		Expect(str.SendBufferLen()).To(BeZero())
+	})
+
+	It("doesn't count data that couldn't be written", func() {
+		qstr.write = func(b []byte) (int, error) { return 400, errors.New("stream reset") }
+		n, err := str.Write(make([]byte, 1000))
+		Expect(err).To(MatchError("stream reset"))
+		Expect(n).To(Equal(400))
+		Expect(str.SendBufferLen()).To(BeZero())
+	})
+
+	It("resets the stream with error code 0", func() {
+		Expect(str.Reset()).To(Succeed())
+		Expect(qstr.cancelReadCode).To(BeZero())
+		Expect(qstr.cancelWriteCode).To(BeZero())
+		Expect(done).To(BeTrue())
+	})
+
+	It("requests a receive window for the stream", func() {
+		wstr := &windowStream{mockStream: qstr}
+		str.Stream = wstr
+		Expect(str.SetReceiveWindow(4 << 20)).To(Succeed())
+		Expect(wstr.window).To(BeEquivalentTo(4 << 20))
+		Expect(str.SetReceiveWindow(0)).To(MatchError("receive window must be positive"))
+		Expect(wstr.window).To(BeEquivalentTo(4 << 20))
+	})
+
+	It("says if the receive window can't be changed", func() {
+		Expect(str.SetReceiveWindow(4 << 20)).To(MatchError(ErrStreamWindowUnsupported))
+	})
+
+	It("refuses error codes that quic-go can't send", func() {
+		Expect(str.CancelRead(1 << 16)).To(MatchError("stream error code too large: 65536"))
+		Expect(str.CancelWrite(1 << 16)).To(MatchError("stream error code too large: 65536"))
+		Expect(str.ResetWithCode(1 << 16)).To(MatchError("stream error code too large: 65536"))
+		Expect(done).To(BeFalse())
+		Expect(qstr.canceledRead).To(BeFalse())
+		Expect(qstr.canceledWrite).To(BeFalse())
+	})
+})
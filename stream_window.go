@@ -0,0 +1,27 @@
+package libp2pquic
+
+import "errors"
+
+// ErrStreamWindowUnsupported is returned by SetReceiveWindow if the QUIC stream doesn't support per-stream flow control windows.
+var ErrStreamWindowUnsupported = errors.New("per-stream receive windows are not supported")
+
+// A receiveWindowSetter is a QUIC stream whose flow control window can be changed after it was opened.
+// quic-go v0.11 only allows configuring the window for all streams (see WithReceiveWindowBounds),
+// so its streams don't implement this interface.
+type receiveWindowSetter interface {
+	SetReceiveWindow(uint64) error
+}
+
+// SetReceiveWindow requests a stream-level flow control window of size bytes for this stream,
+// e.g. to use a large window for a stream carrying bulk data, and a small one for control streams.
+// It returns ErrStreamWindowUnsupported if the window can't be changed, which is always the case with quic-go v0.11.
+func (s *stream) SetReceiveWindow(size uint64) error {
+	if size == 0 {
+		return errors.New("receive window must be positive")
+	}
+	setter, ok := s.Stream.(receiveWindowSetter)
+	if !ok {
+		return ErrStreamWindowUnsupported
+	}
+	return setter.SetReceiveWindow(size)
+}
@@ -0,0 +1,31 @@
+package libp2pquic
+
+import "time"
+
+// EstablishmentTimings records when the stages of dialing a connection completed.
+type EstablishmentTimings struct {
+	// Start is when the dial started.
+	Start time.Time
+	// SocketReady is when the UDP socket used for dialing was set up.
+	SocketReady time.Time
+	// FirstPacketReceived is when the first packet was received from the peer,
+	// i.e. when the first flight of the handshake completed.
+	FirstPacketReceived time.Time
+	// HandshakeComplete is when the handshake completed.
+	HandshakeComplete time.Time
+}
+
+// SocketSetup returns how long it took to set up the UDP socket.
+func (t *EstablishmentTimings) SocketSetup() time.Duration {
+	return t.SocketReady.Sub(t.Start)
+}
+
+// FirstFlight returns how long it took from sending the first packet until receiving the first packet from the peer.
+func (t *EstablishmentTimings) FirstFlight() time.Duration {
+	return t.FirstPacketReceived.Sub(t.SocketReady)
+}
+
+// Handshake returns how long the handshake took.
+func (t *EstablishmentTimings) Handshake() time.Duration {
+	return t.HandshakeComplete.Sub(t.SocketReady)
+}
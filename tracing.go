@@ -0,0 +1,59 @@
+package libp2pquic
+
+import (
+	"context"
+)
+
+// A Tracer starts the spans emitted for dials.
+// Its methods mirror the OpenTelemetry tracing API, so that an OpenTelemetry (or OpenCensus) tracer
+// can be plugged in using a thin adapter.
+type Tracer interface {
+	// Start starts a span.
+	// The returned context carries the span, such that spans started using it are children of the span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// A Span is a traced operation.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// The names of the spans emitted for dials.
+const (
+	// The span covering a whole dial.
+	// It carries the attributes peer.id, net.peer.addr, quic.version and outcome.
+	dialSpanName = "quic.dial"
+	// The span covering the handshake of a dial, a child of the dial span.
+	handshakeSpanName = "quic.tls_handshake"
+)
+
+// quic-go v0.11 only supports a single QUIC version, and doesn't expose the version negotiated for a session.
+const quicVersion = "draft-19"
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+func (noopSpan) RecordError(error)                {}
+func (noopSpan) End()                             {}
+
+// startSpan starts a span using the configured tracer.
+// If no tracer is configured, the span does nothing.
+func (t *transport) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if t.config.tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return t.config.tracer.Start(ctx, name)
+}
+
+// endSpan records the outcome of an operation and ends the span.
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttribute("outcome", "failure")
+	} else {
+		span.SetAttribute("outcome", "success")
+	}
+	span.End()
+}
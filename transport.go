@@ -2,12 +2,17 @@ package libp2pquic
 
 import (
 	"context"
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
 	"net"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	ic "github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/peer"
@@ -16,12 +21,13 @@ import (
 	quic "github.com/lucas-clemente/quic-go"
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr-net"
-	"github.com/whyrusleeping/mafmt"
 )
 
+var quicDialContext = quic.DialContext
+
 var quicConfig = &quic.Config{
 	MaxIncomingStreams:                    1000,
-	MaxIncomingUniStreams:                 -1,              // disable unidirectional streams
+	MaxIncomingUniStreams:                 -1,              // only enabled with control streams, see WithControlStreams
 	MaxReceiveStreamFlowControlWindow:     3 * (1 << 20),   // 3 MB
 	MaxReceiveConnectionFlowControlWindow: 4.5 * (1 << 20), // 4.5 MB
 	AcceptCookie: func(clientAddr net.Addr, cookie *quic.Cookie) bool {
@@ -31,81 +37,544 @@ var quicConfig = &quic.Config{
 	KeepAlive: true,
 }
 
+// ErrPortRangeExhausted is returned when no free port is available in the configured ephemeral port range.
+var ErrPortRangeExhausted = errors.New("no free port in ephemeral port range")
+
+// A ConnManager manages the sockets used for dialing.
+// It can be shared between multiple transports (see WithConnManager),
+// such that their dials use the same sockets.
+type ConnManager struct {
+	connManager *connManager
+}
+
+// NewConnManager creates a new ConnManager.
+func NewConnManager() *ConnManager {
+	return &ConnManager{connManager: &connManager{diagnostics: newSocketDiagnostics()}}
+}
+
+// A reuseConn is a socket that is shared by all dials of the same address family.
+// It is closed as soon as it isn't used by any dial any more.
+// If a socket budget is configured (see WithReuseSocketBudget), unused sockets are kept open,
+// until they are reclaimed based on their usage score.
+type reuseConn struct {
+	*trackingConn
+	refCount int
+
+	// see scoreAt
+	score    float64
+	lastUsed time.Time
+}
+
 type connManager struct {
 	mutex sync.Mutex
 
-	connIPv4 net.PacketConn
-	connIPv6 net.PacketConn
+	// The range of local ports used for dialing.
+	// If maxPort is 0, the OS chooses the port.
+	minPort, maxPort int
+	// The local IP address used for dialing.
+	// If nil, the unspecified address is used.
+	sourceIP net.IP
+
+	reuseConns map[string]*reuseConn // keyed by reuseKey
+
+	// onRefCountChange is called every time the reference count of a reuseConn changes.
+	onRefCountChange func(network string, count int, stack string)
+	// recvErr enables IP_RECVERR on all sockets, see WithPortUnreachableDetection.
+	recvErr bool
+	// The network namespace sockets are created in, see WithNetNamespace.
+	// Only used if useNetNamespace is set.
+	netNamespace    int
+	useNetNamespace bool
+	// dualStack makes IPv4 and IPv6 dials use a single dual-stack socket, see WithDualStack.
+	dualStack bool
+	// The number of sockets kept open, see WithReuseSocketBudget.
+	// If 0, sockets are closed as soon as they aren't used any more.
+	socketBudget int
+	// The errors of all sockets are reported here, see SocketDiagnostics.
+	diagnostics *socketDiagnostics
+	// If set, packets sent on all sockets are dropped and delayed, see WithChaos.
+	chaos *ChaosParams
+	// Called for every new socket, see WithRawSocketOptions.
+	rawSocketOptions func(network string, fd uintptr) error
+	// The number of sockets the packets sent are spread across, see WithWriteFanOut.
+	// If 0 or 1, a single socket is used.
+	writeFanOut int
+	// counts the read buffers of the fan-out sockets holding a packet, see TransportStats
+	readBuffers readBufferCounter
+}
+
+// reuseKey returns the key of the socket shared by all dials of the network using the same DSCP, shard and reuse peer.
+func reuseKey(network string, dscp uint8, shard int, p peer.ID) string {
+	key := network
+	if dscp != 0 {
+		key += fmt.Sprintf("/dscp-%d", dscp)
+	}
+	if shard != noShard {
+		key += fmt.Sprintf("/shard-%d", shard)
+	}
+	if p != "" {
+		key += "/peer-" + p.Pretty()
+	}
+	return key
 }
 
-func (c *connManager) GetConnForAddr(network string) (net.PacketConn, error) {
+// GetConnForAddr returns the socket shared by all dials of the network using the same DSCP and shard (see WithReuseSharding).
+// If p is not empty, the socket is only shared by dials to p (see ReusePerPeer).
+// The release function must be called as soon as the socket isn't used by the dial
+// (and the connection resulting from it) any more.
+func (c *connManager) GetConnForAddr(network string, dscp uint8, shard int, p peer.ID) (pconn *trackingConn, release func(), err error) {
+	pconn, _, release, err = c.getConnForAddr(network, dscp, shard, p, "")
+	return pconn, release, err
+}
+
+// getConnForAddr is like GetConnForAddr, but also says if the socket was already used by another dial.
+// If affinity is not empty, the socket is shared by all dials using the same affinity key (see WithDialAffinity),
+// independent of the shard and p.
+func (c *connManager) getConnForAddr(network string, dscp uint8, shard int, p peer.ID, affinity string) (pconn *trackingConn, reused bool, release func(), err error) {
+	if network != "udp4" && network != "udp6" {
+		return nil, false, nil, fmt.Errorf("unsupported network: %s", network)
+	}
+	network = c.socketNetwork(network)
+
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	switch network {
-	case "udp4":
-		if c.connIPv4 != nil {
-			return c.connIPv4, nil
+	if c.reuseConns == nil {
+		c.reuseConns = make(map[string]*reuseConn)
+	}
+	key := reuseKey(network, dscp, shard, p)
+	if affinity != "" {
+		key = affinityReuseKey(network, dscp, affinity)
+	}
+	rconn, ok := c.reuseConns[key]
+	if !ok {
+		if c.socketBudget > 0 {
+			c.enforceSocketBudget(1)
 		}
-		var err error
-		c.connIPv4, err = c.createConn(network, "0.0.0.0:0")
-		return c.connIPv4, err
-	case "udp6":
-		if c.connIPv6 != nil {
-			return c.connIPv6, nil
+		conn, err := c.createConn(network, c.localIP(network), dscp)
+		if err != nil {
+			return nil, false, nil, err
 		}
-		var err error
-		c.connIPv6, err = c.createConn(network, ":0")
-		return c.connIPv6, err
+		rconn = &reuseConn{trackingConn: conn}
+		c.reuseConns[key] = rconn
+	}
+	rconn.refCount++
+	rconn.use(time.Now())
+	c.refCountChanged(network, rconn.refCount)
+	var once sync.Once
+	return rconn.trackingConn, ok, func() { once.Do(func() { c.releaseConn(network, key, rconn) }) }, nil
+}
+
+func (c *connManager) releaseConn(network, key string, rconn *reuseConn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	rconn.refCount--
+	c.refCountChanged(network, rconn.refCount)
+	if rconn.refCount > 0 {
+		return
+	}
+	if c.socketBudget > 0 && c.reuseConns[key] == rconn {
+		c.enforceSocketBudget(0)
+		return
+	}
+	rconn.Close()
+	if c.reuseConns[key] == rconn {
+		delete(c.reuseConns, key)
+	}
+}
+
+// refCountChanged calls the onRefCountChange callback, if set.
+// It must be called with the mutex held.
+func (c *connManager) refCountChanged(network string, count int) {
+	if c.onRefCountChange == nil {
+		return
+	}
+	c.onRefCountChange(network, count, stackHint(1))
+}
+
+// stackHint returns a hint of the call stack, skipping skip frames.
+// A skip of 0 starts at the function calling stackHint.
+func stackHint(skip int) string {
+	pcs := make([]uintptr, 8)
+	n := runtime.Callers(skip+2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var sb strings.Builder
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&sb, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return sb.String()
+}
+
+// NewConnForAddr creates a new socket that isn't shared with any other dial.
+// The release function closes the socket.
+func (c *connManager) NewConnForAddr(network string, dscp uint8) (pconn *trackingConn, release func(), err error) {
+	switch network {
+	case "udp4", "udp6":
+		network = c.socketNetwork(network)
+		conn, err := c.createConn(network, c.localIP(network), dscp)
+		if err != nil {
+			return nil, nil, err
+		}
+		return conn, func() { conn.Close() }, nil
 	default:
-		return nil, fmt.Errorf("unsupported network: %s", network)
+		return nil, nil, fmt.Errorf("unsupported network: %s", network)
 	}
 }
 
-func (c *connManager) createConn(network, host string) (net.PacketConn, error) {
-	addr, err := net.ResolveUDPAddr(network, host)
+// localIP returns the IP address that sockets for network are bound to.
+// The source IP is only used if it belongs to the address family of the network.
+func (c *connManager) localIP(network string) net.IP {
+	isIPv4 := c.sourceIP != nil && c.sourceIP.To4() != nil
+	switch {
+	case network == "udp4" && isIPv4:
+		return c.sourceIP
+	case network == "udp6" && c.sourceIP != nil && !isIPv4:
+		return c.sourceIP
+	case network == "udp4":
+		return net.IPv4zero
+	default:
+		return net.IPv6unspecified
+	}
+}
+
+func (c *connManager) createConn(network string, ip net.IP, dscp uint8) (*trackingConn, error) {
+	conn, err := c.listenUDP(network, ip)
 	if err != nil {
 		return nil, err
 	}
-	return net.ListenUDP(network, addr)
+	if err := c.configureSocket(conn, network, dscp); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var pconn net.PacketConn = conn
+	if c.writeFanOut > 1 {
+		fconn, err := c.createFanOutConn(conn, network, dscp)
+		if err != nil {
+			return nil, err
+		}
+		pconn = fconn
+	}
+	if c.chaos != nil {
+		pconn = newChaosConn(pconn, *c.chaos)
+	}
+	tconn := newTrackingConn(pconn)
+	tconn.recvErr = c.recvErr
+	tconn.diagnostics = c.diagnostics
+	return tconn, nil
+}
+
+// configureSocket sets the socket options of a new socket.
+func (c *connManager) configureSocket(conn *net.UDPConn, network string, dscp uint8) error {
+	if dscp != 0 {
+		if err := setDSCP(conn, network, dscp); err != nil {
+			return err
+		}
+	}
+	if c.recvErr {
+		if err := enableRecvErr(conn, network); err != nil {
+			return err
+		}
+	}
+	if c.rawSocketOptions != nil {
+		if err := applyRawSocketOptions(conn, network, c.rawSocketOptions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *connManager) listenUDP(network string, ip net.IP) (*net.UDPConn, error) {
+	var conn *net.UDPConn
+	err := c.inNamespace(func() error {
+		var err error
+		conn, err = c.listenUDPInRange(network, ip)
+		return err
+	})
+	return conn, err
+}
+
+// inNamespace calls fn in the network namespace sockets are created in, see WithNetNamespace.
+func (c *connManager) inNamespace(fn func() error) error {
+	if !c.useNetNamespace {
+		return fn()
+	}
+	return inNetNamespace(c.netNamespace, fn)
+}
+
+// bindUDP creates a socket bound to laddr.
+// With write fan-out, SO_REUSEPORT is set, so that more sockets can be bound to the address later.
+func (c *connManager) bindUDP(network string, laddr *net.UDPAddr) (*net.UDPConn, error) {
+	if c.writeFanOut > 1 {
+		return listenReusePort(network, laddr)
+	}
+	return net.ListenUDP(network, laddr)
+}
+
+func (c *connManager) listenUDPInRange(network string, ip net.IP) (*net.UDPConn, error) {
+	if c.maxPort == 0 {
+		return c.bindUDP(network, &net.UDPAddr{IP: ip})
+	}
+	for port := c.minPort; port <= c.maxPort; port++ {
+		conn, err := c.bindUDP(network, &net.UDPAddr{IP: ip, Port: port})
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, ErrPortRangeExhausted
 }
 
 // The Transport implements the tpt.Transport interface for QUIC connections.
 type transport struct {
+	config      *config
 	privKey     ic.PrivKey
 	localPeer   peer.ID
 	tlsConf     *tls.Config
 	connManager *connManager
+	memory      *memoryManager
+	// nil if dial coalescing is disabled
+	dialCoalescer *dialCoalescer
+	// nil if caching is disabled
+	certCache *certCache
+	// nil if qlog is disabled
+	qlogger *qlogger
+	// the QUIC configs used for dialing and listening
+	dialConfig, listenConfig *quic.Config
+	// the memory reserved for the receive buffers of a connection, see connReceiveBufferSize
+	receiveBufferSize int64
+	// the data written to and read from the streams of all connections, see Stats
+	streamBytes byteCounts
+	// the bytes sent and received on the wire by connections that were closed, see Stats
+	closedWireBytes byteCounts
+	// see Subscribe
+	events eventBus
+	// shared by all connections, nil if the bandwidth is not limited, see WithGlobalBandwidthLimit
+	bandwidthLimit *pacer
+	// nil if dialed connections are not pooled, see WithConnPool
+	connPool *connPool
+	// stops calling the stats callback, nil if no callback is set, see WithStatsInterval
+	stopStatsReporter func()
+	// nil if host certificate changes are not reported, see OnHostCertChange
+	hostCerts *hostCertTracker
+	// nil if handshakes are not timed, see WithHandshakeTiming
+	handshakeTimer *handshakeTimer
+	// Set to 1 while the transport is draining, see SetDraining.
+	// Must be accessed atomically.
+	draining int32
+	// The number of dials in progress, see Shutdown.
+	// Must be accessed atomically.
+	dialsInProgress int32
+	// The number of peers that used an unsupported key type, see TransportStats.
+	// Must be accessed atomically.
+	unsupportedRemoteKeyTypes uint64
+
+	// Generates tlsConf on the first Dial or Listen, nil unless the certificate is generated lazily (see WithLazyCertificate).
+	// tlsConf is nil until then.
+	genTLSConf   func() (*tls.Config, error)
+	tlsConfMutex sync.Mutex
+
+	connsMutex sync.Mutex
+	conns      map[peer.ID]map[*conn]struct{}
+
+	listenersMutex sync.Mutex
+	numListeners   int
+
+	// the listeners on a fixed port, keyed by listenKey
+	listenAddrsMutex sync.Mutex
+	listenAddrs      map[string]*listener
 }
 
 var _ tpt.Transport = &transport{}
 
-// NewTransport creates a new QUIC transport
-func NewTransport(key ic.PrivKey) (tpt.Transport, error) {
-	localPeer, err := peer.IDFromPrivateKey(key)
+// NewTransport creates a new QUIC transport.
+// It returns an *UnsupportedKeyTypeError if the key can't be used for TLS.
+func NewTransport(key ic.PrivKey, opts ...Option) (tpt.Transport, error) {
+	if err := checkKeyType(key.Type()); err != nil {
+		return nil, err
+	}
+	return newTransport(key, key.GetPublic(), func(conf *config) (*tls.Config, error) {
+		return generateConfig(key.GetPublic(), func(conf *config) (*tls.Certificate, error) { return keyToCertificate(key, conf) }, conf)
+	}, opts...)
+}
+
+// NewTransportFromSigner creates a new QUIC transport for a host key that is only accessible
+// via a crypto.Signer, for example a key stored in an HSM or a KMS.
+// Since there's no libp2p private key, LocalPrivateKey returns nil for connections of this transport.
+func NewTransportFromSigner(signer crypto.Signer, opts ...Option) (tpt.Transport, error) {
+	pubKey, err := toLibp2pPubKey(signer.Public())
 	if err != nil {
 		return nil, err
 	}
-	tlsConf, err := generateConfig(key)
+	return newTransport(nil, pubKey, func(conf *config) (*tls.Config, error) {
+		return generateConfig(pubKey, func(conf *config) (*tls.Certificate, error) { return generateCertificate(signer, conf) }, conf)
+	}, opts...)
+}
+
+// NewTransportWithTLSConfig creates a new QUIC transport that uses a custom tls.Config,
+// for example for custom certificate provisioning.
+// The first certificate of the config must be a certificate chain belonging to the host key.
+// The peer's certificate chain is always verified to belong to a libp2p peer (and to the peer ID when dialing),
+// before the config's VerifyPeerCertificate is called.
+// Since libp2p certificates are self-signed, InsecureSkipVerify and ClientAuth are overwritten.
+// Options that affect the generated certificate (e.g. WithDNSNames) are ignored.
+func NewTransportWithTLSConfig(key ic.PrivKey, tlsConf *tls.Config, opts ...Option) (tpt.Transport, error) {
+	if err := checkKeyType(key.Type()); err != nil {
+		return nil, err
+	}
+	return newTransport(key, key.GetPublic(), func(conf *config) (*tls.Config, error) {
+		return customConfig(tlsConf, key.GetPublic(), conf.peerVerifiers)
+	}, opts...)
+}
+
+func newTransport(key ic.PrivKey, pubKey ic.PubKey, genTLSConf func(*config) (*tls.Config, error), opts ...Option) (tpt.Transport, error) {
+	conf, err := newConfig(opts...)
 	if err != nil {
 		return nil, err
 	}
+	localPeer, err := peer.IDFromPublicKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	var tlsConf *tls.Config
+	if !conf.lazyCertificate {
+		tlsConf, err = genTLSConf(conf)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	return &transport{
+	t := &transport{
+		config:      conf,
 		privKey:     key,
 		localPeer:   localPeer,
 		tlsConf:     tlsConf,
-		connManager: &connManager{},
-	}, nil
+		memory:      &memoryManager{limit: conf.memoryLimit},
+		conns:       make(map[peer.ID]map[*conn]struct{}),
+		listenAddrs: make(map[string]*listener),
+	}
+	if conf.lazyCertificate {
+		t.genTLSConf = func() (*tls.Config, error) { return genTLSConf(conf) }
+	}
+	if conf.connPoolSize > 0 {
+		t.connPool = newConnPool(conf.connPoolSize, conf.connPoolIdleTimeout)
+	}
+	if conf.globalBandwidthLimit > 0 {
+		t.bandwidthLimit = &pacer{}
+		t.bandwidthLimit.SetRate(conf.globalBandwidthLimit)
+	}
+	t.listenConfig = quicConfig
+	if conf.maxReceiveWindow > 0 {
+		t.listenConfig = withReceiveWindowBounds(quicConfig, conf.maxReceiveWindow)
+	}
+	if conf.controlStreams {
+		t.listenConfig = withControlStreams(t.listenConfig)
+	}
+	t.receiveBufferSize = int64(t.listenConfig.MaxReceiveConnectionFlowControlWindow)
+	t.dialConfig = t.listenConfig
+	if len(conf.dialVersions) > 0 {
+		dialConfig := *t.listenConfig
+		dialConfig.Versions = conf.dialVersions
+		t.dialConfig = &dialConfig
+	}
+	if conf.connManager != nil {
+		t.connManager = conf.connManager.connManager
+	} else {
+		t.connManager = &connManager{
+			minPort:          conf.minPort,
+			maxPort:          conf.maxPort,
+			sourceIP:         conf.sourceIP,
+			onRefCountChange: conf.onReuseRefCountChange,
+			recvErr:          conf.detectPortUnreachable,
+			netNamespace:     conf.netNamespace,
+			useNetNamespace:  conf.useNetNamespace,
+			dualStack:        conf.dualStack,
+			socketBudget:     conf.reuseSocketBudget,
+			diagnostics:      newSocketDiagnostics(),
+			chaos:            conf.chaos,
+			rawSocketOptions: conf.rawSocketOptions,
+			writeFanOut:      conf.writeFanOut,
+		}
+	}
+	if conf.coalesceDials {
+		t.dialCoalescer = newDialCoalescer()
+	}
+	if !conf.disableCertCache {
+		t.certCache = newCertCache()
+	}
+	if conf.qlogDir != "" {
+		t.qlogger = newQlogger(conf.qlogDir)
+	}
+	if conf.timeHandshakes {
+		t.handshakeTimer = newHandshakeTimer()
+	}
+	if conf.onHostCertChange != nil {
+		t.hostCerts = newHostCertTracker(conf.onHostCertChange)
+	}
+	if conf.onStats != nil {
+		t.stopStatsReporter = t.startStatsReporter(conf.statsInterval, conf.onStats)
+	}
+	return t, nil
 }
 
 // Dial dials a new QUIC connection
 func (t *transport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (tpt.CapableConn, error) {
-	network, host, err := manet.DialArgs(raddr)
+	// Count the dial before checking for draining, so that Shutdown waits for it.
+	atomic.AddInt32(&t.dialsInProgress, 1)
+	defer atomic.AddInt32(&t.dialsInProgress, -1)
+	if t.isDraining() {
+		return nil, ErrDraining
+	}
+	// Connections dialed without the pins are skipped, and the peer is dialed instead.
+	pins := certificatePins(ctx)
+	if t.connPool != nil {
+		if v := t.connPool.Get(p, pins); v != nil {
+			return v, nil
+		}
+	}
+	if t.config.sessionSharing {
+		if c := t.sharedConnTo(p, pins); c != nil {
+			return c, nil
+		}
+	}
+	ctx, span := t.startSpan(ctx, dialSpanName)
+	span.SetAttribute("peer.id", p.Pretty())
+	span.SetAttribute("net.peer.addr", raddr.String())
+	span.SetAttribute("quic.version", quicVersion)
+	t.events.Publish(Event{Type: EventDialStarted, Peer: p, Addr: raddr})
+	if t.config.metrics != nil {
+		t.config.metrics.DialStarted()
+	}
+	var c tpt.CapableConn
+	var err error
+	if t.dialCoalescer != nil {
+		c, err = t.dialCoalescer.Dial(ctx, raddr, p, t.dialConn)
+	} else {
+		c, err = t.dialConn(ctx, raddr, p)
+	}
+	endSpan(span, err)
+	if t.config.metrics != nil {
+		t.config.metrics.DialFinished(err)
+	}
 	if err != nil {
-		return nil, err
+		t.events.Publish(Event{Type: EventDialFailed, Peer: p, Addr: raddr, Err: err})
+	} else {
+		t.events.Publish(Event{Type: EventDialSucceeded, Peer: p, Addr: raddr})
+		if qc, ok := c.(*conn); ok && t.connPool != nil {
+			if v := t.connPool.Put(qc); v != nil {
+				c = v
+			}
+		}
 	}
-	pconn, err := t.connManager.GetConnForAddr(network)
+	return c, err
+}
+
+func (t *transport) dialConn(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (tpt.CapableConn, error) {
+	timings := &EstablishmentTimings{Start: time.Now()}
+	network, host, err := manet.DialArgs(raddr)
 	if err != nil {
 		return nil, err
 	}
@@ -113,58 +582,456 @@ func (t *transport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (tp
 	if err != nil {
 		return nil, err
 	}
+	if err := t.checkAddressFilter(addr); err != nil {
+		return nil, err
+	}
 	var remotePubKey ic.PubKey
-	tlsConf := t.tlsConf.Clone()
+	pins := certificatePins(ctx)
+	baseTLSConf, err := t.getTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	tlsConf := baseTLSConf.Clone()
 	// We need to check the peer ID in the VerifyPeerCertificate callback.
 	// The tls.Config it is also used for listening, and we might also have concurrent dials.
 	// Clone it so we can check for the specific peer ID we're dialing here.
-	tlsConf.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
-		chain := make([]*x509.Certificate, len(rawCerts))
-		for i := 0; i < len(rawCerts); i++ {
-			cert, err := x509.ParseCertificate(rawCerts[i])
-			if err != nil {
-				return err
-			}
-			chain[i] = cert
+	verify := func(rawCerts [][]byte) error {
+		if err := checkChainSize(rawCerts, t.config.maxCertChainLen, t.config.maxCertChainBytes); err != nil {
+			return reject(RejectReasonChainTooLarge, err)
 		}
-		var err error
-		remotePubKey, err = getRemotePubKey(chain)
+		chain, err := parseCertChain(rawCerts)
 		if err != nil {
-			return err
+			return reject(RejectReasonInvalidCertificate, err)
+		}
+		if err := checkKeySizes(chain, t.config.maxRSAKeySize); err != nil {
+			return reject(RejectReasonKeyTooLarge, err)
+		}
+		remotePubKey, err = t.getRemotePubKey(chain)
+		if err != nil {
+			return reject(RejectReasonInvalidCertificate, err)
 		}
 		if !p.MatchesPublicKey(remotePubKey) {
-			return errors.New("peer IDs don't match")
+			return reject(RejectReasonPeerIDMismatch, errors.New("peer IDs don't match"))
+		}
+		if err := checkTrustedCA(chain, t.config.trustedCAs); err != nil {
+			return reject(RejectReasonUntrustedCA, err)
+		}
+		if err := checkCertificatePins(chain, pins); err != nil {
+			return reject(RejectReasonPinMismatch, err)
+		}
+		if t.config.onPeerVerified != nil {
+			t.config.onPeerVerified(p, addr)
+		}
+		if baseTLSConf.VerifyPeerCertificate != nil {
+			return baseTLSConf.VerifyPeerCertificate(rawCerts, nil)
 		}
 		return nil
 	}
-	sess, err := quic.DialContext(ctx, pconn, addr, host, tlsConf, quicConfig)
+	tlsConf.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		start := time.Now()
+		err := verify(rawCerts)
+		t.handshakeTimer.recordVerification(start)
+		if err != nil {
+			t.reportRejection(err, addr)
+		}
+		return err
+	}
+	dscp, err := t.dscpForDial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dialAddr, err := dialAddrForPacketSize(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.memory.Reserve(t.receiveBufferSize); err != nil {
+		return nil, err
+	}
+	var pconn *trackingConn
+	var reused bool
+	var releaseConn func()
+	if affinity := dialAffinity(ctx); t.config.disableReuse && affinity == "" {
+		pconn, releaseConn, err = t.connManager.NewConnForAddr(network, dscp)
+	} else {
+		pconn, reused, releaseConn, err = t.connManager.getConnForAddr(network, dscp, t.shardForDial(ctx), t.reusePeer(p), affinity)
+	}
 	if err != nil {
+		t.memory.Release(t.receiveBufferSize)
 		return nil, err
 	}
-	localMultiaddr, err := toQuicMultiaddr(sess.LocalAddr())
+	if t.config.onDialSocketSelected != nil {
+		laddr, _ := pconn.LocalAddr().(*net.UDPAddr)
+		t.config.onDialSocketSelected(network, laddr, reused)
+	}
+	wireBytes, stopWireCount := pconn.wire.Track(addr)
+	// release cleans up after a failed dial
+	release := func() {
+		t.memory.Release(t.receiveBufferSize)
+		stopWireCount()
+		releaseConn()
+	}
+	timings.SocketReady = time.Now()
+	watch, stopWatch := pconn.Watch(addr)
+	handshakeCtx, handshakeSpan := t.startSpan(ctx, handshakeSpanName)
+	// abort the dial as soon as the peer's host refuses a packet
+	handshakeCtx, cancel := context.WithCancel(handshakeCtx)
+	go func() {
+		select {
+		case <-watch.Refused():
+			cancel()
+		case <-handshakeCtx.Done():
+		}
+	}()
+	sess, err := t.dial(handshakeCtx, pconn, dialAddr, host, tlsConf)
+	cancel()
+	endSpan(handshakeSpan, err)
+	stopWatch()
+	timings.HandshakeComplete = time.Now()
+	timings.FirstPacketReceived = watch.FirstReceived()
+	if err != nil {
+		release()
+		if watch.IsRefused() {
+			return nil, ErrConnRefused
+		}
+		return nil, handshakeTimeoutError(err, watch)
+	}
+	t.handshakeTimer.recordHandshake(timings.Handshake())
+	localMultiaddr, err := t.localMultiaddr(sess.LocalAddr())
 	if err != nil {
+		sess.Close()
+		release()
 		return nil, err
 	}
-	return &conn{
-		sess:            sess,
-		transport:       t,
-		privKey:         t.privKey,
-		localPeer:       t.localPeer,
-		localMultiaddr:  localMultiaddr,
-		remotePubKey:    remotePubKey,
-		remotePeerID:    p,
-		remoteMultiaddr: raddr,
-	}, nil
+	go func() {
+		<-sess.Context().Done()
+		stopWireCount()
+		releaseConn()
+	}()
+	version := watch.Version()
+	c := &conn{
+		sess:                  sess,
+		transport:             t,
+		privKey:               t.privKey,
+		localPeer:             t.localPeer,
+		localMultiaddr:        localMultiaddr,
+		remotePubKey:          remotePubKey,
+		remotePeerID:          p,
+		remoteMultiaddr:       raddr,
+		pings:                 newPingManager(),
+		uniStreams:            make(chan quic.ReceiveStream, uniStreamQueueLen),
+		maxIncomingUniStreams: int32(t.config.maxIncomingUniStreams),
+		controlStreams:        t.config.controlStreams,
+		streamQueue:           t.newStreamQueue(),
+		timings:               timings,
+		openedAt:              time.Now(),
+		remoteConnID:          watch.SrcConnID(),
+		version:               version,
+		peerCertExpiry:        chainExpiry(sess.ConnectionState().PeerCertificates),
+		silencer:              &pconn.silencer,
+		wire:                  wireBytes,
+	}
+	if t.config.metrics != nil {
+		t.config.metrics.HandshakeCompleted(false)
+	}
+	t.addConn(c)
+	if t.config.postDialPathCheckTimeout > 0 {
+		if err := c.checkPath(ctx, t.config.postDialPathCheckTimeout); err != nil {
+			c.closeWithError(0, err)
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// dial dials a QUIC session, retrying on transient errors if configured to do so.
+func (t *transport) dial(ctx context.Context, pconn net.PacketConn, addr net.Addr, host string, tlsConf *tls.Config) (quic.Session, error) {
+	backoff := t.config.dialRetryBackoff
+	for i := 0; ; i++ {
+		sess, err := quicDialContext(ctx, pconn, addr, host, tlsConf, t.dialConfig)
+		if err == nil || i >= t.config.dialRetries || !isTransientError(ctx, err) {
+			return sess, err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// isTransientError says if a dial that failed with err might succeed when retried.
+func isTransientError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	nerr, ok := err.(net.Error)
+	return ok && (nerr.Timeout() || nerr.Temporary())
+}
+
+// newStreamQueue returns the stream accept queue for a new connection,
+// or nil if the depth of the queue is not limited.
+func (t *transport) newStreamQueue() *streamQueue {
+	if t.config.streamQueueDepth == 0 {
+		return nil
+	}
+	return newStreamQueue(t.config.streamQueueDepth)
+}
+
+// addConn registers an active connection.
+// Memory for its receive buffers must already have been reserved.
+// It is removed from the registry (and the memory is released) as soon as the session is closed.
+func (t *transport) addConn(c *conn) {
+	t.connsMutex.Lock()
+	conns, ok := t.conns[c.remotePeerID]
+	if !ok {
+		conns = make(map[*conn]struct{})
+		t.conns[c.remotePeerID] = conns
+	}
+	conns[c] = struct{}{}
+	t.connsMutex.Unlock()
+
+	if t.hostCerts != nil {
+		t.hostCerts.Check(c.remotePeerID, c.RemoteAddr(), c.sess.ConnectionState().PeerCertificates)
+	}
+	if c.controlStreams {
+		go c.handleControlStreams()
+	}
+	if c.streamQueue != nil {
+		go c.streamQueue.run(c.sess)
+	}
+
+	var qlog *connLog
+	if t.qlogger != nil {
+		var err error
+		qlog, err = t.qlogger.NewConnLog(c)
+		if err == nil {
+			qlog.Event("transport:connection_started", map[string]interface{}{
+				"local_address":  c.localMultiaddr.String(),
+				"remote_address": c.remoteMultiaddr.String(),
+				"remote_peer":    c.remotePeerID.Pretty(),
+			})
+		}
+	}
+
+	stopExpiryWarning := t.watchCertExpiry(c)
+	if t.config.keepAlivePeriod > 0 {
+		go c.keepAlive(t.config.keepAlivePeriod)
+	}
+	if t.config.adaptiveIdleTimeout > 0 {
+		go c.closeWhenIdle(t.config.adaptiveIdleTimeout)
+	}
+	c.publish(EventConnOpened)
+	if t.config.metrics != nil {
+		t.config.metrics.ConnOpened()
+	}
+
+	go func() {
+		<-c.sess.Context().Done()
+		closeErr := c.CloseError()
+		stopExpiryWarning()
+		t.removeConn(c)
+		if t.connPool != nil {
+			t.connPool.Remove(c)
+		}
+		c.publishClosed(closeErr)
+		if t.config.metrics != nil {
+			t.config.metrics.ConnClosed()
+		}
+		t.memory.Release(t.receiveBufferSize)
+		if qlog != nil {
+			qlog.Event("transport:connection_closed", map[string]interface{}{
+				"error": closeErr.Error(),
+			})
+			qlog.Close()
+		}
+		c.closeNotifier.Notify(closeErr)
+	}()
+}
+
+func (t *transport) removeConn(c *conn) {
+	t.connsMutex.Lock()
+	defer t.connsMutex.Unlock()
+
+	conns, ok := t.conns[c.remotePeerID]
+	if !ok {
+		return
+	}
+	delete(conns, c)
+	// see Stats
+	t.closedWireBytes.addSent(int(c.wire.Sent()))
+	t.closedWireBytes.addReceived(int(c.wire.Received()))
+	if len(conns) == 0 {
+		delete(t.conns, c.remotePeerID)
+	}
+}
+
+// ReservedMemory returns the amount of memory reserved for the receive buffers of all connections.
+func (t *transport) ReservedMemory() int64 {
+	return t.memory.Reserved()
+}
+
+// CloseConnsToPeer closes all active connections to a peer, using the given application error.
+func (t *transport) CloseConnsToPeer(p peer.ID, code quic.ErrorCode, reason error) error {
+	t.connsMutex.Lock()
+	conns := make([]*conn, 0, len(t.conns[p]))
+	for c := range t.conns[p] {
+		conns = append(conns, c)
+	}
+	t.connsMutex.Unlock()
+
+	var firstErr error
+	for _, c := range conns {
+		if err := c.closeWithError(code, reason); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 // CanDial determines if we can dial to an address
 func (t *transport) CanDial(addr ma.Multiaddr) bool {
-	return mafmt.QUIC.Matches(addr)
+	return t.WhyCannotDial(addr) == nil
+}
+
+// WhyCannotDial returns nil if CanDial accepts the address,
+// and an error describing why the address was rejected otherwise.
+// Like CanDial, it accepts addresses of the form /ip4|ip6/<ip>/udp/<port>/quic.
+func (t *transport) WhyCannotDial(addr ma.Multiaddr) error {
+	if addr == nil || len(addr.Bytes()) == 0 {
+		return errors.New("malformed multiaddr: empty")
+	}
+	protos := addr.Protocols()
+	if code := protos[0].Code; code != ma.P_IP4 && code != ma.P_IP6 {
+		return fmt.Errorf("unsupported address family: %s", protos[0].Name)
+	}
+	if len(protos) < 3 || protos[1].Code != ma.P_UDP || protos[2].Code != ma.P_QUIC {
+		return fmt.Errorf("not a QUIC address: %s", addr)
+	}
+	if len(protos) > 3 {
+		return fmt.Errorf("unexpected components after /quic: %s", addr)
+	}
+	return nil
 }
 
 // Listen listens for new QUIC connections on the passed multiaddr.
 func (t *transport) Listen(addr ma.Multiaddr) (tpt.Listener, error) {
-	return newListener(addr, t, t.localPeer, t.privKey, t.tlsConf)
+	tlsConf, err := t.getTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return t.listenWithRetry(context.Background(), addr, tlsConf, nil)
+}
+
+// A ListenConfig overrides parts of the TLS configuration for a single listener (see ListenWithConfig).
+type ListenConfig struct {
+	// The ALPN protocols supported by the listener.
+	NextProtos []string
+	// If set, clients must use one of these server names (SNI).
+	// This overrides the names used by WithSNIValidation.
+	ServerNames []string
+	// An additional verification of the client's certificate chain.
+	// It is called after the chain was verified to belong to a libp2p peer,
+	// and after the VerifyPeerCertificate of a custom tls.Config (see NewTransportWithTLSConfig).
+	VerifyPeerCertificate func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+}
+
+// ListenWithConfig listens for new QUIC connections on the passed multiaddr,
+// using a TLS configuration that is modified by lc.
+// This allows listeners of the same transport to use different TLS configurations.
+func (t *transport) ListenWithConfig(addr ma.Multiaddr, lc ListenConfig) (tpt.Listener, error) {
+	baseTLSConf, err := t.getTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	tlsConf := baseTLSConf.Clone()
+	if lc.NextProtos != nil {
+		tlsConf.NextProtos = lc.NextProtos
+	}
+	if verify := tlsConf.VerifyPeerCertificate; lc.VerifyPeerCertificate != nil && verify != nil {
+		// The verification of a custom tls.Config (see NewTransportWithTLSConfig) already checks
+		// that the chain belongs to a libp2p peer, and must not be skipped.
+		tlsConf.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			if err := verify(rawCerts, verifiedChains); err != nil {
+				return err
+			}
+			return lc.VerifyPeerCertificate(rawCerts, verifiedChains)
+		}
+	} else if lc.VerifyPeerCertificate != nil {
+		tlsConf.VerifyPeerCertificate = withLibp2pVerification(t.getRemotePubKey, lc.VerifyPeerCertificate)
+	}
+	return t.listenWithRetry(context.Background(), addr, tlsConf, lc.ServerNames)
+}
+
+func (t *transport) listen(addr ma.Multiaddr, tlsConf *tls.Config, serverNames []string) (tpt.Listener, error) {
+	return t.listenOnSocket(addr, nil, tlsConf, serverNames)
+}
+
+// listenOnSocket listens on addr. If pconn is not nil, it is used instead of creating a new socket.
+func (t *transport) listenOnSocket(addr ma.Multiaddr, pconn net.PacketConn, tlsConf *tls.Config, serverNames []string) (tpt.Listener, error) {
+	if t.isDraining() {
+		return nil, ErrDraining
+	}
+	// Hold the lock while creating the listener, so that concurrent duplicate listens are detected.
+	t.listenAddrsMutex.Lock()
+	defer t.listenAddrsMutex.Unlock()
+	key := listenKey(addr)
+	if ln, ok := t.listenAddrs[key]; ok && key != "" {
+		if t.config.duplicateListenPolicy != DuplicateListenShare {
+			return nil, ErrAlreadyListening
+		}
+		ln.refs++
+		return ln, nil
+	}
+	if err := t.reserveListener(); err != nil {
+		return nil, err
+	}
+	ln, err := newListener(addr, pconn, t, t.localPeer, t.privKey, tlsConf, serverNames)
+	if err != nil {
+		t.releaseListener()
+		return nil, err
+	}
+	l := ln.(*listener)
+	if key == "" {
+		// listening on port 0: later listens on the port that was chosen are duplicates
+		key = listenKey(l.localMultiaddr)
+	}
+	if key != "" {
+		l.listenKey = key
+		l.refs = 1
+		t.listenAddrs[key] = l
+	}
+	t.events.Publish(Event{Type: EventListenerOpened, Addr: l.localMultiaddr})
+	return ln, nil
+}
+
+// A TooManyListenersError is returned by Listen when the transport already has the maximum number of listeners
+// (see WithMaxListeners).
+type TooManyListenersError struct {
+	Limit int
+}
+
+func (e *TooManyListenersError) Error() string {
+	return fmt.Sprintf("too many listeners (limit: %d)", e.Limit)
+}
+
+func (t *transport) reserveListener() error {
+	t.listenersMutex.Lock()
+	defer t.listenersMutex.Unlock()
+
+	if t.config.maxListeners > 0 && t.numListeners >= t.config.maxListeners {
+		return &TooManyListenersError{Limit: t.config.maxListeners}
+	}
+	t.numListeners++
+	return nil
+}
+
+func (t *transport) releaseListener() {
+	t.listenersMutex.Lock()
+	t.numListeners--
+	t.listenersMutex.Unlock()
 }
 
 // Proxy returns true if this transport proxies.
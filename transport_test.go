@@ -1,7 +1,18 @@
 package libp2pquic
 
 import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	pb "github.com/libp2p/go-libp2p-core/crypto/pb"
+	"github.com/libp2p/go-libp2p-core/peer"
 	tpt "github.com/libp2p/go-libp2p-core/transport"
+	quic "github.com/lucas-clemente/quic-go"
 	ma "github.com/multiformats/go-multiaddr"
 
 	. "github.com/onsi/ginkgo"
@@ -24,9 +35,425 @@ var _ = Describe("Transport", func() {
 		Expect(t.CanDial(validAddr)).To(BeTrue())
 	})
 
+	It("says why it can't dial an address", func() {
+		Expect(t.(*transport).WhyCannotDial(ma.StringCast("/ip4/127.0.0.1/udp/1234/quic"))).To(Succeed())
+		Expect(t.(*transport).WhyCannotDial(ma.StringCast("/ip6/::1/udp/1234/quic"))).To(Succeed())
+		Expect(t.(*transport).WhyCannotDial(nil)).To(MatchError("malformed multiaddr: empty"))
+		Expect(t.(*transport).WhyCannotDial(ma.StringCast("/dns4/example.com/udp/1234/quic"))).To(MatchError("unsupported address family: dns4"))
+		Expect(t.(*transport).WhyCannotDial(ma.StringCast("/ip4/127.0.0.1/udp/1234"))).To(MatchError("not a QUIC address: /ip4/127.0.0.1/udp/1234"))
+		Expect(t.(*transport).WhyCannotDial(ma.StringCast("/ip4/127.0.0.1/tcp/1234"))).To(MatchError("not a QUIC address: /ip4/127.0.0.1/tcp/1234"))
+		Expect(t.(*transport).WhyCannotDial(ma.StringCast("/ip4/127.0.0.1/udp/1234/quic/ws"))).To(MatchError("unexpected components after /quic: /ip4/127.0.0.1/udp/1234/quic/ws"))
+		Expect(t.CanDial(ma.StringCast("/ip4/127.0.0.1/udp/1234/quic/ws"))).To(BeFalse())
+	})
+
 	It("supports the QUIC protocol", func() {
 		protocols := t.Protocols()
 		Expect(protocols).To(HaveLen(1))
 		Expect(protocols[0]).To(Equal(ma.P_QUIC))
 	})
+
+	Context("ephemeral port range", func() {
+		// getFreePort returns a port that is (very likely) not in use
+		getFreePort := func() int {
+			conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			return conn.LocalAddr().(*net.UDPAddr).Port
+		}
+
+		It("binds to a port in the range", func() {
+			port := getFreePort()
+			cm := &connManager{minPort: port, maxPort: port}
+			conn, release, err := cm.GetConnForAddr("udp4", 0, noShard, "")
+			Expect(err).ToNot(HaveOccurred())
+			defer release()
+			Expect(conn.LocalAddr().(*net.UDPAddr).Port).To(Equal(port))
+		})
+
+		It("errors when the range is exhausted", func() {
+			conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			port := conn.LocalAddr().(*net.UDPAddr).Port
+			cm := &connManager{minPort: port, maxPort: port}
+			_, _, err = cm.GetConnForAddr("udp4", 0, noShard, "")
+			Expect(err).To(MatchError(ErrPortRangeExhausted))
+		})
+
+		It("rejects invalid ranges", func() {
+			_, err := newConfig(WithEphemeralPortRange(2000, 1000))
+			Expect(err).To(MatchError("invalid port range: 2000-1000"))
+			_, err = newConfig(WithEphemeralPortRange(0, 1000))
+			Expect(err).To(HaveOccurred())
+			_, err = newConfig(WithEphemeralPortRange(1000, 70000))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("source IP", func() {
+		It("binds to the source IP", func() {
+			cm := &connManager{sourceIP: net.ParseIP("127.0.0.2")}
+			conn, release, err := cm.GetConnForAddr("udp4", 0, noShard, "")
+			Expect(err).ToNot(HaveOccurred())
+			defer release()
+			addr := conn.LocalAddr().(*net.UDPAddr)
+			Expect(addr.IP.Equal(net.ParseIP("127.0.0.2"))).To(BeTrue())
+			Expect(addr.Port).ToNot(BeZero())
+		})
+
+		It("ignores the source IP for other address families", func() {
+			cm := &connManager{sourceIP: net.ParseIP("127.0.0.2")}
+			Expect(cm.localIP("udp6")).To(Equal(net.IPv6unspecified))
+			cm = &connManager{sourceIP: net.ParseIP("::1")}
+			Expect(cm.localIP("udp4")).To(Equal(net.IPv4zero))
+			Expect(cm.localIP("udp6")).To(Equal(net.ParseIP("::1")))
+		})
+
+		It("rejects the unspecified address", func() {
+			_, err := newConfig(WithSourceIP(net.IPv4zero))
+			Expect(err).To(MatchError("invalid source IP"))
+		})
+	})
+
+	Context("socket budget", func() {
+		use := func(cm *connManager, shard, times int) *trackingConn {
+			var conn *trackingConn
+			for i := 0; i < times; i++ {
+				var release func()
+				var err error
+				conn, release, err = cm.GetConnForAddr("udp4", 0, shard, "")
+				Expect(err).ToNot(HaveOccurred())
+				release()
+			}
+			return conn
+		}
+
+		It("closes sockets when they're not used if no budget is configured", func() {
+			cm := &connManager{}
+			use(cm, 1, 1)
+			Expect(cm.reuseConns).To(BeEmpty())
+		})
+
+		It("reclaims the least used socket first", func() {
+			cm := &connManager{socketBudget: 2}
+			conn1 := use(cm, 1, 3)
+			conn2 := use(cm, 2, 1)
+			// unused sockets are kept open while within the budget
+			Expect(cm.reuseConns).To(HaveLen(2))
+			Expect(use(cm, 1, 1)).To(BeIdenticalTo(conn1))
+
+			// a new socket exceeds the budget
+			_, release, err := cm.GetConnForAddr("udp4", 0, 3, "")
+			Expect(err).ToNot(HaveOccurred())
+			defer release()
+			Expect(cm.reuseConns).To(HaveLen(2))
+			Expect(cm.reuseConns).To(HaveKey(reuseKey("udp4", 0, 1, "")))
+			Expect(cm.reuseConns).ToNot(HaveKey(reuseKey("udp4", 0, 2, "")))
+			_, err = conn2.WriteTo([]byte("foobar"), conn1.LocalAddr())
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("never reclaims sockets in use", func() {
+			cm := &connManager{socketBudget: 1}
+			conn1, release1, err := cm.GetConnForAddr("udp4", 0, 1, "")
+			Expect(err).ToNot(HaveOccurred())
+			conn2, release2, err := cm.GetConnForAddr("udp4", 0, 2, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cm.reuseConns).To(HaveLen(2))
+			Expect(conn2).ToNot(BeIdenticalTo(conn1))
+			// once they're released, the budget is enforced again
+			release1()
+			Expect(cm.reuseConns).To(HaveLen(1))
+			release2()
+			Expect(cm.reuseConns).To(HaveLen(1))
+		})
+
+		It("decays the usage score", func() {
+			now := time.Now()
+			r := &reuseConn{}
+			r.use(now.Add(-reuseScoreHalfLife))
+			r.use(now.Add(-reuseScoreHalfLife))
+			Expect(r.scoreAt(now)).To(BeNumerically("~", 1, 0.001))
+		})
+	})
+
+	Context("reuse sharding", func() {
+		It("uses the same socket for the same shard", func() {
+			cm := &connManager{}
+			conn1, release1, err := cm.GetConnForAddr("udp4", 0, 1, "")
+			Expect(err).ToNot(HaveOccurred())
+			defer release1()
+			conn2, release2, err := cm.GetConnForAddr("udp4", 0, 1, "")
+			Expect(err).ToNot(HaveOccurred())
+			defer release2()
+			Expect(conn2).To(BeIdenticalTo(conn1))
+		})
+
+		It("uses different sockets for different shards", func() {
+			cm := &connManager{}
+			conn1, release1, err := cm.GetConnForAddr("udp4", 0, 1, "")
+			Expect(err).ToNot(HaveOccurred())
+			defer release1()
+			conn2, release2, err := cm.GetConnForAddr("udp4", 0, 2, "")
+			Expect(err).ToNot(HaveOccurred())
+			defer release2()
+			conn3, release3, err := cm.GetConnForAddr("udp4", 0, noShard, "")
+			Expect(err).ToNot(HaveOccurred())
+			defer release3()
+			Expect(conn2.LocalAddr()).ToNot(Equal(conn1.LocalAddr()))
+			Expect(conn3.LocalAddr()).ToNot(Equal(conn1.LocalAddr()))
+			Expect(conn3.LocalAddr()).ToNot(Equal(conn2.LocalAddr()))
+		})
+
+		It("only uses the shard hint if sharding is enabled", func() {
+			ctx := WithShardHint(context.Background(), 3)
+			conf, err := newConfig()
+			Expect(err).ToNot(HaveOccurred())
+			Expect((&transport{config: conf}).shardForDial(ctx)).To(Equal(noShard))
+			conf, err = newConfig(WithReuseSharding())
+			Expect(err).ToNot(HaveOccurred())
+			tr := &transport{config: conf}
+			Expect(tr.shardForDial(ctx)).To(Equal(3))
+			Expect(tr.shardForDial(context.Background())).To(Equal(noShard))
+		})
+	})
+
+	It("pushes stats snapshots until it is shut down", func() {
+		snapshots := make(chan TransportStats, 100)
+		key, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		tr, err := NewTransport(key, WithStatsInterval(10*time.Millisecond, func(s TransportStats) { snapshots <- s }))
+		Expect(err).ToNot(HaveOccurred())
+		atomic.AddUint64(&tr.(*transport).unsupportedRemoteKeyTypes, 1)
+		Eventually(snapshots).Should(Receive(Equal(TransportStats{UnsupportedRemoteKeyTypes: 1})))
+		Eventually(snapshots).Should(Receive())
+
+		Expect(tr.(*transport).Shutdown(context.Background())).To(Succeed())
+		// drain the snapshots pushed before Shutdown returned
+		for len(snapshots) > 0 {
+			<-snapshots
+		}
+		Consistently(snapshots, 50*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("rejects invalid stats intervals", func() {
+		_, err := newConfig(WithStatsInterval(0, func(TransportStats) {}))
+		Expect(err).To(MatchError("stats interval must be positive"))
+		_, err = newConfig(WithStatsInterval(time.Second, nil))
+		Expect(err).To(MatchError("stats callback must not be nil"))
+	})
+
+	It("drops the oldest events when a subscriber doesn't keep up", func() {
+		var bus eventBus
+		events, unsubscribe := bus.Subscribe()
+		for i := 0; i < eventBufferSize+10; i++ {
+			bus.Publish(Event{Type: EventDialStarted, Peer: peer.ID(fmt.Sprintf("peer %d", i))})
+		}
+		Expect(events).To(HaveLen(eventBufferSize))
+		var ev Event
+		Expect(events).To(Receive(&ev))
+		Expect(ev.Peer).To(Equal(peer.ID("peer 10")))
+		unsubscribe()
+		unsubscribe()
+		// publishing after unsubscribing doesn't panic
+		bus.Publish(Event{Type: EventDialStarted})
+		// the channel is closed after the remaining events
+		for range events {
+		}
+	})
+
+	Context("receive window bounds", func() {
+		var key ic.PrivKey
+
+		BeforeEach(func() {
+			var err error
+			key, _, err = ic.GenerateECDSAKeyPair(rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("uses the default windows", func() {
+			tr, err := NewTransport(key)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tr.(*transport).listenConfig).To(BeIdenticalTo(quicConfig))
+			Expect(tr.(*transport).receiveBufferSize).To(Equal(connReceiveBufferSize))
+		})
+
+		It("configures the windows", func() {
+			tr, err := NewTransport(key, WithReceiveWindowBounds(256<<10, 2<<20), WithDialVersions([]quic.VersionNumber{0xff000013}))
+			Expect(err).ToNot(HaveOccurred())
+			qt := tr.(*transport)
+			Expect(qt.config.minReceiveWindow).To(BeEquivalentTo(256 << 10))
+			for _, conf := range []*quic.Config{qt.listenConfig, qt.dialConfig} {
+				Expect(conf.MaxReceiveStreamFlowControlWindow).To(BeEquivalentTo(2 << 20))
+				Expect(conf.MaxReceiveConnectionFlowControlWindow).To(BeEquivalentTo(3 << 20))
+			}
+			Expect(qt.dialConfig.Versions).To(Equal([]quic.VersionNumber{0xff000013}))
+			Expect(qt.receiveBufferSize).To(BeEquivalentTo(3 << 20))
+			// the default config is not modified
+			Expect(quicConfig.MaxReceiveStreamFlowControlWindow).To(BeEquivalentTo(3 << 20))
+		})
+
+		It("validates the bounds", func() {
+			_, err := newConfig(WithReceiveWindowBounds(2<<20, 1<<20))
+			Expect(err).To(MatchError("minimum receive window (2097152) larger than maximum (1048576)"))
+			_, err = newConfig(WithReceiveWindowBounds(1<<20, 2<<20))
+			Expect(err).To(MatchError("minimum receive window (1048576) larger than the initial window (524288)"))
+			_, err = newConfig(WithReceiveWindowBounds(0, 256<<10))
+			Expect(err).To(MatchError("maximum receive window (262144) smaller than the initial window (524288)"))
+		})
+	})
+
+	It("refuses to disable ClientHello padding", func() {
+		_, err := newConfig(WithClientHelloPadding(true))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = newConfig(WithClientHelloPadding(false))
+		Expect(err).To(MatchError("disabling ClientHello padding is not supported by quic-go v0.11"))
+	})
+
+	It("refuses to use zero-length connection IDs", func() {
+		_, err := newConfig(WithZeroLengthConnectionIDs())
+		Expect(err).To(MatchError("zero-length connection IDs are not supported on shared sockets by quic-go v0.11"))
+	})
+
+	It("refuses to enable GREASE", func() {
+		_, err := newConfig(WithGrease(false))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = newConfig(WithGrease(true))
+		Expect(err).To(MatchError("GREASE is not supported by quic-go v0.11"))
+	})
+
+	Context("host key types", func() {
+		It("supports RSA keys", func() {
+			key, _, err := ic.GenerateRSAKeyPair(1024, rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = NewTransport(key)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("supports Ed25519 keys", func() {
+			key, _, err := ic.GenerateEd25519Key(rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = NewTransport(key)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("supports Secp256k1 keys", func() {
+			key, _, err := ic.GenerateSecp256k1Key(rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = NewTransport(key)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("refuses unsupported key types", func() {
+			key, _, err := ic.GenerateECDSAKeyPair(rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = NewTransport(key)
+			Expect(err).To(MatchError(&UnsupportedKeyTypeError{KeyType: pb.KeyType_ECDSA}))
+			Expect(err.Error()).To(Equal("unsupported key type for TLS: ECDSA"))
+		})
+	})
+
+	Context("validating dial addresses", func() {
+		origInterfaceAddrs := interfaceAddrs
+
+		BeforeEach(func() {
+			// only IPv4 is available
+			interfaceAddrs = func() ([]net.Addr, error) {
+				var addrs []net.Addr
+				for _, s := range []string{"127.0.0.1/8", "192.168.1.2/24", "::1/128"} {
+					ip, ipnet, err := net.ParseCIDR(s)
+					Expect(err).ToNot(HaveOccurred())
+					ipnet.IP = ip
+					addrs = append(addrs, ipnet)
+				}
+				return addrs, nil
+			}
+			key, _, err := ic.GenerateEd25519Key(rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			t, err = NewTransport(key)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			interfaceAddrs = origInterfaceAddrs
+		})
+
+		It("accepts a valid address", func() {
+			Expect(t.(*transport).ValidateDialAddr(ma.StringCast("/ip4/1.2.3.4/udp/1234/quic"))).To(Succeed())
+			Expect(t.(*transport).ValidateDialAddr(ma.StringCast("/ip6/::1/udp/1234/quic"))).To(Succeed())
+		})
+
+		It("rejects addresses of an unavailable address family", func() {
+			addr := ma.StringCast("/ip6/2001:db8::2/udp/1234/quic")
+			err := t.(*transport).ValidateDialAddr(addr)
+			Expect(err).To(BeAssignableToTypeOf(&DialAddrError{}))
+			Expect(err.(*DialAddrError).Addr).To(Equal(addr))
+			Expect(err.(*DialAddrError).Err).To(MatchError(ErrAddrFamilyUnavailable))
+		})
+
+		It("rejects malformed addresses", func() {
+			err := t.(*transport).ValidateDialAddr(ma.StringCast("/ip4/1.2.3.4/udp/1234"))
+			Expect(err).To(MatchError("can't dial /ip4/1.2.3.4/udp/1234: not a QUIC multiaddr"))
+			err = t.(*transport).ValidateDialAddr(ma.StringCast("/ip4/1.2.3.4/tcp/1234/quic"))
+			Expect(err).To(BeAssignableToTypeOf(&DialAddrError{}))
+		})
+	})
+
+	Context("validating listen addresses", func() {
+		BeforeEach(func() {
+			key, _, err := ic.GenerateEd25519Key(rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			t, err = NewTransport(key)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("accepts a valid address, without keeping the socket open", func() {
+			conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			Expect(err).ToNot(HaveOccurred())
+			port := conn.LocalAddr().(*net.UDPAddr).Port
+			Expect(conn.Close()).To(Succeed())
+			addr := ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/udp/%d/quic", port))
+			Expect(t.(*transport).ValidateListenAddr(addr)).To(Succeed())
+			// the port can still be used
+			ln, err := t.Listen(addr)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ln.Close()).To(Succeed())
+		})
+
+		It("rejects ports that are in use", func() {
+			conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			addr := ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/udp/%d/quic", conn.LocalAddr().(*net.UDPAddr).Port))
+			err = t.(*transport).ValidateListenAddr(addr)
+			Expect(err).To(BeAssignableToTypeOf(&ListenAddrError{}))
+			Expect(err.(*ListenAddrError).Addr).To(Equal(addr))
+			Expect(err.(*ListenAddrError).Err).To(MatchError(ErrAddrInUse))
+		})
+
+		It("rejects addresses it is already listening on", func() {
+			ln, err := t.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+			err = t.(*transport).ValidateListenAddr(ln.Multiaddr())
+			Expect(err.(*ListenAddrError).Err).To(MatchError(ErrAlreadyListening))
+		})
+
+		It("rejects addresses of an unavailable address family", func() {
+			origInterfaceAddrs := interfaceAddrs
+			defer func() { interfaceAddrs = origInterfaceAddrs }()
+			interfaceAddrs = func() ([]net.Addr, error) {
+				ip, ipnet, err := net.ParseCIDR("127.0.0.1/8")
+				Expect(err).ToNot(HaveOccurred())
+				ipnet.IP = ip
+				return []net.Addr{ipnet}, nil
+			}
+			err := t.(*transport).ValidateListenAddr(ma.StringCast("/ip6/::/udp/0/quic"))
+			Expect(err.(*ListenAddrError).Err).To(MatchError(ErrAddrFamilyUnavailable))
+		})
+
+		It("rejects malformed addresses", func() {
+			err := t.(*transport).ValidateListenAddr(ma.StringCast("/ip4/127.0.0.1/udp/0"))
+			Expect(err).To(MatchError("can't listen on /ip4/127.0.0.1/udp/0: not a QUIC multiaddr"))
+		})
+	})
 })
@@ -0,0 +1,48 @@
+package libp2pquic
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// checkTrustedCA checks that the host certificate of the chain (its last certificate)
+// is contained in the pool, or signed by a certificate in the pool, see WithTrustedCAs.
+// A nil pool trusts every host certificate.
+func checkTrustedCA(chain []*x509.Certificate, pool *x509.CertPool) error {
+	if pool == nil {
+		return nil
+	}
+	if len(chain) == 0 {
+		return errors.New("no certificate presented")
+	}
+	hostCert := chain[len(chain)-1]
+	if _, err := hostCert.Verify(x509.VerifyOptions{
+		Roots:     pool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return fmt.Errorf("host certificate not trusted: %s", err)
+	}
+	return nil
+}
+
+// withTrustedCAs returns a copy of the tls.Config that rejects clients whose host certificate isn't trusted, see WithTrustedCAs.
+func withTrustedCAs(conf *tls.Config, pool *x509.CertPool) *tls.Config {
+	verify := conf.VerifyPeerCertificate
+	conf = conf.Clone()
+	conf.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		chain, err := parseCertChain(rawCerts)
+		if err != nil {
+			return reject(RejectReasonInvalidCertificate, err)
+		}
+		if err := checkTrustedCA(chain, pool); err != nil {
+			return reject(RejectReasonUntrustedCA, err)
+		}
+		if verify != nil {
+			return verify(rawCerts, verifiedChains)
+		}
+		return nil
+	}
+	return conf
+}
@@ -0,0 +1,186 @@
+package libp2pquic
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+// ErrUDPBlocked is matched by the *HandshakeTimeoutError returned by Dial (using errors.Is)
+// when the handshake timed out without a single packet being received from the peer.
+// This usually means that UDP is blocked on the path to the peer,
+// and that the peer should be dialed using a TCP-based transport instead.
+var ErrUDPBlocked = errors.New("UDP appears to be blocked")
+
+// A trackingConn is a net.PacketConn that records if packets were received from the addresses that are being watched.
+type trackingConn struct {
+	net.PacketConn
+	// recvErr says if IP_RECVERR is enabled on the socket, see WithPortUnreachableDetection.
+	recvErr bool
+	// nil if errors are not reported, see SocketDiagnostics
+	diagnostics *socketDiagnostics
+	// drops packets to peers whose connections were closed silently, see WithConnectionClose
+	silencer silencer
+	// counts the bytes of dialed connections, see WireBytesSent
+	wire wireCounter
+
+	numWatches int32 // must be accessed atomically
+	mutex      sync.Mutex
+	watches    map[string]map[*packetWatch]struct{}
+}
+
+type packetWatch struct {
+	firstReceived int64 // UnixNano timestamp, must be accessed atomically
+
+	mutex     sync.Mutex
+	srcConnID []byte
+	version   quic.VersionNumber
+	progress  HandshakeProgress
+
+	refusedOnce sync.Once
+	refused     chan struct{} // closed when the peer refused a packet
+}
+
+// Refused returns a channel that is closed when the peer's host refused a packet (ICMP port unreachable).
+func (w *packetWatch) Refused() <-chan struct{} {
+	return w.refused
+}
+
+// IsRefused says if the peer's host refused a packet.
+func (w *packetWatch) IsRefused() bool {
+	select {
+	case <-w.refused:
+		return true
+	default:
+		return false
+	}
+}
+
+// SrcConnID returns the source connection ID of the first long header packet received.
+// It returns nil if no long header packet was received.
+func (w *packetWatch) SrcConnID() []byte {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.srcConnID
+}
+
+// Version returns the QUIC version of the first long header packet received.
+// It returns 0 if no long header packet was received.
+func (w *packetWatch) Version() quic.VersionNumber {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.version
+}
+
+// Progress returns the packets received since the watch was started.
+func (w *packetWatch) Progress() HandshakeProgress {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.progress
+}
+
+// Received says if any packet was received since the watch was started.
+func (w *packetWatch) Received() bool {
+	return atomic.LoadInt64(&w.firstReceived) != 0
+}
+
+// FirstReceived returns the time when the first packet was received.
+// It returns the zero time if no packet was received.
+func (w *packetWatch) FirstReceived() time.Time {
+	t := atomic.LoadInt64(&w.firstReceived)
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, t)
+}
+
+func newTrackingConn(c net.PacketConn) *trackingConn {
+	return &trackingConn{
+		PacketConn: c,
+		watches:    make(map[string]map[*packetWatch]struct{}),
+	}
+}
+
+// Watch starts recording if packets are received from addr.
+// The stop function must be called when the watch is not needed any more.
+func (c *trackingConn) Watch(addr net.Addr) (w *packetWatch, stop func()) {
+	w = &packetWatch{refused: make(chan struct{})}
+	key := addr.String()
+	c.mutex.Lock()
+	watches, ok := c.watches[key]
+	if !ok {
+		watches = make(map[*packetWatch]struct{})
+		c.watches[key] = watches
+	}
+	watches[w] = struct{}{}
+	c.mutex.Unlock()
+	atomic.AddInt32(&c.numWatches, 1)
+
+	var once sync.Once
+	return w, func() {
+		once.Do(func() {
+			atomic.AddInt32(&c.numWatches, -1)
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			delete(watches, w)
+			if len(watches) == 0 {
+				delete(c.watches, key)
+			}
+		})
+	}
+}
+
+func (c *trackingConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	// With IP_RECVERR, an ICMP error is returned by the next read.
+	// Don't pass it on to quic-go, since that would close all sessions using this socket.
+	for err != nil && c.recvErr && isConnRefused(err) {
+		c.handleRefused()
+		n, addr, err = c.PacketConn.ReadFrom(b)
+	}
+	if err != nil {
+		c.diagnostics.Report(SocketReadError, c.PacketConn, nil, err)
+	} else {
+		c.wire.countReceived(addr, n)
+	}
+	if err == nil && atomic.LoadInt32(&c.numWatches) > 0 {
+		c.mutex.Lock()
+		watches := c.watches[addr.String()]
+		if len(watches) > 0 {
+			now := time.Now().UnixNano()
+			connID, _, isLongHeader := parseSrcConnID(b[:n])
+			version, _ := parseVersion(b[:n])
+			for w := range watches {
+				atomic.CompareAndSwapInt64(&w.firstReceived, 0, now)
+				w.mutex.Lock()
+				if isLongHeader && w.srcConnID == nil {
+					w.srcConnID = connID
+					w.version = version
+				}
+				w.progress.recordPacket(b[:n])
+				w.mutex.Unlock()
+			}
+		}
+		c.mutex.Unlock()
+	}
+	return n, addr, err
+}
+
+// WriteTo sends a packet to addr.
+// quic-go might pass a smallPacketAddr, see WithMaxPacketSize.
+func (c *trackingConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.silencer.IsSilenced(addr) {
+		return len(b), nil
+	}
+	n, err := c.PacketConn.WriteTo(b, unwrapAddr(addr))
+	if err != nil {
+		c.diagnostics.Report(SocketWriteError, c.PacketConn, unwrapAddr(addr), err)
+	} else {
+		c.wire.countSent(addr, n)
+	}
+	return n, err
+}
@@ -0,0 +1,157 @@
+package libp2pquic
+
+import (
+	"sync"
+	"sync/atomic"
+
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+// The number of unidirectional streams opened by the peer that haven't been accepted yet.
+// Streams beyond this limit are reset with StreamQueueFullErrorCode.
+const uniStreamQueueLen = 16
+
+// UniStreamLimitErrorCode is the error code used to refuse unidirectional streams opened by the peer
+// when the limit of incoming unidirectional streams is reached (see WithMaxIncomingUniStreams).
+const UniStreamLimitErrorCode quic.ErrorCode = 0x4
+
+// ConnStats are statistics about a connection.
+type ConnStats struct {
+	// The number of bidirectional streams that were opened or accepted, and haven't been closed or reset yet.
+	OpenBidiStreams int
+	// The number of unidirectional streams that were opened or accepted, and haven't been closed or reset yet.
+	// Streams used internally by the transport are not counted.
+	OpenUniStreams int
+	// The number of streams that couldn't be opened right away, because the peer's stream limit was reached.
+	// Opening these streams blocked until the peer allowed more streams.
+	BlockedStreamOpens int
+}
+
+// Stats returns statistics about the connection.
+func (c *conn) Stats() ConnStats {
+	return ConnStats{
+		OpenBidiStreams:    int(atomic.LoadInt32(&c.numBidiStreams)),
+		OpenUniStreams:     int(atomic.LoadInt32(&c.numUniStreams)),
+		BlockedStreamOpens: int(atomic.LoadUint32(&c.blockedStreamOpens)),
+	}
+}
+
+// countStream increments counter, and returns a function that decrements it again.
+// The function may be called multiple times.
+func countStream(counter *int32) func() {
+	atomic.AddInt32(counter, 1)
+	var once sync.Once
+	return func() { once.Do(func() { atomic.AddInt32(counter, -1) }) }
+}
+
+// OpenUniStream opens a unidirectional stream.
+// Unidirectional streams are shared with control streams, so both peers need to enable them (see WithControlStreams).
+// OpenUniStream returns ErrControlStreamsDisabled or ErrControlStreamsUnsupported otherwise.
+func (c *conn) OpenUniStream() (quic.SendStream, error) {
+	if err := c.checkControlStreams(); err != nil {
+		return nil, err
+	}
+	str, err := c.openUniStreamSync()
+	if err != nil {
+		return nil, c.streamError(err)
+	}
+	if _, err := str.Write([]byte{controlStreamTypeApplication}); err != nil {
+		str.CancelWrite(0)
+		return nil, c.streamError(err)
+	}
+	return &sendStream{SendStream: str, conn: c, done: countStream(&c.numUniStreams)}, nil
+}
+
+// AcceptUniStream accepts a unidirectional stream opened by the peer.
+// It returns ErrControlStreamsDisabled unless control streams are enabled (see WithControlStreams).
+func (c *conn) AcceptUniStream() (quic.ReceiveStream, error) {
+	if !c.controlStreams {
+		return nil, ErrControlStreamsDisabled
+	}
+	select {
+	case str := <-c.uniStreams:
+		uncount := countStream(&c.numUniStreams)
+		var once sync.Once
+		return &receiveStream{ReceiveStream: str, conn: c, done: func() {
+			uncount()
+			once.Do(c.releaseIncomingUniStream)
+		}}, nil
+	case <-c.sess.Context().Done():
+		return nil, c.CloseError()
+	}
+}
+
+// queueUniStream queues a unidirectional stream opened by the peer, until it is accepted.
+// Streams beyond the limit of incoming unidirectional streams are refused.
+func (c *conn) queueUniStream(str quic.ReceiveStream) {
+	if !c.reserveIncomingUniStream() {
+		str.CancelRead(UniStreamLimitErrorCode)
+		return
+	}
+	select {
+	case c.uniStreams <- str:
+	default:
+		c.releaseIncomingUniStream()
+		str.CancelRead(StreamQueueFullErrorCode)
+	}
+}
+
+// reserveIncomingUniStream counts an incoming unidirectional stream.
+// It returns false if the limit of incoming unidirectional streams is reached.
+func (c *conn) reserveIncomingUniStream() bool {
+	n := atomic.AddInt32(&c.numIncomingUniStreams, 1)
+	if c.maxIncomingUniStreams > 0 && n > c.maxIncomingUniStreams {
+		atomic.AddInt32(&c.numIncomingUniStreams, -1)
+		return false
+	}
+	return true
+}
+
+func (c *conn) releaseIncomingUniStream() {
+	atomic.AddInt32(&c.numIncomingUniStreams, -1)
+}
+
+// A sendStream is a unidirectional stream opened by the application.
+type sendStream struct {
+	quic.SendStream
+	conn *conn
+	done func()
+}
+
+func (s *sendStream) Write(b []byte) (int, error) {
+	n, err := s.SendStream.Write(b)
+	s.conn.countSent(n)
+	return n, err
+}
+
+func (s *sendStream) Close() error {
+	s.done()
+	return s.SendStream.Close()
+}
+
+func (s *sendStream) CancelWrite(code quic.ErrorCode) {
+	s.done()
+	s.SendStream.CancelWrite(code)
+}
+
+// A receiveStream is a unidirectional stream accepted by the application.
+// It is considered closed once it has been read completely, or reading was canceled.
+type receiveStream struct {
+	quic.ReceiveStream
+	conn *conn
+	done func()
+}
+
+func (s *receiveStream) Read(b []byte) (int, error) {
+	n, err := s.ReceiveStream.Read(b)
+	s.conn.countReceived(n)
+	if err != nil {
+		s.done()
+	}
+	return n, err
+}
+
+func (s *receiveStream) CancelRead(code quic.ErrorCode) {
+	s.done()
+	s.ReceiveStream.CancelRead(code)
+}
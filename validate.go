@@ -0,0 +1,150 @@
+package libp2pquic
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// ErrAddrFamilyUnavailable is returned (wrapped in a DialAddrError) by ValidateDialAddr
+// if no interface has an address of the address family of the multiaddr.
+var ErrAddrFamilyUnavailable = errors.New("address family unavailable")
+
+// ErrAddrInUse is returned (wrapped in a ListenAddrError) by ValidateListenAddr
+// if another socket is bound to the address.
+var ErrAddrInUse = errors.New("address already in use")
+
+// A DialAddrError is returned by ValidateDialAddr when a multiaddr can't be dialed.
+type DialAddrError struct {
+	Addr ma.Multiaddr
+	Err  error
+}
+
+func (e *DialAddrError) Error() string {
+	return fmt.Sprintf("can't dial %s: %s", e.Addr, e.Err)
+}
+
+func (e *DialAddrError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateDialAddr checks that a multiaddr could be dialed, without dialing it.
+// It checks that the multiaddr is a valid QUIC multiaddr, that the host has an address of the same address family,
+// and that a socket can be obtained for dialing it.
+// It returns a *DialAddrError describing the first problem found.
+func (t *transport) ValidateDialAddr(addr ma.Multiaddr) error {
+	if err := t.validateDialAddr(addr); err != nil {
+		return &DialAddrError{Addr: addr, Err: err}
+	}
+	return nil
+}
+
+func (t *transport) validateDialAddr(addr ma.Multiaddr) error {
+	if !t.CanDial(addr) {
+		return errors.New("not a QUIC multiaddr")
+	}
+	network, host, err := manet.DialArgs(addr)
+	if err != nil {
+		return err
+	}
+	udpAddr, err := net.ResolveUDPAddr(network, host)
+	if err != nil {
+		return err
+	}
+	if err := t.checkAddressFilter(udpAddr); err != nil {
+		return err
+	}
+	ips, err := interfaceIPs(network == "udp4")
+	if err != nil {
+		return err
+	}
+	var available bool
+	for _, ip := range ips {
+		// Loopback addresses can only be used to dial loopback addresses.
+		if !ip.IsLoopback() || udpAddr.IP.IsLoopback() {
+			available = true
+			break
+		}
+	}
+	if !available {
+		return ErrAddrFamilyUnavailable
+	}
+	var release func()
+	if t.config.disableReuse {
+		_, release, err = t.connManager.NewConnForAddr(network, 0)
+	} else {
+		_, release, err = t.connManager.GetConnForAddr(network, 0, noShard, "")
+	}
+	if err != nil {
+		return err
+	}
+	release()
+	return nil
+}
+
+// A ListenAddrError is returned by ValidateListenAddr when it's not possible to listen on a multiaddr.
+type ListenAddrError struct {
+	Addr ma.Multiaddr
+	Err  error
+}
+
+func (e *ListenAddrError) Error() string {
+	return fmt.Sprintf("can't listen on %s: %s", e.Addr, e.Err)
+}
+
+func (e *ListenAddrError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateListenAddr checks that it's possible to listen on a multiaddr, without keeping a socket open.
+// It checks that the multiaddr is a valid QUIC multiaddr, that the host has an address of the same address family,
+// and binds a socket to the address, which is closed right away.
+// It returns a *ListenAddrError wrapping ErrAddrFamilyUnavailable, ErrAddrInUse or ErrAlreadyListening,
+// or describing any other problem found.
+func (t *transport) ValidateListenAddr(addr ma.Multiaddr) error {
+	if err := t.validateListenAddr(addr); err != nil {
+		return &ListenAddrError{Addr: addr, Err: err}
+	}
+	return nil
+}
+
+func (t *transport) validateListenAddr(addr ma.Multiaddr) error {
+	if !t.CanDial(addr) {
+		return errors.New("not a QUIC multiaddr")
+	}
+	network, host, err := manet.DialArgs(addr)
+	if err != nil {
+		return err
+	}
+	laddr, err := net.ResolveUDPAddr(network, host)
+	if err != nil {
+		return err
+	}
+	ips, err := interfaceIPs(network == "udp4")
+	if err != nil {
+		return err
+	}
+	if len(ips) == 0 {
+		return ErrAddrFamilyUnavailable
+	}
+	if key := listenKey(addr); key != "" {
+		t.listenAddrsMutex.Lock()
+		_, ok := t.listenAddrs[key]
+		t.listenAddrsMutex.Unlock()
+		if ok {
+			return ErrAlreadyListening
+		}
+	}
+	pconn, err := listenUDP(t.listenNetwork(network, laddr), laddr)
+	if err != nil {
+		if errors.Is(err, syscall.EADDRINUSE) {
+			return ErrAddrInUse
+		}
+		return err
+	}
+	return pconn.Close()
+}
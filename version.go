@@ -0,0 +1,29 @@
+package libp2pquic
+
+import (
+	"encoding/binary"
+
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+// quic-go v0.11 doesn't expose the version negotiated for a session.
+// It is therefore taken from the version field of the long header packets of the handshake.
+
+// Version returns the QUIC version used by the connection.
+// It returns 0 if the version is not known.
+func (c *conn) Version() quic.VersionNumber {
+	return c.version
+}
+
+// parseVersion returns the version of a long header packet.
+// Version negotiation packets don't carry a version.
+func parseVersion(b []byte) (quic.VersionNumber, bool) {
+	if len(b) < 5 || b[0]&0x80 == 0 {
+		return 0, false
+	}
+	v := quic.VersionNumber(binary.BigEndian.Uint32(b[1:5]))
+	if v == 0 {
+		return 0, false
+	}
+	return v, true
+}
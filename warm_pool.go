@@ -0,0 +1,75 @@
+package libp2pquic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// The time to wait before re-dialing a peer of the warm pool after a dial failed or the connection was closed.
+var warmPoolRedialBackoff = time.Second
+
+// WarmPool keeps connections to the peers open until ctx is done, e.g. to relays that need to be available quickly.
+// The connections are added to the connection pool (see WithConnPool), so that Dial returns them,
+// and are kept there regardless of the pool's idle timeout and size.
+// With session sharing (see WithSessionSharing), an existing connection that isn't pooled (e.g. one accepted from the peer)
+// is used instead of dialing the peer. It is kept open, but not added to the pool.
+// Every peer is dialed on its addresses in order (see DialBest). When a dial fails or the connection is closed,
+// the peer is dialed again. quic-go keeps the connections alive, see also WithKeepAlivePeriod.
+// WarmPool returns immediately, the connections are established in the background.
+// The connections are closed by Shutdown.
+func (t *transport) WarmPool(ctx context.Context, peers []peer.AddrInfo) error {
+	if t.connPool == nil {
+		return errors.New("warm pool requires a connection pool, see WithConnPool")
+	}
+	for _, ai := range peers {
+		if len(ai.Addrs) == 0 {
+			return fmt.Errorf("no addresses for peer %s", ai.ID.Pretty())
+		}
+	}
+	for _, ai := range peers {
+		go t.keepWarm(ctx, ai)
+	}
+	return nil
+}
+
+// keepWarm keeps a connection to the peer in the connection pool, until ctx is done or the pool is closed.
+func (t *transport) keepWarm(ctx context.Context, ai peer.AddrInfo) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-t.connPool.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for {
+		if c, err := t.DialBest(ctx, ai.Addrs, ai.ID); err == nil {
+			// Dial returns a view on the pooled connection.
+			// Holding it keeps the session open, even if the pool removes the connection.
+			if v, ok := c.(*sharedConn); ok {
+				t.connPool.Pin(v.conn)
+				select {
+				case <-v.sess.Context().Done():
+				case <-ctx.Done():
+				}
+				t.connPool.Unpin(v.conn)
+			}
+			c.Close()
+			if ctx.Err() != nil {
+				return
+			}
+		}
+		timer := time.NewTimer(warmPoolRedialBackoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
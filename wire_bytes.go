@@ -0,0 +1,193 @@
+package libp2pquic
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// quic-go v0.11 doesn't count the bytes it sends and receives.
+// To account for the overhead of the handshake, packet headers, acknowledgements and retransmissions,
+// the sockets count the size of the UDP payloads sent to and received from the addresses of connections.
+
+// byteCounts are the number of bytes sent and received.
+type byteCounts struct {
+	sent, received uint64 // must be accessed atomically
+	// the sizes of the datagrams sent, see SentDatagramSizes
+	sentSizes datagramSizes
+}
+
+func (c *byteCounts) addSent(n int) {
+	atomic.AddUint64(&c.sent, uint64(n))
+	c.sentSizes.add(n)
+}
+
+func (c *byteCounts) addReceived(n int) {
+	atomic.AddUint64(&c.received, uint64(n))
+}
+
+// Sent returns the number of bytes sent. It is safe to call on a nil byteCounts.
+func (c *byteCounts) Sent() uint64 {
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&c.sent)
+}
+
+// Received returns the number of bytes received. It is safe to call on a nil byteCounts.
+func (c *byteCounts) Received() uint64 {
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&c.received)
+}
+
+// A wireCounter counts the bytes a socket sends to and receives from remote addresses.
+// Connections to the same remote address over the same socket share their counts.
+type wireCounter struct {
+	// countUntracked makes the counter count the bytes of addresses that aren't tracked yet,
+	// such that the handshake of a connection accepted by a listener is included once it is tracked.
+	countUntracked bool
+
+	numTracked int32 // must be accessed atomically
+	mutex      sync.Mutex
+	counts     map[string]*trackedWireBytes
+	numPending int // the number of counts of addresses that aren't tracked
+}
+
+type trackedWireBytes struct {
+	byteCounts
+	refs int
+}
+
+// Track starts counting the bytes sent to and received from addr.
+// The stop function must be called when the counts are not needed any more.
+func (c *wireCounter) Track(addr net.Addr) (counts *byteCounts, stop func()) {
+	key := unwrapAddr(addr).String()
+	c.mutex.Lock()
+	if c.counts == nil {
+		c.counts = make(map[string]*trackedWireBytes)
+	}
+	t, ok := c.counts[key]
+	if !ok {
+		t = &trackedWireBytes{}
+		c.counts[key] = t
+	} else if t.refs == 0 {
+		c.numPending--
+	}
+	t.refs++
+	c.mutex.Unlock()
+	atomic.AddInt32(&c.numTracked, 1)
+
+	var once sync.Once
+	return &t.byteCounts, func() {
+		once.Do(func() {
+			atomic.AddInt32(&c.numTracked, -1)
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			if t.refs--; t.refs == 0 {
+				delete(c.counts, key)
+			}
+		})
+	}
+}
+
+// countsFor returns the counts of addr, or nil if its bytes are not counted.
+func (c *wireCounter) countsFor(addr net.Addr) *byteCounts {
+	if !c.countUntracked && atomic.LoadInt32(&c.numTracked) == 0 {
+		return nil
+	}
+	key := unwrapAddr(addr).String()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if t, ok := c.counts[key]; ok {
+		return &t.byteCounts
+	}
+	if !c.countUntracked {
+		return nil
+	}
+	if c.counts == nil {
+		c.counts = make(map[string]*trackedWireBytes)
+	}
+	if c.numPending >= maxPendingConnIDs {
+		c.evictPending()
+	}
+	t := &trackedWireBytes{}
+	c.counts[key] = t
+	c.numPending++
+	return &t.byteCounts
+}
+
+// evictPending forgets the counts of a random address that isn't tracked.
+func (c *wireCounter) evictPending() {
+	for key, t := range c.counts {
+		if t.refs == 0 {
+			delete(c.counts, key)
+			c.numPending--
+			return
+		}
+	}
+}
+
+func (c *wireCounter) countSent(addr net.Addr, n int) {
+	if counts := c.countsFor(addr); counts != nil {
+		counts.addSent(n)
+	}
+}
+
+func (c *wireCounter) countReceived(addr net.Addr, n int) {
+	if counts := c.countsFor(addr); counts != nil {
+		counts.addReceived(n)
+	}
+}
+
+// A wireCountingConn is a net.PacketConn that counts the bytes sent to and received from remote addresses.
+type wireCountingConn struct {
+	net.PacketConn
+	counter wireCounter
+}
+
+func (c *wireCountingConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err == nil {
+		c.counter.countReceived(addr, n)
+	}
+	return n, addr, err
+}
+
+func (c *wireCountingConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	if err == nil {
+		c.counter.countSent(addr, n)
+	}
+	return n, err
+}
+
+// countSent counts data written to a stream of this connection.
+func (c *conn) countSent(n int) {
+	atomic.AddUint64(&c.bytesSent, uint64(n))
+	if t, ok := c.transport.(*transport); ok && t != nil {
+		t.streamBytes.addSent(n)
+	}
+}
+
+// countReceived counts data read from a stream of this connection.
+func (c *conn) countReceived(n int) {
+	atomic.AddUint64(&c.bytesReceived, uint64(n))
+	if t, ok := c.transport.(*transport); ok && t != nil {
+		t.streamBytes.addReceived(n)
+	}
+}
+
+// WireBytesSent returns the size of the UDP payloads sent on this connection,
+// including the handshake, packet headers, acknowledgements and retransmissions.
+// Connections to the same address using the same socket share their counts.
+// It returns 0 if the bytes sent are unknown.
+func (c *conn) WireBytesSent() uint64 {
+	return c.wire.Sent()
+}
+
+// WireBytesReceived returns the size of the UDP payloads received on this connection, see WireBytesSent.
+func (c *conn) WireBytesReceived() uint64 {
+	return c.wire.Received()
+}
@@ -0,0 +1,63 @@
+package libp2pquic
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Wire byte counting", func() {
+	addr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 1234}
+
+	It("only counts tracked addresses", func() {
+		var c wireCounter
+		c.countSent(addr, 100)
+		counts, stop := c.Track(addr)
+		c.countSent(addr, 10)
+		c.countReceived(addr, 20)
+		c.countSent(&net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 4321}, 100)
+		Expect(counts.Sent()).To(BeEquivalentTo(10))
+		Expect(counts.Received()).To(BeEquivalentTo(20))
+		stop()
+		Expect(c.counts).To(BeEmpty())
+	})
+
+	It("counts the bytes of untracked addresses when tracking starts later", func() {
+		c := wireCounter{countUntracked: true}
+		c.countReceived(addr, 1200)
+		Expect(c.numPending).To(Equal(1))
+		counts, stop := c.Track(addr)
+		defer stop()
+		Expect(c.numPending).To(BeZero())
+		Expect(counts.Received()).To(BeEquivalentTo(1200))
+	})
+
+	It("evicts the counts of untracked addresses", func() {
+		c := wireCounter{countUntracked: true}
+		for i := 0; i < maxPendingConnIDs+10; i++ {
+			c.countSent(&net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: i}, 1)
+		}
+		Expect(c.numPending).To(Equal(maxPendingConnIDs))
+		Expect(c.counts).To(HaveLen(maxPendingConnIDs))
+	})
+
+	It("is safe to read nil counts", func() {
+		var counts *byteCounts
+		Expect(counts.Sent()).To(BeZero())
+		Expect(counts.Received()).To(BeZero())
+	})
+
+	It("counts the sizes of the datagrams sent", func() {
+		var c wireCounter
+		counts, stop := c.Track(addr)
+		defer stop()
+		for _, n := range []int{1, 64, 65, 1252, 1252, 2000} {
+			c.countSent(addr, n)
+		}
+		h := counts.sentSizes.Histogram()
+		Expect(h.Bounds).To(Equal([]int{64, 128, 256, 512, 1024, 1280, 1500}))
+		Expect(h.Counts).To(Equal([]uint64{2, 1, 0, 0, 0, 2, 0, 1}))
+		Expect(h.Total()).To(BeEquivalentTo(6))
+	})
+})
@@ -0,0 +1,150 @@
+package libp2pquic
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrWriteFanOutUnsupported is returned by NewTransport when WithWriteFanOut is used on a platform without SO_REUSEPORT support.
+var ErrWriteFanOutUnsupported = errors.New("write fan-out requires SO_REUSEPORT, which is only supported on Linux")
+
+// The size of the buffer used by a fanOutConn to read a packet.
+const fanOutReadBufferSize = 1 << 16
+
+type fanOutPacket struct {
+	data []byte
+	addr net.Addr
+	err  error
+	done chan struct{} // closed when data has been copied
+}
+
+// A fanOutConn spreads the packets it sends across multiple sockets bound to the same local address using SO_REUSEPORT.
+// Since all sockets use the same address, the peer doesn't see a difference: The connection still uses a single path,
+// so this doesn't require multipath support in QUIC.
+// The kernel distributes the packets received among the sockets, so packets are read from all sockets.
+// All other methods operate on the first socket.
+type fanOutConn struct {
+	*net.UDPConn
+	sockets []*net.UDPConn
+
+	next    uint32   // must be accessed atomically
+	writes  []uint64 // the number of packets sent on every socket, must be accessed atomically
+	packets chan fanOutPacket
+	// counts the buffers holding a packet
+	buffers *readBufferCounter
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	readers   sync.WaitGroup
+}
+
+var _ net.PacketConn = &fanOutConn{}
+
+func newFanOutConn(sockets []*net.UDPConn, buffers *readBufferCounter) *fanOutConn {
+	c := &fanOutConn{
+		UDPConn: sockets[0],
+		sockets: sockets,
+		writes:  make([]uint64, len(sockets)),
+		packets: make(chan fanOutPacket),
+		buffers: buffers,
+		closed:  make(chan struct{}),
+	}
+	c.readers.Add(len(sockets))
+	for _, s := range sockets {
+		go c.read(s)
+	}
+	return c
+}
+
+func (c *fanOutConn) read(s *net.UDPConn) {
+	defer c.readers.Done()
+	buf := make([]byte, fanOutReadBufferSize)
+	done := make(chan struct{}, 1)
+	for {
+		n, addr, err := s.ReadFrom(buf)
+		c.buffers.Acquire()
+		select {
+		case c.packets <- fanOutPacket{data: buf[:n], addr: addr, err: err, done: done}:
+			<-done
+			c.buffers.Release()
+		case <-c.closed:
+			c.buffers.Release()
+			return
+		}
+		if err != nil {
+			if nerr, ok := err.(net.Error); ok && nerr.Temporary() {
+				continue
+			}
+			return
+		}
+	}
+}
+
+// ReadFrom reads the next packet received on any of the sockets.
+func (c *fanOutConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case p := <-c.packets:
+		n := copy(b, p.data)
+		p.done <- struct{}{}
+		return n, p.addr, p.err
+	case <-c.closed:
+		return 0, nil, errors.New("use of closed network connection")
+	}
+}
+
+// WriteTo sends the packet on the next socket, in a round-robin fashion.
+func (c *fanOutConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	i := int(atomic.AddUint32(&c.next, 1) % uint32(len(c.sockets)))
+	n, err := c.sockets[i].WriteTo(b, addr)
+	if err == nil {
+		atomic.AddUint64(&c.writes[i], 1)
+	}
+	return n, err
+}
+
+// Close closes all sockets.
+func (c *fanOutConn) Close() error {
+	var firstErr error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		for _, s := range c.sockets {
+			if err := s.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		c.readers.Wait()
+	})
+	return firstErr
+}
+
+// createFanOutConn creates the additional sockets for the fan-out (see WithWriteFanOut),
+// bound to the same address as conn, which must have been created using listenReusePort.
+func (c *connManager) createFanOutConn(conn *net.UDPConn, network string, dscp uint8) (*fanOutConn, error) {
+	sockets := []*net.UDPConn{conn}
+	closeAll := func() {
+		for _, s := range sockets {
+			s.Close()
+		}
+	}
+	laddr := conn.LocalAddr().(*net.UDPAddr)
+	for len(sockets) < c.writeFanOut {
+		var s *net.UDPConn
+		err := c.inNamespace(func() error {
+			var err error
+			s, err = listenReusePort(network, laddr)
+			return err
+		})
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		sockets = append(sockets, s)
+		if err := c.configureSocket(s, network, dscp); err != nil {
+			closeAll()
+			return nil, err
+		}
+	}
+	return newFanOutConn(sockets, &c.readBuffers), nil
+}
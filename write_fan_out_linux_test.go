@@ -0,0 +1,139 @@
+package libp2pquic
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"sync/atomic"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	tpt "github.com/libp2p/go-libp2p-core/transport"
+	ma "github.com/multiformats/go-multiaddr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Write fan-out", func() {
+	It("spreads the packets sent across sockets bound to the same address", func() {
+		cm := &connManager{writeFanOut: 3}
+		conn, release, err := cm.GetConnForAddr("udp4", 0, noShard, "")
+		Expect(err).ToNot(HaveOccurred())
+		defer release()
+		fconn, ok := conn.PacketConn.(*fanOutConn)
+		Expect(ok).To(BeTrue())
+		Expect(fconn.sockets).To(HaveLen(3))
+		for _, s := range fconn.sockets {
+			Expect(s.LocalAddr()).To(Equal(fconn.LocalAddr()))
+		}
+
+		peer, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer peer.Close()
+		for i := 0; i < 6; i++ {
+			_, err := conn.WriteTo([]byte("foobar"), peer.LocalAddr())
+			Expect(err).ToNot(HaveOccurred())
+		}
+		port := fconn.LocalAddr().(*net.UDPAddr).Port
+		b := make([]byte, 100)
+		for i := 0; i < 6; i++ {
+			peer.SetReadDeadline(time.Now().Add(time.Second))
+			_, addr, err := peer.ReadFrom(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(addr.(*net.UDPAddr).Port).To(Equal(port))
+		}
+		for i := range fconn.writes {
+			Expect(atomic.LoadUint64(&fconn.writes[i])).To(BeEquivalentTo(2))
+		}
+
+		// the packets received by any socket are read
+		for i := 0; i < 6; i++ {
+			_, err := peer.WriteTo([]byte("foobar"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+			Expect(err).ToNot(HaveOccurred())
+		}
+		for i := 0; i < 6; i++ {
+			n, addr, err := conn.ReadFrom(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b[:n]).To(Equal([]byte("foobar")))
+			Expect(addr.String()).To(Equal(peer.LocalAddr().String()))
+		}
+	})
+
+	It("tracks the read buffers holding packets", func() {
+		cm := &connManager{writeFanOut: 3}
+		conn, release, err := cm.GetConnForAddr("udp4", 0, noShard, "")
+		Expect(err).ToNot(HaveOccurred())
+		defer release()
+		port := conn.LocalAddr().(*net.UDPAddr).Port
+
+		// Send from many ports, so that the kernel distributes the packets across all sockets.
+		// Every socket holds one packet until it is read.
+		const numPackets = 32
+		for i := 0; i < numPackets; i++ {
+			peer, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = peer.WriteTo([]byte("foobar"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+			Expect(err).ToNot(HaveOccurred())
+			peer.Close()
+		}
+		stats := func() TransportStats {
+			var s TransportStats
+			cm.readBuffers.addStats(&s)
+			return s
+		}
+		Eventually(func() int { return stats().ReadBuffersInFlight }).Should(Equal(3))
+		Consistently(func() int { return stats().ReadBuffersInFlight }).Should(Equal(3))
+
+		b := make([]byte, 100)
+		for i := 0; i < numPackets; i++ {
+			_, _, err := conn.ReadFrom(b)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Eventually(func() int { return stats().ReadBuffersInFlight }).Should(BeZero())
+		Expect(stats().ReadBuffersHighWaterMark).To(Equal(3))
+	})
+
+	It("dials using the fan-out", func() {
+		serverKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		serverID, err := peer.IDFromPrivateKey(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		accepted := make(chan tpt.CapableConn, 1)
+		go func() {
+			defer GinkgoRecover()
+			conn, err := ln.Accept()
+			Expect(err).ToNot(HaveOccurred())
+			accepted <- conn
+		}()
+
+		clientTransport, err := NewTransport(clientKey, WithWriteFanOut(2))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Eventually(accepted).Should(Receive())
+		var fconn *fanOutConn
+		for _, rconn := range clientTransport.(*transport).connManager.reuseConns {
+			fconn = rconn.PacketConn.(*fanOutConn)
+		}
+		Expect(fconn).ToNot(BeNil())
+		Expect(atomic.LoadUint64(&fconn.writes[0])).ToNot(BeZero())
+		Expect(atomic.LoadUint64(&fconn.writes[1])).ToNot(BeZero())
+	})
+
+	It("refuses invalid fan-outs", func() {
+		_, err := newConfig(WithWriteFanOut(0))
+		Expect(err).To(MatchError("write fan-out must be at least 1"))
+		_, err = newConfig(WithConnManager(NewConnManager()), WithWriteFanOut(2))
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package libp2pquic
+
+import "net"
+
+// SO_REUSEPORT is only used on Linux, where the kernel distributes the packets received among all sockets.
+
+const writeFanOutSupported = false
+
+func listenReusePort(string, *net.UDPAddr) (*net.UDPConn, error) {
+	return nil, ErrWriteFanOutUnsupported
+}